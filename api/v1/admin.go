@@ -0,0 +1,26 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin checks the request carries "Authorization: Bearer <ADMIN_TOKEN>".
+// Admin endpoints are disabled entirely while ADMIN_TOKEN is unset. On failure
+// the error is written to w and false is returned.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.AdminToken == "" {
+		writeJSONError(w, http.StatusForbidden, "feature_disabled", "Admin endpoints are disabled on this server (ADMIN_TOKEN is not set)")
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+		log.Printf("%s[admin] Rejected %s %s: missing or invalid admin token%s", colorRed, r.Method, r.URL.Path, colorReset)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "A valid admin bearer token is required")
+		return false
+	}
+	return true
+}
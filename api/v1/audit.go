@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxAuditSummaryLen caps the result summary stored per audit entry
+const maxAuditSummaryLen = 200
+
+// auditEntry is one JSON line in the audit log
+type auditEntry struct {
+	Timestamp string `json:"timestamp"`
+	RequestID string `json:"request_id"`
+	Tool      string `json:"tool"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result_summary"`
+	Success   bool   `json:"success"`
+}
+
+// auditLogger appends tool invocations to a file, separate from the console logs.
+// Writes are serialized so concurrent requests never interleave lines.
+type auditLogger struct {
+	mu     sync.Mutex
+	file   *os.File
+	marker string // TRUNCATION_MARKER, appended to cut result summaries
+}
+
+// newAuditLogger opens path for appending; it returns nil when auditing is disabled
+func newAuditLogger(path, marker string) *auditLogger {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("%s[audit] Failed to open audit log %s: %v%s", colorRed, path, err, colorReset)
+		return nil
+	}
+	log.Printf("%s[audit] Writing tool audit log to %s%s", colorMagenta, path, colorReset)
+	return &auditLogger{file: f}
+}
+
+// record appends a single tool invocation; it is a no-op on a nil logger
+func (a *auditLogger) record(requestID, tool, arguments, result string, success bool) {
+	if a == nil {
+		return
+	}
+
+	result = truncateText(result, maxAuditSummaryLen, a.marker)
+
+	line, err := json.Marshal(auditEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID: requestID,
+		Tool:      tool,
+		Arguments: arguments,
+		Result:    result,
+		Success:   success,
+	})
+	if err != nil {
+		log.Printf("%s[audit] Failed to marshal audit entry: %v%s", colorRed, err, colorReset)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("%s[audit] Failed to write audit entry: %v%s", colorRed, err, colorReset)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// completionBatcher is the opt-in micro-batching layer (CHAT_BATCH_WINDOW_MS).
+// Tool-less completions for the same model that arrive within one window are sent
+// upstream together as a single legacy /completions call with an array prompt, and
+// each caller gets back the choice whose index matches its prompt. Only backends
+// that accept array prompts can be used with it.
+type completionBatcher struct {
+	window  time.Duration
+	maxSize int
+	// timeout bounds each batch's upstream call, which no single caller owns
+	timeout time.Duration
+	send    func(ctx context.Context, model string, prompts []string) ([]batchAnswer, chatUsage, *completionError)
+
+	mu      sync.Mutex
+	pending map[string]*completionBatch
+}
+
+// completionBatch collects the prompts for one model until it is flushed
+type completionBatch struct {
+	model   string
+	prompts []string
+	waiters []chan batchResult
+	flushed bool
+}
+
+// batchAnswer is the upstream's answer to one prompt of a batch
+type batchAnswer struct {
+	content      string
+	finishReason string
+}
+
+// batchResult is one caller's share of a flushed batch
+type batchResult struct {
+	batchAnswer
+	usage chatUsage
+	err   *completionError
+}
+
+func newCompletionBatcher(window time.Duration, maxSize int, timeout time.Duration, send func(ctx context.Context, model string, prompts []string) ([]batchAnswer, chatUsage, *completionError)) *completionBatcher {
+	return &completionBatcher{
+		window:  window,
+		maxSize: maxSize,
+		timeout: timeout,
+		send:    send,
+		pending: make(map[string]*completionBatch),
+	}
+}
+
+// submit adds prompt to the open batch for model, starting one if needed, and
+// blocks until the batch has been sent and demultiplexed. It returns ctx.Err()
+// if ctx ends first; the prompt still goes out with the rest of its batch.
+func (b *completionBatcher) submit(ctx context.Context, model, prompt string) (batchResult, error) {
+	done := make(chan batchResult, 1)
+
+	b.mu.Lock()
+	batch, ok := b.pending[model]
+	if !ok {
+		batch = &completionBatch{model: model}
+		b.pending[model] = batch
+		time.AfterFunc(b.window, func() { b.flush(batch) })
+	}
+	batch.prompts = append(batch.prompts, prompt)
+	batch.waiters = append(batch.waiters, done)
+	full := len(batch.prompts) >= b.maxSize
+	b.mu.Unlock()
+
+	if full {
+		go b.flush(batch)
+	}
+
+	select {
+	case result := <-done:
+		return result, nil
+	case <-ctx.Done():
+		return batchResult{}, ctx.Err()
+	}
+}
+
+// flush sends batch once, whichever of the window timer or the size cap comes first,
+// and routes each choice back to the caller that submitted the matching prompt
+func (b *completionBatcher) flush(batch *completionBatch) {
+	b.mu.Lock()
+	if batch.flushed {
+		b.mu.Unlock()
+		return
+	}
+	batch.flushed = true
+	if b.pending[batch.model] == batch {
+		delete(b.pending, batch.model)
+	}
+	b.mu.Unlock()
+
+	log.Printf("%s[/chat] Sending batch of %d prompt(s) for model %s%s", colorYellow, len(batch.prompts), batch.model, colorReset)
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	answers, usage, cerr := b.send(ctx, batch.model, batch.prompts)
+	cancel()
+	if cerr == nil && len(answers) != len(batch.prompts) {
+		cerr = &completionError{status: http.StatusBadGateway, code: "upstream_error",
+			message: fmt.Sprintf("AI API error: batch of %d prompts returned %d answers", len(batch.prompts), len(answers))}
+	}
+
+	// Usage is only reported for the whole batch, so each caller is charged an equal
+	// share. Waiters are buffered, so callers that gave up don't hold the others back.
+	n := len(batch.waiters)
+	share := chatUsage{PromptTokens: usage.PromptTokens / n, CompletionTokens: usage.CompletionTokens / n, TotalTokens: usage.TotalTokens / n}
+	for i, done := range batch.waiters {
+		if cerr != nil {
+			done <- batchResult{err: cerr}
+			continue
+		}
+		done <- batchResult{batchAnswer: answers[i], usage: share}
+	}
+}
+
+// batchPrompt flattens chat messages into a single completion prompt. It reports
+// false when a message is not plain text, in which case the call is not batched.
+func batchPrompt(messages []interface{}) (string, bool) {
+	var prompt strings.Builder
+	for _, m := range messages {
+		msg, ok := m.(map[string]string)
+		if !ok {
+			return "", false
+		}
+		role := msg["role"]
+		if role == "" {
+			return "", false
+		}
+		prompt.WriteString(strings.ToUpper(role[:1]) + role[1:] + ": " + msg["content"] + "\n\n")
+	}
+	prompt.WriteString("Assistant:")
+	return prompt.String(), true
+}
+
+// sendCompletionBatch performs one legacy /completions call for several prompts
+// and returns the answers in prompt order, matched by each choice's index
+func (s *Server) sendCompletionBatch(ctx context.Context, model string, prompts []string) ([]batchAnswer, chatUsage, *completionError) {
+	var usage chatUsage
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"model":  model,
+		"prompt": prompts,
+	})
+	if err != nil {
+		return nil, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to marshal request"}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/completions", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to create request"}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	start := time.Now()
+	httpResp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, usage, s.aiCallError(context.Background(), err, "Failed to call AI API: "+err.Error())
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, usage, s.aiCallError(context.Background(), err, "Failed to read response")
+	}
+	s.latency.record("chat_batch", time.Since(start))
+
+	if httpResp.StatusCode != http.StatusOK {
+		log.Printf("%s[/chat] AI API batch returned status %d, body: %s%s", colorRed, httpResp.StatusCode, string(respBody), colorReset)
+		return nil, usage, &completionError{status: httpResp.StatusCode, code: "upstream_error", message: upstreamErrorMessage(httpResp.StatusCode, respBody)}
+	}
+
+	var batchResp struct {
+		Choices []struct {
+			Index        int    `json:"index"`
+			Text         string `json:"text"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *chatUsage `json:"usage,omitempty"`
+	}
+	if err := json.Unmarshal(respBody, &batchResp); err != nil {
+		return nil, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to parse AI response"}
+	}
+	if batchResp.Usage != nil {
+		usage = *batchResp.Usage
+	}
+
+	answers := make([]batchAnswer, len(prompts))
+	seen := make([]bool, len(prompts))
+	for _, c := range batchResp.Choices {
+		if c.Index < 0 || c.Index >= len(prompts) || seen[c.Index] {
+			return nil, usage, &completionError{status: http.StatusBadGateway, code: "upstream_error",
+				message: fmt.Sprintf("AI API error: unexpected choice index %d in batch of %d", c.Index, len(prompts))}
+		}
+		seen[c.Index] = true
+		answers[c.Index] = batchAnswer{content: strings.TrimSpace(c.Text), finishReason: c.FinishReason}
+	}
+	for i, ok := range seen {
+		if !ok {
+			return nil, usage, &completionError{status: http.StatusBadGateway, code: "upstream_error",
+				message: fmt.Sprintf("AI API error: no answer for prompt %d in batch of %d", i, len(prompts))}
+		}
+	}
+	return answers, usage, nil
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompletionBatcher(t *testing.T) {
+	var sends [][]string
+	send := func(ctx context.Context, model string, prompts []string) ([]batchAnswer, chatUsage, *completionError) {
+		sends = append(sends, prompts)
+		answers := make([]batchAnswer, len(prompts))
+		for i, p := range prompts {
+			answers[i] = batchAnswer{content: "re: " + p, finishReason: "stop"}
+		}
+		answers[1].finishReason = "length"
+		return answers, chatUsage{PromptTokens: 10, CompletionTokens: 20, TotalTokens: 30}, nil
+	}
+	b := newCompletionBatcher(time.Hour, 2, time.Minute, send)
+
+	var wg sync.WaitGroup
+	results := make([]batchResult, 2)
+	submit := func(i int, prompt string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if results[i], err = b.submit(context.Background(), "gpt-5", prompt); err != nil {
+				t.Errorf("submit(%q): %v", prompt, err)
+			}
+		}()
+	}
+	// Submit in order, so the prompts' positions in the batch are known
+	submit(0, "first")
+	for pending := 0; pending == 0; time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		if batch := b.pending["gpt-5"]; batch != nil {
+			pending = len(batch.prompts)
+		}
+		b.mu.Unlock()
+	}
+	submit(1, "second")
+	wg.Wait()
+
+	if len(sends) != 1 || len(sends[0]) != 2 {
+		t.Fatalf("sends = %q, want one batch of both prompts sent when full", sends)
+	}
+	want := []batchResult{
+		{batchAnswer: batchAnswer{content: "re: first", finishReason: "stop"}, usage: chatUsage{PromptTokens: 5, CompletionTokens: 10, TotalTokens: 15}},
+		{batchAnswer: batchAnswer{content: "re: second", finishReason: "length"}, usage: chatUsage{PromptTokens: 5, CompletionTokens: 10, TotalTokens: 15}},
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+}
+
+func TestCompletionBatcherCallerGivesUp(t *testing.T) {
+	sent := make(chan time.Time, 1)
+	release := make(chan struct{})
+	send := func(ctx context.Context, model string, prompts []string) ([]batchAnswer, chatUsage, *completionError) {
+		deadline, _ := ctx.Deadline()
+		sent <- deadline
+		<-release
+		return make([]batchAnswer, len(prompts)), chatUsage{}, nil
+	}
+	b := newCompletionBatcher(time.Millisecond, 10, time.Minute, send)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan error)
+	go func() {
+		_, err := b.submit(ctx, "gpt-5", "abandoned")
+		gaveUp <- err
+	}()
+	deadline := <-sent
+	cancel()
+	select {
+	case err := <-gaveUp:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("submit error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("submit kept waiting after its context ended")
+	}
+	close(release)
+
+	if deadline.IsZero() || time.Until(deadline) > time.Minute {
+		t.Errorf("batch sent with deadline %v, want one within the batcher's timeout", deadline)
+	}
+}
+
+func TestCompletionBatcherSendError(t *testing.T) {
+	send := func(ctx context.Context, model string, prompts []string) ([]batchAnswer, chatUsage, *completionError) {
+		return []batchAnswer{{content: "only one"}}, chatUsage{}, nil
+	}
+	b := newCompletionBatcher(time.Hour, 2, time.Minute, send)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := b.submit(context.Background(), "gpt-5", "p")
+			if err != nil || result.err == nil || result.err.status != http.StatusBadGateway {
+				t.Errorf("submit = %+v, %v, want a 502 for the short batch", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSendCompletionBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		choices string
+		want    []string
+		wantErr string
+	}{
+		{"demultiplexed by index", `[{"index": 2, "text": " c "}, {"index": 0, "text": "a"}, {"index": 1, "text": "b"}]`, []string{"a", "b", "c"}, ""},
+		{"index out of range", `[{"index": 0, "text": "a"}, {"index": 1, "text": "b"}, {"index": 3, "text": "c"}]`, nil, "unexpected choice index 3"},
+		{"duplicate index", `[{"index": 0, "text": "a"}, {"index": 0, "text": "b"}, {"index": 1, "text": "c"}]`, nil, "unexpected choice index 0"},
+		{"missing answer", `[{"index": 0, "text": "a"}, {"index": 2, "text": "c"}]`, nil, "no answer for prompt 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"choices": ` + tt.choices + `}`))
+			}))
+			defer upstream.Close()
+			s := newTestServer(t, upstream.URL, nil)
+
+			answers, _, cerr := s.sendCompletionBatch(t.Context(), "gpt-5", []string{"p0", "p1", "p2"})
+			if tt.wantErr != "" {
+				if cerr == nil || cerr.status != http.StatusBadGateway || !strings.Contains(cerr.message, tt.wantErr) {
+					t.Errorf("error = %+v, want a 502 mentioning %q", cerr, tt.wantErr)
+				}
+				return
+			}
+			var got []string
+			for _, a := range answers {
+				got = append(got, a.content)
+			}
+			if cerr != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("sendCompletionBatch = %q, %+v, want %q", got, cerr, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoCompletionBatched(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/completions" {
+			t.Errorf("batched call went to %s, want /completions", r.URL.Path)
+		}
+		var req struct {
+			Prompt []string `json:"prompt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Prompt) != 1 || req.Prompt[0] != "User: hi\n\nAssistant:" {
+			t.Errorf("prompts = %q", req.Prompt)
+		}
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "text": " Hello ", "finish_reason": "length"}], "usage": {"total_tokens": 7}}`))
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.ChatBatchWindow = time.Millisecond
+	})
+
+	messages := []interface{}{map[string]string{"role": "user", "content": "hi"}}
+	choice, usage, cerr := s.doCompletion(context.Background(), "gpt-5", messages, nil, completionOptions{})
+	if cerr != nil {
+		t.Fatalf("doCompletion: %v", cerr)
+	}
+	if choice.Message.Content == nil || *choice.Message.Content != "Hello" {
+		t.Errorf("content = %v, want Hello", choice.Message.Content)
+	}
+	if choice.FinishReason != "length" {
+		t.Errorf("finish reason = %q, want length carried through the batch", choice.FinishReason)
+	}
+	if usage.TotalTokens != 7 {
+		t.Errorf("usage = %+v, want 7 total tokens", usage)
+	}
+}
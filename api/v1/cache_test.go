@@ -0,0 +1,43 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	c := newTTLCache[int](time.Hour, 2)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get on an empty cache should miss")
+	}
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("a", 3)
+	if v, ok := c.Get("a"); !ok || v != 3 {
+		t.Errorf("Get(a) = %d, %v, want 3, true", v, ok)
+	}
+
+	// b now expires first, so it makes room for c
+	c.Set("c", 4)
+	if _, ok := c.Get("b"); ok {
+		t.Error("the entry closest to expiry should have been evicted")
+	}
+	for key, want := range map[string]int{"a": 3, "c": 4} {
+		if v, ok := c.Get(key); !ok || v != want {
+			t.Errorf("Get(%s) = %d, %v, want %d, true", key, v, ok, want)
+		}
+	}
+}
+
+func TestTTLCacheExpiry(t *testing.T) {
+	c := newTTLCache[string](10*time.Millisecond, 10)
+	c.Set("k", "v")
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("k"); ok {
+		t.Error("Get should miss once the entry has expired")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("expired entries should be dropped on Get, got %v", c.entries)
+	}
+}
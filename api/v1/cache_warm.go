@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Cache warming limits: one request may name at most maxWarmTargets URLs and
+// queries in total, fetched warmConcurrency at a time
+const (
+	maxWarmTargets  = 100
+	warmConcurrency = 4
+)
+
+// Kinds of cache warming target
+const (
+	warmKindURL   = "url"
+	warmKindQuery = "query"
+)
+
+// PostAdminWarm implements ServerInterface.
+// (POST /admin/warm)
+func (s *Server) PostAdminWarm(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req WarmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	var targets []WarmResult
+	if req.Urls != nil {
+		for _, u := range *req.Urls {
+			targets = append(targets, WarmResult{Kind: warmKindURL, Target: u})
+		}
+	}
+	if req.Queries != nil {
+		for _, q := range *req.Queries {
+			targets = append(targets, WarmResult{Kind: warmKindQuery, Target: q})
+		}
+	}
+	if len(targets) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Give at least one of urls or queries")
+		return
+	}
+	if len(targets) > maxWarmTargets {
+		writeJSONError(w, http.StatusBadRequest, "too_many_targets",
+			fmt.Sprintf("At most %d urls and queries may be warmed per request, got %d", maxWarmTargets, len(targets)))
+		return
+	}
+
+	log.Printf("%s[/admin/warm] Warming %d cache entries%s", colorBlue, len(targets), colorReset)
+	resp := WarmResponse{Results: s.warmCaches(r, targets)}
+	for _, result := range resp.Results {
+		if result.Ok {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	log.Printf("%s[/admin/warm] Warmed %d, failed %d%s", colorBlue, resp.Succeeded, resp.Failed, colorReset)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// warmCaches fetches every target, warmConcurrency at a time: URLs into the page
+// cache shared by the page_reader endpoints and tools, queries into the
+// /search/suggest cache. /search itself keeps no result cache. Results come back
+// in target order.
+func (s *Server) warmCaches(r *http.Request, targets []WarmResult) []WarmResult {
+	slots := make(chan struct{}, warmConcurrency)
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(result *WarmResult) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			var err error
+			if result.Kind == warmKindURL {
+				_, err = s.fetchPage(r.Context(), result.Target)
+			} else {
+				_, err = s.suggestQueries(r.Context(), result.Target)
+			}
+			result.Ok = err == nil
+			if err != nil {
+				msg := err.Error()
+				result.Error = &msg
+			}
+		}(&targets[i])
+	}
+	wg.Wait()
+	return targets
+}
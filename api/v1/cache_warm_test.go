@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPostAdminWarm(t *testing.T) {
+	var fetches atomic.Int32
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write([]byte("<html><body><p>Warm page</p></body></html>"))
+	}))
+	defer site.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"queries": [{"keyword": "golang", "response": {"results": [{"title": "Golang tutorial"}]}}]}`))
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+		cfg.AllowPrivateFetch = true // the site is on loopback
+	})
+
+	warm := func(token, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/admin/warm", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		s.PostAdminWarm(rec, r)
+		return rec
+	}
+
+	body := fmt.Sprintf(`{"urls": [%q, "http://127.0.0.1:1/down"], "queries": ["golang"]}`, site.URL+"/a")
+	if rec := warm("wrong", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("with a wrong token: status = %d, want 401", rec.Code)
+	}
+	if _, ok := s.pageCache.Get(site.URL + "/a"); ok {
+		t.Fatal("a rejected request warmed the page cache")
+	}
+
+	rec := warm("admin-secret", body)
+	var resp WarmResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if resp.Succeeded != 2 || resp.Failed != 1 || len(resp.Results) != 3 {
+		t.Fatalf("response = %s, want 2 succeeded and 1 failed", rec.Body)
+	}
+	for i, want := range []WarmResult{{Kind: "url", Target: site.URL + "/a", Ok: true}, {Kind: "url", Target: "http://127.0.0.1:1/down"}, {Kind: "query", Target: "golang", Ok: true}} {
+		got := resp.Results[i]
+		if got.Kind != want.Kind || got.Target != want.Target || got.Ok != want.Ok || (got.Error == nil) != want.Ok {
+			t.Errorf("result %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	if _, ok := s.pageCache.Get(site.URL + "/a"); !ok {
+		t.Error("the page was not cached")
+	}
+	if suggestions, ok := s.suggestCache.Get("golang"); !ok || len(suggestions) == 0 {
+		t.Errorf("suggestions = %q, %v, want the query's suggestions cached", suggestions, ok)
+	}
+	if _, _, _, err := s.CallReadPage(t.Context(), site.URL+"/a", PageReaderRequestFormatText); err != nil || fetches.Load() != 1 {
+		t.Errorf("read after warming: %v, %d fetches, want the cached page reused", err, fetches.Load())
+	}
+
+	tooMany, _ := json.Marshal(map[string][]string{"queries": make([]string, maxWarmTargets+1)})
+	for body, want := range map[string]string{`{}`: "invalid_request", string(tooMany): "too_many_targets"} {
+		if rec := warm("admin-secret", body); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%.40s: status %d, body %s, want a 400 %s", body, rec.Code, rec.Body, want)
+		}
+	}
+}
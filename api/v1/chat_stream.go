@@ -0,0 +1,317 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chatStream forwards a streamed chat to the client as Server-Sent Events. Only the
+// final assistant turn is streamed as content; earlier turns show up as tool_call
+// and tool_result progress events.
+//
+// Events:
+//
+//	delta        a chunk of the final answer's raw text
+//	reset        discard the deltas sent so far; the turn turned out to call tools
+//	tool_call    a tool is about to run: {"tool_call_id", "name", "arguments"}
+//	tool_output  a line of output from a tool that is still running (run_command):
+//	             {"tool_call_id", "name", "line"}
+//	tool_result  a tool finished: {"tool_call_id", "name", "success"}
+//	error        the chat failed: an ErrorResponse
+//	done         the chat finished: the ChatResponse /chat would have returned
+type chatStream struct {
+	sse *sseWriter
+	// client is the streaming request's context, done once the client disconnects
+	client context.Context
+	// withhold keeps deltas back until the answer has been post-processed, for
+	// when sending raw text first would defeat a filter (SAFE_MODE)
+	withhold bool
+}
+
+// errClientDisconnected is the cancellation cause of a streamed chat whose client
+// went away; the tool loop, in-flight tools and upstream calls stop on it
+var errClientDisconnected = errors.New("client disconnected")
+
+// statusClientClosedRequest is the de facto status (nginx's 499) recorded for a
+// chat abandoned by its client; nothing is sent, as nobody is listening
+const statusClientClosedRequest = 499
+
+// clientDisconnected reports whether ctx was cancelled because the streaming
+// client went away
+func clientDisconnected(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errClientDisconnected)
+}
+
+type streamToolCall struct {
+	ToolCallId string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+}
+
+type streamToolResult struct {
+	ToolCallId string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Success    bool   `json:"success"`
+}
+
+type streamToolOutput struct {
+	ToolCallId string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Line       string `json:"line"`
+}
+
+type toolOutputKey struct{}
+
+// withToolOutput returns ctx carrying onLine, which tools that produce output as
+// they run (run_command) call with each line of it
+func withToolOutput(ctx context.Context, onLine func(line string)) context.Context {
+	return context.WithValue(ctx, toolOutputKey{}, onLine)
+}
+
+// toolOutputFrom returns the output callback carried by ctx, or nil
+func toolOutputFrom(ctx context.Context) func(line string) {
+	onLine, _ := ctx.Value(toolOutputKey{}).(func(line string))
+	return onLine
+}
+
+func (c *chatStream) event(event string, v interface{}) {
+	data, _ := json.Marshal(v)
+	_ = c.sse.Event(event, string(data))
+}
+
+func (c *chatStream) delta(text string) {
+	if text != "" && !c.withhold {
+		_ = c.sse.Event("delta", text)
+	}
+}
+
+func (c *chatStream) reset() {
+	if !c.withhold {
+		_ = c.sse.Event("reset", "")
+	}
+}
+
+func (c *chatStream) toolCall(tc chatToolCall) {
+	c.event("tool_call", streamToolCall{ToolCallId: tc.Id, Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+}
+
+func (c *chatStream) toolOutput(tc chatToolCall, line string) {
+	c.event("tool_output", streamToolOutput{ToolCallId: tc.Id, Name: tc.Function.Name, Line: line})
+}
+
+func (c *chatStream) toolResult(out ToolOutput) {
+	c.event("tool_result", streamToolResult{ToolCallId: out.ToolCallId, Name: out.Name, Success: out.Success})
+}
+
+// finish sends the final response. Withheld answers are sent as one delta first so
+// clients that only read deltas still see the answer.
+func (c *chatStream) finish(resp ChatResponse) {
+	if c.withhold && resp.Content != nil {
+		_ = c.sse.Event("delta", *resp.Content)
+	}
+	c.event("done", resp)
+}
+
+// fail reports an error captured by a bufferedResponse
+func (c *chatStream) fail(b *bufferedResponse) {
+	var errResp ErrorResponse
+	if err := json.Unmarshal(b.body.Bytes(), &errResp); err != nil || errResp.Error == "" {
+		message := strings.TrimSpace(b.body.String())
+		errResp = ErrorResponse{Error: "chat_failed", Message: &message}
+	}
+	c.event("error", errResp)
+}
+
+// bufferedResponse captures an error response written by the chat loop after the
+// event stream has already started, so it can be re-sent as an error event
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// streamChunk is one chunk of an upstream chat completion stream
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   json.RawMessage `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				Id       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage,omitempty"`
+}
+
+// doStreamingCompletion performs a single chat completion with "stream": true and
+// reassembles the streamed chunks into a choice. Content deltas are passed on to
+// opts.stream while the turn has not asked for any tools; if tool calls follow
+// content that was already sent, the client is told to reset.
+func (s *Server) doStreamingCompletion(parent context.Context, model string, messages []interface{}, tools []interface{}, opts completionOptions) (choice chatCompletionChoice, usage chatUsage, cerr *completionError) {
+	s.logf(requestIDFrom(parent), "%s[/chat] Calling AI API (streaming)%s (model: %s, messages: %d, tools: %d)...%s", colorYellow, colorReset, model, len(messages), len(tools), metaTag(parent))
+
+	chatReq := map[string]interface{}{
+		"model":          model,
+		"messages":       messages,
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+	if len(tools) > 0 {
+		chatReq["tools"] = tools
+		chatReq["tool_choice"] = "auto"
+	}
+	opts.apply(chatReq)
+	s.mapModelParams(model, chatReq)
+
+	reqBody, err := json.Marshal(chatReq)
+	if err != nil {
+		return choice, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to marshal request"}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, s.cfg.AIRequestTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/chat/completions", bytes.NewReader(reqBody))
+	if err != nil {
+		return choice, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to create request"}
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	start := time.Now()
+	httpResp, err := s.client.Do(httpReq)
+	if err != nil {
+		return choice, usage, s.aiCallError(parent, err, "Failed to call AI API: "+err.Error())
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(httpResp.Body)
+		s.logf(requestIDFrom(parent), "%s[/chat] AI API returned status %d%s, body: %s%s", colorRed, httpResp.StatusCode, metaTag(parent), string(respBody), colorReset)
+		return choice, usage, &completionError{status: httpResp.StatusCode, code: "upstream_error", message: upstreamErrorMessage(httpResp.StatusCode, respBody),
+			retryable: retryableStatus(httpResp.StatusCode)}
+	}
+
+	var content strings.Builder
+	calls := map[int]*chatToolCall{}
+	streamed := false
+
+	var raw bytes.Buffer
+	defer func() { opts.rawUpstream.record(raw.Bytes()) }()
+
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if opts.rawUpstream != nil && raw.Len() <= maxRawUpstreamBody {
+			raw.WriteString(scanner.Text() + "\n")
+		}
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return choice, usage, &completionError{status: http.StatusBadGateway, code: "upstream_error", message: "Failed to parse AI stream chunk"}
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		if reason := chunk.Choices[0].FinishReason; reason != nil && *reason != "" {
+			choice.FinishReason = *reason
+		}
+		delta := chunk.Choices[0].Delta
+		for _, tc := range delta.ToolCalls {
+			call, ok := calls[tc.Index]
+			if !ok {
+				call = &chatToolCall{}
+				calls[tc.Index] = call
+			}
+			if tc.Id != "" {
+				call.Id = tc.Id
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+		if len(delta.ToolCalls) > 0 && streamed {
+			opts.stream.reset()
+			streamed = false
+		}
+
+		// Array-form deltas are joined like a full message's content parts
+		text, err := decodeMessageContent(delta.Content)
+		if err != nil {
+			return choice, usage, &completionError{status: http.StatusBadGateway, code: "upstream_error", message: "Failed to parse AI stream chunk"}
+		}
+		if text != nil {
+			content.WriteString(*text)
+			if len(calls) == 0 {
+				opts.stream.delta(*text)
+				streamed = streamed || *text != ""
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// Reads fail once the call's context ends, so report why it ended instead
+		// of blaming the upstream: cancellation means the client went away
+		if ctxErr := ctx.Err(); errors.Is(ctxErr, context.Canceled) {
+			return choice, usage, s.clientGone()
+		} else if ctxErr != nil {
+			return choice, usage, s.aiCallError(parent, ctxErr, "")
+		}
+		return choice, usage, &completionError{status: http.StatusBadGateway, code: "upstream_error", message: "AI stream interrupted: " + err.Error()}
+	}
+	s.latency.record("chat", time.Since(start))
+
+	s.logf(requestIDFrom(parent), "%s[/chat] AI API stream complete%s", colorYellow, colorReset)
+
+	if content.Len() > 0 {
+		text := content.String()
+		choice.Message.Content = &text
+	}
+	indexes := make([]int, 0, len(calls))
+	for i := range calls {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		choice.Message.ToolCalls = append(choice.Message.ToolCalls, *calls[i])
+	}
+	return choice, usage, nil
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// streamingModel plays a model that streams its completions: it calls toolName
+// with toolArgs until a tool result is in the conversation, then answers with answer
+func streamingModel(t *testing.T, toolName, toolArgs, answer string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("model: decoding request: %v", err)
+		}
+		answered := false
+		for _, m := range req.Messages {
+			answered = answered || m["role"] == "tool"
+		}
+
+		var chunk map[string]interface{}
+		if answered {
+			chunk = map[string]interface{}{"delta": map[string]interface{}{"content": answer}, "finish_reason": "stop"}
+		} else {
+			call := map[string]interface{}{
+				"index": 0, "id": "call_1", "type": "function",
+				"function": map[string]string{"name": toolName, "arguments": toolArgs},
+			}
+			chunk = map[string]interface{}{"delta": map[string]interface{}{"tool_calls": []interface{}{call}}, "finish_reason": "tool_calls"}
+		}
+		data, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{chunk}})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postStreamingChat sends message to PostChat with stream set and returns the events
+func postStreamingChat(t *testing.T, s *Server, message string) []sseEvent {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"message": message, "stream": true})
+	rec := httptest.NewRecorder()
+	s.PostChat(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(string(body))))
+	return readSSEEvents(t, rec.Body.String())
+}
+
+func TestChatStreamOnlyFinalTurn(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		return `{"result": 1.609344}`, true
+	})
+	defer restore()
+
+	call := func(id string) string {
+		return fmt.Sprintf(`{"choices": [{"delta": {"tool_calls": [{"index": 0, "id": %q, "type": "function",
+			"function": {"name": "convert_units", "arguments": "{}"}}]}, "finish_reason": "tool_calls"}]}`, id)
+	}
+	// The first turn starts answering before it decides to call a tool, the second
+	// only calls a tool and the third streams the answer in pieces
+	turns := [][]string{
+		{`{"choices": [{"delta": {"content": "Let me check."}}]}`, call("call_1")},
+		{call("call_2")},
+		{`{"choices": [{"delta": {"content": "1 mile "}}]}`, `{"choices": [{"delta": {"content": "is 1.61 km."}, "finish_reason": "stop"}]}`},
+	}
+	requests := 0
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] != true {
+			t.Errorf("turn %d was not requested as a stream", requests+1)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range turns[requests] {
+			fmt.Fprintf(w, "data: %s\n\n", strings.Join(strings.Fields(chunk), " "))
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		requests++
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, nil)
+
+	var got []string
+	var done ChatResponse
+	for _, ev := range postStreamingChat(t, s, "1 mile in km?") {
+		switch ev.name {
+		case "delta", "reset":
+			got = append(got, ev.name+":"+ev.data)
+		case "tool_call", "tool_result":
+			var call struct {
+				ToolCallId string `json:"tool_call_id"`
+			}
+			_ = json.Unmarshal([]byte(ev.data), &call)
+			got = append(got, ev.name+":"+call.ToolCallId)
+		case "done":
+			if err := json.Unmarshal([]byte(ev.data), &done); err != nil {
+				t.Fatalf("done event %q: %v", ev.data, err)
+			}
+			got = append(got, "done")
+		default:
+			t.Fatalf("unexpected %s event: %s", ev.name, ev.data)
+		}
+	}
+
+	want := []string{
+		"delta:Let me check.", "reset:", // dropped once the turn turned out to call a tool
+		"tool_call:call_1", "tool_result:call_1",
+		"tool_call:call_2", "tool_result:call_2",
+		"delta:1 mile ", "delta:is 1.61 km.",
+		"done",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events =\n%q\nwant\n%q", got, want)
+	}
+	if done.Content == nil || *done.Content != "1 mile is 1.61 km." {
+		t.Errorf("done content = %v, want the whole final answer", done.Content)
+	}
+}
+
+// cancelOnDelta records a streamed response and cancels the request, as a client
+// disconnecting would, once the first delta has been written
+type cancelOnDelta struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnDelta) Write(p []byte) (int, error) {
+	n, err := c.ResponseRecorder.Write(p)
+	if strings.HasPrefix(string(p), "event: delta") {
+		c.cancel()
+	}
+	return n, err
+}
+
+// lastError decodes the error event that ends events, failing if there is none
+func lastError(t *testing.T, events []sseEvent) ErrorResponse {
+	t.Helper()
+	if len(events) == 0 || events[len(events)-1].name != "error" {
+		t.Fatalf("events = %+v, want them to end with an error", events)
+	}
+	var errResp ErrorResponse
+	if err := json.Unmarshal([]byte(events[len(events)-1].data), &errResp); err != nil {
+		t.Fatalf("error event: %v", err)
+	}
+	return errResp
+}
+
+// Regression: a client disconnecting mid-answer was reported as the upstream
+// stream breaking
+func TestChatStreamClientAbort(t *testing.T) {
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": "Part of"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, nil)
+
+	// The client leaves once the first part of the answer reaches it, while the
+	// rest is still being read from the upstream
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &cancelOnDelta{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/chat", strings.NewReader(`{"message": "hi", "stream": true}`))
+	s.PostChat(rec, req)
+
+	events := readSSEEvents(t, rec.Body.String())
+	if events[0].name != "delta" {
+		t.Errorf("first event = %+v, want the delta sent before the client left", events[0])
+	}
+	if got := lastError(t, events); got.Error != "client_disconnected" {
+		t.Errorf("error = %+v, want client_disconnected", got)
+	}
+}
+
+func TestChatStreamUpstreamBreaks(t *testing.T) {
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": "Part of"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler) // drops the connection mid-stream
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, nil)
+
+	events := postStreamingChat(t, s, "hi")
+	got := lastError(t, events)
+	if got.Error != "upstream_error" || got.Message == nil || !strings.Contains(*got.Message, "AI stream interrupted") {
+		t.Errorf("error = %+v, want an interrupted upstream stream", got)
+	}
+	if events[0].name != "delta" || events[0].data != "Part of" {
+		t.Errorf("first event = %+v, want the delta sent before the break", events[0])
+	}
+}
+
+func TestChatStreamHeartbeatDuringSlowTool(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		time.Sleep(150 * time.Millisecond)
+		return `{"result": 1}`, true
+	})
+	defer restore()
+
+	turn := 0
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if turn == 0 {
+			fmt.Fprint(w, `data: {"choices": [{"delta": {"tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "convert_units", "arguments": "{}"}}]}, "finish_reason": "tool_calls"}]}`+"\n\n")
+		} else {
+			fmt.Fprint(w, `data: {"choices": [{"delta": {"content": "done"}, "finish_reason": "stop"}]}`+"\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		turn++
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.SSEHeartbeatInterval = 30 * time.Millisecond
+	})
+
+	rec := httptest.NewRecorder()
+	s.PostChat(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "hi", "stream": true}`)))
+	body := rec.Body.String()
+
+	// Keepalives fill the wait between the tool call and its result
+	toolCall, toolResult := strings.Index(body, "event: tool_call"), strings.Index(body, "event: tool_result")
+	if toolCall < 0 || toolResult < toolCall || !strings.Contains(body[toolCall:toolResult], sseKeepalive) {
+		t.Errorf("no keepalive while the tool ran:\n%s", body)
+	}
+}
+
+func TestChatStreamArrayContent(t *testing.T) {
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": [{"type": "text", "text": "Hello"}, {"type": "annotation"}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": " world"}, "finish_reason": "stop"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, nil)
+
+	var deltas []string
+	var done ChatResponse
+	for _, ev := range postStreamingChat(t, s, "hi") {
+		switch ev.name {
+		case "delta":
+			deltas = append(deltas, ev.data)
+		case "done":
+			_ = json.Unmarshal([]byte(ev.data), &done)
+		}
+	}
+	if strings.Join(deltas, "|") != "Hello| world" {
+		t.Errorf("deltas = %q, want the array part's text then the string delta", deltas)
+	}
+	if done.Content == nil || *done.Content != "Hello world" {
+		t.Errorf("done content = %v, want Hello world", done.Content)
+	}
+}
+
+func TestChatStreamClientDisconnect(t *testing.T) {
+	// The page read by the tool hangs until its fetch is cancelled
+	fetching := make(chan struct{})
+	fetchStopped := make(chan struct{})
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fetching)
+		select {
+		case <-r.Context().Done():
+			close(fetchStopped)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer site.Close()
+
+	var modelCalls atomic.Int32
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modelCalls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		args, _ := json.Marshal(map[string]string{"url": site.URL})
+		call, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{
+			"delta": map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+				"index": 0, "id": "call_1", "type": "function",
+				"function": map[string]string{"name": "read_page", "arguments": string(args)},
+			}}},
+			"finish_reason": "tool_calls",
+		}}})
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", call)
+	}))
+	defer model.Close()
+	s := newToolTestServer(t, model.URL, func(cfg *Config) {
+		cfg.AllowPrivateFetch = true // the site is on loopback
+	})
+
+	handlerDone := make(chan struct{})
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		s.PostChat(w, r)
+	}))
+	defer chat.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, chat.URL, strings.NewReader(`{"message": "read it", "stream": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+
+	<-fetching
+	cancel()
+	for name, ch := range map[string]chan struct{}{"page fetch": fetchStopped, "chat handler": handlerDone} {
+		select {
+		case <-ch:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s kept running after the client disconnected", name)
+		}
+	}
+	if n := modelCalls.Load(); n != 1 {
+		t.Errorf("model called %d times, want no call after the client disconnected", n)
+	}
+}
+
+func TestChatStreamFinishReason(t *testing.T) {
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": "Cut "}, "finish_reason": null}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": "short"}, "finish_reason": "length"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, nil)
+
+	events := postStreamingChat(t, s, "hi")
+	last := events[len(events)-1]
+	var done ChatResponse
+	if err := json.Unmarshal([]byte(last.data), &done); err != nil || last.name != "done" {
+		t.Fatalf("last event %s %q: %v", last.name, last.data, err)
+	}
+	if done.FinishReason == nil || *done.FinishReason != "length" {
+		t.Errorf("finish_reason = %v, want length", done.FinishReason)
+	}
+	if done.Hint == nil {
+		t.Error("no truncation hint in the done event")
+	}
+}
+
+func TestChatStreamRelaysRunCommandOutput(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args, _ := json.Marshal(map[string]string{"command": "ls -1 " + dir})
+	model := streamingModel(t, "run_command", string(args), "Two files.")
+
+	// run_command runs through the server's own /run_command/stream endpoint
+	s := newToolTestServer(t, model.URL, nil)
+
+	var lines []string
+	var order []string
+	var result streamToolResult
+	for _, ev := range postStreamingChat(t, s, "What is in the directory?") {
+		order = append(order, ev.name)
+		switch ev.name {
+		case "tool_output":
+			var out streamToolOutput
+			if err := json.Unmarshal([]byte(ev.data), &out); err != nil {
+				t.Fatalf("tool_output %q: %v", ev.data, err)
+			}
+			if out.ToolCallId != "call_1" || out.Name != "run_command" {
+				t.Errorf("tool_output = %+v, want call_1 run_command", out)
+			}
+			lines = append(lines, out.Line)
+		case "tool_result":
+			if err := json.Unmarshal([]byte(ev.data), &result); err != nil {
+				t.Fatalf("tool_result %q: %v", ev.data, err)
+			}
+		case "error":
+			t.Fatalf("chat failed: %s", ev.data)
+		}
+	}
+
+	if strings.Join(lines, ",") != "a.txt,b.txt" {
+		t.Errorf("tool_output lines = %q, want a.txt and b.txt", lines)
+	}
+	if !result.Success {
+		t.Errorf("tool_result = %+v, want success", result)
+	}
+	want := "tool_call,tool_output,tool_output,tool_result,delta,done"
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,967 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// modelStub plays the AI Builder chat API: it records every request it receives
+// and answers the nth (from 0) with the JSON body reply returns
+type modelStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+func newModelStub(t *testing.T, reply func(n int, req map[string]interface{}) string) *modelStub {
+	t.Helper()
+	m := &modelStub{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("model: decoding request: %v", err)
+		}
+		m.mu.Lock()
+		n := len(m.requests)
+		m.requests = append(m.requests, req)
+		m.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(reply(n, req)))
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// received returns the requests the model has been sent so far
+func (m *modelStub) received() []map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]map[string]interface{}(nil), m.requests...)
+}
+
+// answer is a model reply that ends the chat with content
+func answer(content string) string {
+	b, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{
+		"message":       map[string]interface{}{"role": "assistant", "content": content},
+		"finish_reason": "stop",
+	}}})
+	return string(b)
+}
+
+// toolCalls is a model reply asking for one tool call per name and JSON arguments pair
+func toolCalls(calls ...[2]string) string {
+	var tcs []interface{}
+	for i, c := range calls {
+		tcs = append(tcs, map[string]interface{}{
+			"id": fmt.Sprintf("call_%d", i+1), "type": "function",
+			"function": map[string]string{"name": c[0], "arguments": c[1]},
+		})
+	}
+	b, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{
+		"message":       map[string]interface{}{"role": "assistant", "content": nil, "tool_calls": tcs},
+		"finish_reason": "tool_calls",
+	}}})
+	return string(b)
+}
+
+// postChat sends body to PostChat and returns the recorded response
+func postChat(t *testing.T, s *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.PostChat(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+	return rec
+}
+
+func TestPostChatWithoutAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		demo       bool
+		wantStatus int
+	}{
+		{"rejects", false, http.StatusServiceUnavailable},
+		{"demo mode", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.DemoMode = tt.demo
+		s, err := NewServer("", "http://upstream.invalid", nil, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := postChat(t, s, `{"message": "hi there"}`)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if tt.demo {
+			var resp ChatResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil ||
+				!strings.HasPrefix(*resp.Content, "[demo mode]") || !strings.Contains(*resp.Content, `"hi there"`) {
+				t.Errorf("%s: body = %s, want a demo reply echoing the message", tt.name, rec.Body)
+			}
+			continue
+		}
+		var errResp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp.Error != "service_misconfigured" {
+			t.Errorf("%s: body = %s, want a service_misconfigured error", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestPostChatWrapsUserMessage(t *testing.T) {
+	tests := []struct {
+		prefix, suffix string
+		want           string
+	}{
+		{"", "", "What is Go?"},
+		{"Answer briefly.", "", "Answer briefly.\n\nWhat is Go?"},
+		{"", "Cite sources.", "What is Go?\n\nCite sources."},
+		{"Answer briefly.", "Cite sources.", "Answer briefly.\n\nWhat is Go?\n\nCite sources."},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(int, map[string]interface{}) string { return answer("Go is a language.") })
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.UserPromptPrefix, cfg.UserPromptSuffix = tt.prefix, tt.suffix
+		})
+
+		if rec := postChat(t, s, `{"message": "What is Go?"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+		}
+		messages := model.received()[0]["messages"].([]interface{})
+		last := messages[len(messages)-1].(map[string]interface{})
+		if last["role"] != "user" || last["content"] != tt.want {
+			t.Errorf("prefix %q, suffix %q: user message = %q, want %q", tt.prefix, tt.suffix, last["content"], tt.want)
+		}
+	}
+}
+
+// The search tool is replaced with a fixed result, so the chat loop can be run
+// end to end against a stub model
+func ExampleSetToolExecutor() {
+	restore := SetToolExecutor("search", func(arguments string) (string, bool) {
+		return `{"combined_answer": "sunny"}`, true
+	})
+	defer restore()
+
+	// The model searches first, then answers with what the search found
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if last := req.Messages[len(req.Messages)-1]; last["role"] == "tool" {
+			fmt.Fprint(w, answer(fmt.Sprintf("The search says %v", last["content"])))
+			return
+		}
+		fmt.Fprint(w, toolCalls([2]string{"search", `{"keywords": ["weather"]}`}))
+	}))
+	defer model.Close()
+
+	s, _ := NewServer("key", model.URL, nil, DefaultConfig())
+	rec := httptest.NewRecorder()
+	s.PostChat(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "Is it sunny?"}`)))
+
+	var resp ChatResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	fmt.Println(*resp.Content)
+	// Output: The search says {"combined_answer": "sunny"}
+}
+
+// roles lists the role of each message in a model request
+func roles(req map[string]interface{}) []string {
+	var out []string
+	for _, m := range req["messages"].([]interface{}) {
+		out = append(out, m.(map[string]interface{})["role"].(string))
+	}
+	return out
+}
+
+func TestChatToolLoop(t *testing.T) {
+	restore := SetToolExecutor("search", func(arguments string) (string, bool) {
+		return arguments, true
+	})
+	defer restore()
+
+	tests := []struct {
+		name       string
+		rounds     int // tool rounds before the model answers
+		wantStatus int
+		wantRoles  string // of the last model request
+	}{
+		{"no tools", 0, http.StatusOK, "user"},
+		{"one round", 1, http.StatusOK, "user,assistant,tool"},
+		{"several rounds", 3, http.StatusOK, "user,assistant,tool,assistant,tool,assistant,tool"},
+		{"hits the cap", 10, http.StatusBadGateway, "user,assistant,tool,assistant,tool,assistant,tool"},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			if n < tt.rounds {
+				return withUsage(toolCalls([2]string{"search", fmt.Sprintf(`{"keywords": ["round %d"]}`, n+1)}), 10, 1)
+			}
+			return withUsage(answer("done"), 10, 1)
+		})
+		const maxIterations = 4
+		webhook, events := newWebhookReceiver(t)
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.MaxToolIterations = maxIterations
+			cfg.CompletionWebhookURL = webhook
+		})
+
+		rec := postChat(t, s, `{"message": "hi"}`)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+			continue
+		}
+		received := model.received()
+		if want := min(tt.rounds+1, maxIterations); len(received) != want {
+			t.Errorf("%s: model called %d times, want %d", tt.name, len(received), want)
+		}
+		last := received[len(received)-1]
+		if got := strings.Join(roles(last), ","); got != tt.wantRoles {
+			t.Errorf("%s: last request roles = %s, want %s", tt.name, got, tt.wantRoles)
+		}
+		// Each tool message answers the call before it, in order
+		round := 0
+		for _, m := range last["messages"].([]interface{}) {
+			if msg := m.(map[string]interface{}); msg["role"] == "tool" {
+				round++
+				if want := fmt.Sprintf(`{"keywords": ["round %d"]}`, round); msg["content"] != want || msg["tool_call_id"] != "call_1" {
+					t.Errorf("%s: tool message %d = %v, want %s", tt.name, round, msg, want)
+				}
+			}
+		}
+		if tt.wantStatus == http.StatusOK {
+			var resp ChatResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil || *resp.Content != "done" {
+				t.Errorf("%s: body = %s, want the final answer", tt.name, rec.Body)
+			}
+			// Usage is summed over every model call of the loop
+			calls := len(received)
+			want := chatUsage{PromptTokens: 10 * calls, CompletionTokens: calls, TotalTokens: 11 * calls}
+			if ev, _ := nextCompletionEvent(t, events); ev.Usage != want || ev.ToolCount != tt.rounds {
+				t.Errorf("%s: usage %+v over %d tools, want %+v over %d", tt.name, ev.Usage, ev.ToolCount, want, tt.rounds)
+			}
+		}
+	}
+}
+
+func TestPostChatForwardsSeed(t *testing.T) {
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantSeed   interface{} // as decoded by the model, nil when absent
+	}{
+		{`{"message": "hi", "seed": 42}`, http.StatusOK, float64(42)},
+		{`{"message": "hi", "seed": 0}`, http.StatusOK, float64(0)},
+		{`{"message": "hi"}`, http.StatusOK, nil},
+		{`{"message": "hi", "seed": -1}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(int, map[string]interface{}) string { return answer("ok") })
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.body, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		seed, sent := model.received()[0]["seed"]
+		if seed != tt.wantSeed || sent != (tt.wantSeed != nil) {
+			t.Errorf("%s: upstream seed = %v (sent %v), want %v", tt.body, seed, sent, tt.wantSeed)
+		}
+	}
+}
+
+func TestPostChatIncludeToolOutputs(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		return `{"result": 1.609344}`, true
+	})
+	defer restore()
+
+	for _, include := range []bool{false, true} {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			if n == 0 {
+				return toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "mi", "to_unit": "km"}`})
+			}
+			return answer("1 mile is 1.61 km.")
+		})
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, fmt.Sprintf(`{"message": "1 mile in km?", "include_tool_outputs": %v}`, include))
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil || *resp.Content != "1 mile is 1.61 km." {
+			t.Fatalf("include %v: %d %s", include, rec.Code, rec.Body)
+		}
+		if !include {
+			if resp.ToolOutputs != nil {
+				t.Errorf("tool outputs returned without include_tool_outputs: %+v", *resp.ToolOutputs)
+			}
+			continue
+		}
+		want := []ToolOutput{{
+			ToolCallId: "call_1",
+			Name:       "convert_units",
+			Arguments:  `{"value": 1, "from_unit": "mi", "to_unit": "km"}`,
+			Output:     `{"result": 1.609344}`,
+			Success:    true,
+		}}
+		if resp.ToolOutputs == nil || !slices.Equal(*resp.ToolOutputs, want) {
+			t.Errorf("tool outputs = %+v, want %+v", resp.ToolOutputs, want)
+		}
+	}
+}
+
+func TestUpstreamErrorMessage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"<html><body><h1>502 Bad Gateway</h1><p>nginx/1.18</p></body></html>", "AI API error: upstream returned HTTP 502"},
+		{"", "AI API error: upstream returned HTTP 502"},
+		{"upstream connect error", "AI API error: upstream returned HTTP 502"},
+		{`{"error": {"message": "model overloaded", "type": "server_error"}}`, "AI API error: model overloaded"},
+		{`{"error": "rate limited"}`, "AI API error: rate limited"},
+		{`{"detail": [{"loc": ["body", "model"], "msg": "required"}]}`, `AI API error: [{"loc":["body","model"],"msg":"required"}]`},
+		{`{"message": "bad key"}`, "AI API error: bad key"},
+		{`{"status": "failed"}`, "AI API error: upstream returned HTTP 502"},
+	}
+	for _, tt := range tests {
+		if got := upstreamErrorMessage(http.StatusBadGateway, []byte(tt.body)); got != tt.want {
+			t.Errorf("upstreamErrorMessage(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestPostChatUpstreamHTMLError(t *testing.T) {
+	for _, body := range []string{"<html><title>Error</title><body>Internal stack trace</body></html>", ""} {
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(body))
+		}))
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, `{"message": "hi"}`)
+		model.Close()
+		var errResp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || rec.Code != http.StatusServiceUnavailable ||
+			errResp.Error != "upstream_error" || strings.Contains(rec.Body.String(), "stack trace") {
+			t.Errorf("upstream body %q: got %d %s, want a clean JSON upstream_error", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain text", "plain text"},
+		{"use `go test` *now*", "use \\`go test\\` \\*now\\*"},
+		{"# Title\n- item_1", "\\# Title\n\\- item\\_1"},
+		{"[link](http://x.y) <b>", "\\[link\\]\\(http://x\\.y\\) \\<b\\>"},
+		{`a\b`, `a\\b`},
+	}
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.in); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPostChatOutputFormat(t *testing.T) {
+	tests := []struct {
+		body        string
+		wantStatus  int
+		wantContent string
+	}{
+		{`{"message": "hi"}`, http.StatusOK, "**bold**"},
+		{`{"message": "hi", "output_format": "raw"}`, http.StatusOK, "**bold**"},
+		{`{"message": "hi", "output_format": "markdown_escaped"}`, http.StatusOK, `\*\*bold\*\*`},
+		{`{"message": "hi", "output_format": "html"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(int, map[string]interface{}) string { return answer("**bold**") })
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.body, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil {
+			t.Fatalf("%s: decoding %s: %v", tt.body, rec.Body, err)
+		}
+		if *resp.Content != tt.wantContent {
+			t.Errorf("%s: content = %q, want %q", tt.body, *resp.Content, tt.wantContent)
+		}
+	}
+}
+
+func TestPostChatLanguage(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		body       string
+		wantStatus int
+		wantCode   string // in the injected instruction, "" for none
+	}{
+		{"none", "", `{"message": "hi"}`, http.StatusOK, ""},
+		{"configured", "fr", `{"message": "hi"}`, http.StatusOK, "fr"},
+		{"per request", "", `{"message": "hi", "language": "pt-BR"}`, http.StatusOK, "pt-BR"},
+		{"request overrides config", "fr", `{"message": "hi", "language": "de"}`, http.StatusOK, "de"},
+		{"invalid", "", `{"message": "hi", "language": "French please"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(int, map[string]interface{}) string { return answer("ok") })
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.ResponseLanguage = tt.configured
+		})
+
+		rec := postChat(t, s, tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		messages := model.received()[0]["messages"].([]interface{})
+		content := messages[len(messages)-1].(map[string]interface{})["content"].(string)
+		if tt.wantCode == "" {
+			if content != "hi" {
+				t.Errorf("%s: user message = %q, want it unchanged", tt.name, content)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("Respond only in the language with code %q", tt.wantCode); !strings.HasPrefix(content, "hi\n\n") || !strings.Contains(content, want) {
+			t.Errorf("%s: user message = %q, want the message followed by %q", tt.name, content, want)
+		}
+	}
+}
+
+func TestPostChatEmptyAnswerRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		retries    int
+		answers    []string
+		wantStatus int
+		wantCalls  int
+	}{
+		{"retried once", 1, []string{"", "Here it is."}, http.StatusOK, 2},
+		{"whitespace counts as empty", 1, []string{" \n", "Here it is."}, http.StatusOK, 2},
+		{"still empty", 1, []string{"", ""}, http.StatusBadGateway, 2},
+		{"retries disabled", 0, []string{""}, http.StatusBadGateway, 1},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string { return answer(tt.answers[n]) })
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.EmptyAnswerRetries = tt.retries
+		})
+
+		rec := postChat(t, s, `{"message": "hi"}`)
+		if rec.Code != tt.wantStatus || len(model.received()) != tt.wantCalls {
+			t.Errorf("%s: status %d after %d calls, want %d after %d: %s", tt.name, rec.Code, len(model.received()), tt.wantStatus, tt.wantCalls, rec.Body)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			if !strings.Contains(rec.Body.String(), "no_answer") {
+				t.Errorf("%s: body = %s, want a no_answer error", tt.name, rec.Body)
+			}
+			continue
+		}
+		messages := model.received()[1]["messages"].([]interface{})
+		if nudge := messages[len(messages)-1].(map[string]interface{})["content"]; !strings.Contains(nudge.(string), "previous reply was empty") {
+			t.Errorf("%s: retry ended with %q, want the nudge", tt.name, nudge)
+		}
+	}
+}
+
+func TestPostChatAIRequestTimeout(t *testing.T) {
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			fmt.Fprint(w, answer("slow but fine"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer model.Close()
+
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		wantStatus int
+	}{
+		{"slow answer within the timeout", 5 * time.Second, http.StatusOK},
+		{"timed out", 20 * time.Millisecond, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.AIRequestTimeout = tt.timeout
+		})
+		rec := postChat(t, s, `{"message": "hi"}`)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if tt.wantStatus == http.StatusGatewayTimeout && !strings.Contains(rec.Body.String(), "upstream_timeout") {
+			t.Errorf("%s: body = %s, want an upstream_timeout error", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestPostChatJSONObject(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		answers    []string
+		wantStatus int
+		wantCalls  int
+	}{
+		{"text is not checked", `{"message": "hi"}`, []string{"not json"}, http.StatusOK, 1},
+		{"valid JSON", `{"message": "hi", "response_format": "json_object"}`, []string{`{"a": 1}`}, http.StatusOK, 1},
+		{"retried once", `{"message": "hi", "response_format": "json_object"}`, []string{"Sure! {a: 1}", `{"a": 1}`}, http.StatusOK, 2},
+		{"still invalid", `{"message": "hi", "response_format": "json_object"}`, []string{"nope", "still nope"}, http.StatusBadGateway, 2},
+		{"unknown format", `{"message": "hi", "response_format": "yaml"}`, nil, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string { return answer(tt.answers[n]) })
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, tt.body)
+		requests := model.received()
+		if rec.Code != tt.wantStatus || len(requests) != tt.wantCalls {
+			t.Errorf("%s: status %d after %d calls, want %d after %d: %s", tt.name, rec.Code, len(requests), tt.wantStatus, tt.wantCalls, rec.Body)
+			continue
+		}
+		jsonMode := strings.Contains(tt.body, "json_object")
+		for i, req := range requests {
+			format, _ := req["response_format"].(map[string]interface{})
+			if got := format["type"] == "json_object"; got != jsonMode {
+				t.Errorf("%s: call %d response_format = %v, want json_object forwarded %v", tt.name, i+1, req["response_format"], jsonMode)
+			}
+		}
+		if tt.wantStatus == http.StatusBadGateway && !strings.Contains(rec.Body.String(), "invalid_json") {
+			t.Errorf("%s: body = %s, want an invalid_json error", tt.name, rec.Body)
+		}
+		if tt.wantStatus == http.StatusOK && jsonMode {
+			var resp ChatResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil || *resp.Content != `{"a": 1}` {
+				t.Errorf("%s: content = %v, want the JSON answer", tt.name, resp.Content)
+			}
+		}
+	}
+}
+
+func TestPostChatMaxRuntime(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		time.Sleep(30 * time.Millisecond)
+		return `{"result": 1}`, true
+	})
+	defer restore()
+
+	// The model never stops calling the slow tool
+	busyModel := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "m", "to_unit": "m"}`})
+	})
+	slowModel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body) // so the server notices the caller leaving
+		select {
+		case <-time.After(5 * time.Second):
+			fmt.Fprint(w, answer("too late"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowModel.Close()
+
+	for _, tt := range []struct {
+		name    string
+		baseURL string
+	}{
+		{"slow tools", busyModel.URL},
+		{"slow model call", slowModel.URL},
+	} {
+		s := newTestServer(t, tt.baseURL, func(cfg *Config) {
+			cfg.ChatMaxRuntime = 100 * time.Millisecond
+			cfg.MaxToolIterations = 100
+		})
+		start := time.Now()
+		rec := postChat(t, s, `{"message": "convert forever"}`)
+		if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "chat_timeout") {
+			t.Errorf("%s: status %d, body %s, want a 504 chat_timeout", tt.name, rec.Code, rec.Body)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: chat took %s, want it stopped near the 100ms ceiling", tt.name, elapsed)
+		}
+	}
+}
+
+func TestPostChatAssistantPrefill(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		return `{"result": 1.609344}`, true
+	})
+	defer restore()
+
+	const prefill = "| Miles | Kilometres |\n"
+	tests := []struct {
+		name      string
+		toolRound bool
+		wantCalls int
+	}{
+		// The draft answer is replaced by a prefilled call without tools
+		{"after a tool round", true, 3},
+		{"answered without tools", false, 2},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			if tt.toolRound && n == 0 {
+				return toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "mi", "to_unit": "km"}`})
+			}
+			return answer("| 1 | 1.61 |")
+		})
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, `{"message": "1 mile in km, as a table", "assistant_prefill": "| Miles | Kilometres |\n"}`)
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil {
+			t.Fatalf("%s: %d %s", tt.name, rec.Code, rec.Body)
+		}
+		if want := prefill + "| 1 | 1.61 |"; *resp.Content != want {
+			t.Errorf("%s: content = %q, want %q", tt.name, *resp.Content, want)
+		}
+
+		requests := model.received()
+		if len(requests) != tt.wantCalls {
+			t.Fatalf("%s: %d model calls, want %d", tt.name, len(requests), tt.wantCalls)
+		}
+		for i, req := range requests {
+			messages := req["messages"].([]interface{})
+			last := messages[len(messages)-1].(map[string]interface{})
+			isLast := i == len(requests)-1
+			if prefilled := last["role"] == "assistant" && last["content"] == prefill; prefilled != isLast {
+				t.Errorf("%s: call %d ends with %v, want the prefill only on the final call", tt.name, i+1, last)
+			}
+			if isLast && req["tools"] != nil {
+				t.Errorf("%s: the prefilled call offered tools", tt.name)
+			}
+		}
+	}
+}
+
+func TestDecodeMessageContent(t *testing.T) {
+	// want is "<nil>" when no content should be returned
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{``, "<nil>"},
+		{`null`, "<nil>"},
+		{`"plain"`, "plain"},
+		{`""`, ""},
+		{`[{"type": "text", "text": "Hello"}, {"type": "annotation", "url": "https://go.dev"}, {"type": "output_text", "text": "world"}]`, "Hello\nworld"},
+		{`[{"type": "refusal", "refusal": "I can't help with that."}]`, "I can't help with that."},
+		{`[{"type": "annotation"}]`, "<nil>"},
+		{`[]`, "<nil>"},
+	}
+	for _, tt := range tests {
+		content, err := decodeMessageContent(json.RawMessage(tt.raw))
+		if err != nil {
+			t.Errorf("decodeMessageContent(%s): %v", tt.raw, err)
+			continue
+		}
+		got := "<nil>"
+		if content != nil {
+			got = *content
+		}
+		if got != tt.want {
+			t.Errorf("decodeMessageContent(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+	if _, err := decodeMessageContent(json.RawMessage(`{"text": "an object"}`)); err == nil {
+		t.Error("an object should be refused")
+	}
+}
+
+func TestPostChatArrayContent(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return `{"choices": [{"message": {"role": "assistant", "content": [
+			{"type": "text", "text": "Go is a programming language."},
+			{"type": "annotation", "annotation": {"url": "https://go.dev"}},
+			{"type": "text", "text": "It was designed at Google."}
+		]}, "finish_reason": "stop"}]}`
+	})
+	s := newTestServer(t, model.URL, nil)
+
+	rec := postChat(t, s, `{"message": "What is Go?"}`)
+	var resp ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if want := "Go is a programming language.\nIt was designed at Google."; *resp.Content != want {
+		t.Errorf("content = %q, want %q", *resp.Content, want)
+	}
+}
+
+func TestPostChatIncludeTurns(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		return `{"result": 1.609344}`, true
+	})
+	defer restore()
+
+	const mi, km = `{"value": 1, "from_unit": "mi", "to_unit": "km"}`, `{"value": 1, "from_unit": "km", "to_unit": "mi"}`
+	for _, include := range []bool{false, true} {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			switch n {
+			case 0:
+				// A tool-calling turn that also says something
+				reply := toolCalls([2]string{"convert_units", mi})
+				return strings.Replace(reply, `"content":null`, `"content":"Let me convert that."`, 1)
+			case 1:
+				return toolCalls([2]string{"convert_units", km}, [2]string{"convert_units", mi})
+			}
+			return answer("1 mile is 1.61 km.")
+		})
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, fmt.Sprintf(`{"message": "1 mile in km?", "include_turns": %v}`, include))
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("include %v: %d %s", include, rec.Code, rec.Body)
+		}
+		if !include {
+			if resp.Turns != nil || strings.Contains(rec.Body.String(), `"turns"`) {
+				t.Errorf("turns returned without include_turns: %s", rec.Body)
+			}
+			continue
+		}
+
+		if resp.Turns == nil {
+			t.Fatalf("no turns in %s", rec.Body)
+		}
+		var got []string
+		for _, turn := range *resp.Turns {
+			desc := "-"
+			if turn.Content != nil {
+				desc = *turn.Content
+			}
+			if turn.ToolCalls != nil {
+				for _, tc := range *turn.ToolCalls {
+					desc += fmt.Sprintf(" %s:%s(%s)", tc.Id, tc.Function.Name, tc.Function.Arguments)
+				}
+			}
+			got = append(got, desc)
+		}
+		want := []string{
+			"Let me convert that. call_1:convert_units(" + mi + ")",
+			"- call_1:convert_units(" + km + ") call_2:convert_units(" + mi + ")",
+			"1 mile is 1.61 km.",
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("turns =\n%q\nwant\n%q", got, want)
+		}
+	}
+}
+
+func TestPostChatFinishReason(t *testing.T) {
+	tests := []struct {
+		reason   string // "" leaves finish_reason out of the model's reply
+		wantHint bool
+	}{
+		{"stop", false},
+		{"length", true},
+		{"content_filter", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			choice := map[string]interface{}{"message": map[string]interface{}{"role": "assistant", "content": "An answer"}}
+			if tt.reason != "" {
+				choice["finish_reason"] = tt.reason
+			}
+			b, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{choice}})
+			return string(b)
+		})
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, `{"message": "hi"}`)
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d, body %s: %v", tt.reason, rec.Code, rec.Body, err)
+		}
+		if tt.reason == "" {
+			if resp.FinishReason != nil {
+				t.Errorf("no reason given: finish_reason = %q, want it left out", *resp.FinishReason)
+			}
+		} else if resp.FinishReason == nil || *resp.FinishReason != tt.reason {
+			t.Errorf("%q: finish_reason = %v", tt.reason, resp.FinishReason)
+		}
+		if gotHint := resp.Hint != nil && strings.Contains(*resp.Hint, "truncated"); gotHint != tt.wantHint {
+			t.Errorf("%q: hint = %v, want a truncation hint %v", tt.reason, resp.Hint, tt.wantHint)
+		}
+	}
+}
+
+func TestPostChatAutoContinue(t *testing.T) {
+	parts := []string{"The quick ", "brown fox ", "jumps."}
+	truncated := func(n int, req map[string]interface{}) string {
+		reason := "length"
+		if n == len(parts)-1 {
+			reason = "stop"
+		}
+		b, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{
+			"message":       map[string]interface{}{"role": "assistant", "content": parts[n]},
+			"finish_reason": reason,
+		}}})
+		return string(b)
+	}
+
+	tests := []struct {
+		name             string
+		body             string
+		maxContinuations int
+		want             string
+		wantCalls        int
+		wantReason       string
+	}{
+		{"continued", `{"message": "hi", "auto_continue": true}`, 3, "The quick brown fox jumps.", 3, "stop"},
+		{"not asked", `{"message": "hi"}`, 3, "The quick", 1, "length"},
+		{"continuation limit", `{"message": "hi", "auto_continue": true}`, 1, "The quick brown fox", 2, "length"},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, truncated)
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.MaxContinuations = tt.maxContinuations
+		})
+
+		rec := postChat(t, s, tt.body)
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s: %v", tt.name, rec.Code, rec.Body, err)
+		}
+		if resp.Content == nil || *resp.Content != tt.want {
+			t.Errorf("%s: content = %v, want %q", tt.name, resp.Content, tt.want)
+		}
+		if resp.FinishReason == nil || *resp.FinishReason != tt.wantReason {
+			t.Errorf("%s: finish_reason = %v, want %s", tt.name, resp.FinishReason, tt.wantReason)
+		}
+		if gotHint := resp.Hint != nil; gotHint != (tt.wantReason == "length") {
+			t.Errorf("%s: hint = %v, want one only for a truncated answer", tt.name, resp.Hint)
+		}
+
+		requests := model.received()
+		if len(requests) != tt.wantCalls {
+			t.Fatalf("%s: %d model calls, want %d", tt.name, len(requests), tt.wantCalls)
+		}
+		// Each resumption carries the part so far and asks the model to continue
+		for i, req := range requests[1:] {
+			got := roles(req)
+			if len(got) < 2 || got[len(got)-2] != "assistant" || got[len(got)-1] != "user" {
+				t.Fatalf("%s: resumption %d roles = %v, want the answer so far then the instruction", tt.name, i+1, got)
+			}
+			messages := req["messages"].([]interface{})
+			prior := messages[len(messages)-2].(map[string]interface{})["content"]
+			instruction := messages[len(messages)-1].(map[string]interface{})["content"]
+			if prior != parts[i] || instruction != continueInstruction {
+				t.Errorf("%s: resumption %d sent %q then %q", tt.name, i+1, prior, instruction)
+			}
+		}
+	}
+}
+
+func TestPostChatStopSequences(t *testing.T) {
+	restore := SetToolExecutor("convert_units", func(arguments string) (string, bool) {
+		return `{"result": 1.609344}`, true
+	})
+	defer restore()
+
+	tests := []struct {
+		name string
+		stop string
+		want []interface{}
+	}{
+		{"array", `["END", "\n\n"]`, []interface{}{"END", "\n\n"}},
+		{"single string", `"END"`, []interface{}{"END"}},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			if n == 0 {
+				return toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "mi", "to_unit": "km"}`})
+			}
+			return answer("1.61 km")
+		})
+		s := newTestServer(t, model.URL, nil)
+
+		rec := postChat(t, s, `{"message": "1 mile in km?", "stop": `+tt.stop+`}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", tt.name, rec.Code, rec.Body)
+		}
+		// The tool-choosing call and the draft answer go without stop sequences;
+		// the draft is then replaced by a synthesis call carrying them
+		requests := model.received()
+		if len(requests) != 3 {
+			t.Fatalf("%s: %d model calls, want 3", tt.name, len(requests))
+		}
+		for i, req := range requests[:2] {
+			if _, ok := req["stop"]; ok {
+				t.Errorf("%s: call %d sent stop %v while tools were on offer", tt.name, i+1, req["stop"])
+			}
+		}
+		final := requests[2]
+		if got, _ := final["stop"].([]interface{}); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: synthesis call stop = %v, want %q", tt.name, final["stop"], tt.want)
+		}
+		if _, ok := final["tools"]; ok {
+			t.Errorf("%s: synthesis call offered tools", tt.name)
+		}
+	}
+
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	for _, stop := range []string{`["a", "b", "c", "d", "e"]`, `[]`, `[""]`, `""`, `42`} {
+		rec := postChat(t, s, `{"message": "hi", "stop": `+stop+`}`)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_stop") {
+			t.Errorf("stop %s: status %d, body %s, want 400 invalid_stop", stop, rec.Code, rec.Body)
+		}
+	}
+}
+
+// Regression: a chat that outlasted the server's WriteTimeout lost its answer,
+// as the connection was closed before the response could be written
+func TestPostChatOutlastsWriteTimeout(t *testing.T) {
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Slow answer."}, "finish_reason": "stop"}]}`))
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(s.PostChat))
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/chat", "application/json", strings.NewReader(`{"message": "hi"}`))
+	if err != nil {
+		t.Fatalf("POST /chat: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the answer: %v", err)
+	}
+
+	var chat ChatResponse
+	if err := json.Unmarshal(body, &chat); err != nil || chat.Content == nil || *chat.Content != "Slow answer." {
+		t.Errorf("status %d, body %s, want the slow answer", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import "encoding/json"
+
+// citationsFromToolOutputs lists the sources fed to the model during a chat: search
+// results, pages read and feeds fetched by successful tool calls, in call order and
+// without duplicate URLs. It is derived from the tool results, not the answer text.
+func citationsFromToolOutputs(outputs []ToolOutput) []Citation {
+	citations := []Citation{}
+	seen := map[string]bool{}
+	add := func(tool, rawURL, title string) {
+		if rawURL == "" || seen[rawURL] {
+			return
+		}
+		seen[rawURL] = true
+		c := Citation{Tool: tool, Url: rawURL}
+		if title != "" {
+			c.Title = &title
+		}
+		citations = append(citations, c)
+	}
+
+	for _, out := range outputs {
+		if !out.Success {
+			continue
+		}
+		switch out.Name {
+		case "search":
+			var resp SearchResponse
+			if json.Unmarshal([]byte(out.Output), &resp) != nil {
+				continue
+			}
+			mapSearchResults(&resp, func(results []interface{}) []interface{} {
+				for _, r := range results {
+					title := ""
+					if item, ok := r.(map[string]interface{}); ok {
+						title, _ = item["title"].(string)
+					}
+					add(out.Name, searchResultURL(r), title)
+				}
+				return results
+			})
+
+		case "read_page", "read_pages":
+			var resp PageReaderResponse
+			if json.Unmarshal([]byte(out.Output), &resp) != nil {
+				continue
+			}
+			pages := []PageReaderResult{{Url: resp.Url, FinalUrl: resp.FinalUrl, Error: resp.Error}}
+			if resp.Results != nil {
+				pages = *resp.Results
+			}
+			for _, p := range pages {
+				if p.Error != nil {
+					continue
+				}
+				if p.FinalUrl != nil {
+					add(out.Name, *p.FinalUrl, "")
+				} else if p.Url != nil {
+					add(out.Name, *p.Url, "")
+				}
+			}
+
+		case "extract_from_page":
+			var page pageExtraction
+			if json.Unmarshal([]byte(out.Output), &page) != nil {
+				continue
+			}
+			add(out.Name, page.Url, page.Metadata["title"])
+
+		case "read_feed":
+			var feed feedResult
+			if json.Unmarshal([]byte(out.Output), &feed) != nil {
+				continue
+			}
+			add(out.Name, feed.Url, feed.Title)
+		}
+	}
+	return citations
+}
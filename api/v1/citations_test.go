@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCitationsFromToolOutputs(t *testing.T) {
+	title := func(s string) *string { return &s }
+	outputs := []ToolOutput{
+		{Name: "search", Success: true, Output: `{"queries": [{"keyword": "go", "response": {"results": [
+			{"url": "https://go.dev", "title": "The Go Programming Language"},
+			{"url": "https://pkg.go.dev"}]}}]}`},
+		{Name: "read_page", Success: true, Output: `{"url": "https://go.dev/old", "final_url": "https://go.dev/new", "content": "..."}`},
+		{Name: "read_pages", Success: true, Output: `{"results": [
+			{"url": "https://go.dev", "content": "..."},
+			{"url": "https://broken.example", "error": "status 404"},
+			{"url": "https://go.dev/blog", "content": "..."}]}`},
+		{Name: "read_page", Success: false, Output: `{"url": "https://failed.example"}`},
+		{Name: "extract_from_page", Success: true, Output: `{"url": "https://go.dev/doc", "extract": "text", "metadata": {"title": "Documentation"}}`},
+		{Name: "read_feed", Success: true, Output: `{"url": "https://go.dev/blog/feed.atom", "title": "The Go Blog", "format": "atom", "entries": []}`},
+		{Name: "convert_units", Success: true, Output: `{"result": 1}`},
+		{Name: "search", Success: true, Output: `not json`},
+	}
+	want := []Citation{
+		{Tool: "search", Url: "https://go.dev", Title: title("The Go Programming Language")},
+		{Tool: "search", Url: "https://pkg.go.dev"},
+		{Tool: "read_page", Url: "https://go.dev/new"},
+		{Tool: "read_pages", Url: "https://go.dev/blog"},
+		{Tool: "extract_from_page", Url: "https://go.dev/doc", Title: title("Documentation")},
+		{Tool: "read_feed", Url: "https://go.dev/blog/feed.atom", Title: title("The Go Blog")},
+	}
+	if got := citationsFromToolOutputs(outputs); !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		t.Errorf("citations = %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestPostChatCitations(t *testing.T) {
+	restore := SetToolExecutor("search", func(arguments string) (string, bool) {
+		return `{"queries": [{"keyword": "go", "response": {"results": [{"url": "https://go.dev", "title": "Go"}]}}]}`, true
+	})
+	defer restore()
+
+	for _, include := range []bool{false, true} {
+		model := newModelStub(t, func(n int, req map[string]interface{}) string {
+			if n == 0 {
+				return toolCalls([2]string{"search", `{"keywords": ["go"]}`})
+			}
+			return answer("Go is at go.dev.")
+		})
+		s := newTestServer(t, model.URL, nil)
+
+		body := `{"message": "Where is Go?"}`
+		if include {
+			body = `{"message": "Where is Go?", "include_citations": true}`
+		}
+		rec := postChat(t, s, body)
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil {
+			t.Fatalf("include %v: %d %s", include, rec.Code, rec.Body)
+		}
+		if !include {
+			if resp.Citations != nil {
+				t.Errorf("citations returned without include_citations: %+v", *resp.Citations)
+			}
+			continue
+		}
+		if resp.Citations == nil || len(*resp.Citations) != 1 || (*resp.Citations)[0].Url != "https://go.dev" || (*resp.Citations)[0].Tool != "search" {
+			t.Errorf("citations = %+v, want the searched go.dev result", resp.Citations)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// clientLimiter counts in-flight requests per client and refuses new ones past max
+type clientLimiter struct {
+	mu       sync.Mutex
+	max      int
+	inFlight map[string]int
+}
+
+func newClientLimiter(max int) *clientLimiter {
+	return &clientLimiter{max: max, inFlight: make(map[string]int)}
+}
+
+// acquire takes a slot for client, reporting false if the client is already at the limit
+func (l *clientLimiter) acquire(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[client] >= l.max {
+		return false
+	}
+	l.inFlight[client]++
+	return true
+}
+
+// release frees a slot taken by acquire
+func (l *clientLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[client] <= 1 {
+		delete(l.inFlight, client)
+		return
+	}
+	l.inFlight[client]--
+}
+
+// clientKey identifies the caller for per-client limits: by its bearer token
+// (hashed, so tokens are never held in memory or logged) when that is a known
+// token, one with a MODEL_ACCESS_FILE entry, and by remote IP otherwise. Unknown
+// tokens are ignored, so a caller can't get a fresh allowance by sending a new
+// random token with each request.
+func (s *Server) clientKey(r *http.Request) string {
+	if _, known := s.cfg.ModelAccess[bearerToken(r)]; known {
+		return "token:" + tokenHash(r)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// bearerToken returns the request's bearer token, or "" without one
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// tokenHash returns a short hash of the request's bearer token, or "" without one
+func tokenHash(r *http.Request) string {
+	token := bearerToken(r)
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// clientIP returns the host part of the request's remote address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isChatRequest reports whether r runs the chat tool loop
+func isChatRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && (r.URL.Path == "/chat" || strings.HasPrefix(r.URL.Path, "/chat/template/"))
+}
+
+// LimitConcurrentChats is middleware that rejects a client's chat requests with
+// 429 while it already has MaxConcurrentChatsPerClient chats in flight. Clients
+// are told apart by clientKey. Other requests pass through untouched.
+func (s *Server) LimitConcurrentChats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isChatRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := s.clientKey(r)
+		if !s.chatLimiter.acquire(client) {
+			log.Printf("%s[/chat] Rejecting request from %s: %d chats already in flight%s", colorRed, client, s.cfg.MaxConcurrentChatsPerClient, colorReset)
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, http.StatusTooManyRequests, "too_many_concurrent_chats",
+				fmt.Sprintf("At most %d concurrent chats are allowed per client", s.cfg.MaxConcurrentChatsPerClient))
+			return
+		}
+		// Deferred so the slot is returned even if the handler panics
+		defer s.chatLimiter.release(client)
+
+		next.ServeHTTP(w, r)
+	})
+}
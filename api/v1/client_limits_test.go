@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func chatRequestFrom(remoteAddr, token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	r.RemoteAddr = remoteAddr
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestClientLimiter(t *testing.T) {
+	l := newClientLimiter(2)
+	if !l.acquire("a") || !l.acquire("a") {
+		t.Fatal("first two acquires should succeed")
+	}
+	if l.acquire("a") {
+		t.Error("third acquire should fail at max 2")
+	}
+	if !l.acquire("b") {
+		t.Error("another client should have its own slots")
+	}
+	l.release("a")
+	if !l.acquire("a") {
+		t.Error("acquire should succeed after a release")
+	}
+	l.release("a")
+	l.release("a")
+	l.release("b")
+	if len(l.inFlight) != 0 {
+		t.Errorf("released clients should be forgotten, got %v", l.inFlight)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.ModelAccess = map[string][]string{"team-a": {"gpt-5"}}
+	})
+
+	known := s.clientKey(chatRequestFrom("203.0.113.7:1000", "team-a"))
+	if known != "token:"+tokenHash(chatRequestFrom("", "team-a")) {
+		t.Errorf("known token key = %q, want the token's hash", known)
+	}
+	if other := s.clientKey(chatRequestFrom("198.51.100.1:2000", "team-a")); other != known {
+		t.Errorf("known token from another IP = %q, want %q", other, known)
+	}
+
+	byIP := s.clientKey(chatRequestFrom("203.0.113.7:1000", ""))
+	if byIP != "ip:203.0.113.7" {
+		t.Errorf("no token key = %q, want ip:203.0.113.7", byIP)
+	}
+	for _, token := range []string{"random-1", "random-2"} {
+		if got := s.clientKey(chatRequestFrom("203.0.113.7:3000", token)); got != byIP {
+			t.Errorf("unknown token %q key = %q, want the IP key %q", token, got, byIP)
+		}
+	}
+}
+
+func TestLimitConcurrentChats(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.MaxConcurrentChatsPerClient = 1
+		cfg.ModelAccess = map[string][]string{"team-a": {"gpt-5"}}
+	})
+
+	// The first chat holds its slot until release is closed
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := s.LimitConcurrentChats(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hold") != "" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	held := chatRequestFrom("203.0.113.7:1000", "random-1")
+	held.Header.Set("X-Hold", "1")
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, held)
+		done <- rec.Code
+	}()
+	<-entered
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"same IP, new random token", chatRequestFrom("203.0.113.7:1001", "random-2"), http.StatusTooManyRequests},
+		{"same IP, no token", chatRequestFrom("203.0.113.7:1002", ""), http.StatusTooManyRequests},
+		{"same IP, known token", chatRequestFrom("203.0.113.7:1003", "team-a"), http.StatusOK},
+		{"other IP", chatRequestFrom("198.51.100.1:1000", ""), http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, tt.req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	nonChat := httptest.NewRequest(http.MethodGet, "/features", nil)
+	nonChat.RemoteAddr = "203.0.113.7:1004"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, nonChat)
+	if rec.Code != http.StatusOK {
+		t.Errorf("non-chat request: status = %d, want 200", rec.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("held chat: status = %d, want 200", code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, chatRequestFrom("203.0.113.7:1005", ""))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after the slot is released: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLimitConcurrentChatsReleasesOnPanic(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.MaxConcurrentChatsPerClient = 1
+	})
+	handler := s.LimitConcurrentChats(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), chatRequestFrom("203.0.113.7:1000", ""))
+	}()
+	if len(s.chatLimiter.inFlight) != 0 {
+		t.Errorf("slot still held after a panic: %v", s.chatLimiter.inFlight)
+	}
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// commandPolicy restricts the arguments a whitelisted command may take
+type commandPolicy struct {
+	// shortFlags are the single-letter flags allowed, alone or combined (e.g. -la)
+	shortFlags string
+	// longFlags are the allowed --flags
+	longFlags map[string]bool
+	// maxOperands caps the non-flag arguments; -1 means unlimited
+	maxOperands int
+}
+
+// Whitelisted commands for run_command and the arguments each may take
+var commandPolicies = map[string]commandPolicy{
+	"ls": {
+		shortFlags:  "1aAdhlrRStF",
+		longFlags:   map[string]bool{"--all": true, "--almost-all": true, "--human-readable": true, "--recursive": true, "--reverse": true},
+		maxOperands: -1,
+	},
+	"cd": {
+		maxOperands: 1,
+	},
+}
+
+// allowedCommandNames returns the whitelisted command names in sorted order
+func allowedCommandNames() []string {
+	names := make([]string, 0, len(commandPolicies))
+	for name := range commandPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describe summarizes the arguments command accepts, for the run_command tool
+// description, e.g. "ls (flags: -a -l, --all; any number of paths)"
+func (p commandPolicy) describe(command string) string {
+	var flags []string
+	for _, c := range p.shortFlags {
+		flags = append(flags, "-"+string(c))
+	}
+	longFlags := make([]string, 0, len(p.longFlags))
+	for name := range p.longFlags {
+		longFlags = append(longFlags, name)
+	}
+	sort.Strings(longFlags)
+	flags = append(flags, longFlags...)
+
+	flagText := "no flags"
+	if len(flags) > 0 {
+		flagText = "flags: " + strings.Join(flags, " ")
+	}
+	operandText := "any number of paths"
+	switch {
+	case p.maxOperands == 0:
+		operandText = "no paths"
+	case p.maxOperands == 1:
+		operandText = "at most 1 path"
+	case p.maxOperands > 1:
+		operandText = fmt.Sprintf("at most %d paths", p.maxOperands)
+	}
+	return fmt.Sprintf("%s (%s; %s)", command, flagText, operandText)
+}
+
+// describeCommandPolicies lists every whitelisted command with the arguments it accepts
+func describeCommandPolicies() string {
+	var parts []string
+	for _, name := range allowedCommandNames() {
+		parts = append(parts, commandPolicies[name].describe(name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// check validates args against the policy. Everything after a "--" is treated
+// as an operand, as the commands themselves do.
+func (p commandPolicy) check(command string, args []string) error {
+	operands := 0
+	flagsDone := false
+	for _, arg := range args {
+		switch {
+		case flagsDone || arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands++
+		case arg == "--":
+			flagsDone = true
+		case strings.HasPrefix(arg, "--"):
+			name := strings.SplitN(arg, "=", 2)[0]
+			if !p.longFlags[name] {
+				return fmt.Errorf("argument not allowed for %s: %s", command, arg)
+			}
+		default:
+			for _, c := range arg[1:] {
+				if !strings.ContainsRune(p.shortFlags, c) {
+					return fmt.Errorf("argument not allowed for %s: %s (flag -%c)", command, arg, c)
+				}
+			}
+		}
+	}
+	if p.maxOperands >= 0 && operands > p.maxOperands {
+		return fmt.Errorf("%s takes at most %d argument(s), got %d", command, p.maxOperands, operands)
+	}
+	return nil
+}
+
+// splitShellWords splits a command line into words the way a POSIX shell would
+// for simple commands: whitespace separates words, single quotes preserve text
+// literally, double quotes allow backslash-escaping of " and \, and an unquoted
+// backslash escapes the next character. No expansion of any kind is performed.
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\'):
+				i++
+				word.WriteRune(runes[i])
+			default:
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("trailing backslash in command")
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// checkDeniedPaths rejects any non-flag argument that resolves to a denied path or
+// something beneath it. Relative paths are resolved against dir, the directory the
+// command runs in, "~" against the home directory, and symlinks are followed where
+// they exist, so "../../etc" or a link into a denied tree is caught too. A
+// recursive listing (-R or --recursive) reaches everything below its operands, so
+// it is also rejected when an operand, or dir when there is none, holds a denied
+// path.
+func checkDeniedPaths(args []string, denied []string, dir string) error {
+	if len(denied) == 0 {
+		return nil
+	}
+
+	var operands []string
+	recursive := false
+	flagsDone := false
+	for _, arg := range args {
+		switch {
+		case flagsDone || arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands = append(operands, arg)
+		case arg == "--":
+			flagsDone = true
+		case arg == "--recursive":
+			recursive = true
+		case !strings.HasPrefix(arg, "--") && strings.ContainsRune(arg[1:], 'R'):
+			recursive = true
+		}
+	}
+	if recursive && len(operands) == 0 {
+		operands = []string{"."}
+	}
+
+	for _, arg := range operands {
+		resolved := resolveCommandPath(arg, dir)
+		for _, d := range denied {
+			d = resolveCommandPath(d, dir)
+			if pathWithin(resolved, d) {
+				return fmt.Errorf("path not allowed: %s", arg)
+			}
+			if recursive && pathWithin(d, resolved) {
+				return fmt.Errorf("path not allowed: a recursive listing of %s would include a denied path", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// pathWithin reports whether the clean absolute path p is dir or lies beneath it
+func pathWithin(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// resolveCommandPath turns p into a clean absolute path, relative to dir,
+// expanding a leading "~" and following symlinks when the path exists
+func resolveCommandPath(p, dir string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	if real, err := filepath.EvalSymlinks(p); err == nil {
+		p = real
+	}
+	return filepath.Clean(p)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommandPolicyCheck(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		wantErr bool
+	}{
+		{"ls", nil, false},
+		{"ls", []string{"-la", "/tmp", "/var"}, false},
+		{"ls", []string{"--all", "--human-readable"}, false},
+		{"ls", []string{"-x"}, true},
+		{"ls", []string{"-lax"}, true},
+		{"ls", []string{"--color=always"}, true},
+		{"ls", []string{"--", "-x"}, false},
+		{"cd", []string{"/tmp"}, false},
+		{"cd", []string{"/tmp", "/var"}, true},
+		{"cd", []string{"-P", "/tmp"}, true},
+		{"cd", []string{"-"}, false},
+	}
+	for _, tt := range tests {
+		err := commandPolicies[tt.command].check(tt.command, tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: error = %v, want error %v", tt.command, tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "ls -la /tmp", want: []string{"ls", "-la", "/tmp"}},
+		{line: "  ls\t-l  \n", want: []string{"ls", "-l"}},
+		{line: "ls 'my dir'", want: []string{"ls", "my dir"}},
+		{line: `ls "my dir"`, want: []string{"ls", "my dir"}},
+		{line: `ls my\ dir`, want: []string{"ls", "my dir"}},
+		{line: `ls 'a'"b"c`, want: []string{"ls", "abc"}},
+		{line: `ls "say \"hi\" \\ \n"`, want: []string{"ls", `say "hi" \ \n`}},
+		{line: `ls '$HOME' * ; rm`, want: []string{"ls", "$HOME", "*", ";", "rm"}},
+		{line: `ls ''`, want: []string{"ls", ""}},
+		{line: "", want: nil},
+		{line: "ls 'open", wantErr: true},
+		{line: `ls "open`, wantErr: true},
+		{line: `ls \`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitShellWords(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitShellWords(%q) error = %v, want error %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeCommandPolicies(t *testing.T) {
+	desc := describeCommandPolicies()
+	for _, name := range allowedCommandNames() {
+		if !strings.Contains(desc, name+" (") {
+			t.Errorf("description %q does not mention %s", desc, name)
+		}
+	}
+	for _, want := range []string{"-R", "--recursive", "at most 1 path"} {
+		if !strings.Contains(desc, want) {
+			t.Errorf("description %q does not mention %s", desc, want)
+		}
+	}
+}
+
+func TestCheckDeniedPaths(t *testing.T) {
+	root := t.TempDir()
+	secret := filepath.Join(root, "home", "secret")
+	public := filepath.Join(root, "public")
+	for _, dir := range []string{secret, public} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(public, "link")); err != nil {
+		t.Fatal(err)
+	}
+	denied := []string{secret}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"unrelated path", []string{"-la", public}, false},
+		{"denied path", []string{secret}, true},
+		{"beneath denied path", []string{filepath.Join(secret, "keys")}, true},
+		{"relative escape", []string{"../home/secret"}, true},
+		{"symlink into denied tree", []string{"link"}, true},
+		{"operand after --", []string{"--", secret}, true},
+		{"ancestor without recursion", []string{root}, false},
+		{"no operands", []string{"-l"}, false},
+
+		// Regression: -R on an ancestor used to list the denied tree
+		{"recursive ancestor", []string{"-R", root}, true},
+		{"recursive filesystem root", []string{"-R", "/"}, true},
+		{"combined recursive flag", []string{"-laR", filepath.Join(root, "home")}, true},
+		{"long recursive flag", []string{"--recursive", root}, true},
+		{"recursive from unrelated dir", []string{"-R"}, false},
+		{"recursive unrelated path", []string{"-R", public}, false},
+		{"recursive flag after --", []string{"--", "-R"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDeniedPaths(tt.args, denied, public)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDeniedPaths(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("recursive listing of the working directory", func(t *testing.T) {
+		if err := checkDeniedPaths([]string{"-R"}, denied, root); err == nil || !strings.Contains(err.Error(), "recursive") {
+			t.Errorf("bare -R from an ancestor of a denied path: error = %v, want a recursive listing error", err)
+		}
+	})
+
+	t.Run("no denylist", func(t *testing.T) {
+		if err := checkDeniedPaths([]string{"-R", "/"}, nil, public); err != nil {
+			t.Errorf("error = %v with no denied paths", err)
+		}
+	})
+}
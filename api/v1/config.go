@@ -0,0 +1,964 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every server setting. It is loaded once at startup by LoadConfig
+// and passed into NewServer; handlers never read the environment directly.
+type Config struct {
+	// ListenAddr is the address the HTTP server binds to (LISTEN_ADDR)
+	ListenAddr string
+
+	// TLSCertFile and TLSKeyFile enable HTTPS (and HTTP/2) when both are set (TLS_CERT_FILE, TLS_KEY_FILE)
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound the HTTP server's
+	// connection handling (READ_HEADER_TIMEOUT_SECONDS, READ_TIMEOUT_SECONDS,
+	// WRITE_TIMEOUT_SECONDS, IDLE_TIMEOUT_SECONDS). Streaming endpoints lift the write
+	// deadline for their own response, and chats extend it past ChatMaxRuntime.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// InternalBaseURL is how chat tool calls reach this server's own endpoints.
+	// Derived from ListenAddr unless set explicitly (INTERNAL_BASE_URL).
+	InternalBaseURL string
+
+	// APIKey authenticates calls to the AI Builder API (API_KEY)
+	APIKey string
+
+	// AIBaseURL is the AI Builder API root used for chat and search (AI_BASE_URL)
+	AIBaseURL string
+
+	// AIBaseURLRegions maps region names to regional AI Builder API roots, as
+	// comma-separated region=url pairs (AI_BASE_URL_REGIONS)
+	AIBaseURLRegions map[string]string
+
+	// Region picks this deployment's entry from AIBaseURLRegions at startup; when it
+	// is unset or unmapped, AIBaseURL is used as is (REGION)
+	Region string
+
+	// AIRequestTimeout bounds each chat completion call to the AI Builder API,
+	// separately from page fetches and other outbound calls (AI_REQUEST_TIMEOUT_SECONDS)
+	AIRequestTimeout time.Duration
+
+	// ToolTimeout bounds each tool call the model makes (TOOL_TIMEOUT_SECONDS)
+	ToolTimeout time.Duration
+
+	// ToolTimeouts overrides ToolTimeout for individual tools, read from
+	// TOOL_TIMEOUT_<TOOL> in seconds, e.g. TOOL_TIMEOUT_READ_PAGE=45
+	ToolTimeouts map[string]time.Duration
+
+	// MaxConcurrentTools caps tool calls running at once across all chats; further
+	// calls wait for a slot until their chat's deadline (MAX_CONCURRENT_TOOLS)
+	MaxConcurrentTools int
+
+	// SSEHeartbeatInterval is how long a streaming response may sit idle before a
+	// keepalive comment is sent; 0 disables heartbeats (SSE_HEARTBEAT_SECONDS)
+	SSEHeartbeatInterval time.Duration
+
+	// DefaultModel is used when a chat request does not name a model (DEFAULT_MODEL)
+	DefaultModel string
+
+	// AllowedModels restricts the models chats may request; empty allows any model.
+	// Comma-separated (ALLOWED_MODELS)
+	AllowedModels []string
+
+	// ModelAccess maps bearer tokens to the models each may use, overriding
+	// AllowedModels for that token. Loaded from a JSON file (MODEL_ACCESS_FILE)
+	ModelAccess map[string][]string
+
+	// ModelParams maps models to the upstream names of chat parameters they spell
+	// differently, e.g. max_tokens as max_completion_tokens for gpt-5; other
+	// parameters pass through. Built-in entries cover OpenAI's reasoning models;
+	// MODEL_PARAM_MAP adds model:param=upstream_param pairs on top (MODEL_PARAM_MAP)
+	ModelParams map[string]map[string]string
+
+	// MaxContextTokens is the prompt size /chat/estimate compares against (MAX_CONTEXT_TOKENS)
+	MaxContextTokens int
+
+	// MaxConcurrentChatsPerClient caps in-flight chats per known bearer token (a
+	// MODEL_ACCESS_FILE entry), or per IP for other callers (MAX_CONCURRENT_CHATS_PER_CLIENT)
+	MaxConcurrentChatsPerClient int
+
+	// DailyChatQuota caps the chats each known bearer token (a MODEL_ACCESS_FILE
+	// entry), or IP for other callers, may make over a rolling 24 hours; 0 means no
+	// quota (DAILY_CHAT_QUOTA)
+	DailyChatQuota int
+
+	// ChatBatchWindow enables micro-batching when positive: tool-less completions for
+	// the same model arriving within this window share one upstream /completions call
+	// with an array prompt, up to ChatBatchMaxSize prompts. Only for backends that
+	// accept array prompts (CHAT_BATCH_WINDOW_MS, CHAT_BATCH_MAX_SIZE)
+	ChatBatchWindow  time.Duration
+	ChatBatchMaxSize int
+
+	// MaxToolIterations caps the model calls made by one chat's tool loop (MAX_TOOL_ITERATIONS)
+	MaxToolIterations int
+
+	// ChatMaxRuntime caps the wall-clock time of one chat's whole tool loop; a chat
+	// that runs past it fails with 504 chat_timeout (CHAT_MAX_RUNTIME_SECONDS)
+	ChatMaxRuntime time.Duration
+
+	// EmptyAnswerRetries is how many times an empty final answer is retried with a
+	// nudge before the chat fails with no_answer; 0 disables retries (EMPTY_ANSWER_RETRIES)
+	EmptyAnswerRetries int
+
+	// MaxContinuations is how many times a chat with auto_continue resumes an answer
+	// cut off by the token limit; each resumption is one more model call (MAX_CONTINUATIONS)
+	MaxContinuations int
+
+	// MaxStopSequences caps the stop sequences a chat may send; OpenAI-compatible
+	// backends typically accept at most 4 (MAX_STOP_SEQUENCES)
+	MaxStopSequences int
+
+	// UpstreamRetries is how many times a transient AI or search API failure (a
+	// connection error, 429 or gateway error) is retried; 0 disables retries
+	// (UPSTREAM_RETRIES). The delay starts at UpstreamRetryBaseDelay and doubles up
+	// to UpstreamRetryMaxDelay (UPSTREAM_RETRY_BASE_MS, UPSTREAM_RETRY_MAX_MS).
+	// UpstreamRetryJitter randomizes each delay between zero and that value so
+	// clients don't retry in lockstep after an outage (UPSTREAM_RETRY_JITTER)
+	UpstreamRetries        int
+	UpstreamRetryBaseDelay time.Duration
+	UpstreamRetryMaxDelay  time.Duration
+	UpstreamRetryJitter    bool
+
+	// ChatRetryBudget caps the retries one chat may make in total, across its model
+	// calls and the searches its tools run; once spent, failures are returned at
+	// once. A search shared by several chats spends only the budget of the chat
+	// that started it. 0 disables retries within chats (CHAT_RETRY_BUDGET)
+	ChatRetryBudget int
+
+	// ToolBudgets caps how often each named tool may run within one chat; tools not
+	// listed are only bounded by MaxToolIterations. Comma-separated name=count pairs;
+	// set empty to remove all budgets (TOOL_BUDGETS)
+	ToolBudgets map[string]int
+
+	// SearchMaxResults is the default number of results per search keyword (SEARCH_MAX_RESULTS)
+	SearchMaxResults int
+
+	// SearchMaxResultsLimit caps the results per keyword a request or the search
+	// tool may ask for (SEARCH_MAX_RESULTS_LIMIT)
+	SearchMaxResultsLimit int
+
+	// SearchMaxKeywords caps the keywords in one search; extra keywords are dropped
+	// (SEARCH_MAX_KEYWORDS)
+	SearchMaxKeywords int
+
+	// SearchLowercaseKeywords lowercases search keywords before they are sent, so
+	// queries differing only in case share upstream calls. Off by default since some
+	// queries are case-sensitive (SEARCH_LOWERCASE_KEYWORDS)
+	SearchLowercaseKeywords bool
+
+	// SearchBlockedKeywords refuses searches whose keywords contain any of these
+	// terms, matched case-insensitively on whole words. Comma-separated
+	// (SEARCH_BLOCKED_KEYWORDS)
+	SearchBlockedKeywords []string
+
+	// SearchSnippetMaxLength cuts each search result's snippet to this many
+	// characters; 0 keeps snippets whole (SEARCH_SNIPPET_MAX_LENGTH)
+	SearchSnippetMaxLength int
+
+	// SearchBroadenMinResults is the result count below which a search that asked
+	// to broaden is retried once with relaxed keywords (SEARCH_BROADEN_MIN_RESULTS)
+	SearchBroadenMinResults int
+
+	// TruncationMarker is appended wherever text is cut short (search snippets,
+	// feed summaries, raw upstream bodies, audit summaries), with "{bytes}"
+	// replaced by the number of bytes cut (TRUNCATION_MARKER)
+	TruncationMarker string
+
+	// SearchSafeSearch is the default safe search level: off, moderate or strict
+	// (SEARCH_SAFE_SEARCH). When SearchSafeSearchUpstream is set the level is
+	// forwarded to the search providers; otherwise results are filtered here with a
+	// basic keyword list (SEARCH_SAFE_SEARCH_UPSTREAM)
+	SearchSafeSearch         string
+	SearchSafeSearchUpstream bool
+
+	// SearchFallbackURL is an optional secondary search provider (SEARCH_FALLBACK_URL)
+	SearchFallbackURL string
+
+	// SearchFallbackAPIKey authenticates the fallback provider, defaulting to APIKey (SEARCH_FALLBACK_API_KEY)
+	SearchFallbackAPIKey string
+
+	// FeedMaxEntries caps the entries the read_feed tool returns per feed (FEED_MAX_ENTRIES)
+	FeedMaxEntries int
+
+	// PageReaderMaxURLs caps the pages one /page_reader request or read_pages call
+	// may read; longer lists are rejected (PAGE_READER_MAX_URLS)
+	PageReaderMaxURLs int
+
+	// UserPromptPrefix is prepended to every chat message when set (USER_PROMPT_PREFIX)
+	UserPromptPrefix string
+
+	// UserPromptSuffix is appended to every chat message when set (USER_PROMPT_SUFFIX)
+	UserPromptSuffix string
+
+	// AllowPrivateFetch lets read_page and extract_from_page reach loopback and
+	// private network addresses; off by default to prevent SSRF (ALLOW_PRIVATE_FETCH)
+	AllowPrivateFetch bool
+
+	// MaxConcurrentPageFetches caps outbound page fetches in flight across the whole
+	// process, for every tool and endpoint that reads pages (MAX_CONCURRENT_PAGE_FETCHES)
+	MaxConcurrentPageFetches int
+
+	// PageMinTLSVersion is the lowest TLS version page fetches accept: 1.0, 1.1, 1.2
+	// or 1.3 (PAGE_MIN_TLS_VERSION)
+	PageMinTLSVersion string
+
+	// InsecureSkipVerify disables certificate verification for page fetches, for
+	// dev servers with self-signed certs. Never applies to AI Builder calls (INSECURE_SKIP_VERIFY)
+	InsecureSkipVerify bool
+
+	// Readability makes read_page return only the block that looks like the main
+	// content, dropping navigation, cookie banners and footers, when one stands out;
+	// otherwise the whole page's text is returned as before (READABILITY)
+	Readability bool
+
+	// ReadPageMarkdownFallback makes read_page return plain text, flagged with
+	// markdown_fallback, when Markdown was requested but the page's HTML defeats the
+	// converter; when false the conversion error is returned (READ_PAGE_MARKDOWN_FALLBACK)
+	ReadPageMarkdownFallback bool
+
+	// ReadPageExtraContentTypes are media types read_page accepts besides text/html
+	// and text/plain, returned as text; "type/*" accepts a whole family. Anything
+	// else gets an "unsupported content type" error. Comma-separated (READ_PAGE_EXTRA_CONTENT_TYPES)
+	ReadPageExtraContentTypes []string
+
+	// StrippedResponseHeaders are removed from headers returned by /page_reader/head.
+	// Comma-separated, case-insensitive (STRIPPED_RESPONSE_HEADERS)
+	StrippedResponseHeaders []string
+
+	// ConversationTTL is how long an idle server-side conversation is kept (CONVERSATION_TTL_MINUTES)
+	ConversationTTL time.Duration
+
+	// MaxConversations caps stored conversations; the least recently used is evicted (MAX_CONVERSATIONS)
+	MaxConversations int
+
+	// KVMaxKeys caps the keys one conversation may hold in the kv_set/kv_get store (KV_MAX_KEYS)
+	KVMaxKeys int
+
+	// KVMaxValueBytes caps the size of one value stored with kv_set (KV_MAX_VALUE_BYTES)
+	KVMaxValueBytes int
+
+	// ResponseLanguage is a language code every chat answer is requested in unless the
+	// request names its own (RESPONSE_LANGUAGE)
+	ResponseLanguage string
+
+	// PromptTemplatesDir holds extra *.tmpl prompt templates for /chat/template/{name} (PROMPT_TEMPLATES_DIR)
+	PromptTemplatesDir string
+
+	// EnableSearch, EnableReadPage and EnableRunCommand switch tools off both in chat
+	// and at their endpoints (ENABLE_SEARCH, ENABLE_READ_PAGE, ENABLE_RUN_COMMAND).
+	// EnableReadPage covers every tool that fetches a page: read_page, read_pages,
+	// extract_from_page and read_feed.
+	EnableSearch     bool
+	EnableReadPage   bool
+	EnableRunCommand bool
+
+	// DeniedCommandPaths are paths run_command arguments may not reference, including
+	// anything beneath them. Comma-separated; set empty to allow all (DENIED_COMMAND_PATHS)
+	DeniedCommandPaths []string
+
+	// CommandDir is the working directory run_command runs commands in, and resolves
+	// relative paths against; empty means the server's own (COMMAND_DIR)
+	CommandDir string
+
+	// CommandOutputEncoding is how run_command output bytes that are not valid UTF-8
+	// are read: "utf-8" replaces them with U+FFFD, "latin1" decodes each as
+	// ISO-8859-1 (COMMAND_OUTPUT_ENCODING)
+	CommandOutputEncoding string
+
+	// StartupPing checks once at boot that the AI Builder API accepts APIKey; the
+	// result is logged and reported by /readyz (STARTUP_PING)
+	StartupPing bool
+
+	// SafeMode runs every final chat answer through a keyword/regex filter (SAFE_MODE).
+	// SafeModePatterns are the comma-separated, case-insensitive patterns
+	// (SAFE_MODE_PATTERNS); SafeModeAction is "redact" to blank out matches or
+	// "refuse" to replace the whole answer (SAFE_MODE_ACTION).
+	SafeMode         bool
+	SafeModePatterns []string
+	SafeModeAction   string
+
+	// DemoMode answers chats with a canned explanation instead of failing when APIKey is missing (DEMO_MODE)
+	DemoMode bool
+
+	// MockMode answers chats with canned, deterministic model replies and tool
+	// results so the whole chat flow runs offline, for demos and CI (MOCK_MODE)
+	MockMode bool
+
+	// DebugEndpoints enables /debug/echo and include_raw_upstream on /chat; keep off
+	// in production (DEBUG_ENDPOINTS)
+	DebugEndpoints bool
+
+	// DebugLogging adds debug-level lines to the log, such as unexpected fields in
+	// tool arguments (DEBUG_LOGGING)
+	DebugLogging bool
+
+	// StrictContentType rejects POST bodies not sent as application/json with 415;
+	// off, any Content-Type is decoded as JSON (STRICT_CONTENT_TYPE)
+	StrictContentType bool
+
+	// ToolResultGuard wraps tool results sent to the model in delimiters and a note
+	// marking them untrusted, against prompt injection from fetched pages (TOOL_RESULT_GUARD)
+	ToolResultGuard bool
+
+	// AdminToken enables the /admin endpoints, which require it as a bearer token (ADMIN_TOKEN)
+	AdminToken string
+
+	// AuditLogPath enables the tool-call audit log when set (AUDIT_LOG_PATH)
+	AuditLogPath string
+
+	// CompletionWebhookURL, when set, receives a JSON summary of every finished
+	// chat, POSTed in the background (COMPLETION_WEBHOOK_URL)
+	CompletionWebhookURL string
+}
+
+// DefaultAIBaseURL is the AI Builder API root used when AI_BASE_URL is unset
+const DefaultAIBaseURL = "https://space.ai-builders.com/backend/v1"
+
+// DefaultConfig returns the configuration used when no environment overrides are set
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:               "0.0.0.0:8080",
+		ReadHeaderTimeout:        10 * time.Second,
+		ReadTimeout:              30 * time.Second,
+		WriteTimeout:             5 * time.Minute,
+		IdleTimeout:              2 * time.Minute,
+		AIBaseURL:                DefaultAIBaseURL,
+		DefaultModel:             "gpt-5",
+		ModelParams:              defaultModelParams,
+		MaxToolIterations:        10,
+		ChatBatchMaxSize:         8,
+		EmptyAnswerRetries:       1,
+		MaxContinuations:         3,
+		MaxStopSequences:         4,
+		UpstreamRetries:          2,
+		UpstreamRetryBaseDelay:   250 * time.Millisecond,
+		UpstreamRetryMaxDelay:    5 * time.Second,
+		UpstreamRetryJitter:      true,
+		ChatRetryBudget:          4,
+		MaxContextTokens:         128000,
+		SearchMaxResults:         6,
+		SearchSnippetMaxLength:   300,
+		SearchBroadenMinResults:  3,
+		TruncationMarker:         "…[truncated {bytes} bytes]",
+		SearchSafeSearch:         safeSearchOff,
+		FeedMaxEntries:           20,
+		PageReaderMaxURLs:        10,
+		ConversationTTL:          30 * time.Minute,
+		MaxConversations:         1000,
+		KVMaxKeys:                50,
+		KVMaxValueBytes:          8192,
+		EnableSearch:             true,
+		EnableReadPage:           true,
+		EnableRunCommand:         true,
+		ReadPageMarkdownFallback: true,
+		SafeModeAction:           safeModeRedact,
+		CommandOutputEncoding:    commandOutputUTF8,
+		PageMinTLSVersion:        "1.2",
+
+		AIRequestTimeout:         90 * time.Second,
+		ToolTimeout:              60 * time.Second,
+		MaxConcurrentTools:       32,
+		ChatMaxRuntime:           5 * time.Minute,
+		SSEHeartbeatInterval:     15 * time.Second,
+		SearchMaxResultsLimit:    20,
+		SearchMaxKeywords:        10,
+		MaxConcurrentPageFetches: 8,
+		ToolBudgets:              map[string]int{"search": 5, "read_page": 5, "read_pages": 5},
+
+		DeniedCommandPaths: []string{"/etc", "/proc", "/sys", "/var/run/secrets"},
+		StrippedResponseHeaders: []string{
+			"Set-Cookie", "Set-Cookie2", "Cookie", "Authorization", "Proxy-Authorization",
+			"WWW-Authenticate", "Proxy-Authenticate", "X-Amz-Security-Token",
+		},
+
+		MaxConcurrentChatsPerClient: 4,
+	}
+}
+
+// LoadConfig reads the configuration from environment variables, applying
+// defaults and failing fast on invalid values.
+func LoadConfig() (Config, error) {
+	cfg := DefaultConfig()
+
+	cfg.ListenAddr = envString("LISTEN_ADDR", cfg.ListenAddr)
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	cfg.InternalBaseURL = envString("INTERNAL_BASE_URL", internalBaseURL(cfg.ListenAddr, cfg.TLSEnabled()))
+	cfg.APIKey = os.Getenv("API_KEY")
+	cfg.AIBaseURL = envString("AI_BASE_URL", cfg.AIBaseURL)
+	cfg.Region = strings.ToLower(strings.TrimSpace(os.Getenv("REGION")))
+	cfg.DefaultModel = envString("DEFAULT_MODEL", cfg.DefaultModel)
+	cfg.SearchFallbackURL = os.Getenv("SEARCH_FALLBACK_URL")
+	cfg.SearchFallbackAPIKey = envString("SEARCH_FALLBACK_API_KEY", cfg.APIKey)
+	cfg.AuditLogPath = os.Getenv("AUDIT_LOG_PATH")
+	cfg.CompletionWebhookURL = os.Getenv("COMPLETION_WEBHOOK_URL")
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	cfg.UserPromptPrefix = os.Getenv("USER_PROMPT_PREFIX")
+	cfg.UserPromptSuffix = os.Getenv("USER_PROMPT_SUFFIX")
+	cfg.PromptTemplatesDir = os.Getenv("PROMPT_TEMPLATES_DIR")
+	cfg.ResponseLanguage = os.Getenv("RESPONSE_LANGUAGE")
+	cfg.DeniedCommandPaths = envList("DENIED_COMMAND_PATHS", cfg.DeniedCommandPaths)
+	cfg.CommandDir = envString("COMMAND_DIR", cfg.CommandDir)
+	cfg.CommandOutputEncoding = strings.ToLower(envString("COMMAND_OUTPUT_ENCODING", cfg.CommandOutputEncoding))
+	cfg.SearchBlockedKeywords = envList("SEARCH_BLOCKED_KEYWORDS", cfg.SearchBlockedKeywords)
+	cfg.StrippedResponseHeaders = envList("STRIPPED_RESPONSE_HEADERS", cfg.StrippedResponseHeaders)
+	cfg.ReadPageExtraContentTypes = envList("READ_PAGE_EXTRA_CONTENT_TYPES", cfg.ReadPageExtraContentTypes)
+	for i, t := range cfg.ReadPageExtraContentTypes {
+		cfg.ReadPageExtraContentTypes[i] = strings.ToLower(t)
+	}
+	cfg.SafeModePatterns = envList("SAFE_MODE_PATTERNS", cfg.SafeModePatterns)
+	cfg.SafeModeAction = envString("SAFE_MODE_ACTION", cfg.SafeModeAction)
+	cfg.SearchSafeSearch = envString("SEARCH_SAFE_SEARCH", cfg.SearchSafeSearch)
+	cfg.PageMinTLSVersion = envString("PAGE_MIN_TLS_VERSION", cfg.PageMinTLSVersion)
+	cfg.AllowedModels = envList("ALLOWED_MODELS", cfg.AllowedModels)
+
+	var err error
+	paramEntries, err := envStringMap("MODEL_PARAM_MAP", nil)
+	if err != nil {
+		return Config{}, err
+	}
+	if cfg.ModelParams, err = mergeModelParams(cfg.ModelParams, paramEntries); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIBaseURLRegions, err = envStringMap("AI_BASE_URL_REGIONS", cfg.AIBaseURLRegions); err != nil {
+		return Config{}, err
+	}
+	cfg.AIBaseURL, _ = regionBaseURL(cfg.AIBaseURLRegions, cfg.Region, cfg.AIBaseURL)
+	if path := os.Getenv("MODEL_ACCESS_FILE"); path != "" {
+		if cfg.ModelAccess, err = loadModelAccess(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.ReadHeaderTimeout, err = envSeconds("READ_HEADER_TIMEOUT_SECONDS", cfg.ReadHeaderTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReadTimeout, err = envSeconds("READ_TIMEOUT_SECONDS", cfg.ReadTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.WriteTimeout, err = envSeconds("WRITE_TIMEOUT_SECONDS", cfg.WriteTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.IdleTimeout, err = envSeconds("IDLE_TIMEOUT_SECONDS", cfg.IdleTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.ChatMaxRuntime, err = envSeconds("CHAT_MAX_RUNTIME_SECONDS", cfg.ChatMaxRuntime); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIRequestTimeout, err = envSeconds("AI_REQUEST_TIMEOUT_SECONDS", cfg.AIRequestTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.ToolTimeout, err = envSeconds("TOOL_TIMEOUT_SECONDS", cfg.ToolTimeout); err != nil {
+		return Config{}, err
+	}
+	for _, name := range chatToolNames {
+		key := toolTimeoutEnv(name)
+		if os.Getenv(key) == "" {
+			continue
+		}
+		if cfg.ToolTimeouts == nil {
+			cfg.ToolTimeouts = map[string]time.Duration{}
+		}
+		if cfg.ToolTimeouts[name], err = envSeconds(key, 0); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.MaxConcurrentTools, err = envInt("MAX_CONCURRENT_TOOLS", cfg.MaxConcurrentTools); err != nil {
+		return Config{}, err
+	}
+	if cfg.SSEHeartbeatInterval, err = envSeconds("SSE_HEARTBEAT_SECONDS", cfg.SSEHeartbeatInterval); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchMaxResults, err = envInt("SEARCH_MAX_RESULTS", cfg.SearchMaxResults); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchMaxResultsLimit, err = envInt("SEARCH_MAX_RESULTS_LIMIT", cfg.SearchMaxResultsLimit); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchMaxKeywords, err = envInt("SEARCH_MAX_KEYWORDS", cfg.SearchMaxKeywords); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchSnippetMaxLength, err = envInt("SEARCH_SNIPPET_MAX_LENGTH", cfg.SearchSnippetMaxLength); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchBroadenMinResults, err = envInt("SEARCH_BROADEN_MIN_RESULTS", cfg.SearchBroadenMinResults); err != nil {
+		return Config{}, err
+	}
+	cfg.TruncationMarker = envString("TRUNCATION_MARKER", cfg.TruncationMarker)
+	if cfg.SearchLowercaseKeywords, err = envBool("SEARCH_LOWERCASE_KEYWORDS", cfg.SearchLowercaseKeywords); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchSafeSearchUpstream, err = envBool("SEARCH_SAFE_SEARCH_UPSTREAM", cfg.SearchSafeSearchUpstream); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxConcurrentChatsPerClient, err = envInt("MAX_CONCURRENT_CHATS_PER_CLIENT", cfg.MaxConcurrentChatsPerClient); err != nil {
+		return Config{}, err
+	}
+	if cfg.DailyChatQuota, err = envInt("DAILY_CHAT_QUOTA", cfg.DailyChatQuota); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxContextTokens, err = envInt("MAX_CONTEXT_TOKENS", cfg.MaxContextTokens); err != nil {
+		return Config{}, err
+	}
+	if cfg.FeedMaxEntries, err = envInt("FEED_MAX_ENTRIES", cfg.FeedMaxEntries); err != nil {
+		return Config{}, err
+	}
+	if cfg.PageReaderMaxURLs, err = envInt("PAGE_READER_MAX_URLS", cfg.PageReaderMaxURLs); err != nil {
+		return Config{}, err
+	}
+	if cfg.ChatBatchWindow, err = envMillis("CHAT_BATCH_WINDOW_MS", cfg.ChatBatchWindow); err != nil {
+		return Config{}, err
+	}
+	if cfg.ChatBatchMaxSize, err = envInt("CHAT_BATCH_MAX_SIZE", cfg.ChatBatchMaxSize); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxToolIterations, err = envInt("MAX_TOOL_ITERATIONS", cfg.MaxToolIterations); err != nil {
+		return Config{}, err
+	}
+	if cfg.EmptyAnswerRetries, err = envInt("EMPTY_ANSWER_RETRIES", cfg.EmptyAnswerRetries); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxContinuations, err = envInt("MAX_CONTINUATIONS", cfg.MaxContinuations); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxStopSequences, err = envInt("MAX_STOP_SEQUENCES", cfg.MaxStopSequences); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamRetries, err = envInt("UPSTREAM_RETRIES", cfg.UpstreamRetries); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamRetryBaseDelay, err = envMillis("UPSTREAM_RETRY_BASE_MS", cfg.UpstreamRetryBaseDelay); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamRetryMaxDelay, err = envMillis("UPSTREAM_RETRY_MAX_MS", cfg.UpstreamRetryMaxDelay); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpstreamRetryJitter, err = envBool("UPSTREAM_RETRY_JITTER", cfg.UpstreamRetryJitter); err != nil {
+		return Config{}, err
+	}
+	if cfg.ChatRetryBudget, err = envInt("CHAT_RETRY_BUDGET", cfg.ChatRetryBudget); err != nil {
+		return Config{}, err
+	}
+	if cfg.ToolBudgets, err = envIntMap("TOOL_BUDGETS", cfg.ToolBudgets); err != nil {
+		return Config{}, err
+	}
+	if cfg.ConversationTTL, err = envMinutes("CONVERSATION_TTL_MINUTES", cfg.ConversationTTL); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxConversations, err = envInt("MAX_CONVERSATIONS", cfg.MaxConversations); err != nil {
+		return Config{}, err
+	}
+	if cfg.KVMaxKeys, err = envInt("KV_MAX_KEYS", cfg.KVMaxKeys); err != nil {
+		return Config{}, err
+	}
+	if cfg.KVMaxValueBytes, err = envInt("KV_MAX_VALUE_BYTES", cfg.KVMaxValueBytes); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowPrivateFetch, err = envBool("ALLOW_PRIVATE_FETCH", cfg.AllowPrivateFetch); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxConcurrentPageFetches, err = envInt("MAX_CONCURRENT_PAGE_FETCHES", cfg.MaxConcurrentPageFetches); err != nil {
+		return Config{}, err
+	}
+	if cfg.InsecureSkipVerify, err = envBool("INSECURE_SKIP_VERIFY", cfg.InsecureSkipVerify); err != nil {
+		return Config{}, err
+	}
+	if cfg.Readability, err = envBool("READABILITY", cfg.Readability); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReadPageMarkdownFallback, err = envBool("READ_PAGE_MARKDOWN_FALLBACK", cfg.ReadPageMarkdownFallback); err != nil {
+		return Config{}, err
+	}
+	if cfg.EnableSearch, err = envBool("ENABLE_SEARCH", cfg.EnableSearch); err != nil {
+		return Config{}, err
+	}
+	if cfg.EnableReadPage, err = envBool("ENABLE_READ_PAGE", cfg.EnableReadPage); err != nil {
+		return Config{}, err
+	}
+	if cfg.EnableRunCommand, err = envBool("ENABLE_RUN_COMMAND", cfg.EnableRunCommand); err != nil {
+		return Config{}, err
+	}
+	if cfg.StartupPing, err = envBool("STARTUP_PING", cfg.StartupPing); err != nil {
+		return Config{}, err
+	}
+	if cfg.SafeMode, err = envBool("SAFE_MODE", cfg.SafeMode); err != nil {
+		return Config{}, err
+	}
+	if cfg.DebugEndpoints, err = envBool("DEBUG_ENDPOINTS", cfg.DebugEndpoints); err != nil {
+		return Config{}, err
+	}
+	if cfg.DebugLogging, err = envBool("DEBUG_LOGGING", cfg.DebugLogging); err != nil {
+		return Config{}, err
+	}
+	if cfg.StrictContentType, err = envBool("STRICT_CONTENT_TYPE", cfg.StrictContentType); err != nil {
+		return Config{}, err
+	}
+	if cfg.ToolResultGuard, err = envBool("TOOL_RESULT_GUARD", cfg.ToolResultGuard); err != nil {
+		return Config{}, err
+	}
+	if cfg.DemoMode, err = envBool("DEMO_MODE", cfg.DemoMode); err != nil {
+		return Config{}, err
+	}
+	if cfg.MockMode, err = envBool("MOCK_MODE", cfg.MockMode); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that all configured values are usable
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("LISTEN_ADDR: invalid address %q: %w", c.ListenAddr, err)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for _, f := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("TLS file %s: %w", f, err)
+		}
+	}
+	if err := validateHTTPURL(c.InternalBaseURL); err != nil {
+		return fmt.Errorf("INTERNAL_BASE_URL: %w", err)
+	}
+	for region, baseURL := range c.AIBaseURLRegions {
+		if err := validateHTTPURL(baseURL); err != nil {
+			return fmt.Errorf("AI_BASE_URL_REGIONS: region %s: %w", region, err)
+		}
+	}
+	if err := validateHTTPURL(c.AIBaseURL); err != nil {
+		return fmt.Errorf("AI_BASE_URL: %w", err)
+	}
+	if c.DefaultModel == "" {
+		return fmt.Errorf("DEFAULT_MODEL must not be empty")
+	}
+	if !modelNameRe.MatchString(c.DefaultModel) {
+		return fmt.Errorf("DEFAULT_MODEL: invalid model name %q", c.DefaultModel)
+	}
+	for name, d := range map[string]time.Duration{
+		"READ_HEADER_TIMEOUT_SECONDS": c.ReadHeaderTimeout,
+		"READ_TIMEOUT_SECONDS":        c.ReadTimeout,
+		"WRITE_TIMEOUT_SECONDS":       c.WriteTimeout,
+		"IDLE_TIMEOUT_SECONDS":        c.IdleTimeout,
+		"AI_REQUEST_TIMEOUT_SECONDS":  c.AIRequestTimeout,
+		"CHAT_MAX_RUNTIME_SECONDS":    c.ChatMaxRuntime,
+		"TOOL_TIMEOUT_SECONDS":        c.ToolTimeout,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	for tool, d := range c.ToolTimeouts {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", toolTimeoutEnv(tool))
+		}
+	}
+	if c.CommandDir != "" {
+		if info, err := os.Stat(c.CommandDir); err != nil {
+			return fmt.Errorf("COMMAND_DIR: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("COMMAND_DIR: %s is not a directory", c.CommandDir)
+		}
+	}
+	if c.CommandOutputEncoding != commandOutputUTF8 && c.CommandOutputEncoding != commandOutputLatin1 {
+		return fmt.Errorf("COMMAND_OUTPUT_ENCODING must be utf-8 or latin1, got %q", c.CommandOutputEncoding)
+	}
+	for _, t := range c.ReadPageExtraContentTypes {
+		if family, subtype, ok := strings.Cut(t, "/"); !ok || family == "" || subtype == "" || strings.ContainsAny(t, " ;,") {
+			return fmt.Errorf("READ_PAGE_EXTRA_CONTENT_TYPES: invalid media type %q (use type/subtype or type/*)", t)
+		}
+	}
+	if _, ok := tlsVersions[c.PageMinTLSVersion]; !ok {
+		return fmt.Errorf("PAGE_MIN_TLS_VERSION must be 1.0, 1.1, 1.2 or 1.3, got %q", c.PageMinTLSVersion)
+	}
+	if c.ResponseLanguage != "" && !languageCodeRe.MatchString(c.ResponseLanguage) {
+		return fmt.Errorf("RESPONSE_LANGUAGE: invalid language code %q", c.ResponseLanguage)
+	}
+	if c.SSEHeartbeatInterval < 0 {
+		return fmt.Errorf("SSE_HEARTBEAT_SECONDS must not be negative")
+	}
+	if c.ChatBatchWindow < 0 {
+		return fmt.Errorf("CHAT_BATCH_WINDOW_MS must not be negative")
+	}
+	if c.ChatBatchWindow > 0 && c.ChatBatchMaxSize <= 0 {
+		return fmt.Errorf("CHAT_BATCH_MAX_SIZE must be positive, got %d", c.ChatBatchMaxSize)
+	}
+	if c.MaxToolIterations <= 0 {
+		return fmt.Errorf("MAX_TOOL_ITERATIONS must be positive, got %d", c.MaxToolIterations)
+	}
+	if c.EmptyAnswerRetries < 0 {
+		return fmt.Errorf("EMPTY_ANSWER_RETRIES must not be negative, got %d", c.EmptyAnswerRetries)
+	}
+	if c.MaxContinuations < 0 {
+		return fmt.Errorf("MAX_CONTINUATIONS must not be negative, got %d", c.MaxContinuations)
+	}
+	if c.MaxStopSequences < 1 {
+		return fmt.Errorf("MAX_STOP_SEQUENCES must be at least 1, got %d", c.MaxStopSequences)
+	}
+	if c.UpstreamRetries < 0 {
+		return fmt.Errorf("UPSTREAM_RETRIES must not be negative, got %d", c.UpstreamRetries)
+	}
+	if c.ChatRetryBudget < 0 {
+		return fmt.Errorf("CHAT_RETRY_BUDGET must not be negative, got %d", c.ChatRetryBudget)
+	}
+	if c.UpstreamRetryBaseDelay <= 0 || c.UpstreamRetryMaxDelay < c.UpstreamRetryBaseDelay {
+		return fmt.Errorf("UPSTREAM_RETRY_BASE_MS must be positive and no more than UPSTREAM_RETRY_MAX_MS")
+	}
+	for name, n := range c.ToolBudgets {
+		if n < 0 {
+			return fmt.Errorf("TOOL_BUDGETS: budget for %s must not be negative, got %d", name, n)
+		}
+	}
+	if c.SearchMaxResults <= 0 {
+		return fmt.Errorf("SEARCH_MAX_RESULTS must be positive, got %d", c.SearchMaxResults)
+	}
+	if c.SearchMaxResultsLimit < c.SearchMaxResults {
+		return fmt.Errorf("SEARCH_MAX_RESULTS_LIMIT must be at least SEARCH_MAX_RESULTS (%d), got %d", c.SearchMaxResults, c.SearchMaxResultsLimit)
+	}
+	if c.SearchMaxKeywords <= 0 {
+		return fmt.Errorf("SEARCH_MAX_KEYWORDS must be positive, got %d", c.SearchMaxKeywords)
+	}
+	if c.SearchBroadenMinResults < 1 {
+		return fmt.Errorf("SEARCH_BROADEN_MIN_RESULTS must be at least 1, got %d", c.SearchBroadenMinResults)
+	}
+	if c.SearchSnippetMaxLength < 0 {
+		return fmt.Errorf("SEARCH_SNIPPET_MAX_LENGTH must not be negative, got %d", c.SearchSnippetMaxLength)
+	}
+	if !validSafeSearch(c.SearchSafeSearch) {
+		return fmt.Errorf("SEARCH_SAFE_SEARCH must be off, moderate or strict, got %q", c.SearchSafeSearch)
+	}
+	if c.MaxConcurrentChatsPerClient <= 0 {
+		return fmt.Errorf("MAX_CONCURRENT_CHATS_PER_CLIENT must be positive, got %d", c.MaxConcurrentChatsPerClient)
+	}
+	if c.DailyChatQuota < 0 {
+		return fmt.Errorf("DAILY_CHAT_QUOTA must not be negative, got %d", c.DailyChatQuota)
+	}
+	if c.MaxContextTokens <= 0 {
+		return fmt.Errorf("MAX_CONTEXT_TOKENS must be positive, got %d", c.MaxContextTokens)
+	}
+	if c.FeedMaxEntries <= 0 {
+		return fmt.Errorf("FEED_MAX_ENTRIES must be positive, got %d", c.FeedMaxEntries)
+	}
+	if c.PageReaderMaxURLs <= 0 {
+		return fmt.Errorf("PAGE_READER_MAX_URLS must be positive, got %d", c.PageReaderMaxURLs)
+	}
+	if c.MaxConcurrentTools <= 0 {
+		return fmt.Errorf("MAX_CONCURRENT_TOOLS must be positive, got %d", c.MaxConcurrentTools)
+	}
+	if c.MaxConcurrentPageFetches <= 0 {
+		return fmt.Errorf("MAX_CONCURRENT_PAGE_FETCHES must be positive, got %d", c.MaxConcurrentPageFetches)
+	}
+	if c.ConversationTTL <= 0 {
+		return fmt.Errorf("CONVERSATION_TTL_MINUTES must be positive")
+	}
+	if c.MaxConversations <= 0 {
+		return fmt.Errorf("MAX_CONVERSATIONS must be positive, got %d", c.MaxConversations)
+	}
+	if c.KVMaxKeys <= 0 {
+		return fmt.Errorf("KV_MAX_KEYS must be positive, got %d", c.KVMaxKeys)
+	}
+	if c.KVMaxValueBytes <= 0 {
+		return fmt.Errorf("KV_MAX_VALUE_BYTES must be positive, got %d", c.KVMaxValueBytes)
+	}
+	if c.SafeMode {
+		if c.SafeModeAction != safeModeRedact && c.SafeModeAction != safeModeRefuse {
+			return fmt.Errorf("SAFE_MODE_ACTION must be redact or refuse, got %q", c.SafeModeAction)
+		}
+		if len(c.SafeModePatterns) == 0 {
+			return fmt.Errorf("SAFE_MODE requires SAFE_MODE_PATTERNS")
+		}
+		if _, err := compileSafeModePatterns(c.SafeModePatterns); err != nil {
+			return err
+		}
+	}
+	if c.CompletionWebhookURL != "" {
+		if err := validateHTTPURL(c.CompletionWebhookURL); err != nil {
+			return fmt.Errorf("COMPLETION_WEBHOOK_URL: %w", err)
+		}
+	}
+	if c.SearchFallbackURL != "" {
+		if err := validateHTTPURL(c.SearchFallbackURL); err != nil {
+			return fmt.Errorf("SEARCH_FALLBACK_URL: %w", err)
+		}
+	}
+	return nil
+}
+
+// TLSEnabled reports whether the server should serve HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// internalBaseURL derives the loopback URL for this server from its listen address
+func internalBaseURL(listenAddr string, tls bool) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "http://localhost:8080"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// envString returns the value of key, or def when it is unset or empty
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits key on commas, trimming blanks. Unlike the other helpers an
+// explicitly empty value yields an empty list; def is used only when key is unset.
+func envList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envIntMap parses key as comma-separated name=integer pairs. Like envList, an
+// explicitly empty value yields an empty map; def is used only when key is unset.
+func envIntMap(key string, def map[string]int) (map[string]int, error) {
+	if _, ok := os.LookupEnv(key); !ok {
+		return def, nil
+	}
+	m := map[string]int{}
+	for _, item := range envList(key, nil) {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s entries must look like name=count, got %q", key, item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: count for %s must be an integer, got %q", key, name, value)
+		}
+		m[name] = n
+	}
+	return m, nil
+}
+
+// envStringMap parses key as comma-separated name=value pairs with lowercased
+// names. Like envList, an explicitly empty value yields an empty map; def is used
+// only when key is unset.
+func envStringMap(key string, def map[string]string) (map[string]string, error) {
+	if _, ok := os.LookupEnv(key); !ok {
+		return def, nil
+	}
+	m := map[string]string{}
+	for _, item := range envList(key, nil) {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s entries must look like name=value, got %q", key, item)
+		}
+		m[name] = strings.TrimSpace(value)
+	}
+	return m, nil
+}
+
+// regionBaseURL returns region's entry in regions, or def when region is empty or
+// has no entry; matched reports which. region is expected lowercased.
+func regionBaseURL(regions map[string]string, region, def string) (baseURL string, matched bool) {
+	if baseURL, ok := regions[region]; ok && region != "" {
+		return baseURL, true
+	}
+	return def, false
+}
+
+// envInt parses key as an integer, returning def when it is unset
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", key, v)
+	}
+	return n, nil
+}
+
+// envMinutes parses key as a whole number of minutes, returning def when it is unset
+func envMinutes(key string, def time.Duration) (time.Duration, error) {
+	n, err := envInt(key, int(def/time.Minute))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * time.Minute, nil
+}
+
+// envSeconds parses key as a whole number of seconds, returning def when it is unset
+func envSeconds(key string, def time.Duration) (time.Duration, error) {
+	n, err := envInt(key, int(def/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+// envMillis parses key as a whole number of milliseconds, returning def when it is unset
+func envMillis(key string, def time.Duration) (time.Duration, error) {
+	n, err := envInt(key, int(def/time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * time.Millisecond, nil
+}
+
+// envBool parses key as a boolean (true/false/1/0), returning def when it is unset
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", key, v)
+	}
+	return b, nil
+}
+
+// validateHTTPURL checks that raw is an absolute http(s) URL
+func validateHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q: must be an absolute http(s) URL", raw)
+	}
+	return nil
+}
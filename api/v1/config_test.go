@@ -0,0 +1,182 @@
+package api
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(Config) bool
+		wantErr string
+	}{
+		{"defaults", nil, func(c Config) bool {
+			return c.DefaultModel == "gpt-5" && c.SearchMaxResults == 6 && c.SearchFallbackURL == ""
+		}, ""},
+		{"strings", map[string]string{"API_KEY": "k", "AI_BASE_URL": "http://localhost:9000/v1", "DEFAULT_MODEL": "gpt-4o"}, func(c Config) bool {
+			return c.APIKey == "k" && c.AIBaseURL == "http://localhost:9000/v1" && c.DefaultModel == "gpt-4o"
+		}, ""},
+		{"fallback key defaults to API_KEY", map[string]string{"API_KEY": "k", "SEARCH_FALLBACK_URL": "https://search.example.com"}, func(c Config) bool {
+			return c.SearchFallbackAPIKey == "k"
+		}, ""},
+		{"ints", map[string]string{"SEARCH_MAX_RESULTS": "3"}, func(c Config) bool {
+			return c.SearchMaxResults == 3
+		}, ""},
+		{"internal URL follows LISTEN_ADDR", map[string]string{"LISTEN_ADDR": "0.0.0.0:9090"}, func(c Config) bool {
+			return c.ListenAddr == "0.0.0.0:9090" && c.InternalBaseURL == "http://localhost:9090"
+		}, ""},
+		{"minutes", map[string]string{"CONVERSATION_TTL_MINUTES": "5"}, func(c Config) bool {
+			return c.ConversationTTL == 5*time.Minute
+		}, ""},
+		{"seconds", map[string]string{"WRITE_TIMEOUT_SECONDS": "90"}, func(c Config) bool {
+			return c.WriteTimeout == 90*time.Second
+		}, ""},
+		{"tool timeouts", map[string]string{"TOOL_TIMEOUT_SECONDS": "30", "TOOL_TIMEOUT_READ_PAGE": "45"}, func(c Config) bool {
+			return c.ToolTimeout == 30*time.Second && maps.Equal(c.ToolTimeouts, map[string]time.Duration{"read_page": 45 * time.Second})
+		}, ""},
+		{"milliseconds", map[string]string{"CHAT_BATCH_WINDOW_MS": "20"}, func(c Config) bool {
+			return c.ChatBatchWindow == 20*time.Millisecond
+		}, ""},
+		{"bools", map[string]string{"DEMO_MODE": "1"}, func(c Config) bool {
+			return c.DemoMode
+		}, ""},
+		{"media type lists", map[string]string{"READ_PAGE_EXTRA_CONTENT_TYPES": "Text/CSV, application/*"}, func(c Config) bool {
+			return slices.Equal(c.ReadPageExtraContentTypes, []string{"text/csv", "application/*"})
+		}, ""},
+		{"lists", map[string]string{"DENIED_COMMAND_PATHS": " /etc, ,/srv/private "}, func(c Config) bool {
+			return slices.Equal(c.DeniedCommandPaths, []string{"/etc", "/srv/private"})
+		}, ""},
+		{"int maps", map[string]string{"TOOL_BUDGETS": "search=1, read_page = 2"}, func(c Config) bool {
+			return maps.Equal(c.ToolBudgets, map[string]int{"search": 1, "read_page": 2})
+		}, ""},
+		{"region selects a base URL", map[string]string{"AI_BASE_URL_REGIONS": "eu=https://eu.example.com/v1, US-East = https://us.example.com/v1", "REGION": " us-east "}, func(c Config) bool {
+			return c.AIBaseURL == "https://us.example.com/v1" && c.AIBaseURLRegions["eu"] == "https://eu.example.com/v1"
+		}, ""},
+		{"unmapped region keeps AI_BASE_URL", map[string]string{"AI_BASE_URL_REGIONS": "eu=https://eu.example.com/v1", "REGION": "ap", "AI_BASE_URL": "http://localhost:9000/v1"}, func(c Config) bool {
+			return c.AIBaseURL == "http://localhost:9000/v1"
+		}, ""},
+		{"no region keeps AI_BASE_URL", map[string]string{"AI_BASE_URL_REGIONS": "eu=https://eu.example.com/v1"}, func(c Config) bool {
+			return c.AIBaseURL == DefaultAIBaseURL
+		}, ""},
+		{"bad region pair", map[string]string{"AI_BASE_URL_REGIONS": "https://eu.example.com"}, nil, "AI_BASE_URL_REGIONS entries must look like name=value"},
+		{"bad regional URL", map[string]string{"AI_BASE_URL_REGIONS": "eu=https://eu.example.com/v1,ap=ap.example.com", "REGION": "eu"}, nil, "region ap"},
+		{"model param map", map[string]string{"MODEL_PARAM_MAP": "deepseek:max_tokens=max_output_tokens"}, func(c Config) bool {
+			return c.ModelParams["deepseek"]["max_tokens"] == "max_output_tokens" && c.ModelParams["gpt-5*"]["max_tokens"] == "max_completion_tokens"
+		}, ""},
+		{"bad model param map", map[string]string{"MODEL_PARAM_MAP": "max_tokens=max_output_tokens"}, nil, "MODEL_PARAM_MAP entries must look like model:param=upstream_param"},
+		{"bad int map", map[string]string{"TOOL_BUDGETS": "search"}, nil, "TOOL_BUDGETS entries must look like name=count"},
+		{"bad int map count", map[string]string{"TOOL_BUDGETS": "search=many"}, nil, "count for search must be an integer"},
+		{"bad bool", map[string]string{"DEMO_MODE": "sometimes"}, nil, "DEMO_MODE must be a boolean"},
+		{"bad int", map[string]string{"SEARCH_MAX_RESULTS": "three"}, nil, "SEARCH_MAX_RESULTS must be an integer"},
+		{"fails validation", map[string]string{"SEARCH_MAX_RESULTS": "0"}, nil, "SEARCH_MAX_RESULTS must be positive"},
+	}
+	// Every variable any case sets is cleared for the others
+	keys := map[string]bool{}
+	for _, tt := range tests {
+		for key := range tt.env {
+			keys[key] = true
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key := range keys {
+				t.Setenv(key, tt.env[key])
+			}
+			cfg, err := LoadConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("config = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"bad AI base URL", func(c *Config) { c.AIBaseURL = "space.ai-builders.com" }, "AI_BASE_URL"},
+		{"empty model", func(c *Config) { c.DefaultModel = "" }, "DEFAULT_MODEL"},
+		{"no tool iterations", func(c *Config) { c.MaxToolIterations = 0 }, "MAX_TOOL_ITERATIONS"},
+		{"no conversation TTL", func(c *Config) { c.ConversationTTL = 0 }, "CONVERSATION_TTL_MINUTES"},
+		{"no conversations", func(c *Config) { c.MaxConversations = 0 }, "MAX_CONVERSATIONS"},
+		{"no write timeout", func(c *Config) { c.WriteTimeout = 0 }, "WRITE_TIMEOUT_SECONDS"},
+		{"no tool timeout", func(c *Config) { c.ToolTimeout = 0 }, "TOOL_TIMEOUT_SECONDS"},
+		{"zero tool timeout override", func(c *Config) { c.ToolTimeouts = map[string]time.Duration{"run_command": 0} }, "TOOL_TIMEOUT_RUN_COMMAND"},
+		{"negative heartbeat", func(c *Config) { c.SSEHeartbeatInterval = -time.Second }, "SSE_HEARTBEAT_SECONDS"},
+		{"unknown command output encoding", func(c *Config) { c.CommandOutputEncoding = "shift-jis" }, "COMMAND_OUTPUT_ENCODING"},
+		{"bad completion webhook URL", func(c *Config) { c.CompletionWebhookURL = "ftp://hooks.example.com" }, "COMPLETION_WEBHOOK_URL"},
+		{"bad default model", func(c *Config) { c.DefaultModel = "gpt 5" }, "DEFAULT_MODEL"},
+		{"no kv keys", func(c *Config) { c.KVMaxKeys = 0 }, "KV_MAX_KEYS"},
+		{"no kv value size", func(c *Config) { c.KVMaxValueBytes = 0 }, "KV_MAX_VALUE_BYTES"},
+		{"negative retries", func(c *Config) { c.UpstreamRetries = -1 }, "UPSTREAM_RETRIES"},
+		{"negative retry budget", func(c *Config) { c.ChatRetryBudget = -1 }, "CHAT_RETRY_BUDGET"},
+		{"retry base above max", func(c *Config) { c.UpstreamRetryBaseDelay = time.Minute }, "UPSTREAM_RETRY_BASE_MS"},
+		{"no chat runtime", func(c *Config) { c.ChatMaxRuntime = 0 }, "CHAT_MAX_RUNTIME_SECONDS"},
+		{"no AI request timeout", func(c *Config) { c.AIRequestTimeout = 0 }, "AI_REQUEST_TIMEOUT_SECONDS"},
+		{"no results", func(c *Config) { c.SearchMaxResults = 0 }, "SEARCH_MAX_RESULTS"},
+		{"results limit below the default", func(c *Config) { c.SearchMaxResultsLimit = 3 }, "SEARCH_MAX_RESULTS_LIMIT"},
+		{"no context tokens", func(c *Config) { c.MaxContextTokens = 0 }, "MAX_CONTEXT_TOKENS"},
+		{"negative daily quota", func(c *Config) { c.DailyChatQuota = -1 }, "DAILY_CHAT_QUOTA"},
+		{"no concurrent chats", func(c *Config) { c.MaxConcurrentChatsPerClient = 0 }, "MAX_CONCURRENT_CHATS_PER_CLIENT"},
+		{"bad response language", func(c *Config) { c.ResponseLanguage = "english" }, "RESPONSE_LANGUAGE"},
+		{"bad safe mode action", func(c *Config) { c.SafeMode, c.SafeModePatterns, c.SafeModeAction = true, []string{"x"}, "block" }, "SAFE_MODE_ACTION"},
+		{"safe mode without patterns", func(c *Config) { c.SafeMode = true }, "SAFE_MODE_PATTERNS"},
+		{"bad safe mode pattern", func(c *Config) { c.SafeMode, c.SafeModePatterns = true, []string{"("} }, "invalid pattern"},
+		{"negative tool budget", func(c *Config) { c.ToolBudgets = map[string]int{"search": -1} }, "TOOL_BUDGETS"},
+		{"bad TLS version", func(c *Config) { c.PageMinTLSVersion = "1.4" }, "PAGE_MIN_TLS_VERSION"},
+		{"no feed entries", func(c *Config) { c.FeedMaxEntries = 0 }, "FEED_MAX_ENTRIES"},
+		{"negative batch window", func(c *Config) { c.ChatBatchWindow = -time.Millisecond }, "CHAT_BATCH_WINDOW_MS"},
+		{"no batch size", func(c *Config) { c.ChatBatchWindow, c.ChatBatchMaxSize = time.Millisecond, 0 }, "CHAT_BATCH_MAX_SIZE"},
+		{"negative empty answer retries", func(c *Config) { c.EmptyAnswerRetries = -1 }, "EMPTY_ANSWER_RETRIES"},
+		{"negative continuations", func(c *Config) { c.MaxContinuations = -1 }, "MAX_CONTINUATIONS"},
+		{"no broaden minimum", func(c *Config) { c.SearchBroadenMinResults = 0 }, "SEARCH_BROADEN_MIN_RESULTS"},
+		{"no stop sequences", func(c *Config) { c.MaxStopSequences = 0 }, "MAX_STOP_SEQUENCES"},
+		{"no search keywords", func(c *Config) { c.SearchMaxKeywords = 0 }, "SEARCH_MAX_KEYWORDS"},
+		{"negative snippet length", func(c *Config) { c.SearchSnippetMaxLength = -1 }, "SEARCH_SNIPPET_MAX_LENGTH"},
+		{"no concurrent tools", func(c *Config) { c.MaxConcurrentTools = 0 }, "MAX_CONCURRENT_TOOLS"},
+		{"no page reader urls", func(c *Config) { c.PageReaderMaxURLs = 0 }, "PAGE_READER_MAX_URLS"},
+		{"no concurrent page fetches", func(c *Config) { c.MaxConcurrentPageFetches = 0 }, "MAX_CONCURRENT_PAGE_FETCHES"},
+		{"bad safe search level", func(c *Config) { c.SearchSafeSearch = "high" }, "SEARCH_SAFE_SEARCH"},
+		{"bad extra content type", func(c *Config) { c.ReadPageExtraContentTypes = []string{"csv"} }, "READ_PAGE_EXTRA_CONTENT_TYPES"},
+		{"bad listen address", func(c *Config) { c.ListenAddr = "8080" }, "LISTEN_ADDR"},
+		{"TLS cert without key", func(c *Config) { c.TLSCertFile = "cert.pem" }, "TLS_CERT_FILE and TLS_KEY_FILE"},
+		{"missing TLS files", func(c *Config) { c.TLSCertFile, c.TLSKeyFile = "missing.pem", "missing.key" }, "TLS file missing.pem"},
+		{"missing command dir", func(c *Config) { c.CommandDir = "missing-dir" }, "COMMAND_DIR"},
+		{"command dir is a file", func(c *Config) { c.CommandDir = "config_test.go" }, "COMMAND_DIR"},
+		{"no internal URL", func(c *Config) { c.InternalBaseURL = "" }, "INTERNAL_BASE_URL"},
+		{"relative fallback", func(c *Config) { c.SearchFallbackURL = "/search" }, "SEARCH_FALLBACK_URL"},
+		{"non-http fallback", func(c *Config) { c.SearchFallbackURL = "ftp://search.example.com" }, "SEARCH_FALLBACK_URL"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.InternalBaseURL = "http://localhost:8080" // LoadConfig derives it
+		tt.modify(&cfg)
+		err := cfg.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want one naming %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// RequireJSONContentType is middleware that, with STRICT_CONTENT_TYPE on, rejects
+// POST requests whose Content-Type is not application/json with 415, so a client
+// sending JSON as text/plain finds out instead of silently working. /debug/echo
+// takes any body and is exempt. Other requests pass through untouched.
+func (s *Server) RequireJSONContentType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.StrictContentType || r.Method != http.MethodPost || r.URL.Path == "/debug/echo" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !isJSONContentType(r.Header.Get("Content-Type")) {
+			log.Printf("%s[%s] Rejecting request with Content-Type %q%s", colorRed, r.URL.Path, r.Header.Get("Content-Type"), colorReset)
+			writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported_media_type",
+				fmt.Sprintf("Content-Type must be application/json, got %q", r.Header.Get("Content-Type")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJSONContentType reports whether a Content-Type header names application/json,
+// with or without parameters such as charset
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireJSONContentType(t *testing.T) {
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		wantStrict  int
+	}{
+		{http.MethodPost, "/chat/estimate", "application/json", http.StatusOK},
+		{http.MethodPost, "/chat/estimate", "Application/JSON; charset=utf-8", http.StatusOK},
+		{http.MethodPost, "/chat/estimate", "text/plain", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "/chat/estimate", "", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "/chat/estimate", "application/json-patch+json", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "/debug/echo", "text/plain", http.StatusOK},
+		{http.MethodGet, "/features", "", http.StatusOK},
+	}
+	for _, strict := range []bool{false, true} {
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.StrictContentType = strict
+			cfg.DebugEndpoints = true
+		})
+		handler := s.RequireJSONContentType(HandlerFromMux(s, http.NewServeMux()))
+
+		for _, tt := range tests {
+			body := ""
+			if tt.method == http.MethodPost {
+				body = `{"message": "hello there"}`
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			want := http.StatusOK
+			if strict {
+				want = tt.wantStrict
+			}
+			if rec.Code != want {
+				t.Errorf("strict %v, %s %s as %q: status = %d, want %d: %s", strict, tt.method, tt.path, tt.contentType, rec.Code, want, rec.Body)
+			}
+			if want == http.StatusUnsupportedMediaType && !strings.Contains(rec.Body.String(), "unsupported_media_type") {
+				t.Errorf("strict, %q: body = %s, want an unsupported_media_type error", tt.contentType, rec.Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// conversationStore keeps chat histories server-side so clients can continue a
+// conversation by ID. Entries expire after ttl of inactivity, and when more than
+// maxEntries are stored the least recently used one is evicted.
+type conversationStore struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+}
+
+type conversation struct {
+	id        string
+	messages  []interface{}
+	expiresAt time.Time
+}
+
+// newConversationStore creates a store with the given inactivity TTL and capacity
+func newConversationStore(ttl time.Duration, maxEntries int) *conversationStore {
+	return &conversationStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns a copy of the conversation's messages and marks it as recently used
+func (c *conversationStore) Get(id string) ([]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	conv := el.Value.(*conversation)
+	if time.Now().After(conv.expiresAt) {
+		c.removeLocked(el)
+		return nil, false
+	}
+
+	conv.expiresAt = time.Now().Add(c.ttl)
+	c.order.MoveToFront(el)
+	return append([]interface{}(nil), conv.messages...), true
+}
+
+// Save stores messages under id, replacing any previous history
+func (c *conversationStore) Save(id string, messages []interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[id]; ok {
+		conv := el.Value.(*conversation)
+		conv.messages = messages
+		conv.expiresAt = time.Now().Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.pruneExpiredLocked()
+	for c.order.Len() >= c.maxEntries {
+		c.removeLocked(c.order.Back())
+	}
+
+	c.items[id] = c.order.PushFront(&conversation{
+		id:        id,
+		messages:  messages,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+}
+
+// Delete removes a conversation and reports whether it existed
+func (c *conversationStore) Delete(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[id]
+	if !ok {
+		return false
+	}
+	c.removeLocked(el)
+	return true
+}
+
+// pruneExpiredLocked drops expired conversations; c.mu must be held
+func (c *conversationStore) pruneExpiredLocked() {
+	now := time.Now()
+	for el := c.order.Back(); el != nil; {
+		prev := el.Prev()
+		if now.After(el.Value.(*conversation).expiresAt) {
+			c.removeLocked(el)
+		}
+		el = prev
+	}
+}
+
+// removeLocked unlinks el from the store; c.mu must be held
+func (c *conversationStore) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*conversation).id)
+}
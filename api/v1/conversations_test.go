@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConversationStoreEviction(t *testing.T) {
+	c := newConversationStore(time.Hour, 2)
+	c.Save("a", []interface{}{"a1"})
+	c.Save("b", []interface{}{"b1"})
+	if _, ok := c.Get("a"); !ok { // a is now the most recently used
+		t.Fatal("a should be stored")
+	}
+	c.Save("c", []interface{}{"c1"})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b, the least recently used, should have been evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := c.Get(id); !ok {
+			t.Errorf("%s should still be stored", id)
+		}
+	}
+
+	// Replacing a stored conversation does not evict anything
+	c.Save("a", []interface{}{"a1", "a2"})
+	if got, ok := c.Get("a"); !ok || len(got) != 2 {
+		t.Errorf("a = %v, %v, want its replaced history", got, ok)
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("c should survive a replace of a")
+	}
+
+	if !c.Delete("a") || c.Delete("a") {
+		t.Error("Delete should report whether the conversation existed")
+	}
+}
+
+func TestConversationStoreExpiry(t *testing.T) {
+	c := newConversationStore(20*time.Millisecond, 10)
+	c.Save("a", []interface{}{"a1"})
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("an idle conversation should expire after the TTL")
+	}
+}
+
+func TestPostChatContinuesConversation(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return answer(fmt.Sprintf("answer %d", n+1))
+	})
+	s := newTestServer(t, model.URL, nil)
+
+	rec := postChat(t, s, `{"message": "first"}`)
+	var first ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || first.ConversationId == nil {
+		t.Fatalf("first turn: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = postChat(t, s, fmt.Sprintf(`{"message": "second", "conversation_id": %q}`, *first.ConversationId))
+	var second ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil || second.ConversationId == nil || *second.ConversationId != *first.ConversationId {
+		t.Fatalf("second turn: %d %s, want the same conversation", rec.Code, rec.Body)
+	}
+
+	// The second turn replays the first turn's question and answer
+	var got []string
+	for _, m := range model.received()[1]["messages"].([]interface{}) {
+		msg := m.(map[string]interface{})
+		got = append(got, fmt.Sprintf("%s: %s", msg["role"], msg["content"]))
+	}
+	want := "user: first|assistant: answer 1|user: second"
+	if strings.Join(got, "|") != want {
+		t.Errorf("second turn sent %q, want %q", strings.Join(got, "|"), want)
+	}
+
+	rec = httptest.NewRecorder()
+	s.DeleteChat(rec, httptest.NewRequest(http.MethodDelete, "/chat/"+*first.ConversationId, nil), *first.ConversationId)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE = %d, want 204", rec.Code)
+	}
+	rec = postChat(t, s, fmt.Sprintf(`{"message": "third", "conversation_id": %q}`, *first.ConversationId))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("continuing a deleted conversation = %d, want 404", rec.Code)
+	}
+}
+
+// Regression: the stored user turn kept its prompt wrapping, so every later turn
+// replayed the prefix, suffix and language instruction once more
+func TestPostChatConversationStoresUnwrappedMessage(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return answer(fmt.Sprintf("answer %d", n+1))
+	})
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.UserPromptPrefix = "BEGIN"
+		cfg.UserPromptSuffix = "END"
+	})
+
+	rec := postChat(t, s, `{"message": "first"}`)
+	var first ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || first.ConversationId == nil {
+		t.Fatalf("first turn: %d %s", rec.Code, rec.Body)
+	}
+	postChat(t, s, fmt.Sprintf(`{"message": "second", "conversation_id": %q}`, *first.ConversationId))
+
+	var got []string
+	for _, m := range model.received()[1]["messages"].([]interface{}) {
+		msg := m.(map[string]interface{})
+		got = append(got, fmt.Sprintf("%s: %s", msg["role"], msg["content"]))
+	}
+	want := []string{"user: first", "assistant: answer 1", "user: BEGIN\n\nsecond\n\nEND"}
+	if !slices.Equal(got, want) {
+		t.Errorf("second turn sent %q, want %q", got, want)
+	}
+
+	stored, _ := s.conversations.Get(*first.ConversationId)
+	if len(stored) != 4 || stored[2].(map[string]string)["content"] != "second" {
+		t.Errorf("stored history = %v, want the user turns as written", stored)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Daily quota tuning: chats are counted in hourly buckets over a rolling day, and
+// clients with nothing left in the window are dropped at most every sweep interval
+const (
+	quotaWindow        = 24 * time.Hour
+	quotaBucketSize    = time.Hour
+	quotaSweepInterval = 10 * time.Minute
+)
+
+// dailyQuota caps each client's chats over a rolling 24 hours (DAILY_CHAT_QUOTA).
+// Counts are kept per hour, so allowance comes back an hour's worth at a time as
+// old chats age out of the window rather than all at once at midnight.
+type dailyQuota struct {
+	mu        sync.Mutex
+	limit     int
+	clients   map[string][]quotaBucket // oldest bucket first
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// quotaBucket counts a client's chats started within one hour
+type quotaBucket struct {
+	start time.Time
+	count int
+}
+
+func newDailyQuota(limit int) *dailyQuota {
+	return &dailyQuota{limit: limit, clients: make(map[string][]quotaBucket), now: time.Now}
+}
+
+// take counts one chat for client. It returns how many chats the client has left
+// in the window, or false and how long until the oldest counted hour ages out
+// when the quota is already used up.
+func (q *dailyQuota) take(client string) (remaining int, retryAfter time.Duration, ok bool) {
+	now := q.now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if now.Sub(q.lastSweep) >= quotaSweepInterval {
+		for c, buckets := range q.clients {
+			if len(liveQuotaBuckets(buckets, now)) == 0 {
+				delete(q.clients, c)
+			}
+		}
+		q.lastSweep = now
+	}
+
+	buckets := liveQuotaBuckets(q.clients[client], now)
+	used := 0
+	for _, b := range buckets {
+		used += b.count
+	}
+	if used >= q.limit {
+		q.clients[client] = buckets
+		return 0, buckets[0].start.Add(quotaWindow).Sub(now), false
+	}
+
+	start := now.Truncate(quotaBucketSize)
+	if n := len(buckets); n > 0 && buckets[n-1].start.Equal(start) {
+		buckets[n-1].count++
+	} else {
+		buckets = append(buckets, quotaBucket{start: start, count: 1})
+	}
+	q.clients[client] = buckets
+	return q.limit - used - 1, 0, true
+}
+
+// liveQuotaBuckets drops the buckets that have aged out of the window
+func liveQuotaBuckets(buckets []quotaBucket, now time.Time) []quotaBucket {
+	i := 0
+	for i < len(buckets) && !now.Before(buckets[i].start.Add(quotaWindow)) {
+		i++
+	}
+	return buckets[i:]
+}
+
+// EnforceDailyQuota is middleware that counts each client's chat requests and
+// rejects them with 429 once DailyChatQuota chats have been made in the last 24
+// hours. Clients are told apart by clientKey, so rotating unknown bearer tokens
+// doesn't reset the count, and told what is left in the X-Quota-Remaining header.
+// Other requests, including the server's own internal tool calls, pass through.
+func (s *Server) EnforceDailyQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.quota == nil || !isChatRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := s.clientKey(r)
+		remaining, retryAfter, ok := s.quota.take(client)
+		w.Header().Set("X-Quota-Limit", strconv.Itoa(s.cfg.DailyChatQuota))
+		w.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			log.Printf("%s[/chat] Rejecting request from %s: daily quota of %d chats used up%s", colorRed, client, s.cfg.DailyChatQuota, colorReset)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "daily_quota_exceeded",
+				fmt.Sprintf("The daily quota of %d chats per client is used up; try again in %s", s.cfg.DailyChatQuota, retryAfter.Round(time.Minute)))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDailyQuotaTake(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	q := newDailyQuota(3)
+	q.now = func() time.Time { return now }
+
+	for want := 2; want >= 1; want-- {
+		if remaining, _, ok := q.take("a"); !ok || remaining != want {
+			t.Fatalf("take = %d, %v, want %d, true", remaining, ok, want)
+		}
+	}
+	now = now.Add(2 * time.Hour)
+	if remaining, _, ok := q.take("a"); !ok || remaining != 0 {
+		t.Fatalf("third take = %d, %v, want 0, true", remaining, ok)
+	}
+	if _, _, ok := q.take("b"); !ok {
+		t.Error("another client should have its own quota")
+	}
+
+	_, retryAfter, ok := q.take("a")
+	if ok {
+		t.Fatal("take past the limit should fail")
+	}
+	// The two 09:00 chats age out at 09:00 the next day, 21.5 hours from 11:30
+	if want := 21*time.Hour + 30*time.Minute; retryAfter != want {
+		t.Errorf("retryAfter = %v, want %v", retryAfter, want)
+	}
+
+	now = now.Add(retryAfter)
+	for want := 1; want >= 0; want-- {
+		if remaining, _, ok := q.take("a"); !ok || remaining != want {
+			t.Errorf("take once the oldest hour aged out = %d, %v, want %d, true", remaining, ok, want)
+		}
+	}
+	if _, _, ok := q.take("a"); ok {
+		t.Error("the 11:00 chat is still in the window, so the quota should be used up again")
+	}
+
+	now = now.Add(quotaWindow)
+	q.take("c")
+	if _, found := q.clients["b"]; found {
+		t.Error("a client with nothing left in the window should be swept")
+	}
+}
+
+func TestEnforceDailyQuota(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.DailyChatQuota = 1
+		cfg.ModelAccess = map[string][]string{"team-a": {"gpt-5"}}
+	})
+	handler := s.EnforceDailyQuota(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		req           *http.Request
+		want          int
+		wantRemaining string
+	}{
+		{"first chat", chatRequestFrom("203.0.113.7:1000", "random-1"), http.StatusOK, "0"},
+		{"same IP, new random token", chatRequestFrom("203.0.113.7:1001", "random-2"), http.StatusTooManyRequests, "0"},
+		{"same IP, no token", chatRequestFrom("203.0.113.7:1002", ""), http.StatusTooManyRequests, "0"},
+		{"same IP, known token", chatRequestFrom("203.0.113.7:1003", "team-a"), http.StatusOK, "0"},
+		{"known token again, other IP", chatRequestFrom("198.51.100.1:1000", "team-a"), http.StatusTooManyRequests, "0"},
+		{"other IP", chatRequestFrom("198.51.100.1:1001", ""), http.StatusOK, "0"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, tt.req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if got := rec.Header().Get("X-Quota-Remaining"); got != tt.wantRemaining {
+			t.Errorf("%s: X-Quota-Remaining = %q, want %q", tt.name, got, tt.wantRemaining)
+		}
+		if tt.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 429 without Retry-After", tt.name)
+		}
+	}
+
+	nonChat := httptest.NewRequest(http.MethodGet, "/features", nil)
+	nonChat.RemoteAddr = "203.0.113.7:1004"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, nonChat)
+	if rec.Code != http.StatusOK {
+		t.Errorf("non-chat request: status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxDebugEchoBody caps how much of the request body /debug/echo reads
+const maxDebugEchoBody = 1 << 20
+
+// redactedEchoHeaders have their values masked in /debug/echo so credentials are
+// not reflected into client logs or screenshots
+var redactedEchoHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// PostDebugEcho implements ServerInterface.
+// (POST /debug/echo)
+func (s *Server) PostDebugEcho(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.DebugEndpoints, "DEBUG_ENDPOINTS") {
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxDebugEchoBody+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_body", "Failed to read request body: "+err.Error())
+		return
+	}
+	truncated := len(raw) > maxDebugEchoBody
+	if truncated {
+		raw = raw[:maxDebugEchoBody]
+	}
+
+	// JSON bodies are echoed parsed; anything else comes back as a string
+	var body interface{}
+	if len(raw) > 0 && json.Unmarshal(raw, &body) != nil {
+		body = string(raw)
+	}
+
+	headers := r.Header.Clone()
+	headers.Set("Host", r.Host)
+	for _, name := range redactedEchoHeaders {
+		values := headers.Values(name) // the clone's own slice, so it can be edited in place
+		for i, v := range values {
+			values[i] = fmt.Sprintf("[redacted, %d chars]", len(v))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(DebugEchoResponse{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		Query:         r.URL.Query(),
+		Headers:       headers,
+		Body:          body,
+		BodyTruncated: truncated,
+	})
+}
+
+// maxRawUpstreamBody caps the upstream body returned by include_raw_upstream
+const maxRawUpstreamBody = 64 << 10
+
+// upstreamCapture keeps the body of a chat's last upstream completion response for
+// include_raw_upstream, truncated to maxRawUpstreamBody. Streamed responses are
+// kept as their raw event-stream lines.
+type upstreamCapture struct {
+	body   string
+	marker string // TRUNCATION_MARKER
+}
+
+// record replaces the captured body; a nil capture records nothing
+func (c *upstreamCapture) record(body []byte) {
+	if c == nil {
+		return
+	}
+	c.body = truncateText(string(body), maxRawUpstreamBody, c.marker)
+}
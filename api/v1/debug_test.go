@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPostDebugEcho(t *testing.T) {
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/debug/echo?q=1&q=2", strings.NewReader(`{"hello": "world"}`))
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("X-Client", "cli")
+		return r
+	}
+
+	rec := httptest.NewRecorder()
+	Handler(newTestServer(t, "http://upstream.invalid", nil)).ServeHTTP(rec, request())
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "DEBUG_ENDPOINTS") {
+		t.Fatalf("without DEBUG_ENDPOINTS: %d %s, want 403", rec.Code, rec.Body)
+	}
+
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.DebugEndpoints = true
+	})
+	rec = httptest.NewRecorder()
+	Handler(s).ServeHTTP(rec, request())
+	var resp DebugEchoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if resp.Method != http.MethodPost || resp.Path != "/debug/echo" || !reflect.DeepEqual(resp.Query["q"], []string{"1", "2"}) {
+		t.Errorf("method, path, query = %s %s %v", resp.Method, resp.Path, resp.Query)
+	}
+	if !reflect.DeepEqual(resp.Body, map[string]interface{}{"hello": "world"}) || resp.BodyTruncated {
+		t.Errorf("body = %#v (truncated %v), want the parsed JSON", resp.Body, resp.BodyTruncated)
+	}
+	if got := resp.Headers["X-Client"]; len(got) != 1 || got[0] != "cli" {
+		t.Errorf("X-Client = %q", got)
+	}
+	if got := resp.Headers["Authorization"]; len(got) != 1 || strings.Contains(got[0], "secret") {
+		t.Errorf("Authorization = %q, want it redacted", got)
+	}
+}
+
+func TestPostChatIncludeRawUpstream(t *testing.T) {
+	small := answer("hello")
+	// Pad the answer past the cap with whitespace the JSON decoder skips
+	large := small[:len(small)-1] + strings.Repeat(" ", 70<<10) + "}"
+
+	tests := []struct {
+		name  string
+		debug bool
+		reply string
+		want  func(raw *string) bool
+	}{
+		{"gated off", false, small, func(raw *string) bool { return raw == nil }},
+		{"small body", true, small, func(raw *string) bool { return raw != nil && *raw == small }},
+		{"large body", true, large, func(raw *string) bool {
+			return raw != nil && strings.HasPrefix(*raw, large[:maxRawUpstreamBody]) &&
+				strings.HasSuffix(*raw, fmt.Sprintf("…[truncated %d bytes]", len(large)-maxRawUpstreamBody))
+		}},
+	}
+	for _, tt := range tests {
+		model := newModelStub(t, func(int, map[string]interface{}) string { return tt.reply })
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.DebugEndpoints = tt.debug
+		})
+
+		rec := postChat(t, s, `{"message": "hi", "include_raw_upstream": true}`)
+		var resp ChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil || *resp.Content != "hello" {
+			t.Fatalf("%s: %d %.200s", tt.name, rec.Code, rec.Body)
+		}
+		if !tt.want(resp.RawUpstream) {
+			raw := "<nil>"
+			if resp.RawUpstream != nil {
+				raw = *resp.RawUpstream
+			}
+			t.Errorf("%s: raw_upstream = %.200q… (%d bytes)", tt.name, raw, len(raw))
+		}
+	}
+}
+
+func TestChatStreamIncludeRawUpstream(t *testing.T) {
+	const chunk = `data: {"choices": [{"delta": {"content": "hi"}, "finish_reason": "stop"}]}`
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, chunk+"\n\ndata: [DONE]\n\n")
+	}))
+	defer model.Close()
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.DebugEndpoints = true
+	})
+
+	rec := postChat(t, s, `{"message": "hi", "stream": true, "include_raw_upstream": true}`)
+	var done ChatResponse
+	for _, ev := range readSSEEvents(t, rec.Body.String()) {
+		if ev.name == "done" {
+			_ = json.Unmarshal([]byte(ev.data), &done)
+		}
+	}
+	if done.RawUpstream == nil {
+		t.Fatalf("no raw_upstream in the done event")
+	}
+	// Lines are kept up to the end-of-stream marker
+	if want := chunk + "\n\ndata: [DONE]\n"; *done.RawUpstream != want {
+		t.Errorf("raw_upstream = %q, want the event-stream lines %q", *done.RawUpstream, want)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEncodeDecodeInput caps the input of one encode_decode call
+const maxEncodeDecodeInput = 64 * 1024
+
+// encodeDecodeResult is returned to the model by the encode_decode tool. Decoded
+// bytes that aren't UTF-8 text are returned hex-encoded in ResultHex instead.
+type encodeDecodeResult struct {
+	Operation string `json:"operation"`
+	Format    string `json:"format"`
+	Result    string `json:"result"`
+	ResultHex string `json:"result_hex,omitempty"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// EncodeDecode encodes input to, or decodes it from, base64, hex or URL percent
+// encoding. Decoding is lenient about the variants models produce: base64 may be
+// URL-safe or unpadded and wrapped across lines, hex may be upper case and spaced.
+func EncodeDecode(operation, format, input string) (*encodeDecodeResult, error) {
+	operation = strings.ToLower(strings.TrimSpace(operation))
+	format = strings.ToLower(strings.TrimSpace(format))
+	if operation != "encode" && operation != "decode" {
+		return nil, fmt.Errorf("unsupported operation %q (use encode or decode)", operation)
+	}
+	if len(input) > maxEncodeDecodeInput {
+		return nil, fmt.Errorf("input is too large (%d bytes, limit %d)", len(input), maxEncodeDecodeInput)
+	}
+
+	result := &encodeDecodeResult{Operation: operation, Format: format}
+	if operation == "encode" {
+		switch format {
+		case "base64":
+			result.Result = base64.StdEncoding.EncodeToString([]byte(input))
+		case "hex":
+			result.Result = hex.EncodeToString([]byte(input))
+		case "url":
+			result.Result = url.QueryEscape(input)
+		default:
+			return nil, fmt.Errorf("unsupported format %q (use base64, hex or url)", format)
+		}
+		return result, nil
+	}
+
+	var decoded []byte
+	var err error
+	switch format {
+	case "base64":
+		decoded, err = decodeBase64(input)
+	case "hex":
+		decoded, err = hex.DecodeString(strings.TrimPrefix(stripSpace(input), "0x"))
+	case "url":
+		var s string
+		s, err = url.QueryUnescape(input)
+		decoded = []byte(s)
+	default:
+		return nil, fmt.Errorf("unsupported format %q (use base64, hex or url)", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("input is not valid %s: %v", format, err)
+	}
+	if utf8.Valid(decoded) {
+		result.Result = string(decoded)
+	} else {
+		result.ResultHex = hex.EncodeToString(decoded)
+		result.Binary = true
+	}
+	return result, nil
+}
+
+// decodeBase64 decodes standard or URL-safe base64, padded or not
+func decodeBase64(input string) ([]byte, error) {
+	input = strings.TrimRight(stripSpace(input), "=")
+	if strings.ContainsAny(input, "-_") {
+		return base64.RawURLEncoding.DecodeString(input)
+	}
+	return base64.RawStdEncoding.DecodeString(input)
+}
+
+// stripSpace removes all whitespace, e.g. the line breaks of wrapped base64
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		operation, format, input string
+		want                     encodeDecodeResult
+	}{
+		{"encode", "base64", "héllo?", encodeDecodeResult{Result: "aMOpbGxvPw=="}},
+		{"decode", "base64", "aMOpbGxvPw==", encodeDecodeResult{Result: "héllo?"}},
+		{"decode", "base64", "aMOpbG\nxvPw", encodeDecodeResult{Result: "héllo?"}},       // wrapped, unpadded
+		{"decode", "base64", "-_8", encodeDecodeResult{ResultHex: "fbff", Binary: true}}, // URL-safe, not text
+		{"encode", "hex", "Go!", encodeDecodeResult{Result: "476f21"}},
+		{"decode", "hex", "0x47 6F 21", encodeDecodeResult{Result: "Go!"}},
+		{"encode", "url", "a b&c=d/é", encodeDecodeResult{Result: "a+b%26c%3Dd%2F%C3%A9"}},
+		{"decode", "url", "a+b%26c%3Dd%2F%C3%A9", encodeDecodeResult{Result: "a b&c=d/é"}},
+		{" Encode ", "HEX", "", encodeDecodeResult{Result: ""}},
+	}
+	for _, tt := range tests {
+		got, err := EncodeDecode(tt.operation, tt.format, tt.input)
+		if err != nil {
+			t.Errorf("%s %s %q: %v", tt.operation, tt.format, tt.input, err)
+			continue
+		}
+		tt.want.Operation = strings.ToLower(strings.TrimSpace(tt.operation))
+		tt.want.Format = strings.ToLower(tt.format)
+		if *got != tt.want {
+			t.Errorf("%s %s %q = %+v, want %+v", tt.operation, tt.format, tt.input, *got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecodeErrors(t *testing.T) {
+	tests := []struct {
+		operation, format, input string
+		want                     string
+	}{
+		{"decode", "base64", "not base64!", "input is not valid base64"},
+		{"decode", "hex", "abc", "input is not valid hex"},
+		{"decode", "hex", "zz", "input is not valid hex"},
+		{"decode", "url", "100%", "input is not valid url"},
+		{"encrypt", "base64", "x", "unsupported operation"},
+		{"encode", "rot13", "x", "unsupported format"},
+		{"decode", "rot13", "x", "unsupported format"},
+		{"encode", "hex", strings.Repeat("x", maxEncodeDecodeInput+1), "input is too large"},
+	}
+	for _, tt := range tests {
+		if got, err := EncodeDecode(tt.operation, tt.format, tt.input); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %s %.20q = %+v, %v, want an error containing %q", tt.operation, tt.format, tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecodeTool(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", nil)
+
+	result, ok := s.executeTool(t.Context(), "encode_decode", `{"operation": "decode", "format": "base64", "input": "aGk="}`)
+	var got encodeDecodeResult
+	if !ok || json.Unmarshal([]byte(result), &got) != nil || got.Result != "hi" {
+		t.Errorf("decode = %s, %v, want hi", result, ok)
+	}
+
+	// Bad input is reported to the model, which can correct it and try again
+	for _, args := range []string{
+		`{"operation": "decode", "format": "hex", "input": "xyz"}`,
+		`{"operation": "encode", "format": "hex"}`,
+		`not json`,
+	} {
+		result, ok := s.executeTool(t.Context(), "encode_decode", args)
+		var body map[string]string
+		if ok || json.Unmarshal([]byte(result), &body) != nil || body["error"] == "" {
+			t.Errorf("%s: %s, %v, want an error result", args, result, ok)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// feedEntry is one normalized RSS item or Atom entry
+type feedEntry struct {
+	Title     string `json:"title"`
+	Link      string `json:"link"`
+	Published string `json:"published,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+}
+
+// feedResult is the result of the read_feed tool
+type feedResult struct {
+	Url     string      `json:"url"`
+	Title   string      `json:"title"`
+	Format  string      `json:"format"`
+	Entries []feedEntry `json:"entries"`
+}
+
+// rssDocument covers RSS 2.0 (<rss><channel><item>) and RSS 1.0/RDF (<rdf:RDF><item>)
+type rssDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+}
+
+type atomDocument struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+}
+
+// maxFeedSummaryLength caps each entry's summary so large feeds stay within the model's context
+const maxFeedSummaryLength = 500
+
+// ReadFeed fetches rawURL through the SSRF-protected page client and parses it as
+// an RSS or Atom feed, returning at most maxEntries entries with their summaries
+// cut to maxFeedSummaryLength.
+func (s *Server) ReadFeed(ctx context.Context, rawURL string, maxEntries int) (*feedResult, error) {
+	page, err := s.fetchPage(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parseFeed([]byte(page.Body))
+	if err != nil {
+		return nil, err
+	}
+	result.Url = page.URL
+	if len(result.Entries) > maxEntries {
+		result.Entries = result.Entries[:maxEntries]
+	}
+	for i := range result.Entries {
+		result.Entries[i].Summary = truncateRunes(result.Entries[i].Summary, maxFeedSummaryLength, s.cfg.TruncationMarker)
+	}
+	return result, nil
+}
+
+// parseFeed detects the feed format from the root element and normalizes its entries
+func parseFeed(body []byte) (*feedResult, error) {
+	root, err := feedRootElement(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root {
+	case "feed":
+		var doc atomDocument
+		if err := xml.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("invalid Atom feed: %w", err)
+		}
+		result := &feedResult{Title: strings.TrimSpace(doc.Title), Format: "atom", Entries: []feedEntry{}}
+		for _, e := range doc.Entries {
+			published := e.Published
+			if published == "" {
+				published = e.Updated
+			}
+			summary := e.Summary
+			if summary == "" {
+				summary = e.Content
+			}
+			result.Entries = append(result.Entries, feedEntry{
+				Title:     strings.TrimSpace(e.Title),
+				Link:      atomEntryLink(e),
+				Published: normalizeFeedDate(published),
+				Summary:   feedSummary(summary),
+			})
+		}
+		return result, nil
+
+	case "rss", "RDF":
+		var doc rssDocument
+		if err := xml.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("invalid RSS feed: %w", err)
+		}
+		items := doc.Channel.Items
+		if len(items) == 0 {
+			items = doc.Items
+		}
+		result := &feedResult{Title: strings.TrimSpace(doc.Channel.Title), Format: "rss", Entries: []feedEntry{}}
+		for _, item := range items {
+			link := strings.TrimSpace(item.Link)
+			if link == "" {
+				link = strings.TrimSpace(item.GUID)
+			}
+			published := item.PubDate
+			if published == "" {
+				published = item.Date
+			}
+			result.Entries = append(result.Entries, feedEntry{
+				Title:     strings.TrimSpace(item.Title),
+				Link:      link,
+				Published: normalizeFeedDate(published),
+				Summary:   feedSummary(item.Description),
+			})
+		}
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed (root element <%s>)", root)
+	}
+}
+
+// feedRootElement returns the local name of the document's first element
+func feedRootElement(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("not an RSS or Atom feed: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// atomEntryLink prefers the rel="alternate" (or unlabelled) link of an entry
+func atomEntryLink(e atomEntry) string {
+	for _, l := range e.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	if len(e.Links) > 0 {
+		return strings.TrimSpace(e.Links[0].Href)
+	}
+	return ""
+}
+
+// normalizeFeedDate converts the common RSS and Atom date formats to RFC 3339,
+// returning the trimmed input unchanged when it cannot be parsed
+func normalizeFeedDate(v string) string {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{time.RFC3339, time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return v
+}
+
+// feedSummary strips markup from an entry summary
+func feedSummary(v string) string {
+	return htmlToText(v)
+}
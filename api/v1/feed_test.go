@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const rssFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title> Go Blog </title>
+    <item>
+      <title>Go 1.25 is released</title>
+      <link>https://go.dev/blog/go1.25</link>
+      <pubDate>Tue, 12 Aug 2025 10:00:00 +0000</pubDate>
+      <description>&lt;p&gt;The &lt;b&gt;latest&lt;/b&gt; release.&lt;/p&gt;</description>
+    </item>
+    <item>
+      <title>No link</title>
+      <guid>https://go.dev/blog/guid-only</guid>
+      <pubDate>sometime last week</pubDate>
+    </item>
+    <item>
+      <title>Third</title>
+      <link>https://go.dev/blog/third</link>
+    </item>
+  </channel>
+</rss>`
+
+const atomFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example Atom</title>
+  <entry>
+    <title>First entry</title>
+    <link rel="self" href="https://example.com/api/1"/>
+    <link rel="alternate" href="https://example.com/posts/1"/>
+    <published>2025-03-01T08:30:00+02:00</published>
+    <summary>Short summary</summary>
+  </entry>
+  <entry>
+    <title>Second entry</title>
+    <link href="https://example.com/posts/2"/>
+    <updated>2025-03-02T12:00:00Z</updated>
+    <content type="html">&lt;p&gt;Body only&lt;/p&gt;</content>
+  </entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		format string
+		title  string
+		want   []feedEntry
+	}{
+		{"rss", rssFixture, "rss", "Go Blog", []feedEntry{
+			{Title: "Go 1.25 is released", Link: "https://go.dev/blog/go1.25", Published: "2025-08-12T10:00:00Z", Summary: "The latest release."},
+			{Title: "No link", Link: "https://go.dev/blog/guid-only", Published: "sometime last week"},
+			{Title: "Third", Link: "https://go.dev/blog/third"},
+		}},
+		{"atom", atomFixture, "atom", "Example Atom", []feedEntry{
+			{Title: "First entry", Link: "https://example.com/posts/1", Published: "2025-03-01T06:30:00Z", Summary: "Short summary"},
+			{Title: "Second entry", Link: "https://example.com/posts/2", Published: "2025-03-02T12:00:00Z", Summary: "Body only"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFeed([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("parseFeed: %v", err)
+			}
+			if got.Format != tt.format || got.Title != tt.title {
+				t.Errorf("format, title = %q, %q, want %q, %q", got.Format, got.Title, tt.format, tt.title)
+			}
+			if len(got.Entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d: %+v", len(got.Entries), len(tt.want), got.Entries)
+			}
+			for i, w := range tt.want {
+				if got.Entries[i] != w {
+					t.Errorf("entry %d = %+v, want %+v", i, got.Entries[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFeedRejectsOtherDocuments(t *testing.T) {
+	for _, body := range []string{`<html><body>hi</body></html>`, `not xml at all`, ``} {
+		if _, err := parseFeed([]byte(body)); err == nil || !strings.Contains(err.Error(), "not an RSS or Atom feed") {
+			t.Errorf("parseFeed(%q) error = %v", body, err)
+		}
+	}
+}
+
+func TestReadFeedLimitsEntries(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = w.Write([]byte(rssFixture))
+	}))
+	defer site.Close()
+
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true // the feed is on loopback
+	})
+	got, err := s.ReadFeed(context.Background(), site.URL+"/feed.xml", 2)
+	if err != nil {
+		t.Fatalf("ReadFeed: %v", err)
+	}
+	if got.Url != site.URL+"/feed.xml" || got.Format != "rss" || len(got.Entries) != 2 {
+		t.Errorf("ReadFeed = %+v, want the first 2 RSS entries of %s/feed.xml", got, site.URL)
+	}
+}
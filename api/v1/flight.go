@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls with the same key into one execution
+// whose result every caller receives, like golang.org/x/sync/singleflight.
+type flightGroup[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+	dups  int
+}
+
+// Do runs fn once for all concurrent callers with the same key. fn runs on its
+// own goroutine, so a caller whose ctx is done returns ctx.Err() right away while
+// the call carries on for the others; fn must therefore bound itself rather than
+// rely on any one caller's context. shared reports whether the result was handed
+// to more than one caller, in which case callers that modify it must copy it first.
+func (g *flightGroup[V]) Do(ctx context.Context, key string, fn func() (V, error)) (value V, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[V])
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.dups++
+	} else {
+		c = &flightCall[V]{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		// dups is final once the key is removed, which happens before done closes
+		return c.value, c.err, c.dups > 0
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err(), false
+	}
+}
+
+// run executes fn for c and releases its waiters and key, even if fn panics
+func (g *flightGroup[V]) run(key string, c *flightCall[V], fn func() (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s[flight] Shared call panicked: %v%s", colorRed, r, colorReset)
+			c.err = fmt.Errorf("shared call panicked: %v", r)
+		}
+		// Removing the key and freezing dups under one lock means no caller can
+		// join after shared has been decided
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.err = fn()
+}
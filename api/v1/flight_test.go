@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForDups blocks until dups callers have joined the flight for key
+func waitForDups[V any](g *flightGroup[V], key string, dups int) {
+	for {
+		g.mu.Lock()
+		c := g.calls[key]
+		joined := c != nil && c.dups == dups
+		g.mu.Unlock()
+		if joined {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlightGroupShares(t *testing.T) {
+	var g flightGroup[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make(chan bool, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do(context.Background(), "k", fn)
+			if v != 42 || err != nil {
+				t.Errorf("Do = %d, %v, want 42, nil", v, err)
+			}
+			results <- shared
+		}()
+	}
+	waitForDups(&g, "k", callers-1)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn ran %d times, want 1", n)
+	}
+	for shared := range results {
+		if !shared {
+			t.Error("every caller of a shared flight should be told it was shared")
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("finished flights should be forgotten, got %v", g.calls)
+	}
+
+	if _, _, shared := g.Do(context.Background(), "k", func() (int, error) { return 1, nil }); shared {
+		t.Error("a lone caller should not be told its result was shared")
+	}
+}
+
+func TestFlightGroupCallerGivesUp(t *testing.T) {
+	var g flightGroup[int]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		close(started)
+		<-release
+		return 7, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan error)
+	go func() {
+		_, err, _ := g.Do(ctx, "k", fn)
+		gaveUp <- err
+	}()
+	<-started
+
+	waited := make(chan int)
+	go func() {
+		v, _, _ := g.Do(context.Background(), "k", fn)
+		waited <- v
+	}()
+	waitForDups(&g, "k", 1)
+
+	cancel()
+	if err := <-gaveUp; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if v := <-waited; v != 7 {
+		t.Errorf("remaining caller got %d, want 7 from the flight the first caller started", v)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup[int]
+	_, err, _ := g.Do(context.Background(), "k", func() (int, error) { panic("boom") })
+	if err == nil {
+		t.Fatal("a panicking call should be reported as an error")
+	}
+	if v, err, _ := g.Do(context.Background(), "k", func() (int, error) { return 1, nil }); v != 1 || err != nil {
+		t.Errorf("Do after a panic = %d, %v, want 1, nil", v, err)
+	}
+}
+
+func TestCallSearchAPICoalesces(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		keyword := "golang"
+		_ = json.NewEncoder(w).Encode(SearchResponse{Queries: &[]SearchQueryResult{{
+			Keyword:  &keyword,
+			Response: &map[string]interface{}{"results": []interface{}{map[string]interface{}{"title": "Go"}}},
+		}}})
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, nil)
+
+	const callers = 5
+	responses := make(chan *SearchResponse, callers)
+	for range callers {
+		go func() {
+			resp, err := s.CallSearchAPI(t.Context(), []string{"golang"}, SearchOptions{MaxResults: 3})
+			if err != nil {
+				t.Errorf("CallSearchAPI: %v", err)
+			}
+			responses <- resp
+		}()
+	}
+	for joined := false; !joined; time.Sleep(time.Millisecond) {
+		s.searchFlight.mu.Lock()
+		for _, c := range s.searchFlight.calls {
+			joined = c.dups == callers-1
+		}
+		s.searchFlight.mu.Unlock()
+	}
+	close(release)
+
+	seen := map[*SearchResponse]bool{}
+	for range callers {
+		resp := <-responses
+		if !hasSearchResults(resp) {
+			t.Errorf("caller got %+v, want the shared results", resp)
+		}
+		seen[resp] = true
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream searched %d times, want 1", n)
+	}
+	if len(seen) != callers {
+		t.Errorf("callers got %d distinct responses, want each its own copy", len(seen))
+	}
+}
+
+func TestCallSearchAPINormalizedKeywordsShareCalls(t *testing.T) {
+	var calls atomic.Int32
+	var sent atomic.Value
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Keywords []string `json:"keywords"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		sent.Store(req.Keywords)
+		<-release
+		_, _ = w.Write([]byte(`{"queries": [{"keyword": "go tips", "response": {"results": [{"title": "Go"}]}}]}`))
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.SearchLowercaseKeywords = true
+	})
+
+	spellings := [][]string{{"go tips"}, {"  Go   Tips "}, {"GO TIPS", "go tips"}}
+	done := make(chan error, len(spellings))
+	for _, keywords := range spellings {
+		go func() {
+			_, err := s.CallSearchAPI(t.Context(), keywords, SearchOptions{})
+			done <- err
+		}()
+	}
+	for joined := false; !joined; time.Sleep(time.Millisecond) {
+		s.searchFlight.mu.Lock()
+		for _, c := range s.searchFlight.calls {
+			joined = c.dups == len(spellings)-1
+		}
+		s.searchFlight.mu.Unlock()
+	}
+	close(release)
+	for range spellings {
+		if err := <-done; err != nil {
+			t.Errorf("CallSearchAPI: %v", err)
+		}
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream searched %d times, want every spelling to share one call", n)
+	}
+	if got := sent.Load().([]string); !slices.Equal(got, []string{"go tips"}) {
+		t.Errorf("upstream got keywords %q, want the normalized keyword", got)
+	}
+
+	if _, err := s.CallSearchAPI(t.Context(), []string{" ", ""}, SearchOptions{}); err == nil || !strings.Contains(err.Error(), "no search keywords") {
+		t.Errorf("blank keywords: error = %v", err)
+	}
+}
@@ -6,34 +6,242 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/oapi-codegen/runtime"
 )
 
+// Defines values for ChatRequestOutputFormat.
+const (
+	MarkdownEscaped ChatRequestOutputFormat = "markdown_escaped"
+	Raw             ChatRequestOutputFormat = "raw"
+)
+
+// Defines values for ChatRequestResponseFormat.
+const (
+	JsonObject ChatRequestResponseFormat = "json_object"
+	Text       ChatRequestResponseFormat = "text"
+)
+
+// Defines values for PageReaderRequestFormat.
+const (
+	PageReaderRequestFormatMarkdown PageReaderRequestFormat = "markdown"
+	PageReaderRequestFormatText     PageReaderRequestFormat = "text"
+)
+
+// Defines values for SearchRequestSafeSearch.
+const (
+	Moderate SearchRequestSafeSearch = "moderate"
+	Off      SearchRequestSafeSearch = "off"
+	Strict   SearchRequestSafeSearch = "strict"
+)
+
 // Defines values for ToolCallType.
 const (
 	Function ToolCallType = "function"
 )
 
+// AssistantTurn defines model for AssistantTurn.
+type AssistantTurn struct {
+	// Content Text the model sent with this turn, if any
+	Content *string `json:"content,omitempty"`
+
+	// ToolCalls Tool calls the model made in this turn
+	ToolCalls *[]ToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChatEstimateRequest defines model for ChatEstimateRequest.
+type ChatEstimateRequest struct {
+	// History Prior conversation turns that would be sent along with the message
+	History *[]ChatMessage `json:"history,omitempty"`
+
+	// Message User message to estimate
+	Message string `json:"message"`
+}
+
+// ChatEstimateResponse defines model for ChatEstimateResponse.
+type ChatEstimateResponse struct {
+	// Exceeds Whether the estimate is above the configured limit
+	Exceeds bool `json:"exceeds"`
+
+	// Limit Configured context limit in tokens
+	Limit int `json:"limit"`
+
+	// Tokens Approximate number of prompt tokens
+	Tokens int `json:"tokens"`
+}
+
+// ChatMessage defines model for ChatMessage.
+type ChatMessage struct {
+	// Content Message text
+	Content string `json:"content"`
+
+	// Role Message author role, e.g. user, assistant or system
+	Role string `json:"role"`
+}
+
 // ChatRequest defines model for ChatRequest.
 type ChatRequest struct {
+	// AssistantPrefill Start of the final answer; the model continues from it, and the returned content begins with it
+	AssistantPrefill *string `json:"assistant_prefill,omitempty"`
+
+	// AutoContinue When the answer stops at the token limit (finish_reason length), ask the model to continue and join the parts, up to MAX_CONTINUATIONS times
+	AutoContinue *bool `json:"auto_continue,omitempty"`
+
+	// ConversationId Continue a server-side conversation returned by a previous /chat call
+	ConversationId *string `json:"conversation_id,omitempty"`
+
+	// IncludeCitations Also return the sources (search results, pages, feeds) fed to the model while answering
+	IncludeCitations *bool `json:"include_citations,omitempty"`
+
+	// IncludeRawUpstream Also return the last raw upstream response body, truncated; honored only when DEBUG_ENDPOINTS is on
+	IncludeRawUpstream *bool `json:"include_raw_upstream,omitempty"`
+
+	// IncludeToolOutputs Also return each tool call's raw output alongside the final synthesized content
+	IncludeToolOutputs *bool `json:"include_tool_outputs,omitempty"`
+
+	// IncludeTurns Also return every assistant message of the tool loop, including turns that only made tool calls
+	IncludeTurns *bool `json:"include_turns,omitempty"`
+
+	// Language Language code the answer must be written in (e.g. en, fr, pt-BR); overrides RESPONSE_LANGUAGE
+	Language *string `json:"language,omitempty"`
+
+	// MaxTokens Upper bound on the tokens generated by each model call; sent under the parameter name the selected model expects
+	MaxTokens *int `json:"max_tokens,omitempty"`
+
 	// Message User message to send to the AI
 	Message string `json:"message"`
 
 	// Model Model to use - gpt-5, supermind-agent-v1, deepseek, etc.
 	Model *string `json:"model,omitempty"`
+
+	// OutputFormat How the returned content is encoded - raw model text, or with Markdown control characters escaped
+	OutputFormat *ChatRequestOutputFormat `json:"output_format,omitempty"`
+
+	// ResponseFormat Format the answer must take - free text, or a single valid JSON object (forwarded to the upstream and validated)
+	ResponseFormat *ChatRequestResponseFormat `json:"response_format,omitempty"`
+
+	// Seed Sampling seed for reproducible outputs; only honored if the upstream model supports it
+	Seed *int `json:"seed,omitempty"`
+
+	// Stop End the final answer at any of these sequences, which are not included; a single string or up to MAX_STOP_SEQUENCES strings. Only sent on the final synthesis call, never while the model is choosing tools
+	Stop *ChatRequest_Stop `json:"stop,omitempty"`
+
+	// Stream Stream the response as Server-Sent Events; only the final answer is streamed token by token, tool calls are reported as progress events
+	Stream *bool `json:"stream,omitempty"`
+}
+
+// ChatRequestOutputFormat How the returned content is encoded - raw model text, or with Markdown control characters escaped
+type ChatRequestOutputFormat string
+
+// ChatRequestResponseFormat Format the answer must take - free text, or a single valid JSON object (forwarded to the upstream and validated)
+type ChatRequestResponseFormat string
+
+// ChatRequestStop0 defines model for .
+type ChatRequestStop0 = string
+
+// ChatRequestStop1 defines model for .
+type ChatRequestStop1 = []string
+
+// ChatRequest_Stop End the final answer at any of these sequences, which are not included; a single string or up to MAX_STOP_SEQUENCES strings. Only sent on the final synthesis call, never while the model is choosing tools
+type ChatRequest_Stop struct {
+	union json.RawMessage
 }
 
 // ChatResponse defines model for ChatResponse.
 type ChatResponse struct {
+	// Citations Sources fed to the model while answering, in call order (only when include_citations is set)
+	Citations *[]Citation `json:"citations,omitempty"`
+
 	// Content AI response content
-	Content       *string         `json:"content,omitempty"`
+	Content *string `json:"content,omitempty"`
+
+	// ConversationId ID to pass back in ChatRequest to continue this conversation
+	ConversationId *string `json:"conversation_id,omitempty"`
+
+	// FinishReason Why the model stopped writing the answer - stop, length (hit the token limit), tool_calls, content_filter, ...; omitted when the upstream did not say
+	FinishReason *string `json:"finish_reason,omitempty"`
+
+	// Hint Advice about the answer, e.g. that it may be truncated when finish_reason is length
+	Hint *string `json:"hint,omitempty"`
+
+	// RawUpstream Body of the last upstream response, truncated (only when include_raw_upstream is set and DEBUG_ENDPOINTS is on)
+	RawUpstream   *string         `json:"raw_upstream,omitempty"`
 	SearchResults *SearchResponse `json:"search_results,omitempty"`
 
 	// ToolCalls Tool calls requested by the model
 	ToolCalls *[]ToolCall `json:"tool_calls,omitempty"`
+
+	// ToolOutputs Outputs of the tools run while answering, in call order (only when include_tool_outputs is set)
+	ToolOutputs *[]ToolOutput `json:"tool_outputs,omitempty"`
+
+	// Turns Assistant messages of the tool loop in order, ending with the final answer (only when include_turns is set)
+	Turns *[]AssistantTurn `json:"turns,omitempty"`
+}
+
+// ChatTemplateRequest defines model for ChatTemplateRequest.
+type ChatTemplateRequest struct {
+	// Model Model to use - gpt-5, supermind-agent-v1, deepseek, etc.
+	Model *string `json:"model,omitempty"`
+
+	// Variables Values for the template's variables
+	Variables map[string]string `json:"variables"`
+}
+
+// Citation defines model for Citation.
+type Citation struct {
+	// Title Title of the source, when the tool reported one
+	Title *string `json:"title,omitempty"`
+
+	// Tool Tool whose result included the source
+	Tool string `json:"tool"`
+
+	// Url URL of the source
+	Url string `json:"url"`
+}
+
+// DebugEchoResponse defines model for DebugEchoResponse.
+type DebugEchoResponse struct {
+	// Body Request body, parsed when it is JSON and as a string otherwise
+	Body interface{} `json:"body"`
+
+	// BodyTruncated Whether the body was cut at 1 MiB
+	BodyTruncated bool `json:"body_truncated"`
+
+	// Headers Request headers (credential values redacted)
+	Headers map[string][]string `json:"headers"`
+
+	// Method HTTP method of the request
+	Method string `json:"method"`
+
+	// Path Request path
+	Path string `json:"path"`
+
+	// Query Parsed query parameters
+	Query map[string][]string `json:"query"`
+}
+
+// ErrorResponse defines model for ErrorResponse.
+type ErrorResponse struct {
+	// Error Machine-readable error code
+	Error string `json:"error"`
+
+	// Message Human-readable explanation of the error
+	Message *string `json:"message,omitempty"`
+}
+
+// FeaturesResponse defines model for FeaturesResponse.
+type FeaturesResponse struct {
+	// Flags Feature toggles keyed by name
+	Flags map[string]bool `json:"flags"`
+
+	// Limits Numeric limits keyed by name
+	Limits map[string]int `json:"limits"`
+
+	// Tools Tools offered to the model in /chat
+	Tools []string `json:"tools"`
 }
 
 // HelloResponse defines model for HelloResponse.
@@ -41,12 +249,78 @@ type HelloResponse struct {
 	Message string `json:"message"`
 }
 
+// LatencyStats defines model for LatencyStats.
+type LatencyStats struct {
+	// Count Calls recorded in the current window
+	Count int `json:"count"`
+
+	// P50Ms Median latency in milliseconds
+	P50Ms int `json:"p50_ms"`
+
+	// P90Ms 90th percentile latency in milliseconds
+	P90Ms int `json:"p90_ms"`
+
+	// P99Ms 99th percentile latency in milliseconds
+	P99Ms int `json:"p99_ms"`
+}
+
+// PageHeadRequest defines model for PageHeadRequest.
+type PageHeadRequest struct {
+	// Url URL of the webpage
+	Url string `json:"url"`
+}
+
+// PageHeadResponse defines model for PageHeadResponse.
+type PageHeadResponse struct {
+	// Headers Response headers, with sensitive ones removed
+	Headers map[string]string `json:"headers"`
+
+	// Status HTTP status code returned by the page
+	Status int `json:"status"`
+
+	// Url Final URL after redirects
+	Url string `json:"url"`
+}
+
+// PageMetaRequest defines model for PageMetaRequest.
+type PageMetaRequest struct {
+	// Url URL of the webpage
+	Url string `json:"url"`
+}
+
+// PageMetaResponse defines model for PageMetaResponse.
+type PageMetaResponse struct {
+	// CanonicalUrl Absolute URL from the page's rel=canonical link
+	CanonicalUrl *string `json:"canonical_url,omitempty"`
+
+	// Description Content of the description meta tag
+	Description *string `json:"description,omitempty"`
+
+	// OpenGraph OpenGraph properties without the og prefix (title, image, type, ...); image and url are made absolute
+	OpenGraph map[string]string `json:"open_graph"`
+
+	// Title Contents of the page's title element
+	Title *string `json:"title,omitempty"`
+
+	// Url Final URL after redirects
+	Url string `json:"url"`
+}
+
 // PageReaderRequest defines model for PageReaderRequest.
 type PageReaderRequest struct {
+	// Format Return the page as plain text (the default) or as Markdown
+	Format *PageReaderRequestFormat `json:"format,omitempty"`
+
 	// Url URL of the webpage to read
-	Url string `json:"url"`
+	Url *string `json:"url,omitempty"`
+
+	// Urls URLs of several webpages to read concurrently, at most PAGE_READER_MAX_URLS (default 10) including url
+	Urls *[]string `json:"urls,omitempty"`
 }
 
+// PageReaderRequestFormat Return the page as plain text (the default) or as Markdown
+type PageReaderRequestFormat string
+
 // PageReaderResponse defines model for PageReaderResponse.
 type PageReaderResponse struct {
 	// Content Extracted text content from the webpage
@@ -55,10 +329,85 @@ type PageReaderResponse struct {
 	// Error Error message if fetch failed
 	Error *string `json:"error,omitempty"`
 
+	// FinalUrl The URL actually read, after following redirects
+	FinalUrl *string `json:"final_url,omitempty"`
+
+	// MarkdownFallback Markdown was requested but the page's HTML could not be converted, so plain text was returned instead
+	MarkdownFallback *bool `json:"markdown_fallback,omitempty"`
+
+	// Results Per-URL results when multiple urls were requested
+	Results *[]PageReaderResult `json:"results,omitempty"`
+
+	// Url The URL that was fetched
+	Url *string `json:"url,omitempty"`
+}
+
+// PageReaderResult defines model for PageReaderResult.
+type PageReaderResult struct {
+	// Content Extracted text content from the webpage
+	Content *string `json:"content,omitempty"`
+
+	// Error Error message if fetch failed
+	Error *string `json:"error,omitempty"`
+
+	// FinalUrl The URL actually read, after following redirects
+	FinalUrl *string `json:"final_url,omitempty"`
+
+	// MarkdownFallback Markdown was requested but the page's HTML could not be converted, so plain text was returned instead
+	MarkdownFallback *bool `json:"markdown_fallback,omitempty"`
+
 	// Url The URL that was fetched
 	Url *string `json:"url,omitempty"`
 }
 
+// ReadyzResponse defines model for ReadyzResponse.
+type ReadyzResponse struct {
+	// Checks Result of each readiness check keyed by name; docs (the Swagger UI files) is reported but never makes the server unready
+	Checks map[string]string `json:"checks"`
+
+	// Ready Whether every readiness check passed
+	Ready bool `json:"ready"`
+}
+
+// RecentChat defines model for RecentChat.
+type RecentChat struct {
+	// DurationMs Time taken to answer, in milliseconds
+	DurationMs int `json:"duration_ms"`
+
+	// Model Model the chat used
+	Model string `json:"model"`
+
+	// RequestId Request ID of the chat
+	RequestId string `json:"request_id"`
+
+	// Status HTTP status returned to the client
+	Status int `json:"status"`
+
+	// Timestamp When the request arrived (RFC 3339, UTC)
+	Timestamp string `json:"timestamp"`
+
+	// ToolCount Number of tool calls run while answering
+	ToolCount int `json:"tool_count"`
+}
+
+// RecentChatsResponse defines model for RecentChatsResponse.
+type RecentChatsResponse struct {
+	// Chats Summaries of the most recent chat requests, newest first
+	Chats []RecentChat `json:"chats"`
+}
+
+// RequestLogsResponse defines model for RequestLogsResponse.
+type RequestLogsResponse struct {
+	// Dropped Number of older lines dropped to stay within the per-request cap
+	Dropped int `json:"dropped"`
+
+	// Lines Buffered log lines for the request, oldest first
+	Lines []string `json:"lines"`
+
+	// RequestId Request ID the lines belong to
+	RequestId string `json:"request_id"`
+}
+
 // RunCommandRequest defines model for RunCommandRequest.
 type RunCommandRequest struct {
 	// Command Shell command to execute (only whitelisted commands allowed)
@@ -97,21 +446,69 @@ type SearchQueryResult struct {
 
 // SearchRequest defines model for SearchRequest.
 type SearchRequest struct {
-	// Keywords Search keywords
+	// Broaden When fewer than SEARCH_BROADEN_MIN_RESULTS results come back, retry once with broader keywords (quotes and the most specific term dropped)
+	Broaden *bool `json:"broaden,omitempty"`
+
+	// ExcludeDomains Drop results whose host is one of these domains or their subdomains
+	ExcludeDomains *[]string `json:"exclude_domains,omitempty"`
+
+	// Fields Only include these fields in each result (default all fields)
+	Fields *[]string `json:"fields,omitempty"`
+
+	// IncludeDomains Only keep results whose host is one of these domains or their subdomains
+	IncludeDomains *[]string `json:"include_domains,omitempty"`
+
+	// Keywords Search keywords; only the first SEARCH_MAX_KEYWORDS are used
 	Keywords []string `json:"keywords"`
 
-	// MaxResults Maximum number of results per keyword
+	// Language Only keep results detected as this language, e.g. en or pt-BR; results whose language is uncertain are kept
+	Language *string `json:"language,omitempty"`
+
+	// MaxResults Maximum number of results per keyword (default SEARCH_MAX_RESULTS, capped at SEARCH_MAX_RESULTS_LIMIT)
 	MaxResults *int `json:"max_results,omitempty"`
+
+	// SafeSearch Filter explicit content from results (default SEARCH_SAFE_SEARCH)
+	SafeSearch *SearchRequestSafeSearch `json:"safe_search,omitempty"`
+
+	// SnippetLength Cut each result's snippet to this many characters (default SEARCH_SNIPPET_MAX_LENGTH)
+	SnippetLength *int `json:"snippet_length,omitempty"`
 }
 
+// SearchRequestSafeSearch Filter explicit content from results (default SEARCH_SAFE_SEARCH)
+type SearchRequestSafeSearch string
+
 // SearchResponse defines model for SearchResponse.
 type SearchResponse struct {
+	// BroadenedKeywords The broader keywords the results are for, when broaden retried the search and found more
+	BroadenedKeywords *[]string `json:"broadened_keywords,omitempty"`
+
 	// CombinedAnswer Combined answer from search results
 	CombinedAnswer *string              `json:"combined_answer,omitempty"`
 	Errors         *[]SearchError       `json:"errors,omitempty"`
 	Queries        *[]SearchQueryResult `json:"queries,omitempty"`
 }
 
+// SearchSuggestRequest defines model for SearchSuggestRequest.
+type SearchSuggestRequest struct {
+	// Limit Maximum number of suggestions to return
+	Limit *int `json:"limit,omitempty"`
+
+	// Query Partial query typed so far
+	Query string `json:"query"`
+}
+
+// SearchSuggestResponse defines model for SearchSuggestResponse.
+type SearchSuggestResponse struct {
+	// Suggestions Suggested completions or related queries
+	Suggestions []string `json:"suggestions"`
+}
+
+// StatsResponse defines model for StatsResponse.
+type StatsResponse struct {
+	// UpstreamLatency Latency percentiles of upstream calls keyed by upstream (chat, chat_batch, search, search_fallback)
+	UpstreamLatency map[string]LatencyStats `json:"upstream_latency"`
+}
+
 // ToolCall defines model for ToolCall.
 type ToolCall struct {
 	Function ToolCallFunction `json:"function"`
@@ -135,41 +532,220 @@ type ToolCallFunction struct {
 	Name string `json:"name"`
 }
 
+// ToolOutput defines model for ToolOutput.
+type ToolOutput struct {
+	// Arguments JSON-encoded arguments the model passed to the tool
+	Arguments string `json:"arguments"`
+
+	// Name Name of the tool that ran
+	Name string `json:"name"`
+
+	// Output Raw tool result as sent back to the model
+	Output string `json:"output"`
+
+	// Success Whether the tool ran without error
+	Success bool `json:"success"`
+
+	// ToolCallId ID of the tool call this output answers
+	ToolCallId string `json:"tool_call_id"`
+}
+
+// WarmRequest defines model for WarmRequest.
+type WarmRequest struct {
+	// Queries Search queries whose suggestions to prefetch into the /search/suggest cache
+	Queries *[]string `json:"queries,omitempty"`
+
+	// Urls Pages to prefetch into the page_reader cache
+	Urls *[]string `json:"urls,omitempty"`
+}
+
+// WarmResponse defines model for WarmResponse.
+type WarmResponse struct {
+	// Failed Number of targets that could not be fetched
+	Failed int `json:"failed"`
+
+	// Results Outcome per target, urls first, in request order
+	Results []WarmResult `json:"results"`
+
+	// Succeeded Number of targets fetched and cached
+	Succeeded int `json:"succeeded"`
+}
+
+// WarmResult defines model for WarmResult.
+type WarmResult struct {
+	// Error Why the target could not be fetched
+	Error *string `json:"error,omitempty"`
+
+	// Kind Whether the target is a url or a query
+	Kind string `json:"kind"`
+
+	// Ok Whether the target was fetched and cached
+	Ok bool `json:"ok"`
+
+	// Target The URL or query
+	Target string `json:"target"`
+}
+
 // GetHelloParams defines parameters for GetHello.
 type GetHelloParams struct {
 	// Name Name to greet
 	Name string `form:"name" json:"name"`
 }
 
+// PostAdminWarmJSONRequestBody defines body for PostAdminWarm for application/json ContentType.
+type PostAdminWarmJSONRequestBody = WarmRequest
+
 // PostChatJSONRequestBody defines body for PostChat for application/json ContentType.
 type PostChatJSONRequestBody = ChatRequest
 
+// PostChatEstimateJSONRequestBody defines body for PostChatEstimate for application/json ContentType.
+type PostChatEstimateJSONRequestBody = ChatEstimateRequest
+
+// PostChatTemplateJSONRequestBody defines body for PostChatTemplate for application/json ContentType.
+type PostChatTemplateJSONRequestBody = ChatTemplateRequest
+
 // PostPageReaderJSONRequestBody defines body for PostPageReader for application/json ContentType.
 type PostPageReaderJSONRequestBody = PageReaderRequest
 
+// PostPageReaderHeadJSONRequestBody defines body for PostPageReaderHead for application/json ContentType.
+type PostPageReaderHeadJSONRequestBody = PageHeadRequest
+
+// PostPageReaderMetaJSONRequestBody defines body for PostPageReaderMeta for application/json ContentType.
+type PostPageReaderMetaJSONRequestBody = PageMetaRequest
+
 // PostRunCommandJSONRequestBody defines body for PostRunCommand for application/json ContentType.
 type PostRunCommandJSONRequestBody = RunCommandRequest
 
+// PostRunCommandStreamJSONRequestBody defines body for PostRunCommandStream for application/json ContentType.
+type PostRunCommandStreamJSONRequestBody = RunCommandRequest
+
 // PostSearchJSONRequestBody defines body for PostSearch for application/json ContentType.
 type PostSearchJSONRequestBody = SearchRequest
 
+// PostSearchSuggestJSONRequestBody defines body for PostSearchSuggest for application/json ContentType.
+type PostSearchSuggestJSONRequestBody = SearchSuggestRequest
+
+// AsChatRequestStop0 returns the union data inside the ChatRequest_Stop as a ChatRequestStop0
+func (t ChatRequest_Stop) AsChatRequestStop0() (ChatRequestStop0, error) {
+	var body ChatRequestStop0
+	err := json.Unmarshal(t.union, &body)
+	return body, err
+}
+
+// FromChatRequestStop0 overwrites any union data inside the ChatRequest_Stop as the provided ChatRequestStop0
+func (t *ChatRequest_Stop) FromChatRequestStop0(v ChatRequestStop0) error {
+	b, err := json.Marshal(v)
+	t.union = b
+	return err
+}
+
+// MergeChatRequestStop0 performs a merge with any union data inside the ChatRequest_Stop, using the provided ChatRequestStop0
+func (t *ChatRequest_Stop) MergeChatRequestStop0(v ChatRequestStop0) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	merged, err := runtime.JSONMerge(t.union, b)
+	t.union = merged
+	return err
+}
+
+// AsChatRequestStop1 returns the union data inside the ChatRequest_Stop as a ChatRequestStop1
+func (t ChatRequest_Stop) AsChatRequestStop1() (ChatRequestStop1, error) {
+	var body ChatRequestStop1
+	err := json.Unmarshal(t.union, &body)
+	return body, err
+}
+
+// FromChatRequestStop1 overwrites any union data inside the ChatRequest_Stop as the provided ChatRequestStop1
+func (t *ChatRequest_Stop) FromChatRequestStop1(v ChatRequestStop1) error {
+	b, err := json.Marshal(v)
+	t.union = b
+	return err
+}
+
+// MergeChatRequestStop1 performs a merge with any union data inside the ChatRequest_Stop, using the provided ChatRequestStop1
+func (t *ChatRequest_Stop) MergeChatRequestStop1(v ChatRequestStop1) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	merged, err := runtime.JSONMerge(t.union, b)
+	t.union = merged
+	return err
+}
+
+func (t ChatRequest_Stop) MarshalJSON() ([]byte, error) {
+	b, err := t.union.MarshalJSON()
+	return b, err
+}
+
+func (t *ChatRequest_Stop) UnmarshalJSON(b []byte) error {
+	err := t.union.UnmarshalJSON(b)
+	return err
+}
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// Buffered log lines for one request (admin)
+	// (GET /admin/logs/{request_id})
+	GetAdminLogs(w http.ResponseWriter, r *http.Request, requestId string)
+	// Summaries of recent chat requests (admin)
+	// (GET /admin/recent)
+	GetAdminRecent(w http.ResponseWriter, r *http.Request)
+	// Prefetch pages and search suggestions into the caches (admin)
+	// (POST /admin/warm)
+	PostAdminWarm(w http.ResponseWriter, r *http.Request)
 	// Chat with AI
 	// (POST /chat)
 	PostChat(w http.ResponseWriter, r *http.Request)
+	// Estimate the token count of a chat message and history
+	// (POST /chat/estimate)
+	PostChatEstimate(w http.ResponseWriter, r *http.Request)
+	// Run a named server-side prompt template
+	// (POST /chat/template/{name})
+	PostChatTemplate(w http.ResponseWriter, r *http.Request, name string)
+	// Delete a stored conversation
+	// (DELETE /chat/{id})
+	DeleteChat(w http.ResponseWriter, r *http.Request, id string)
+	// Echo back the received request (debug)
+	// (POST /debug/echo)
+	PostDebugEcho(w http.ResponseWriter, r *http.Request)
+	// List active feature flags, enabled tools and limits
+	// (GET /features)
+	GetFeatures(w http.ResponseWriter, r *http.Request)
 	// Say hello
 	// (GET /hello)
 	GetHello(w http.ResponseWriter, r *http.Request, params GetHelloParams)
-	// Read and extract text from a webpage
+	// Read and extract text from one or more webpages
 	// (POST /page_reader)
 	PostPageReader(w http.ResponseWriter, r *http.Request)
+	// Fetch a webpage's response headers with a HEAD request
+	// (POST /page_reader/head)
+	PostPageReaderHead(w http.ResponseWriter, r *http.Request)
+	// Fetch a webpage's title, description, OpenGraph tags and canonical URL
+	// (POST /page_reader/meta)
+	PostPageReaderMeta(w http.ResponseWriter, r *http.Request)
+	// Report whether the server is ready to serve chats
+	// (GET /readyz)
+	GetReadyz(w http.ResponseWriter, r *http.Request)
 	// Run a whitelisted shell command
 	// (POST /run_command)
 	PostRunCommand(w http.ResponseWriter, r *http.Request)
+	// Run a whitelisted shell command, streaming output lines as they arrive
+	// (POST /run_command/stream)
+	PostRunCommandStream(w http.ResponseWriter, r *http.Request)
 	// Search the web
 	// (POST /search)
 	PostSearch(w http.ResponseWriter, r *http.Request)
+	// Suggest query completions for a partial search query
+	// (POST /search/suggest)
+	PostSearchSuggest(w http.ResponseWriter, r *http.Request)
+	// Upstream latency percentiles
+	// (GET /stats)
+	GetStats(w http.ResponseWriter, r *http.Request)
 }
 
 // ServerInterfaceWrapper converts contexts to parameters.
@@ -181,6 +757,59 @@ type ServerInterfaceWrapper struct {
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
+// GetAdminLogs operation middleware
+func (siw *ServerInterfaceWrapper) GetAdminLogs(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "request_id" -------------
+	var requestId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "request_id", r.PathValue("request_id"), &requestId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "request_id", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetAdminLogs(w, r, requestId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetAdminRecent operation middleware
+func (siw *ServerInterfaceWrapper) GetAdminRecent(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetAdminRecent(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PostAdminWarm operation middleware
+func (siw *ServerInterfaceWrapper) PostAdminWarm(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostAdminWarm(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // PostChat operation middleware
 func (siw *ServerInterfaceWrapper) PostChat(w http.ResponseWriter, r *http.Request) {
 
@@ -195,6 +824,98 @@ func (siw *ServerInterfaceWrapper) PostChat(w http.ResponseWriter, r *http.Reque
 	handler.ServeHTTP(w, r)
 }
 
+// PostChatEstimate operation middleware
+func (siw *ServerInterfaceWrapper) PostChatEstimate(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostChatEstimate(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PostChatTemplate operation middleware
+func (siw *ServerInterfaceWrapper) PostChatTemplate(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "name" -------------
+	var name string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "name", r.PathValue("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "name", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostChatTemplate(w, r, name)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// DeleteChat operation middleware
+func (siw *ServerInterfaceWrapper) DeleteChat(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", r.PathValue("id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteChat(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PostDebugEcho operation middleware
+func (siw *ServerInterfaceWrapper) PostDebugEcho(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostDebugEcho(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetFeatures operation middleware
+func (siw *ServerInterfaceWrapper) GetFeatures(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetFeatures(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // GetHello operation middleware
 func (siw *ServerInterfaceWrapper) GetHello(w http.ResponseWriter, r *http.Request) {
 
@@ -243,6 +964,48 @@ func (siw *ServerInterfaceWrapper) PostPageReader(w http.ResponseWriter, r *http
 	handler.ServeHTTP(w, r)
 }
 
+// PostPageReaderHead operation middleware
+func (siw *ServerInterfaceWrapper) PostPageReaderHead(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostPageReaderHead(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// PostPageReaderMeta operation middleware
+func (siw *ServerInterfaceWrapper) PostPageReaderMeta(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostPageReaderMeta(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetReadyz operation middleware
+func (siw *ServerInterfaceWrapper) GetReadyz(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetReadyz(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // PostRunCommand operation middleware
 func (siw *ServerInterfaceWrapper) PostRunCommand(w http.ResponseWriter, r *http.Request) {
 
@@ -257,6 +1020,20 @@ func (siw *ServerInterfaceWrapper) PostRunCommand(w http.ResponseWriter, r *http
 	handler.ServeHTTP(w, r)
 }
 
+// PostRunCommandStream operation middleware
+func (siw *ServerInterfaceWrapper) PostRunCommandStream(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostRunCommandStream(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // PostSearch operation middleware
 func (siw *ServerInterfaceWrapper) PostSearch(w http.ResponseWriter, r *http.Request) {
 
@@ -271,6 +1048,34 @@ func (siw *ServerInterfaceWrapper) PostSearch(w http.ResponseWriter, r *http.Req
 	handler.ServeHTTP(w, r)
 }
 
+// PostSearchSuggest operation middleware
+func (siw *ServerInterfaceWrapper) PostSearchSuggest(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.PostSearchSuggest(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetStats operation middleware
+func (siw *ServerInterfaceWrapper) GetStats(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetStats(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 type UnescapedCookieParamError struct {
 	ParamName string
 	Err       error
@@ -391,11 +1196,25 @@ func HandlerWithOptions(si ServerInterface, options StdHTTPServerOptions) http.H
 		ErrorHandlerFunc:   options.ErrorHandlerFunc,
 	}
 
+	m.HandleFunc("GET "+options.BaseURL+"/admin/logs/{request_id}", wrapper.GetAdminLogs)
+	m.HandleFunc("GET "+options.BaseURL+"/admin/recent", wrapper.GetAdminRecent)
+	m.HandleFunc("POST "+options.BaseURL+"/admin/warm", wrapper.PostAdminWarm)
 	m.HandleFunc("POST "+options.BaseURL+"/chat", wrapper.PostChat)
+	m.HandleFunc("POST "+options.BaseURL+"/chat/estimate", wrapper.PostChatEstimate)
+	m.HandleFunc("POST "+options.BaseURL+"/chat/template/{name}", wrapper.PostChatTemplate)
+	m.HandleFunc("DELETE "+options.BaseURL+"/chat/{id}", wrapper.DeleteChat)
+	m.HandleFunc("POST "+options.BaseURL+"/debug/echo", wrapper.PostDebugEcho)
+	m.HandleFunc("GET "+options.BaseURL+"/features", wrapper.GetFeatures)
 	m.HandleFunc("GET "+options.BaseURL+"/hello", wrapper.GetHello)
 	m.HandleFunc("POST "+options.BaseURL+"/page_reader", wrapper.PostPageReader)
+	m.HandleFunc("POST "+options.BaseURL+"/page_reader/head", wrapper.PostPageReaderHead)
+	m.HandleFunc("POST "+options.BaseURL+"/page_reader/meta", wrapper.PostPageReaderMeta)
+	m.HandleFunc("GET "+options.BaseURL+"/readyz", wrapper.GetReadyz)
 	m.HandleFunc("POST "+options.BaseURL+"/run_command", wrapper.PostRunCommand)
+	m.HandleFunc("POST "+options.BaseURL+"/run_command/stream", wrapper.PostRunCommandStream)
 	m.HandleFunc("POST "+options.BaseURL+"/search", wrapper.PostSearch)
+	m.HandleFunc("POST "+options.BaseURL+"/search/suggest", wrapper.PostSearchSuggest)
+	m.HandleFunc("GET "+options.BaseURL+"/stats", wrapper.GetStats)
 
 	return m
 }
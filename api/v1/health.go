@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// startupPingTimeout bounds the one-off upstream check made when STARTUP_PING is set
+const startupPingTimeout = 10 * time.Second
+
+// docsIndexFile is the page the Swagger UI directory must hold for /docs/ to work
+const docsIndexFile = "index.html"
+
+// Upstream check states reported by /readyz
+const (
+	upstreamUnchecked = "unchecked"
+	upstreamPending   = "pending"
+	upstreamOK        = "ok"
+)
+
+// upstreamStatus records the outcome of a startup check: the upstream ping, or the
+// docs check
+type upstreamStatus struct {
+	mu     sync.Mutex
+	status string
+}
+
+func (u *upstreamStatus) set(status string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = status
+}
+
+func (u *upstreamStatus) get() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status
+}
+
+// PingUpstream makes one authenticated GET /models call to the AI Builder API to
+// confirm the base URL is reachable and the API key is accepted. The result is
+// logged and reported by /readyz; failures never stop the server.
+func (s *Server) PingUpstream(ctx context.Context) error {
+	s.upstream.set(upstreamPending)
+
+	err := s.pingUpstream(ctx)
+	if err != nil {
+		s.upstream.set("failed: " + err.Error())
+		log.Printf("%s[startup] Upstream check failed: %v%s", colorRed, err, colorReset)
+		return err
+	}
+
+	s.upstream.set(upstreamOK)
+	log.Printf("%s[startup] Upstream check passed: API key accepted by %s%s", colorGreen, s.baseURL, colorReset)
+	return nil
+}
+
+func (s *Server) pingUpstream(ctx context.Context) error {
+	if s.apiKey == "" {
+		return fmt.Errorf("API_KEY is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, startupPingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upstream unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("API key rejected (HTTP %d)", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("upstream returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CheckDocs verifies the Swagger UI directory served under /docs/ holds its index
+// page, logging a warning when it doesn't. The result is reported by /readyz. The
+// OpenAPI spec needs no check: it is embedded in the binary (OpenAPISpec).
+func (s *Server) CheckDocs(dir string) error {
+	info, err := os.Stat(filepath.Join(dir, docsIndexFile))
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%s is a directory", filepath.Join(dir, docsIndexFile))
+	}
+	if err != nil {
+		s.docs.set("missing: " + err.Error())
+		log.Printf("%s[startup] Swagger UI files not found, /docs/ will not work: %v%s", colorYellow, err, colorReset)
+		return err
+	}
+	s.docs.set(upstreamOK)
+	return nil
+}
+
+// GetReadyz implements ServerInterface.
+// (GET /readyz)
+func (s *Server) GetReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	switch {
+	case s.apiKey != "":
+		checks["api_key"] = "ok"
+	case s.cfg.MockMode:
+		checks["api_key"] = "missing (mock mode)"
+	case s.cfg.DemoMode:
+		checks["api_key"] = "missing (demo mode)"
+	default:
+		checks["api_key"] = "missing"
+		ready = false
+	}
+
+	// The upstream check only counts once it has run; without STARTUP_PING it stays unchecked
+	upstream := s.upstream.get()
+	checks["upstream"] = upstream
+	if upstream != upstreamOK && upstream != upstreamUnchecked {
+		ready = false
+	}
+
+	// Missing docs are reported but don't make the server unready: the API works without them
+	if docs := s.docs.get(); docs != "" {
+		checks["docs"] = docs
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ReadyzResponse{Ready: ready, Checks: checks})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readyz returns the status and body of GET /readyz
+func readyz(t *testing.T, s *Server) (int, ReadyzResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.GetReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp ReadyzResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	return rec.Code, resp
+}
+
+func TestPingUpstream(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		wantErr  string
+		wantCode int
+	}{
+		{"key accepted", http.StatusOK, "", http.StatusOK},
+		{"key rejected", http.StatusUnauthorized, "API key rejected (HTTP 401)", http.StatusServiceUnavailable},
+		{"upstream error", http.StatusInternalServerError, "upstream returned HTTP 500", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/models" || r.Header.Get("Authorization") != "Bearer test-key" {
+					t.Errorf("ping sent %s %s with Authorization %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer upstream.Close()
+			s := newTestServer(t, upstream.URL, nil)
+
+			if code, resp := readyz(t, s); code != http.StatusOK || resp.Checks["upstream"] != upstreamUnchecked {
+				t.Errorf("before the ping: status %d, checks %v", code, resp.Checks)
+			}
+
+			err := s.PingUpstream(context.Background())
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("PingUpstream error = %v, want %q", err, tt.wantErr)
+			}
+			code, resp := readyz(t, s)
+			if code != tt.wantCode || resp.Ready != (tt.wantCode == http.StatusOK) {
+				t.Errorf("after the ping: status %d, %+v", code, resp)
+			}
+			if tt.wantErr != "" && !strings.Contains(resp.Checks["upstream"], tt.wantErr) {
+				t.Errorf("upstream check = %q, want it to mention %q", resp.Checks["upstream"], tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPingUpstreamUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	s := newTestServer(t, upstream.URL, nil)
+	if err := s.PingUpstream(context.Background()); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("error = %v, want an unreachable upstream", err)
+	}
+}
+
+func TestCheckDocs(t *testing.T) {
+	withIndex := t.TempDir()
+	if err := os.WriteFile(filepath.Join(withIndex, docsIndexFile), []byte("<html></html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	indexIsDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(indexIsDir, docsIndexFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{"present", withIndex, false},
+		{"missing directory", filepath.Join(t.TempDir(), "swagger-ui"), true},
+		{"empty directory", t.TempDir(), true},
+		{"index is a directory", indexIsDir, true},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "http://upstream.invalid", nil)
+		if _, resp := readyz(t, s); resp.Checks["docs"] != "" {
+			t.Errorf("%s: docs check before CheckDocs = %q, want none", tt.name, resp.Checks["docs"])
+		}
+
+		if err := s.CheckDocs(tt.dir); (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckDocs error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		// Missing docs show up in /readyz but leave the server ready
+		code, resp := readyz(t, s)
+		if code != http.StatusOK {
+			t.Errorf("%s: /readyz status %d, want 200", tt.name, code)
+		}
+		if got := resp.Checks["docs"]; tt.wantErr != strings.HasPrefix(got, "missing: ") || !tt.wantErr && got != upstreamOK {
+			t.Errorf("%s: docs check = %q", tt.name, got)
+		}
+	}
+}
@@ -1,16 +1,25 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // ANSI color codes for terminal output
@@ -34,15 +43,180 @@ func prettyJSON(data interface{}) string {
 	return string(jsonBytes)
 }
 
-type Server struct{}
+// writeJSONError writes an ErrorResponse with the given status code
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	resp := ErrorResponse{
+		Error: code,
+	}
+	if message != "" {
+		resp.Message = &message
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// requireEnabled writes a 403 and returns false when an endpoint has been switched
+// off by the operator through envVar
+func requireEnabled(w http.ResponseWriter, enabled bool, envVar string) bool {
+	if enabled {
+		return true
+	}
+	writeJSONError(w, http.StatusForbidden, "feature_disabled",
+		fmt.Sprintf("This endpoint is disabled on this server (%s=false)", envVar))
+	return false
+}
+
+// newRequestID returns a random identifier used to correlate a request's logs
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDFor reuses the caller's X-Request-ID when provided, so logs can be
+// correlated end to end, and echoes the ID back on the response.
+func requestIDFor(w http.ResponseWriter, r *http.Request) string {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	return requestID
+}
+
+// Server implements ServerInterface. All external dependencies are injected
+// through NewServer so handlers can be exercised without touching process env.
+type Server struct {
+	cfg     Config
+	apiKey  string
+	baseURL string
+	client  *http.Client
+	audit   *auditLogger
+
+	suggestCache  *ttlCache[[]string]
+	conversations *conversationStore
+	kv            *kvStore
+
+	pageClient *http.Client
+	pageCache  *ttlCache[*fetchedPage]
+	// pageFetchSlots bounds outbound page fetches across the whole process
+	pageFetchSlots chan struct{}
+	// toolSlots bounds tool calls running across the whole process
+	toolSlots chan struct{}
+
+	templates  *promptTemplates
+	safeFilter *contentFilter
+
+	chatLimiter *clientLimiter
+	// quota counts chats per client for DAILY_CHAT_QUOTA; nil when there is no quota
+	quota *dailyQuota
+
+	upstream *upstreamStatus
+	docs     *upstreamStatus // "" until CheckDocs runs
+	latency  *latencyRecorder
+
+	requestLogs *requestLogBuffer
+	recent      *recentChats
+
+	searchFlight flightGroup[*SearchResponse]
+
+	// batcher is nil unless CHAT_BATCH_WINDOW_MS enables micro-batching
+	batcher *completionBatcher
+}
+
+// NewServer creates a Server that calls the AI Builder API at baseURL with apiKey,
+// using client for all upstream requests. It fails if cfg.PromptTemplatesDir
+// contains a template that cannot be read or parsed.
+func NewServer(apiKey, baseURL string, client *http.Client, cfg Config) (*Server, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	templates, err := loadPromptTemplates(cfg.PromptTemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	safeFilter, err := newContentFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Fix run_command's working directory now, so later changes to the server's
+	// own do not move it
+	if cfg.CommandDir, err = filepath.Abs(cfg.CommandDir); err != nil {
+		return nil, fmt.Errorf("COMMAND_DIR: %w", err)
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+		audit:   newAuditLogger(cfg.AuditLogPath, cfg.TruncationMarker),
+
+		suggestCache:  newTTLCache[[]string](suggestCacheTTL, suggestCacheMaxEntries),
+		conversations: newConversationStore(cfg.ConversationTTL, cfg.MaxConversations),
+		kv:            newKVStore(cfg.ConversationTTL, cfg.MaxConversations, cfg.KVMaxKeys, cfg.KVMaxValueBytes),
+
+		pageClient: newPageClient(cfg),
+		pageCache:  newTTLCache[*fetchedPage](pageCacheTTL, pageCacheMaxEntries),
+
+		pageFetchSlots: make(chan struct{}, cfg.MaxConcurrentPageFetches),
+		toolSlots:      make(chan struct{}, cfg.MaxConcurrentTools),
+
+		templates:  templates,
+		safeFilter: safeFilter,
+
+		chatLimiter: newClientLimiter(cfg.MaxConcurrentChatsPerClient),
+
+		upstream: &upstreamStatus{status: upstreamUnchecked},
+		docs:     &upstreamStatus{},
+		latency:  newLatencyRecorder(),
+
+		requestLogs: newRequestLogBuffer(),
+		recent:      &recentChats{},
+	}
+	if cfg.MockMode {
+		log.Printf("%s%s[startup] MOCK_MODE is on - chats get canned answers and tools return canned data; no model or provider is called.%s",
+			colorBold, colorYellow, colorReset)
+	}
+	if cfg.ChatBatchWindow > 0 {
+		s.batcher = newCompletionBatcher(cfg.ChatBatchWindow, cfg.ChatBatchMaxSize, cfg.AIRequestTimeout, s.sendCompletionBatch)
+	}
+	if cfg.DailyChatQuota > 0 {
+		s.quota = newDailyQuota(cfg.DailyChatQuota)
+	}
+	return s, nil
+}
+
+// Config returns the configuration the server was built with
+func (s *Server) Config() Config {
+	return s.cfg
+}
 
-func NewServer() Server {
-	return Server{}
+// NewServerFromEnv loads the Config from the environment and builds a Server from it
+func NewServerFromEnv() (*Server, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.APIKey == "" {
+		log.Println("Warning: API_KEY not set")
+	}
+	if _, matched := regionBaseURL(cfg.AIBaseURLRegions, cfg.Region, ""); cfg.Region != "" && !matched {
+		log.Printf("Warning: REGION %q has no entry in AI_BASE_URL_REGIONS, using %s", cfg.Region, cfg.AIBaseURL)
+	} else if matched {
+		log.Printf("Using the %s AI Builder endpoint: %s", cfg.Region, cfg.AIBaseURL)
+	}
+	return NewServer(cfg.APIKey, cfg.AIBaseURL, &http.Client{}, cfg)
 }
 
 // GetHello implements ServerInterface.
 // (GET /hello)
-func (Server) GetHello(w http.ResponseWriter, r *http.Request, params GetHelloParams) {
+func (s *Server) GetHello(w http.ResponseWriter, r *http.Request, params GetHelloParams) {
 	resp := HelloResponse{
 		Message: fmt.Sprintf("Hello, World %s", params.Name),
 	}
@@ -52,10 +226,81 @@ func (Server) GetHello(w http.ResponseWriter, r *http.Request, params GetHelloPa
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// GetFeatures implements ServerInterface.
+// (GET /features)
+func (s *Server) GetFeatures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(s.features())
+}
+
+// features describes the active configuration to clients. Only switches and limits
+// are reported; keys, URLs and file paths stay private.
+func (s *Server) features() FeaturesResponse {
+	return FeaturesResponse{
+		Tools: toolNames(s.chatTools()),
+		Flags: map[string]bool{
+			"chat_available":        s.apiKey != "" || s.cfg.DemoMode || s.cfg.MockMode,
+			"demo_mode":             s.cfg.DemoMode,
+			"mock_mode":             s.cfg.MockMode,
+			"audit_log":             s.cfg.AuditLogPath != "",
+			"search_fallback":       s.cfg.SearchFallbackURL != "",
+			"prompt_wrapping":       s.cfg.UserPromptPrefix != "" || s.cfg.UserPromptSuffix != "",
+			"tls":                   s.cfg.TLSEnabled(),
+			"private_fetch":         s.cfg.AllowPrivateFetch,
+			"insecure_skip_verify":  s.cfg.InsecureSkipVerify,
+			"safe_mode":             s.cfg.SafeMode,
+			"tool_result_guard":     s.cfg.ToolResultGuard,
+			"debug_endpoints":       s.cfg.DebugEndpoints,
+			"strict_content_type":   s.cfg.StrictContentType,
+			"chat_streaming":        true,
+			"chat_batching":         s.cfg.ChatBatchWindow > 0,
+			"run_command_streaming": true,
+			"search":                s.cfg.EnableSearch,
+			"read_page":             s.cfg.EnableReadPage,
+			"run_command":           s.cfg.EnableRunCommand,
+		},
+		Limits: map[string]int{
+			"max_context_tokens":              s.cfg.MaxContextTokens,
+			"max_concurrent_chats_per_client": s.cfg.MaxConcurrentChatsPerClient,
+			"daily_chat_quota":                s.cfg.DailyChatQuota,
+			"max_tool_iterations":             s.cfg.MaxToolIterations,
+			"max_continuations":               s.cfg.MaxContinuations,
+			"max_stop_sequences":              s.cfg.MaxStopSequences,
+			"search_max_results":              s.cfg.SearchMaxResults,
+			"search_max_results_limit":        s.cfg.SearchMaxResultsLimit,
+			"search_max_keywords":             s.cfg.SearchMaxKeywords,
+			"search_snippet_max_length":       s.cfg.SearchSnippetMaxLength,
+			"search_broaden_min_results":      s.cfg.SearchBroadenMinResults,
+			"feed_max_entries":                s.cfg.FeedMaxEntries,
+			"suggest_max_limit":               suggestMaxLimit,
+			"max_concurrent_page_reads":       maxConcurrentPageReads,
+			"page_reader_max_urls":            s.cfg.PageReaderMaxURLs,
+			"max_concurrent_page_fetches":     s.cfg.MaxConcurrentPageFetches,
+			"max_concurrent_tools":            s.cfg.MaxConcurrentTools,
+		},
+	}
+}
+
 // PostChat implements ServerInterface.
 // (POST /chat)
-func (Server) PostChat(w http.ResponseWriter, r *http.Request) {
-	log.Printf("%s%s[/chat] ========== New request ==========%s", colorBold, colorCyan, colorReset)
+func (s *Server) PostChat(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(w, r)
+	s.extendChatWriteDeadline(w)
+
+	// Summarize the request for /admin/recent once it finishes, whatever the outcome
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	model := s.cfg.DefaultModel
+	toolCount := 0
+	var usage chatUsage
+	defer func() {
+		s.recordRecentChat(requestID, model, start, toolCount, rec.status)
+		s.notifyCompletion(requestID, model, start, toolCount, usage, rec.status)
+	}()
+
+	s.logf(requestID, "%s%s[/chat] ========== New request (id: %s)%s ==========%s", colorBold, colorCyan, requestID, requestMetaFrom(r.Context()).logTag(), colorReset)
 
 	// Parse request body
 	var req ChatRequest
@@ -64,344 +309,1379 @@ func (Server) PostChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("%s[/chat] Received message:%s %q", colorGreen, colorReset, req.Message)
+	s.logf(requestID, "%s[/chat] Received message:%s %q", colorGreen, colorReset, req.Message)
+
+	if req.Seed != nil && *req.Seed < 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_seed", "seed must be a non-negative integer")
+		return
+	}
+	if req.MaxTokens != nil && *req.MaxTokens <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_max_tokens", "max_tokens must be a positive integer")
+		return
+	}
+	stop, err := s.stopSequences(req.Stop)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_stop", err.Error())
+		return
+	}
+	if req.OutputFormat != nil && *req.OutputFormat != Raw && *req.OutputFormat != MarkdownEscaped {
+		writeJSONError(w, http.StatusBadRequest, "invalid_output_format", "output_format must be raw or markdown_escaped")
+		return
+	}
+	if req.ResponseFormat != nil && *req.ResponseFormat != Text && *req.ResponseFormat != JsonObject {
+		writeJSONError(w, http.StatusBadRequest, "invalid_response_format", "response_format must be text or json_object")
+		return
+	}
+	var language string
+	if req.Language != nil && *req.Language != "" {
+		if !languageCodeRe.MatchString(*req.Language) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_language", "language must be a language code such as en, fr or pt-BR")
+			return
+		}
+		language = *req.Language
+	}
+
+	if s.apiKey == "" && !s.cfg.MockMode {
+		if s.cfg.DemoMode {
+			s.logf(requestID, "%s[/chat] API_KEY not configured, answering in demo mode%s", colorYellow, colorReset)
+			content := fmt.Sprintf("[demo mode] API_KEY is not configured on this server, so no model was called. "+
+				"Set API_KEY in .env to get real answers. Your message was: %q", req.Message)
+
+			if req.Stream != nil && *req.Stream {
+				if sse := newSSEWriter(w); sse != nil {
+					(&chatStream{sse: sse, withhold: true}).finish(ChatResponse{Content: &content})
+					return
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(ChatResponse{Content: &content})
+			return
+		}
 
-	// Get API key from environment
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
-		http.Error(w, "API_KEY not configured", http.StatusInternalServerError)
+		s.logf(requestID, "%s[/chat] API_KEY not configured, rejecting request%s", colorRed, colorReset)
+		writeJSONError(w, http.StatusServiceUnavailable, "service_misconfigured",
+			"The chat service is not configured: API_KEY is missing on the server")
 		return
 	}
 
-	// Determine model (default to gpt-5)
-	model := "gpt-5"
+	// Determine model (default from config)
 	if req.Model != nil && *req.Model != "" {
 		model = *req.Model
 	}
-
-	// Default tool definitions
-	searchTool := map[string]interface{}{
-		"type": "function",
-		"function": map[string]interface{}{
-			"name":        "search",
-			"description": "Search the web for real-time information like weather, news, current events",
-			"parameters": map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"keywords": map[string]interface{}{
-						"type":        "array",
-						"items":       map[string]string{"type": "string"},
-						"description": "Search keywords",
-					},
-				},
-				"required": []string{"keywords"},
-			},
-		},
+	if !s.requireModelAllowed(w, r, model) {
+		return
 	}
 
-	readPageTool := map[string]interface{}{
-		"type": "function",
-		"function": map[string]interface{}{
-			"name":        "read_page",
-			"description": "Fetch a webpage URL and extract the main text content. Use this when you need to read the content of a specific webpage.",
-			"parameters": map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"url": map[string]interface{}{
-						"type":        "string",
-						"description": "The URL of the webpage to read",
-					},
-				},
-				"required": []string{"url"},
-			},
-		},
+	// Continue a stored conversation, or start a new one
+	var history []interface{}
+	conversationID := newRequestID()
+	if req.ConversationId != nil && *req.ConversationId != "" {
+		stored, ok := s.conversations.Get(*req.ConversationId)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "conversation_not_found",
+				"Conversation not found or expired; start a new one by omitting conversation_id")
+			return
+		}
+		history = stored
+		conversationID = *req.ConversationId
+		s.logf(requestID, "%s[/chat] Continuing conversation %s (%d prior messages)%s", colorBlue, conversationID, len(history), colorReset)
 	}
 
-	runCommandTool := map[string]interface{}{
-		"type": "function",
-		"function": map[string]interface{}{
-			"name":        "run_command",
-			"description": "Run a shell command on the system. Only whitelisted commands are allowed: ls, cd. Use this to list files or check directories.",
-			"parameters": map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"command": map[string]interface{}{
-						"type":        "string",
-						"description": "The shell command to execute (e.g., 'ls -la', 'ls /tmp')",
-					},
-				},
-				"required": []string{"command"},
-			},
-		},
+	// Build initial messages
+	userMsg := map[string]string{"role": "user", "content": s.wrapUserMessage(req.Message, language)}
+	messages := append(history, userMsg)
+
+	// First API call with all tools
+	tools := s.chatTools()
+	s.logf(requestID, "%s[/chat] Tools configured:%s %s", colorMagenta, colorReset, strings.Join(toolNames(tools), ", "))
+	opts := completionOptions{Seed: req.Seed, MaxTokens: req.MaxTokens, Stop: stop, conversationID: conversationID, meta: requestMetaFrom(r.Context())}
+	if req.AssistantPrefill != nil {
+		opts.Prefill = *req.AssistantPrefill
+	}
+	if req.ResponseFormat != nil && *req.ResponseFormat == JsonObject {
+		opts.JSONObject = true
+	}
+	opts.autoContinue = req.AutoContinue != nil && *req.AutoContinue
+	if req.IncludeRawUpstream != nil && *req.IncludeRawUpstream {
+		if s.cfg.DebugEndpoints {
+			opts.rawUpstream = &upstreamCapture{marker: s.cfg.TruncationMarker}
+		} else {
+			s.logf(requestID, "%s[/chat] Ignoring include_raw_upstream: DEBUG_ENDPOINTS is off%s", colorYellow, colorReset)
+		}
 	}
 
-	// Build initial messages
-	messages := []interface{}{
-		map[string]string{"role": "user", "content": req.Message},
+	// Once the event stream has started, errors from the tool loop are captured and
+	// sent as an error event instead
+	loopWriter := w
+	var errBuf *bufferedResponse
+	if req.Stream != nil && *req.Stream {
+		sse := newSSEWriter(w)
+		if sse == nil {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		defer sse.Heartbeat(s.cfg.SSEHeartbeatInterval)()
+		opts.stream = &chatStream{sse: sse, client: r.Context(), withhold: s.cfg.SafeMode}
+		errBuf = newBufferedResponse()
+		loopWriter = errBuf
 	}
 
-	// First API call with all tools
-	tools := []interface{}{searchTool, readPageTool, runCommandTool}
-	log.Printf("%s[/chat] Tools configured:%s search, read_page, run_command", colorMagenta, colorReset)
-	finalContent := callAIAPI(apiKey, model, messages, tools, w)
-	if finalContent == nil {
+	result := s.callAIAPI(requestID, model, messages, tools, opts, loopWriter)
+	if result == nil {
+		if opts.stream != nil {
+			rec.status = errBuf.status
+			opts.stream.fail(errBuf)
+		}
 		return // Error already written to response
 	}
+	toolCount = len(result.ToolOutputs)
+	usage = result.Usage
+
+	// Only the user turn and the final answer are kept; tool traffic is not replayed.
+	// The user turn is kept as written: the prefix, suffix and language wrapping are
+	// for the upstream call only, and would otherwise pile up in later turns.
+	s.conversations.Save(conversationID, append(history[:len(history):len(history)],
+		map[string]string{"role": "user", "content": req.Message},
+		map[string]string{"role": "assistant", "content": result.Content}))
+
+	// The stored history keeps the raw answer; escaping only affects this response
+	content := result.Content
+	if req.OutputFormat != nil && *req.OutputFormat == MarkdownEscaped {
+		content = escapeMarkdown(content)
+	}
 
 	resp := ChatResponse{
-		Content: finalContent,
+		Content:        &content,
+		ConversationId: &conversationID,
+	}
+	if result.FinishReason != "" {
+		resp.FinishReason = &result.FinishReason
+	}
+	if result.FinishReason == finishReasonLength {
+		hint := truncatedAnswerHint
+		resp.Hint = &hint
+	}
+	if req.IncludeToolOutputs != nil && *req.IncludeToolOutputs {
+		toolOutputs := append([]ToolOutput{}, result.ToolOutputs...)
+		resp.ToolOutputs = &toolOutputs
+	}
+	if req.IncludeCitations != nil && *req.IncludeCitations {
+		citations := citationsFromToolOutputs(result.ToolOutputs)
+		resp.Citations = &citations
+	}
+	if req.IncludeTurns != nil && *req.IncludeTurns {
+		turns := append([]AssistantTurn{}, result.Turns...)
+		resp.Turns = &turns
+	}
+	if opts.rawUpstream != nil && opts.rawUpstream.body != "" {
+		resp.RawUpstream = &opts.rawUpstream.body
 	}
 
-	log.Printf("%s%s[/chat] ========== Request complete ==========%s", colorBold, colorCyan, colorReset)
+	s.logf(requestID, "%s%s[/chat] ========== Request complete ==========%s", colorBold, colorCyan, colorReset)
 
+	if opts.stream != nil {
+		opts.stream.finish(resp)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// callAIAPI calls the AI Builder API and handles tool calls recursively
-func callAIAPI(apiKey, model string, messages []interface{}, tools []interface{}, w http.ResponseWriter) *string {
-	log.Printf("%s[/chat] Calling AI API%s (model: %s, messages: %d, tools: %d)...", colorYellow, colorReset, model, len(messages), len(tools))
+// PostChatEstimate implements ServerInterface.
+// (POST /chat/estimate)
+func (s *Server) PostChatEstimate(w http.ResponseWriter, r *http.Request) {
+	var req ChatEstimateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var history []ChatMessage
+	if req.History != nil {
+		history = *req.History
+	}
+	tokens := estimateChatTokens(history, s.wrapUserMessage(req.Message, ""))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(ChatEstimateResponse{
+		Tokens:  tokens,
+		Limit:   s.cfg.MaxContextTokens,
+		Exceeds: tokens > s.cfg.MaxContextTokens,
+	})
+}
+
+// PostChatTemplate implements ServerInterface.
+// (POST /chat/template/{name})
+func (s *Server) PostChatTemplate(w http.ResponseWriter, r *http.Request, name string) {
+	requestID := requestIDFor(w, r)
+	s.extendChatWriteDeadline(w)
+
+	// Summarize the request for /admin/recent and the completion webhook once it
+	// finishes, as /chat does
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	model := s.cfg.DefaultModel
+	toolCount := 0
+	var usage chatUsage
+	defer func() {
+		s.recordRecentChat(requestID, model, start, toolCount, rec.status)
+		s.notifyCompletion(requestID, model, start, toolCount, usage, rec.status)
+	}()
+
+	var req ChatTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	prompt, ok, err := s.templates.Render(name, req.Variables)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "template_not_found",
+			fmt.Sprintf("Unknown template %q (available: %s)", name, strings.Join(s.templates.Names(), ", ")))
+		return
+	}
+	if errors.Is(err, errMissingTemplateVariable) {
+		writeJSONError(w, http.StatusBadRequest, "missing_variable", err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "template_error", err.Error())
+		return
+	}
+
+	if s.apiKey == "" && !s.cfg.MockMode {
+		writeJSONError(w, http.StatusServiceUnavailable, "service_misconfigured",
+			"The chat service is not configured: API_KEY is missing on the server")
+		return
+	}
+
+	if req.Model != nil && *req.Model != "" {
+		model = *req.Model
+	}
+	if !s.requireModelAllowed(w, r, model) {
+		return
+	}
+
+	s.logf(requestID, "%s%s[/chat/template] ========== Template %q (id: %s) ==========%s", colorBold, colorCyan, name, requestID, colorReset)
+
+	messages := []interface{}{
+		map[string]string{"role": "user", "content": s.wrapUserMessage(prompt, "")},
+	}
+	result := s.callAIAPI(requestID, model, messages, s.chatTools(), completionOptions{meta: requestMetaFrom(r.Context())}, w)
+	if result == nil {
+		return // Error already written to response
+	}
+	toolCount = len(result.ToolOutputs)
+	usage = result.Usage
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(ChatResponse{Content: &result.Content})
+}
+
+// DeleteChat implements ServerInterface.
+// (DELETE /chat/{id})
+func (s *Server) DeleteChat(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.conversations.Delete(id) {
+		writeJSONError(w, http.StatusNotFound, "conversation_not_found", "Conversation not found or expired")
+		return
+	}
+	s.kv.Delete(id)
+
+	log.Printf("%s[/chat] Deleted conversation %s%s", colorBlue, id, colorReset)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// wrapUserMessage surrounds message with the configured guardrail prefix and suffix,
+// then appends an instruction to answer in language (or the configured
+// ResponseLanguage when language is empty). When none apply the message is
+// returned unchanged.
+func (s *Server) wrapUserMessage(message, language string) string {
+	if s.cfg.UserPromptPrefix != "" {
+		message = s.cfg.UserPromptPrefix + "\n\n" + message
+	}
+	if s.cfg.UserPromptSuffix != "" {
+		message = message + "\n\n" + s.cfg.UserPromptSuffix
+	}
+	if language == "" {
+		language = s.cfg.ResponseLanguage
+	}
+	if language != "" {
+		message = message + "\n\n" + fmt.Sprintf("Respond only in the language with code %q, regardless of the language used above.", language)
+	}
+	return message
+}
+
+// languageCodeRe matches BCP 47 style language tags such as "en", "fr" or "pt-BR"
+var languageCodeRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// markdownEscaper backslash-escapes every character Markdown treats as syntax
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "{", "\\{", "}", "\\}",
+	"[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "#", "\\#", "+", "\\+",
+	"-", "\\-", ".", "\\.", "!", "\\!", "|", "\\|", "<", "\\<", ">", "\\>", "~", "\\~",
+)
+
+// escapeMarkdown makes text render literally in a Markdown viewer
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// chatToolCall is a tool call requested by the model in a chat completion
+type chatToolCall struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// chatCompletionChoice is a single choice of an upstream chat completion
+type chatCompletionChoice struct {
+	Message chatMessage `json:"message"`
+	// FinishReason is why the model stopped: stop, length, tool_calls, ... ("" when
+	// the upstream didn't say)
+	FinishReason string `json:"finish_reason"`
+}
+
+// finishReasonLength is the finish_reason of an answer cut off by the token limit
+const finishReasonLength = "length"
+
+// truncatedAnswerHint is returned with answers that stopped on the token limit
+const truncatedAnswerHint = "The answer stopped at the token limit (finish_reason length) and may be truncated; raise max_tokens or set auto_continue."
+
+// continueInstruction asks the model to resume an answer cut off by the token limit
+const continueInstruction = "Your previous reply was cut off by the length limit. Continue exactly where it stopped, without repeating anything or adding a preamble."
+
+// chatMessage is the assistant message of a chat completion choice
+type chatMessage struct {
+	Content   *string        `json:"content"`
+	ToolCalls []chatToolCall `json:"tool_calls,omitempty"`
+}
+
+// UnmarshalJSON accepts content either as a string or as an array of parts
+func (m *chatMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Content   json.RawMessage `json:"content"`
+		ToolCalls []chatToolCall  `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	content, err := decodeMessageContent(raw.Content)
+	if err != nil {
+		return err
+	}
+	m.Content, m.ToolCalls = content, raw.ToolCalls
+	return nil
+}
+
+// decodeMessageContent reads upstream message content, which some models send as
+// an array of parts instead of a string. Text parts (and refusals, which are text
+// too) are joined with newlines in order; other parts such as annotations carry no
+// answer text and are skipped. It returns nil for absent or null content.
+func decodeMessageContent(raw json.RawMessage) (*string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return &text, nil
+	}
+
+	var parts []struct {
+		Type    string `json:"type"`
+		Text    string `json:"text"`
+		Refusal string `json:"refusal"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("content is neither a string nor an array of parts: %w", err)
+	}
+	var texts []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text", "output_text":
+			texts = append(texts, part.Text)
+		case "refusal":
+			texts = append(texts, part.Refusal)
+		}
+	}
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	text = strings.Join(texts, "\n")
+	return &text, nil
+}
+
+// chatUsage is the token usage reported by the upstream, accumulated across a chat's model calls
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *chatUsage) add(other chatUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// completionOptions carries optional per-request sampling parameters that are
+// forwarded to the upstream chat completion only when set.
+type completionOptions struct {
+	// Seed makes sampling reproducible on backends that support it
+	Seed *int
+	// MaxTokens caps the tokens generated per call; it is sent as max_tokens or
+	// whatever the model calls it (MODEL_PARAM_MAP)
+	MaxTokens *int
+	// JSONObject asks the upstream for a single JSON object (response_format
+	// json_object); the tool loop also checks the final answer parses
+	JSONObject bool
+	// Stop ends the final answer at any of these sequences. Like the prefill it is
+	// only sent on the synthesis call, so it can't cut short a turn choosing tools
+	Stop []string
+	// Prefill seeds the start of the final answer: the synthesis call ends with an
+	// assistant message holding it, and the model continues from there
+	Prefill string
+	// stream, when set, makes the completion stream from the upstream and relays
+	// the final answer's tokens to the client (POST /chat with stream: true)
+	stream *chatStream
+	// conversationID scopes the kv_set/kv_get tools to the chat's conversation
+	conversationID string
+	// meta identifies the client the chat runs for, in the logs of the model and
+	// tool calls it makes; the chat loop runs on a context of its own
+	meta *requestMeta
+	// autoContinue resumes a final answer cut off by the token limit, up to
+	// MaxContinuations times, and joins the parts (POST /chat with auto_continue)
+	autoContinue bool
+	// rawUpstream, when set, keeps the last upstream response body (POST /chat with
+	// include_raw_upstream, under DEBUG_ENDPOINTS)
+	rawUpstream *upstreamCapture
+}
+
+// batchable reports whether a call with these options may share a micro-batch;
+// options that change sampling per request keep the call on its own
+func (o completionOptions) batchable() bool {
+	return o.Seed == nil && o.MaxTokens == nil && !o.JSONObject && len(o.Stop) == 0 && o.Prefill == "" && o.stream == nil && o.rawUpstream == nil
+}
+
+// apply adds the options that are set to an upstream chat completion request
+func (o completionOptions) apply(chatReq map[string]interface{}) {
+	if o.Seed != nil {
+		chatReq["seed"] = *o.Seed
+	}
+	if o.MaxTokens != nil {
+		chatReq["max_tokens"] = *o.MaxTokens
+	}
+	if o.JSONObject {
+		chatReq["response_format"] = map[string]string{"type": "json_object"}
+	}
+	if len(o.Stop) > 0 {
+		chatReq["stop"] = o.Stop
+	}
+}
+
+// chatResult is the outcome of a completed tool loop
+type chatResult struct {
+	// Content is the model's final answer (empty if the model returned none)
+	Content string
+	// ToolOutputs records every tool run during the loop, in call order
+	ToolOutputs []ToolOutput
+	// Usage is the token usage summed over the loop's model calls
+	Usage chatUsage
+	// Turns records every assistant message of the loop, ending with the final answer
+	Turns []AssistantTurn
+	// FinishReason is why the model stopped writing the final answer
+	FinishReason string
+}
+
+// chatWriteGrace is how long past ChatMaxRuntime a chat may still take to write
+// its response
+const chatWriteGrace = 30 * time.Second
+
+// extendChatWriteDeadline moves the write deadline of a chat's response past
+// ChatMaxRuntime, as the server's WriteTimeout may be shorter than a chat is
+// allowed to run and would otherwise drop the answer once it is ready
+func (s *Server) extendChatWriteDeadline(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(s.cfg.ChatMaxRuntime + chatWriteGrace)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("%s[/chat] Could not extend the write deadline: %v%s", colorYellow, err, colorReset)
+	}
+}
+
+// callAIAPI calls the AI Builder API and handles tool calls in a loop: while the model
+// asks for tools, their results are appended to messages and the model is called
+// again, up to MaxToolIterations model calls and ChatMaxRuntime of wall-clock time.
+// It returns the final result, or nil after writing an error response to w.
+func (s *Server) callAIAPI(requestID, model string, messages []interface{}, tools []interface{}, opts completionOptions, w http.ResponseWriter) *chatResult {
+	// A running tool is not interrupted; the deadline is checked before each model
+	// call and each tool call, and cancels an in-flight model call
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ChatMaxRuntime)
+	defer cancel()
+	// A streaming client that disconnects stops everything at once, running tools
+	// included, since nobody is left to read the answer
+	if opts.stream != nil && opts.stream.client != nil {
+		var cancelCause context.CancelCauseFunc
+		ctx, cancelCause = context.WithCancelCause(ctx)
+		defer cancelCause(nil)
+		stop := context.AfterFunc(opts.stream.client, func() { cancelCause(errClientDisconnected) })
+		defer stop()
+	}
+	ctx = withRetryBudget(ctx, s.cfg.ChatRetryBudget)
+	ctx = withRequestID(ctx, requestID)
+	if opts.conversationID != "" {
+		ctx = withConversationID(ctx, opts.conversationID)
+	}
+	if opts.meta != nil {
+		ctx = withRequestMeta(ctx, opts.meta)
+	}
+
+	var usage chatUsage
+	var toolOutputs []ToolOutput
+	var turns []AssistantTurn
+	toolCalls := map[string]int{}
+	emptyRetries := 0
+	jsonRetried := false
+	continuations := 0
+	var continued string // parts of a truncated answer already resumed
+
+	for iteration := 1; iteration <= s.cfg.MaxToolIterations; iteration++ {
+		if clientDisconnected(ctx) {
+			s.logf(requestID, "%s[/chat] Client disconnected, abandoning the chat%s", colorRed, colorReset)
+			writeCompletionError(w, s.clientGone())
+			return nil
+		}
+		if ctx.Err() != nil {
+			s.logf(requestID, "%s[/chat] Chat ran past CHAT_MAX_RUNTIME_SECONDS (%s), stopping%s", colorRed, s.cfg.ChatMaxRuntime, colorReset)
+			writeCompletionError(w, s.chatRuntimeExceeded())
+			return nil
+		}
+
+		// Without tools every call is a synthesis call, so the prefill and stop
+		// sequences go in directly
+		synthesis := len(tools) == 0
+		prefilled := opts.Prefill != "" && synthesis
+		callOpts := opts
+		if !synthesis {
+			callOpts.Stop = nil
+		}
+		choice, callUsage, ok := s.requestCompletion(ctx, model, withPrefill(messages, opts, prefilled), tools, callOpts, w)
+		if !ok {
+			return nil // Error already written to response
+		}
+		usage.add(callUsage)
+
+		// The prefill and stop sequences must not steer tool selection, so they are
+		// only added once the model has answered without tools: that draft is
+		// replaced by a synthesis call
+		if (opts.Prefill != "" || len(opts.Stop) > 0) && !synthesis && len(choice.Message.ToolCalls) == 0 {
+			s.logf(requestID, "%s[/chat] Repeating the final call with the assistant prefill or stop sequences%s", colorBlue, colorReset)
+			if opts.stream != nil {
+				opts.stream.reset()
+			}
+			prefilled = opts.Prefill != ""
+			choice, callUsage, ok = s.requestCompletion(ctx, model, withPrefill(messages, opts, prefilled), nil, opts, w)
+			if !ok {
+				return nil
+			}
+			usage.add(callUsage)
+			choice.Message.ToolCalls = nil
+		}
+		if prefilled && len(choice.Message.ToolCalls) == 0 {
+			answer := opts.Prefill
+			if choice.Message.Content != nil {
+				answer += *choice.Message.Content
+			}
+			choice.Message.Content = &answer
+		}
+
+		// An answer cut off by the token limit is resumed where it stopped; the
+		// parts are joined before the answer is checked like any other
+		if len(choice.Message.ToolCalls) == 0 {
+			var part string
+			if choice.Message.Content != nil {
+				part = *choice.Message.Content
+			}
+			if opts.autoContinue && choice.FinishReason == finishReasonLength && continuations < s.cfg.MaxContinuations {
+				continuations++
+				s.logf(requestID, "%s[/chat] Answer hit the token limit, asking the model to continue (%d/%d)%s", colorYellow, continuations, s.cfg.MaxContinuations, colorReset)
+				continued += part
+				opts.Prefill = "" // already part of the answer
+				messages = append(messages,
+					map[string]interface{}{"role": "assistant", "content": part},
+					map[string]string{"role": "user", "content": continueInstruction},
+				)
+				continue
+			}
+			if continued != "" {
+				joined := continued + part
+				choice.Message.Content = &joined
+			}
+		}
+
+		// An empty answer is retried with a nudge before giving up
+		if len(choice.Message.ToolCalls) == 0 && (choice.Message.Content == nil || strings.TrimSpace(*choice.Message.Content) == "") {
+			if emptyRetries >= s.cfg.EmptyAnswerRetries {
+				s.logf(requestID, "%s[/chat] LLM returned empty content after %d retries, giving up%s", colorRed, emptyRetries, colorReset)
+				writeJSONError(w, http.StatusBadGateway, "no_answer", "The model produced no answer; try rephrasing the question")
+				return nil
+			}
+			emptyRetries++
+			s.logf(requestID, "%s[/chat] LLM returned empty content, retrying (%d/%d)%s", colorYellow, emptyRetries, s.cfg.EmptyAnswerRetries, colorReset)
+			messages = append(messages, map[string]string{
+				"role":    "user",
+				"content": "Your previous reply was empty. Please answer the question above.",
+			})
+			continue
+		}
+
+		// In JSON mode an answer that does not parse is retried once before giving up
+		if len(choice.Message.ToolCalls) == 0 && opts.JSONObject && !json.Valid([]byte(*choice.Message.Content)) {
+			if jsonRetried {
+				s.logf(requestID, "%s[/chat] LLM returned invalid JSON again, giving up%s", colorRed, colorReset)
+				writeJSONError(w, http.StatusBadGateway, "invalid_json", "The model did not return valid JSON")
+				return nil
+			}
+			jsonRetried = true
+			s.logf(requestID, "%s[/chat] LLM returned invalid JSON, retrying once%s", colorYellow, colorReset)
+			if opts.stream != nil {
+				opts.stream.reset()
+			}
+			messages = append(messages,
+				map[string]interface{}{"role": "assistant", "content": *choice.Message.Content},
+				map[string]string{"role": "user", "content": "Your previous reply was not valid JSON. Reply again with only a single valid JSON object and nothing else."},
+			)
+			continue
+		}
+
+		// If no tool calls, return the content directly
+		if len(choice.Message.ToolCalls) == 0 {
+			s.logf(requestID, "%s%s[/chat] LLM returned final answer (no tool calls)%s", colorBold, colorGreen, colorReset)
+			s.logf(requestID, "%s%s", colorGreen, "────────────────────────────────────────────────────────────────────────────────")
+			s.logf(requestID, "[/chat] FINAL RESPONSE:")
+			s.logf(requestID, "────────────────────────────────────────────────────────────────────────────────%s", colorReset)
+			if choice.Message.Content != nil {
+				s.logf(requestID, "%s%s%s%s", colorBold, colorGreen, *choice.Message.Content, colorReset)
+			} else {
+				s.logf(requestID, "%s%s(empty content)%s", colorBold, colorGreen, colorReset)
+			}
+			s.logf(requestID, "%s%s────────────────────────────────────────────────────────────────────────────────%s", colorBold, colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Model calls: %d, token usage: prompt=%d completion=%d total=%d%s",
+				colorBlue, iteration, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, colorReset)
+			result := &chatResult{ToolOutputs: toolOutputs, Usage: usage, FinishReason: choice.FinishReason}
+			if choice.Message.Content != nil {
+				result.Content = s.filterAnswer(applyOutputTransformers(*choice.Message.Content))
+			}
+			result.Turns = append(turns, AssistantTurn{Content: &result.Content})
+			return result
+		}
+
+		// Handle tool calls
+		s.logf(requestID, "%s[/chat] LLM returned %d tool call(s)%s", colorMagenta, len(choice.Message.ToolCalls), colorReset)
+
+		// Build assistant message with tool_calls
+		assistantMsg := map[string]interface{}{
+			"role":       "assistant",
+			"content":    choice.Message.Content,
+			"tool_calls": choice.Message.ToolCalls,
+		}
+		messages = append(messages, assistantMsg)
+		turns = append(turns, s.assistantTurn(choice.Message))
+
+		// Execute each tool call and add tool response. Duplicate page reads in this
+		// turn reuse the first read's result under their own tool_call_id.
+		type pageRead struct {
+			content string
+			success bool
+		}
+		pageReads := map[string]pageRead{}
+		for _, tc := range choice.Message.ToolCalls {
+			if ctx.Err() != nil {
+				break // Reported at the top of the loop
+			}
+			s.logf(requestID, "%s[/chat] Executing tool:%s %s(%s)", colorMagenta, colorReset, tc.Function.Name, tc.Function.Arguments)
+			if opts.stream != nil {
+				opts.stream.toolCall(tc)
+			}
+
+			var resultContent string
+			var success bool
+			readURL, isPageRead := pageReadKey(tc)
+			if prior, ok := pageReads[readURL]; isPageRead && ok {
+				s.logf(requestID, "%s[/chat] Reusing this turn's read of %s for %s%s", colorBlue, readURL, tc.Id, colorReset)
+				resultContent, success = prior.content, prior.success
+			} else if err := validateToolCall(tc); err != nil {
+				// Report malformed calls back to the model instead of guessing what was meant
+				s.logf(requestID, "%s[/chat] Skipping malformed tool call %s: %v%s", colorRed, tc.Id, err, colorReset)
+				resultBytes, _ := json.Marshal(map[string]string{"error": "malformed tool call: " + err.Error()})
+				resultContent = string(resultBytes)
+			} else if s.toolBudgetExceeded(tc.Function.Name, toolCalls[tc.Function.Name]) {
+				// Refuse without running so the model has to answer with what it already has
+				s.logf(requestID, "%s[/chat] Tool budget exhausted for %s (%d calls)%s", colorRed, tc.Function.Name, toolCalls[tc.Function.Name], colorReset)
+				resultBytes, _ := json.Marshal(map[string]string{"error": fmt.Sprintf(
+					"tool budget exceeded: %s may be called at most %d times per chat; answer with the information you already have",
+					tc.Function.Name, s.cfg.ToolBudgets[tc.Function.Name])})
+				resultContent = string(resultBytes)
+			} else {
+				toolCalls[tc.Function.Name]++
+				toolCtx := ctx
+				if opts.stream != nil {
+					toolCtx = withToolOutput(ctx, func(line string) { opts.stream.toolOutput(tc, line) })
+				}
+				resultContent, success = s.executeTool(toolCtx, tc.Function.Name, tc.Function.Arguments)
+				resultContent = normalizeToolResult(tc.Function.Name, resultContent)
+				if isPageRead {
+					pageReads[readURL] = pageRead{content: resultContent, success: success}
+				}
+			}
+			s.logf(requestID, "[/chat] Tool %s finished (success: %t, %d bytes)", tc.Function.Name, success, len(resultContent))
+
+			s.audit.record(requestID, tc.Function.Name, tc.Function.Arguments, resultContent, success)
+			toolOutput := ToolOutput{
+				ToolCallId: tc.Id,
+				Name:       tc.Function.Name,
+				Arguments:  tc.Function.Arguments,
+				Output:     resultContent,
+				Success:    success,
+			}
+			toolOutputs = append(toolOutputs, toolOutput)
+			if opts.stream != nil {
+				opts.stream.toolResult(toolOutput)
+			}
+
+			// Add tool response message
+			toolMsg := map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": tc.Id,
+				"content":      s.guardToolResult(tc.Function.Name, resultContent),
+			}
+			messages = append(messages, toolMsg)
+		}
+
+		// Send tool results back on the next iteration
+		s.logf(requestID, "%s[/chat] Sending tool results back to LLM (iteration %d/%d)...%s", colorBlue, iteration, s.cfg.MaxToolIterations, colorReset)
+	}
+
+	s.logf(requestID, "%s[/chat] Tool loop stopped after %d model calls without a final answer%s", colorRed, s.cfg.MaxToolIterations, colorReset)
+	http.Error(w, fmt.Sprintf("AI did not produce a final answer within %d tool iterations", s.cfg.MaxToolIterations), http.StatusBadGateway)
+	return nil
+}
+
+// assistantTurn converts a tool-calling assistant message for include_turns, running
+// its text through the safe mode filter like the final answer
+func (s *Server) assistantTurn(msg chatMessage) AssistantTurn {
+	var turn AssistantTurn
+	if msg.Content != nil && *msg.Content != "" {
+		content, _ := s.safeFilter.apply(*msg.Content)
+		turn.Content = &content
+	}
+	toolCalls := make([]ToolCall, 0, len(msg.ToolCalls))
+	for _, tc := range msg.ToolCalls {
+		toolCalls = append(toolCalls, ToolCall{
+			Id:       tc.Id,
+			Type:     ToolCallType(tc.Type),
+			Function: ToolCallFunction{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+		})
+	}
+	turn.ToolCalls = &toolCalls
+	return turn
+}
+
+// withPrefill returns messages ending with the assistant prefill when prefill is
+// set, streaming the prefill to the client since the upstream only sends the rest
+func withPrefill(messages []interface{}, opts completionOptions, prefill bool) []interface{} {
+	if !prefill {
+		return messages
+	}
+	if opts.stream != nil {
+		opts.stream.delta(opts.Prefill)
+	}
+	return append(messages[:len(messages):len(messages)], map[string]string{"role": "assistant", "content": opts.Prefill})
+}
+
+// stopSequences returns a chat request's stop field as a list, checking it holds
+// between one and MaxStopSequences non-empty strings. A missing field is nil.
+func (s *Server) stopSequences(stop *ChatRequest_Stop) ([]string, error) {
+	if stop == nil {
+		return nil, nil
+	}
+	sequences, err := stop.AsChatRequestStop1()
+	if err != nil {
+		single, err := stop.AsChatRequestStop0()
+		if err != nil {
+			return nil, fmt.Errorf("stop must be a string or an array of strings")
+		}
+		sequences = []string{single}
+	}
+	if len(sequences) == 0 {
+		return nil, fmt.Errorf("stop must not be an empty array")
+	}
+	if len(sequences) > s.cfg.MaxStopSequences {
+		return nil, fmt.Errorf("stop has %d sequences; at most %d are allowed (MAX_STOP_SEQUENCES)", len(sequences), s.cfg.MaxStopSequences)
+	}
+	for _, seq := range sequences {
+		if seq == "" {
+			return nil, fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	return sequences, nil
+}
+
+// upstreamErrorMessage turns an AI API error body into a client-facing message.
+// Structured details from JSON bodies ({"error": {"message": ...}}, {"error": "..."},
+// {"detail": ...} or {"message": ...}) are kept; HTML pages, empty bodies and other
+// non-JSON content are replaced with a generic message so upstream internals don't leak.
+func upstreamErrorMessage(status int, body []byte) string {
+	generic := fmt.Sprintf("AI API error: upstream returned HTTP %d", status)
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return generic
+	}
+
+	if e, ok := parsed["error"].(map[string]interface{}); ok {
+		if msg, ok := e["message"].(string); ok && msg != "" {
+			return "AI API error: " + msg
+		}
+	}
+	for _, key := range []string{"error", "detail", "message"} {
+		switch v := parsed[key].(type) {
+		case string:
+			if v != "" {
+				return "AI API error: " + v
+			}
+		case nil:
+		default:
+			if detail, err := json.Marshal(v); err == nil {
+				return "AI API error: " + string(detail)
+			}
+		}
+	}
+	return generic
+}
+
+// completionError is a failed chat completion call, carrying the status and
+// message to report to the client. An empty code means a plain-text error.
+// retryable marks failures worth another attempt (see retryUpstream).
+type completionError struct {
+	status    int
+	code      string
+	message   string
+	retryable bool
+}
+
+func (e *completionError) Error() string {
+	return e.message
+}
+
+// aiCallError turns a failed AI API round trip into a completionError, reporting a
+// 504 when the call ran past AIRequestTimeout or parent (the chat) ran out of time
+func (s *Server) aiCallError(parent context.Context, err error, message string) *completionError {
+	if clientDisconnected(parent) {
+		return s.clientGone()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		if parent.Err() != nil {
+			return s.chatRuntimeExceeded()
+		}
+		return &completionError{status: http.StatusGatewayTimeout, code: "upstream_timeout",
+			message: fmt.Sprintf("AI API did not respond within %s (AI_REQUEST_TIMEOUT_SECONDS)", s.cfg.AIRequestTimeout)}
+	}
+	return &completionError{status: http.StatusInternalServerError, message: message, retryable: true}
+}
+
+// clientGone is the error for a streamed chat whose client disconnected
+func (s *Server) clientGone() *completionError {
+	return &completionError{status: statusClientClosedRequest, code: "client_disconnected", message: "The client disconnected before the chat finished"}
+}
+
+// chatRuntimeExceeded is the error for a chat that hit ChatMaxRuntime
+func (s *Server) chatRuntimeExceeded() *completionError {
+	return &completionError{status: http.StatusGatewayTimeout, code: "chat_timeout",
+		message: fmt.Sprintf("The chat did not finish within %s (CHAT_MAX_RUNTIME_SECONDS)", s.cfg.ChatMaxRuntime)}
+}
+
+// writeCompletionError writes err to w as a JSON error, or as plain text when it has no code
+func writeCompletionError(w http.ResponseWriter, err *completionError) {
+	if err.code == "" {
+		http.Error(w, err.message, err.status)
+	} else {
+		writeJSONError(w, err.status, err.code, err.message)
+	}
+}
+
+// requestCompletion performs a single chat completion call, retrying transient
+// upstream failures. On failure it writes an error response to w and returns ok=false.
+func (s *Server) requestCompletion(ctx context.Context, model string, messages []interface{}, tools []interface{}, opts completionOptions, w http.ResponseWriter) (choice chatCompletionChoice, usage chatUsage, ok bool) {
+	var err *completionError
+	s.retryUpstream(ctx, "AI API call", func() bool {
+		choice, usage, err = s.doCompletion(ctx, model, messages, tools, opts)
+		return err != nil && err.retryable
+	})
+	if err != nil {
+		writeCompletionError(w, err)
+		return choice, usage, false
+	}
+	return choice, usage, true
+}
+
+// doCompletion performs a single chat completion call without touching any
+// ResponseWriter, so it can also back in-process tools. Tools are only sent when
+// there are some. The call is bounded by AIRequestTimeout and by ctx.
+func (s *Server) doCompletion(parent context.Context, model string, messages []interface{}, tools []interface{}, opts completionOptions) (choice chatCompletionChoice, usage chatUsage, cerr *completionError) {
+	if s.cfg.MockMode {
+		return s.mockCompletion(messages, tools, opts)
+	}
+	if s.batcher != nil && len(tools) == 0 && opts.batchable() {
+		if prompt, ok := batchPrompt(messages); ok {
+			result, err := s.batcher.submit(parent, model, prompt)
+			if err != nil {
+				return choice, usage, s.aiCallError(parent, err, "Chat ended while waiting for its batch: "+err.Error())
+			}
+			if result.err != nil {
+				return choice, usage, result.err
+			}
+			choice.Message.Content = &result.content
+			choice.FinishReason = result.finishReason
+			return choice, result.usage, nil
+		}
+	}
+	if opts.stream != nil {
+		return s.doStreamingCompletion(parent, model, messages, tools, opts)
+	}
+
+	s.logf(requestIDFrom(parent), "%s[/chat] Calling AI API%s (model: %s, messages: %d, tools: %d)...%s", colorYellow, colorReset, model, len(messages), len(tools), metaTag(parent))
 
 	chatReq := map[string]interface{}{
-		"model":       model,
-		"messages":    messages,
-		"tools":       tools,
-		"tool_choice": "auto",
+		"model":    model,
+		"messages": messages,
 	}
+	if len(tools) > 0 {
+		chatReq["tools"] = tools
+		chatReq["tool_choice"] = "auto"
+	}
+	opts.apply(chatReq)
+	s.mapModelParams(model, chatReq)
 
 	reqBody, err := json.Marshal(chatReq)
 	if err != nil {
-		http.Error(w, "Failed to marshal request", http.StatusInternalServerError)
-		return nil
+		return choice, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to marshal request"}
 	}
 
-	httpReq, err := http.NewRequest("POST", "https://space.ai-builders.com/backend/v1/chat/completions", bytes.NewReader(reqBody))
+	ctx, cancel := context.WithTimeout(parent, s.cfg.AIRequestTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/chat/completions", bytes.NewReader(reqBody))
 	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
-		return nil
+		return choice, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to create request"}
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	httpReq.Header.Set("Authorization", "Bearer "+s.apiKey)
 
-	client := &http.Client{}
-	httpResp, err := client.Do(httpReq)
+	start := time.Now()
+	httpResp, err := s.client.Do(httpReq)
 	if err != nil {
-		http.Error(w, "Failed to call AI API: "+err.Error(), http.StatusInternalServerError)
-		return nil
+		return choice, usage, s.aiCallError(parent, err, "Failed to call AI API: "+err.Error())
 	}
 	defer httpResp.Body.Close()
 
 	respBody, err := io.ReadAll(httpResp.Body)
 	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return nil
+		return choice, usage, s.aiCallError(parent, err, "Failed to read response")
 	}
+	s.latency.record("chat", time.Since(start))
+	opts.rawUpstream.record(respBody)
 
 	if httpResp.StatusCode != http.StatusOK {
-		http.Error(w, "AI API error: "+string(respBody), httpResp.StatusCode)
-		return nil
+		s.logf(requestIDFrom(parent), "%s[/chat] AI API returned status %d%s, body: %s%s", colorRed, httpResp.StatusCode, metaTag(parent), string(respBody), colorReset)
+		return choice, usage, &completionError{status: httpResp.StatusCode, code: "upstream_error", message: upstreamErrorMessage(httpResp.StatusCode, respBody),
+			retryable: retryableStatus(httpResp.StatusCode)}
 	}
 
-	log.Printf("%s[/chat] AI API response received%s", colorYellow, colorReset)
+	s.logf(requestIDFrom(parent), "%s[/chat] AI API response received%s", colorYellow, colorReset)
 
 	// Parse response
 	var chatResp struct {
-		Choices []struct {
-			Message struct {
-				Content   *string `json:"content"`
-				ToolCalls []struct {
-					Id       string `json:"id"`
-					Type     string `json:"type"`
-					Function struct {
-						Name      string `json:"name"`
-						Arguments string `json:"arguments"`
-					} `json:"function"`
-				} `json:"tool_calls,omitempty"`
-			} `json:"message"`
-		} `json:"choices"`
+		Choices []chatCompletionChoice `json:"choices"`
+		Usage   *chatUsage             `json:"usage,omitempty"`
 	}
 
 	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		http.Error(w, "Failed to parse AI response", http.StatusInternalServerError)
-		return nil
+		return choice, usage, &completionError{status: http.StatusInternalServerError, message: "Failed to parse AI response"}
 	}
 
 	if len(chatResp.Choices) == 0 {
-		http.Error(w, "No response from AI", http.StatusInternalServerError)
-		return nil
+		return choice, usage, &completionError{status: http.StatusInternalServerError, message: "No response from AI"}
 	}
 
-	choice := chatResp.Choices[0]
+	if chatResp.Usage != nil {
+		usage = *chatResp.Usage
+	}
+	return chatResp.Choices[0], usage, nil
+}
 
-	// If no tool calls, return the content directly
-	if len(choice.Message.ToolCalls) == 0 {
-		log.Printf("%s%s[/chat] LLM returned final answer (no tool calls)%s", colorBold, colorGreen, colorReset)
-		log.Printf("%s%s", colorGreen, "────────────────────────────────────────────────────────────────────────────────")
-		log.Printf("[/chat] FINAL RESPONSE:")
-		log.Printf("────────────────────────────────────────────────────────────────────────────────%s", colorReset)
-		if choice.Message.Content != nil {
-			log.Printf("%s%s%s%s", colorBold, colorGreen, *choice.Message.Content, colorReset)
-		} else {
-			log.Printf("%s%s(empty content)%s", colorBold, colorGreen, colorReset)
-		}
-		log.Printf("%s%s────────────────────────────────────────────────────────────────────────────────%s", colorBold, colorGreen, colorReset)
-		return choice.Message.Content
+// executeTool runs a single tool call and returns the content of the tool message
+// sent back to the model, plus whether the tool succeeded. Tests can replace any
+// tool's behaviour with SetToolExecutor. ctx carries the chat's retry budget.
+func (s *Server) executeTool(ctx context.Context, name, arguments string) (resultContent string, success bool) {
+	requestID := requestIDFrom(ctx)
+	arguments = s.tolerateToolArgs(name, arguments)
+
+	if override := lookupToolExecutor(name); override != nil {
+		resultContent, success = override(arguments)
+		s.logf(requestID, "%s[/chat] Tool %s handled by override executor%s", colorMagenta, name, colorReset)
+		return resultContent, success
 	}
 
-	// Handle tool calls
-	log.Printf("%s[/chat] LLM returned %d tool call(s)%s", colorMagenta, len(choice.Message.ToolCalls), colorReset)
+	if !s.toolEnabled(name) {
+		s.logf(requestID, "%s[/chat] Refusing disabled tool: %s%s%s", colorRed, name, metaTag(ctx), colorReset)
+		resultBytes, _ := json.Marshal(map[string]string{"error": "tool disabled: " + name})
+		return string(resultBytes), false
+	}
 
-	// Build assistant message with tool_calls
-	assistantMsg := map[string]interface{}{
-		"role":       "assistant",
-		"content":    choice.Message.Content,
-		"tool_calls": choice.Message.ToolCalls,
+	if s.cfg.MockMode {
+		s.logf(requestID, "%s[/chat] MOCK_MODE: returning canned output for %s%s", colorYellow, name, colorReset)
+		return mockToolResult(name, arguments), true
 	}
-	messages = append(messages, assistantMsg)
 
-	// Execute each tool call and add tool response
-	for _, tc := range choice.Message.ToolCalls {
-		log.Printf("%s[/chat] Executing tool:%s %s(%s)", colorMagenta, colorReset, tc.Function.Name, tc.Function.Arguments)
+	// Waiting for a slot counts against the chat's deadline, not the tool's
+	release, err := s.acquireToolSlot(ctx)
+	if err != nil {
+		s.logf(requestID, "%s[/chat] Tool %s did not get a slot (MAX_CONCURRENT_TOOLS=%d): %v%s%s", colorRed, name, s.cfg.MaxConcurrentTools, err, metaTag(ctx), colorReset)
+		resultBytes, _ := json.Marshal(map[string]string{"error": fmt.Sprintf("%s could not run: the server is busy (%v)", name, err)})
+		return string(resultBytes), false
+	}
+	defer release()
+
+	// The tool gets its own deadline in place of the chat's, so a running tool is
+	// bounded by its timeout but not cut short by the chat running out of time. A
+	// streaming client disconnecting does cut it short.
+	timeout := s.toolTimeout(name)
+	chatCtx := ctx
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	stop := context.AfterFunc(chatCtx, func() {
+		if clientDisconnected(chatCtx) {
+			cancel()
+		}
+	})
+	defer stop()
+	defer func() {
+		if clientDisconnected(chatCtx) {
+			resultContent, success = `{"error": "client disconnected"}`, false
+			s.logf(requestID, "%s[/chat] Tool %s aborted: client disconnected%s%s", colorRed, name, metaTag(ctx), colorReset)
+			return
+		}
+		if !success && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			resultBytes, _ := json.Marshal(map[string]string{"error": fmt.Sprintf("%s timed out after %s", name, timeout)})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Tool %s timed out after %s%s%s", colorRed, name, timeout, metaTag(ctx), colorReset)
+		}
+	}()
+
+	switch name {
+	case "search":
+		searchResults, err := s.callInternalSearchAPI(ctx, arguments)
+		if errors.Is(err, errSearchBlocked) {
+			// Reported as a result the model can recover from by choosing other
+			// keywords, rather than as a failed search
+			resultBytes, _ := json.Marshal(map[string]string{"error": errSearchBlocked.Error() + "; do not search for this topic"})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Search refused for a blocked term%s", colorRed, colorReset)
+		} else if searchResults != nil {
+			resultBytes, _ := json.Marshal(searchResults)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Search tool executed successfully%s", colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Tool Result (search):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(searchResults), colorReset)
+		} else {
+			resultContent = `{"error": "search failed"}`
+			s.logf(requestID, "%s[/chat] Search tool execution failed%s", colorRed, colorReset)
+		}
 
-		var resultContent string
+	case "read_page":
+		pageContent := s.callInternalPageReaderAPI(ctx, arguments)
+		if pageContent != nil {
+			resultBytes, _ := json.Marshal(pageContent)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Read page tool executed successfully%s", colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Tool Result (read_page):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(pageContent), colorReset)
+		} else {
+			resultContent = `{"error": "read_page failed"}`
+			s.logf(requestID, "%s[/chat] Read page tool execution failed%s", colorRed, colorReset)
+		}
 
-		switch tc.Function.Name {
-		case "search":
-			searchResults := callInternalSearchAPI(tc.Function.Arguments)
-			if searchResults != nil {
-				resultBytes, _ := json.Marshal(searchResults)
-				resultContent = string(resultBytes)
-				log.Printf("%s[/chat] Search tool executed successfully%s", colorGreen, colorReset)
-				log.Printf("%s[/chat] Tool Result (search):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(searchResults), colorReset)
-			} else {
-				resultContent = `{"error": "search failed"}`
-				log.Printf("%s[/chat] Search tool execution failed%s", colorRed, colorReset)
-			}
+	case "read_pages":
+		pagesContent := s.callInternalReadPagesAPI(ctx, arguments)
+		if pagesContent != nil {
+			resultBytes, _ := json.Marshal(pagesContent)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Read pages tool executed successfully%s", colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Tool Result (read_pages):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(pagesContent), colorReset)
+		} else {
+			resultContent = `{"error": "read_pages failed"}`
+			s.logf(requestID, "%s[/chat] Read pages tool execution failed%s", colorRed, colorReset)
+		}
 
-		case "read_page":
-			pageContent := callInternalPageReaderAPI(tc.Function.Arguments)
-			if pageContent != nil {
-				resultBytes, _ := json.Marshal(pageContent)
-				resultContent = string(resultBytes)
-				log.Printf("%s[/chat] Read page tool executed successfully%s", colorGreen, colorReset)
-				log.Printf("%s[/chat] Tool Result (read_page):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(pageContent), colorReset)
-			} else {
-				resultContent = `{"error": "read_page failed"}`
-				log.Printf("%s[/chat] Read page tool execution failed%s", colorRed, colorReset)
-			}
+	case "run_command":
+		cmdResult := s.callInternalRunCommandAPI(ctx, arguments)
+		if cmdResult != nil {
+			resultBytes, _ := json.Marshal(cmdResult)
+			resultContent = string(resultBytes)
+			success = cmdResult.Error == nil
+			s.logf(requestID, "%s[/chat] Run command tool executed successfully%s", colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Tool Result (run_command):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(cmdResult), colorReset)
+		} else {
+			resultContent = `{"error": "run_command failed"}`
+			s.logf(requestID, "%s[/chat] Run command tool execution failed%s", colorRed, colorReset)
+		}
 
-		case "run_command":
-			cmdResult := callInternalRunCommandAPI(tc.Function.Arguments)
-			if cmdResult != nil {
-				resultBytes, _ := json.Marshal(cmdResult)
-				resultContent = string(resultBytes)
-				log.Printf("%s[/chat] Run command tool executed successfully%s", colorGreen, colorReset)
-				log.Printf("%s[/chat] Tool Result (run_command):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(cmdResult), colorReset)
-			} else {
-				resultContent = `{"error": "run_command failed"}`
-				log.Printf("%s[/chat] Run command tool execution failed%s", colorRed, colorReset)
-			}
+	case "convert_units":
+		conversion, err := callConvertUnitsTool(arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(conversion)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Convert units tool executed successfully%s", colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Tool Result (convert_units):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(conversion), colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Convert units tool execution failed: %v%s", colorRed, err, colorReset)
+		}
 
-		default:
-			resultContent = fmt.Sprintf(`{"error": "unknown tool: %s"}`, tc.Function.Name)
-			log.Printf("%s[/chat] Unknown tool: %s%s", colorRed, tc.Function.Name, colorReset)
+	case "text_diff":
+		diff, err := callTextDiffTool(arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(diff)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Text diff tool executed successfully (+%d -%d)%s", colorGreen, diff.Added, diff.Removed, colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Text diff tool execution failed: %v%s", colorRed, err, colorReset)
+		}
+
+	case "encode_decode":
+		converted, err := callEncodeDecodeTool(arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(converted)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Encode/decode tool executed successfully (%s %s)%s", colorGreen, converted.Operation, converted.Format, colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Encode/decode tool execution failed: %v%s", colorRed, err, colorReset)
+		}
+
+	case "extract_from_page":
+		extraction, err := s.callExtractFromPageTool(ctx, arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(extraction)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Extract from page tool executed successfully%s", colorGreen, colorReset)
+			s.logf(requestID, "%s[/chat] Tool Result (extract_from_page):%s\n%s%s%s", colorCyan, colorReset, colorCyan, prettyJSON(extraction), colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Extract from page tool execution failed: %v%s", colorRed, err, colorReset)
+		}
+
+	case "read_feed":
+		feed, err := s.callReadFeedTool(ctx, arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(feed)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Read feed tool executed successfully (%d entries)%s", colorGreen, len(feed.Entries), colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Read feed tool execution failed: %v%s", colorRed, err, colorReset)
 		}
 
-		// Add tool response message
-		toolMsg := map[string]interface{}{
-			"role":         "tool",
-			"tool_call_id": tc.Id,
-			"content":      resultContent,
+	case "translate":
+		translation, err := s.callTranslateTool(ctx, arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(translation)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] Translate tool executed successfully (%s)%s", colorGreen, translation.TargetLanguage, colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] Translate tool execution failed: %v%s", colorRed, err, colorReset)
 		}
-		messages = append(messages, toolMsg)
+
+	case "kv_set", "kv_get":
+		kvResult, err := s.callKVTool(ctx, name, arguments)
+		if err == nil {
+			resultBytes, _ := json.Marshal(kvResult)
+			resultContent = string(resultBytes)
+			success = true
+			s.logf(requestID, "%s[/chat] %s tool executed successfully (key %q)%s", colorGreen, name, kvResult["key"], colorReset)
+		} else {
+			resultBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+			resultContent = string(resultBytes)
+			s.logf(requestID, "%s[/chat] %s tool execution failed: %v%s", colorRed, name, err, colorReset)
+		}
+
+	default:
+		resultBytes, _ := json.Marshal(map[string]string{"error": "unknown tool: " + name})
+		resultContent = string(resultBytes)
+		s.logf(requestID, "%s[/chat] Unknown tool: %s%s", colorRed, name, colorReset)
 	}
 
-	// Make second API call with tool results
-	log.Printf("%s[/chat] Sending tool results back to LLM...%s", colorBlue, colorReset)
-	return callAIAPI(apiKey, model, messages, tools, w)
+	return resultContent, success
 }
 
 // Ensure Server implements ServerInterface
 var _ ServerInterface = (*Server)(nil)
 
-// callInternalSearchAPI calls the internal /search API endpoint
-func callInternalSearchAPI(arguments string) *SearchResponse {
+// callInternalSearchAPI calls the internal /search API endpoint, sharing the retry
+// budget in ctx with it. A search refused for a blocked term fails with
+// errSearchBlocked.
+func (s *Server) callInternalSearchAPI(ctx context.Context, arguments string) (*SearchResponse, error) {
 	// Parse arguments to get keywords
 	var args struct {
-		Keywords []string `json:"keywords"`
+		Keywords       []string `json:"keywords"`
+		IncludeDomains []string `json:"include_domains"`
+		ExcludeDomains []string `json:"exclude_domains"`
+		SafeSearch     string   `json:"safe_search"`
+		MaxResults     int      `json:"max_results"`
+		Language       string   `json:"language"`
+		Broaden        bool     `json:"broaden"`
 	}
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
-		log.Printf("%s[/chat] Failed to parse search arguments: %v%s", colorRed, err, colorReset)
-		return nil
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to parse search arguments: %v%s", colorRed, err, colorReset)
+		return nil, err
 	}
 
-	log.Printf("%s[/chat] Calling /search API%s with keywords: %v", colorYellow, colorReset, args.Keywords)
+	s.logf(requestIDFrom(ctx), "%s[/chat] Calling /search API%s with keywords: %v%s", colorYellow, colorReset, args.Keywords, metaTag(ctx))
 
 	// Build request body
 	searchReq := SearchRequest{
 		Keywords: args.Keywords,
 	}
+	if len(args.IncludeDomains) > 0 {
+		searchReq.IncludeDomains = &args.IncludeDomains
+	}
+	if len(args.ExcludeDomains) > 0 {
+		searchReq.ExcludeDomains = &args.ExcludeDomains
+	}
+	if args.SafeSearch != "" {
+		safeSearch := SearchRequestSafeSearch(args.SafeSearch)
+		searchReq.SafeSearch = &safeSearch
+	}
+	if args.MaxResults > 0 {
+		searchReq.MaxResults = &args.MaxResults
+	}
+	if args.Language != "" {
+		searchReq.Language = &args.Language
+	}
+	if args.Broaden {
+		searchReq.Broaden = &args.Broaden
+	}
 	reqBody, err := json.Marshal(searchReq)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	// Call internal /search endpoint
-	httpReq, err := http.NewRequest("POST", "http://localhost:8080/search", bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.cfg.InternalBaseURL+"/search", bytes.NewReader(reqBody))
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	budget := retryBudgetFrom(ctx)
+	if budget != nil {
+		httpReq.Header.Set(retryBudgetHeader, strconv.Itoa(budget.left()))
+	}
 
 	client := &http.Client{}
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
-		log.Printf("%s[/chat] /search API call failed: %v%s", colorRed, err, colorReset)
-		return nil
+		s.logf(requestIDFrom(ctx), "%s[/chat] /search API call failed: %v%s", colorRed, err, colorReset)
+		return nil, err
 	}
 	defer httpResp.Body.Close()
+	if used, err := strconv.Atoi(httpResp.Header.Get(retriesUsedHeader)); err == nil {
+		budget.spend(used)
+	}
 
 	if httpResp.StatusCode != http.StatusOK {
-		log.Printf("%s[/chat] /search API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
-		return nil
+		var errResp ErrorResponse
+		if httpResp.StatusCode == http.StatusForbidden && json.NewDecoder(httpResp.Body).Decode(&errResp) == nil && errResp.Error == "search_blocked" {
+			return nil, errSearchBlocked
+		}
+		s.logf(requestIDFrom(ctx), "%s[/chat] /search API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
+		return nil, fmt.Errorf("/search API returned status %d", httpResp.StatusCode)
 	}
 
 	var searchResp SearchResponse
 	if err := json.NewDecoder(httpResp.Body).Decode(&searchResp); err != nil {
-		log.Printf("%s[/chat] Failed to decode search response: %v%s", colorRed, err, colorReset)
-		return nil
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to decode search response: %v%s", colorRed, err, colorReset)
+		return nil, err
 	}
 
-	log.Printf("%s[/chat] /search API returned results%s", colorGreen, colorReset)
-	return &searchResp
+	s.logf(requestIDFrom(ctx), "%s[/chat] /search API returned results%s", colorGreen, colorReset)
+	return &searchResp, nil
 }
 
 // callInternalPageReaderAPI calls the internal /page_reader API endpoint
-func callInternalPageReaderAPI(arguments string) *PageReaderResponse {
+func (s *Server) callInternalPageReaderAPI(ctx context.Context, arguments string) *PageReaderResponse {
 	// Parse arguments to get url
 	var args struct {
-		Url string `json:"url"`
+		Url    string                   `json:"url"`
+		Format *PageReaderRequestFormat `json:"format"`
 	}
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
-		log.Printf("%s[/chat] Failed to parse read_page arguments: %v%s", colorRed, err, colorReset)
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to parse read_page arguments: %v%s", colorRed, err, colorReset)
 		return nil
 	}
 
-	log.Printf("%s[/chat] Calling /page_reader API%s with url: %s", colorYellow, colorReset, args.Url)
+	s.logf(requestIDFrom(ctx), "%s[/chat] Calling /page_reader API%s with url: %s%s", colorYellow, colorReset, args.Url, metaTag(ctx))
 
-	// Build request body
-	pageReq := PageReaderRequest{
-		Url: args.Url,
+	return s.postInternalPageReaderAPI(ctx, PageReaderRequest{Url: &args.Url, Format: args.Format})
+}
+
+// callInternalReadPagesAPI calls the internal /page_reader API endpoint with several urls
+func (s *Server) callInternalReadPagesAPI(ctx context.Context, arguments string) *PageReaderResponse {
+	// Parse arguments to get urls
+	var args struct {
+		Urls []string `json:"urls"`
 	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to parse read_pages arguments: %v%s", colorRed, err, colorReset)
+		return nil
+	}
+
+	s.logf(requestIDFrom(ctx), "%s[/chat] Calling /page_reader API%s with urls: %v%s", colorYellow, colorReset, args.Urls, metaTag(ctx))
+
+	return s.postInternalPageReaderAPI(ctx, PageReaderRequest{Urls: &args.Urls})
+}
+
+// postInternalPageReaderAPI posts a request to the internal /page_reader endpoint
+func (s *Server) postInternalPageReaderAPI(ctx context.Context, pageReq PageReaderRequest) *PageReaderResponse {
+	// Build request body
 	reqBody, err := json.Marshal(pageReq)
 	if err != nil {
 		return nil
 	}
 
 	// Call internal /page_reader endpoint
-	httpReq, err := http.NewRequest("POST", "http://localhost:8080/page_reader", bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.cfg.InternalBaseURL+"/page_reader", bytes.NewReader(reqBody))
 	if err != nil {
 		return nil
 	}
@@ -410,38 +1690,43 @@ func callInternalPageReaderAPI(arguments string) *PageReaderResponse {
 	client := &http.Client{}
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
-		log.Printf("%s[/chat] /page_reader API call failed: %v%s", colorRed, err, colorReset)
+		s.logf(requestIDFrom(ctx), "%s[/chat] /page_reader API call failed: %v%s", colorRed, err, colorReset)
 		return nil
 	}
 	defer httpResp.Body.Close()
 
 	if httpResp.StatusCode != http.StatusOK {
-		log.Printf("%s[/chat] /page_reader API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
+		s.logf(requestIDFrom(ctx), "%s[/chat] /page_reader API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
 		return nil
 	}
 
 	var pageResp PageReaderResponse
 	if err := json.NewDecoder(httpResp.Body).Decode(&pageResp); err != nil {
-		log.Printf("%s[/chat] Failed to decode page_reader response: %v%s", colorRed, err, colorReset)
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to decode page_reader response: %v%s", colorRed, err, colorReset)
 		return nil
 	}
 
-	log.Printf("%s[/chat] /page_reader API returned results%s", colorGreen, colorReset)
+	s.logf(requestIDFrom(ctx), "%s[/chat] /page_reader API returned results%s", colorGreen, colorReset)
 	return &pageResp
 }
 
 // callInternalRunCommandAPI calls the internal /run_command API endpoint
-func callInternalRunCommandAPI(arguments string) *RunCommandResponse {
+func (s *Server) callInternalRunCommandAPI(ctx context.Context, arguments string) *RunCommandResponse {
 	// Parse arguments to get command
 	var args struct {
 		Command string `json:"command"`
 	}
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
-		log.Printf("%s[/chat] Failed to parse run_command arguments: %v%s", colorRed, err, colorReset)
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to parse run_command arguments: %v%s", colorRed, err, colorReset)
 		return nil
 	}
+	if strings.TrimSpace(args.Command) == "" {
+		s.logf(requestIDFrom(ctx), "%s[/chat] run_command called without a command%s", colorRed, colorReset)
+		errMsg := "command is required"
+		return &RunCommandResponse{Error: &errMsg}
+	}
 
-	log.Printf("%s[/chat] Calling /run_command API%s with command: %s", colorYellow, colorReset, args.Command)
+	s.logf(requestIDFrom(ctx), "%s[/chat] Calling /run_command API%s with command: %s%s", colorYellow, colorReset, args.Command, metaTag(ctx))
 
 	// Build request body
 	cmdReq := RunCommandRequest{
@@ -451,88 +1736,469 @@ func callInternalRunCommandAPI(arguments string) *RunCommandResponse {
 	if err != nil {
 		return nil
 	}
-
-	// Call internal /run_command endpoint
-	httpReq, err := http.NewRequest("POST", "http://localhost:8080/run_command", bytes.NewReader(reqBody))
-	if err != nil {
-		return nil
+
+	// Streamed chats relay the output line by line as the command runs
+	if onLine := toolOutputFrom(ctx); onLine != nil {
+		return s.callInternalRunCommandStream(ctx, reqBody, onLine)
+	}
+
+	// Call internal /run_command endpoint
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.cfg.InternalBaseURL+"/run_command", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		s.logf(requestIDFrom(ctx), "%s[/chat] /run_command API call failed: %v%s", colorRed, err, colorReset)
+		return nil
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		s.logf(requestIDFrom(ctx), "%s[/chat] /run_command API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
+		return nil
+	}
+
+	var cmdResp RunCommandResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&cmdResp); err != nil {
+		s.logf(requestIDFrom(ctx), "%s[/chat] Failed to decode run_command response: %v%s", colorRed, err, colorReset)
+		return nil
+	}
+
+	s.logf(requestIDFrom(ctx), "%s[/chat] /run_command API returned results%s", colorGreen, colorReset)
+	return &cmdResp
+}
+
+// callInternalRunCommandStream calls the internal /run_command/stream API endpoint,
+// passing each line of output to onLine as it arrives, and assembles the response
+// /run_command would have returned
+func (s *Server) callInternalRunCommandStream(ctx context.Context, reqBody []byte, onLine func(line string)) *RunCommandResponse {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.cfg.InternalBaseURL+"/run_command/stream", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	client := &http.Client{}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		s.logf(requestIDFrom(ctx), "%s[/chat] /run_command/stream API call failed: %v%s", colorRed, err, colorReset)
+		return nil
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		s.logf(requestIDFrom(ctx), "%s[/chat] /run_command/stream API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
+		return nil
+	}
+
+	var output strings.Builder
+	var event string
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			event = name
+			continue
+		}
+		// Blank lines end events; keepalive comments carry nothing
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		switch event {
+		case "output":
+			output.WriteString(data)
+			output.WriteString("\n")
+			onLine(data)
+		case "done":
+			var cmdResp RunCommandResponse
+			if err := json.Unmarshal([]byte(data), &cmdResp); err != nil {
+				s.logf(requestIDFrom(ctx), "%s[/chat] Failed to decode run_command result: %v%s", colorRed, err, colorReset)
+				return nil
+			}
+			if cmdResp.Error == nil {
+				result := output.String()
+				cmdResp.Output = &result
+			}
+			s.logf(requestIDFrom(ctx), "%s[/chat] /run_command/stream API returned results%s", colorGreen, colorReset)
+			return &cmdResp
+		}
+	}
+
+	s.logf(requestIDFrom(ctx), "%s[/chat] /run_command/stream API ended without a result: %v%s", colorRed, scanner.Err(), colorReset)
+	return nil
+}
+
+// callConvertUnitsTool parses convert_units arguments and performs the conversion in-process
+func callConvertUnitsTool(arguments string) (*unitConversionResult, error) {
+	var args struct {
+		Value    *float64 `json:"value"`
+		FromUnit string   `json:"from_unit"`
+		ToUnit   string   `json:"to_unit"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid convert_units arguments: %w", err)
+	}
+	if args.Value == nil {
+		return nil, fmt.Errorf("value is required")
+	}
+
+	return ConvertUnits(*args.Value, args.FromUnit, args.ToUnit)
+}
+
+// callTextDiffTool parses text_diff arguments and diffs the two texts in-process
+func callTextDiffTool(arguments string) (*textDiffResult, error) {
+	var args struct {
+		A *string `json:"a"`
+		B *string `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid text_diff arguments: %w", err)
+	}
+	if args.A == nil || args.B == nil {
+		return nil, fmt.Errorf("both a and b are required")
+	}
+
+	return TextDiff(*args.A, *args.B)
+}
+
+// callEncodeDecodeTool parses encode_decode arguments and runs the conversion in-process
+func callEncodeDecodeTool(arguments string) (*encodeDecodeResult, error) {
+	var args struct {
+		Operation string  `json:"operation"`
+		Format    string  `json:"format"`
+		Input     *string `json:"input"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid encode_decode arguments: %w", err)
+	}
+	if args.Input == nil {
+		return nil, fmt.Errorf("input is required")
+	}
+
+	return EncodeDecode(args.Operation, args.Format, *args.Input)
+}
+
+// callExtractFromPageTool parses extract_from_page arguments and extracts the requested data
+func (s *Server) callExtractFromPageTool(ctx context.Context, arguments string) (*pageExtraction, error) {
+	var args struct {
+		Url     string `json:"url"`
+		Extract string `json:"extract"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid extract_from_page arguments: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	httpResp, err := client.Do(httpReq)
-	if err != nil {
-		log.Printf("%s[/chat] /run_command API call failed: %v%s", colorRed, err, colorReset)
-		return nil
+	log.Printf("%s[/chat] Extracting %s%s from url: %s", colorYellow, args.Extract, colorReset, args.Url)
+	return s.ExtractFromPage(ctx, args.Url, args.Extract)
+}
+
+// callReadFeedTool runs the read_feed tool in-process
+func (s *Server) callReadFeedTool(ctx context.Context, arguments string) (*feedResult, error) {
+	var args struct {
+		Url        string `json:"url"`
+		MaxEntries int    `json:"max_entries"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid read_feed arguments: %w", err)
 	}
-	defer httpResp.Body.Close()
 
-	if httpResp.StatusCode != http.StatusOK {
-		log.Printf("%s[/chat] /run_command API returned status: %d%s", colorRed, httpResp.StatusCode, colorReset)
-		return nil
+	maxEntries := s.cfg.FeedMaxEntries
+	if args.MaxEntries > 0 && args.MaxEntries < maxEntries {
+		maxEntries = args.MaxEntries
 	}
 
-	var cmdResp RunCommandResponse
-	if err := json.NewDecoder(httpResp.Body).Decode(&cmdResp); err != nil {
-		log.Printf("%s[/chat] Failed to decode run_command response: %v%s", colorRed, err, colorReset)
-		return nil
+	log.Printf("%s[/chat] Reading feed%s (max %d entries): %s", colorYellow, colorReset, maxEntries, args.Url)
+	return s.ReadFeed(ctx, args.Url, maxEntries)
+}
+
+// callTranslateTool runs the translate tool in-process
+func (s *Server) callTranslateTool(ctx context.Context, arguments string) (*translationResult, error) {
+	var args struct {
+		Text           string `json:"text"`
+		TargetLanguage string `json:"target_language"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid translate arguments: %w", err)
 	}
 
-	log.Printf("%s[/chat] /run_command API returned results%s", colorGreen, colorReset)
-	return &cmdResp
+	s.logf(requestIDFrom(ctx), "%s[/chat] Translating %d chars into%s %s", colorYellow, len(args.Text), colorReset, args.TargetLanguage)
+	return s.Translate(ctx, args.Text, args.TargetLanguage)
 }
 
 // PostSearch implements ServerInterface.
 // (POST /search)
-func (Server) PostSearch(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostSearch(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableSearch, "ENABLE_SEARCH") {
+		return
+	}
+
 	var req SearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	maxResults := 6
-	if req.MaxResults != nil && *req.MaxResults > 0 {
-		maxResults = *req.MaxResults
+	var opts SearchOptions
+	if req.MaxResults != nil {
+		opts.MaxResults = *req.MaxResults
+	}
+	if req.SnippetLength != nil {
+		opts.SnippetLength = *req.SnippetLength
+	}
+	opts.Broaden = req.Broaden != nil && *req.Broaden
+	if req.SafeSearch != nil {
+		if !validSafeSearch(string(*req.SafeSearch)) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_safe_search", "safe_search must be off, moderate or strict")
+			return
+		}
+		opts.SafeSearch = string(*req.SafeSearch)
+	}
+	if req.Language != nil && *req.Language != "" && !languageCodeRe.MatchString(*req.Language) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_language", "language must be a language code such as en, fr or pt-BR")
+		return
 	}
 
-	resp, err := CallSearchAPI(req.Keywords, maxResults)
+	// A chat calling through the internal hop passes its remaining retry budget along
+	ctx := retryBudgetFromHeader(r)
+	resp, err := s.CallSearchAPI(ctx, req.Keywords, opts)
+	if budget := retryBudgetFrom(ctx); budget != nil {
+		w.Header().Set(retriesUsedHeader, strconv.Itoa(budget.used()))
+	}
+	if errors.Is(err, errSearchBlocked) {
+		writeJSONError(w, http.StatusForbidden, "search_blocked", err.Error())
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	var include, exclude []string
+	if req.IncludeDomains != nil {
+		include = *req.IncludeDomains
+	}
+	if req.ExcludeDomains != nil {
+		exclude = *req.ExcludeDomains
+	}
+	filterSearchResultsByDomain(resp, include, exclude)
+	if req.Language != nil && *req.Language != "" {
+		filterSearchResultsByLanguage(resp, *req.Language)
+	}
+	if req.Fields != nil {
+		selectSearchResultFields(resp, *req.Fields)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// CallSearchAPI calls the AI Builder search API
-func CallSearchAPI(keywords []string, maxResults int) (*SearchResponse, error) {
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
+// SearchOptions tunes a CallSearchAPI call. Zero values fall back to the server
+// configuration.
+type SearchOptions struct {
+	// MaxResults is the number of results per keyword (default SearchMaxResults,
+	// capped at SearchMaxResultsLimit)
+	MaxResults int
+	// SnippetLength cuts each result's snippet to this many characters (default SearchSnippetMaxLength)
+	SnippetLength int
+	// SafeSearch is off, moderate or strict (default SearchSafeSearch)
+	SafeSearch string
+	// Broaden retries once with relaxed keywords when fewer than
+	// SearchBroadenMinResults results come back
+	Broaden bool
+}
+
+// CallSearchAPI calls the AI Builder search API. When the primary provider errors or
+// returns no results and a fallback provider is configured, the fallback is tried
+// and whichever response yields results is returned. Keywords are normalized first,
+// so concurrent searches that differ only in spacing (or casing, with
+// SEARCH_LOWERCASE_KEYWORDS) share one upstream call; each caller gets its own copy
+// of the response. With opts.Broaden, a search that comes back with fewer than
+// SEARCH_BROADEN_MIN_RESULTS results is retried once with broader keywords, and
+// the broader response is used if it has more results.
+func (s *Server) CallSearchAPI(ctx context.Context, keywords []string, opts SearchOptions) (*SearchResponse, error) {
+	if s.apiKey == "" {
 		return nil, fmt.Errorf("API_KEY not configured")
 	}
 
-	if maxResults <= 0 {
-		maxResults = 6 // default
+	keywords = normalizeSearchKeywords(keywords, s.cfg.SearchLowercaseKeywords)
+	if len(keywords) == 0 {
+		return nil, fmt.Errorf("no search keywords given")
+	}
+	if len(keywords) > s.cfg.SearchMaxKeywords {
+		log.Printf("%s[/search] Dropping %d keywords past SEARCH_MAX_KEYWORDS (%d)%s", colorYellow, len(keywords)-s.cfg.SearchMaxKeywords, s.cfg.SearchMaxKeywords, colorReset)
+		keywords = keywords[:s.cfg.SearchMaxKeywords]
+	}
+	if term := blockedSearchTerm(keywords, s.cfg.SearchBlockedKeywords); term != "" {
+		log.Printf("%s[/search] Refusing search for %v: blocked term %q%s", colorRed, keywords, term, colorReset)
+		return nil, errSearchBlocked
+	}
+
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = s.cfg.SearchMaxResults
+	}
+	if opts.MaxResults > s.cfg.SearchMaxResultsLimit {
+		opts.MaxResults = s.cfg.SearchMaxResultsLimit
+	}
+	if opts.SnippetLength <= 0 {
+		opts.SnippetLength = s.cfg.SearchSnippetMaxLength
+	}
+	if opts.SafeSearch == "" {
+		opts.SafeSearch = s.cfg.SearchSafeSearch
+	}
+
+	search := func(keywords []string) (*SearchResponse, error) {
+		// The budget is deliberately left out of the key so chats can share a search.
+		// The shared search runs under the first caller's ctx: its retries spend only
+		// that caller's CHAT_RETRY_BUDGET, and callers that join it neither spend nor
+		// are limited by their own budgets.
+		key := fmt.Sprintf("%d\x00%s\x00%s", opts.MaxResults, opts.SafeSearch, strings.Join(keywords, "\x00"))
+		resp, err, shared := s.searchFlight.Do(ctx, key, func() (*SearchResponse, error) {
+			return s.searchWithFallback(ctx, keywords, opts.MaxResults, opts.SafeSearch)
+		})
+		if shared && resp != nil {
+			log.Printf("%s[/search] Shared in-flight search for %v%s", colorBlue, keywords, colorReset)
+			resp = cloneSearchResponse(resp)
+		}
+
+		// Without upstream support, safe search is applied here instead
+		if !s.cfg.SearchSafeSearchUpstream {
+			filterUnsafeSearchResults(resp, opts.SafeSearch)
+		}
+		return resp, err
+	}
+
+	resp, err := search(keywords)
+	if count := countSearchResults(resp); opts.Broaden && err == nil && count < s.cfg.SearchBroadenMinResults {
+		if broader, ok := broadenSearchKeywords(keywords); ok {
+			log.Printf("%s[/search] Only %d results for %v (SEARCH_BROADEN_MIN_RESULTS=%d), retrying with broader keywords %v%s",
+				colorYellow, count, keywords, s.cfg.SearchBroadenMinResults, broader, colorReset)
+			broadResp, broadErr := search(broader)
+			if broadCount := countSearchResults(broadResp); broadErr == nil && broadCount > count {
+				log.Printf("%s[/search] Broader keywords found %d results, using them%s", colorYellow, broadCount, colorReset)
+				broadResp.BroadenedKeywords = &broader
+				resp = broadResp
+			} else {
+				log.Printf("%s[/search] Broader keywords found no more results (%d), keeping the original%s", colorYellow, broadCount, colorReset)
+			}
+		}
+	}
+	truncateSearchSnippets(resp, opts.SnippetLength, s.cfg.TruncationMarker)
+	return resp, err
+}
+
+// cloneSearchResponse deep-copies resp so callers can filter results in place
+func cloneSearchResponse(resp *SearchResponse) *SearchResponse {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return resp
+	}
+	var clone SearchResponse
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return resp
+	}
+	return &clone
+}
+
+// searchWithFallback queries the primary search provider, then the fallback
+// provider when the primary errors or finds nothing
+func (s *Server) searchWithFallback(ctx context.Context, keywords []string, maxResults int, safeSearch string) (*SearchResponse, error) {
+	var resp *SearchResponse
+	var err error
+	s.retryUpstream(ctx, "Search", func() bool {
+		start := time.Now()
+		resp, err = s.callSearchEndpoint(context.Background(), s.baseURL+"/search/", s.apiKey, keywords, maxResults, safeSearch)
+		s.latency.record("search", time.Since(start))
+		return isTransient(err)
+	})
+	if err == nil && hasSearchResults(resp) {
+		return resp, nil
+	}
+
+	fallbackURL := s.cfg.SearchFallbackURL
+	if fallbackURL == "" {
+		return resp, err
+	}
+
+	if err != nil {
+		log.Printf("%s[/search] Primary search failed (%v), trying fallback %s%s", colorYellow, err, fallbackURL, colorReset)
+	} else {
+		log.Printf("%s[/search] Primary search returned no results, trying fallback %s%s", colorYellow, fallbackURL, colorReset)
+	}
+
+	var fallbackResp *SearchResponse
+	var fallbackErr error
+	s.retryUpstream(ctx, "Fallback search", func() bool {
+		start := time.Now()
+		fallbackResp, fallbackErr = s.callSearchEndpoint(context.Background(), fallbackURL, s.cfg.SearchFallbackAPIKey, keywords, maxResults, safeSearch)
+		s.latency.record("search_fallback", time.Since(start))
+		return isTransient(fallbackErr)
+	})
+	if fallbackErr == nil && hasSearchResults(fallbackResp) {
+		log.Printf("%s[/search] Fallback search returned results%s", colorGreen, colorReset)
+		return fallbackResp, nil
+	}
+
+	if fallbackErr != nil {
+		log.Printf("%s[/search] Fallback search failed: %v%s", colorRed, fallbackErr, colorReset)
+	} else {
+		log.Printf("%s[/search] Fallback search returned no results%s", colorRed, colorReset)
+	}
+
+	// Neither provider produced results; report the primary outcome
+	if err != nil && fallbackErr == nil {
+		return fallbackResp, nil
+	}
+	return resp, err
+}
+
+// hasSearchResults reports whether a search response carries any usable result
+func hasSearchResults(resp *SearchResponse) bool {
+	if resp == nil {
+		return false
+	}
+	if resp.CombinedAnswer != nil && *resp.CombinedAnswer != "" {
+		return true
+	}
+	if resp.Queries != nil {
+		for _, q := range *resp.Queries {
+			if q.Response != nil && len(*q.Response) > 0 {
+				return true
+			}
+		}
 	}
+	return false
+}
 
+// callSearchEndpoint performs a single search request against the given provider URL
+func (s *Server) callSearchEndpoint(ctx context.Context, url, apiKey string, keywords []string, maxResults int, safeSearch string) (*SearchResponse, error) {
 	// Use a local struct for the request since remote API expects int, not *int
 	searchReq := struct {
 		Keywords   []string `json:"keywords"`
 		MaxResults int      `json:"max_results,omitempty"`
+		SafeSearch string   `json:"safe_search,omitempty"`
 	}{
 		Keywords:   keywords,
 		MaxResults: maxResults,
 	}
+	// Only sent to providers declared to understand it
+	if s.cfg.SearchSafeSearchUpstream {
+		searchReq.SafeSearch = safeSearch
+	}
 
 	reqBody, err := json.Marshal(searchReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal search request: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", "https://space.ai-builders.com/backend/v1/search/", bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -540,10 +2206,9 @@ func CallSearchAPI(keywords []string, maxResults int) (*SearchResponse, error) {
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := &http.Client{}
-	httpResp, err := client.Do(httpReq)
+	httpResp, err := s.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call search API: %w", err)
+		return nil, transientError{fmt.Errorf("failed to call search API: %w", err)}
 	}
 	defer httpResp.Body.Close()
 
@@ -553,7 +2218,11 @@ func CallSearchAPI(keywords []string, maxResults int) (*SearchResponse, error) {
 	}
 
 	if httpResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("search API error (status %d): %s", httpResp.StatusCode, string(respBody))
+		err := fmt.Errorf("search API error (status %d): %s", httpResp.StatusCode, string(respBody))
+		if retryableStatus(httpResp.StatusCode) {
+			return nil, transientError{err}
+		}
+		return nil, err
 	}
 
 	var searchResp SearchResponse
@@ -564,19 +2233,157 @@ func CallSearchAPI(keywords []string, maxResults int) (*SearchResponse, error) {
 	return &searchResp, nil
 }
 
+// Search suggestion tuning: suggestions must feel instant, so upstream calls get a
+// tight deadline and results are cached briefly per normalized query.
+const (
+	suggestTimeout         = 2 * time.Second
+	suggestCacheTTL        = 5 * time.Minute
+	suggestCacheMaxEntries = 500
+	suggestDefaultLimit    = 5
+	suggestMaxLimit        = 10
+)
+
+// PostSearchSuggest implements ServerInterface.
+// (POST /search/suggest)
+func (s *Server) PostSearchSuggest(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableSearch, "ENABLE_SEARCH") {
+		return
+	}
+
+	var req SearchSuggestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	limit := suggestDefaultLimit
+	if req.Limit != nil && *req.Limit > 0 {
+		limit = min(*req.Limit, suggestMaxLimit)
+	}
+
+	suggestions := s.SuggestQueries(r.Context(), req.Query)
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(SearchSuggestResponse{Suggestions: suggestions})
+}
+
+// SuggestQueries returns related queries for a partial query, derived from the
+// titles of a small upstream search. Failures and timeouts yield an empty list.
+func (s *Server) SuggestQueries(ctx context.Context, query string) []string {
+	if strings.TrimSpace(query) == "" || s.apiKey == "" {
+		return []string{}
+	}
+	suggestions, err := s.suggestQueries(ctx, query)
+	if err != nil {
+		log.Printf("%s[/search/suggest] Suggestion lookup failed: %v%s", colorYellow, err, colorReset)
+		return []string{}
+	}
+	return suggestions
+}
+
+// suggestQueries is SuggestQueries reporting why a lookup failed
+func (s *Server) suggestQueries(ctx context.Context, query string) ([]string, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	if query == "" {
+		return []string{}, fmt.Errorf("empty query")
+	}
+	if s.apiKey == "" {
+		return []string{}, fmt.Errorf("API_KEY not configured")
+	}
+
+	cacheKey := strings.ToLower(query)
+	if cached, ok := s.suggestCache.Get(cacheKey); ok {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, suggestTimeout)
+	defer cancel()
+
+	resp, err := s.callSearchEndpoint(ctx, s.baseURL+"/search/", s.apiKey, []string{query}, suggestMaxLimit, "")
+	if err != nil {
+		return []string{}, err
+	}
+
+	suggestions := []string{}
+	seen := map[string]bool{strings.ToLower(query): true}
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		for _, result := range results {
+			item, ok := result.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			title, _ := item["title"].(string)
+			title = strings.Join(strings.Fields(title), " ")
+			if title == "" || seen[strings.ToLower(title)] {
+				continue
+			}
+			seen[strings.ToLower(title)] = true
+			suggestions = append(suggestions, title)
+		}
+		return results
+	})
+
+	s.suggestCache.Set(cacheKey, suggestions)
+	return suggestions, nil
+}
+
 // PostPageReader implements ServerInterface.
 // (POST /page_reader)
-func (Server) PostPageReader(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostPageReader(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableReadPage, "ENABLE_READ_PAGE") {
+		return
+	}
+
 	var req PageReaderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	format := PageReaderRequestFormatText
+	if req.Format != nil {
+		format = *req.Format
+	}
+	if format != PageReaderRequestFormatText && format != PageReaderRequestFormatMarkdown {
+		writeJSONError(w, http.StatusBadRequest, "invalid_format", "format must be text or markdown")
+		return
+	}
+
+	// Batch mode: read every requested URL and report each result individually
+	if req.Urls != nil && len(*req.Urls) > 0 {
+		urls := *req.Urls
+		if req.Url != nil && *req.Url != "" {
+			urls = append([]string{*req.Url}, urls...)
+		}
+		if len(urls) > s.cfg.PageReaderMaxURLs {
+			writeJSONError(w, http.StatusBadRequest, "too_many_urls",
+				fmt.Sprintf("at most %d urls may be read in one request (PAGE_READER_MAX_URLS), got %d", s.cfg.PageReaderMaxURLs, len(urls)))
+			return
+		}
+
+		results := s.CallReadPages(r.Context(), urls, format)
+		resp := PageReaderResponse{
+			Results: &results,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if req.Url == nil || *req.Url == "" {
+		http.Error(w, "url or urls is required", http.StatusBadRequest)
+		return
+	}
 
-	content, err := CallReadPage(req.Url)
+	content, finalURL, markdownFallback, err := s.CallReadPage(r.Context(), *req.Url, format)
 
 	resp := PageReaderResponse{
-		Url: &req.Url,
+		Url: req.Url,
 	}
 
 	if err != nil {
@@ -584,6 +2391,10 @@ func (Server) PostPageReader(w http.ResponseWriter, r *http.Request) {
 		resp.Error = &errMsg
 	} else {
 		resp.Content = &content
+		resp.FinalUrl = &finalURL
+		if markdownFallback {
+			resp.MarkdownFallback = &markdownFallback
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -591,36 +2402,134 @@ func (Server) PostPageReader(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// CallReadPage fetches a URL and extracts plain text from HTML
-func CallReadPage(url string) (string, error) {
-	// Fetch the URL
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// PostPageReaderHead implements ServerInterface.
+// (POST /page_reader/head)
+func (s *Server) PostPageReaderHead(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableReadPage, "ENABLE_READ_PAGE") {
+		return
+	}
+
+	var req PageHeadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Url == "" {
+		http.Error(w, "Invalid request body: url is required", http.StatusBadRequest)
+		return
+	}
+
+	head, err := s.fetchPageHead(r.Context(), req.Url)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		writeJSONError(w, http.StatusBadGateway, "fetch_failed", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(head)
+}
+
+// PostPageReaderMeta implements ServerInterface.
+// (POST /page_reader/meta)
+func (s *Server) PostPageReaderMeta(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableReadPage, "ENABLE_READ_PAGE") {
+		return
 	}
 
-	// Set User-Agent to avoid being blocked
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; PageReader/1.0)")
+	var req PageMetaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Url == "" {
+		http.Error(w, "Invalid request body: url is required", http.StatusBadRequest)
+		return
+	}
 
-	resp, err := client.Do(req)
+	// Shares the page cache with read_page, so a preview and a full read cost one fetch
+	page, err := s.fetchPage(r.Context(), req.Url)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch URL: %w", err)
+		writeJSONError(w, http.StatusBadGateway, "fetch_failed", err.Error())
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(pageMeta(page))
+}
+
+// maxConcurrentPageReads bounds how many pages CallReadPages fetches at once
+const maxConcurrentPageReads = 4
+
+// CallReadPages fetches several URLs concurrently and returns one result per URL,
+// in the same order as the input. A failing URL only sets the error on its own result.
+func (s *Server) CallReadPages(ctx context.Context, urls []string, format PageReaderRequestFormat) []PageReaderResult {
+	results := make([]PageReaderResult, len(urls))
+	sem := make(chan struct{}, maxConcurrentPageReads)
+
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := PageReaderResult{
+				Url: &u,
+			}
+			content, finalURL, markdownFallback, err := s.CallReadPage(ctx, u, format)
+			if err != nil {
+				errMsg := err.Error()
+				result.Error = &errMsg
+			} else {
+				result.Content = &content
+				result.FinalUrl = &finalURL
+				if markdownFallback {
+					result.MarkdownFallback = &markdownFallback
+				}
+			}
+			results[i] = result
+		}(i, u)
 	}
+	wg.Wait()
+
+	return results
+}
 
-	// Read body
-	body, err := io.ReadAll(resp.Body)
+// CallReadPage fetches a URL and extracts plain text from HTML, or Markdown when
+// format asks for it. finalURL is the URL actually read once redirects were
+// followed. markdownFallback reports that the page defeated the Markdown converter
+// and plain text was returned instead (READ_PAGE_MARKDOWN_FALLBACK). Pages that
+// aren't HTML or text/plain are refused unless READ_PAGE_EXTRA_CONTENT_TYPES lists
+// them, and non-HTML pages are returned as they are.
+func (s *Server) CallReadPage(ctx context.Context, url string, format PageReaderRequestFormat) (content, finalURL string, markdownFallback bool, err error) {
+	page, err := s.fetchPage(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", "", false, err
+	}
+	if err := s.checkReadableContentType(page); err != nil {
+		return "", "", false, err
+	}
+	if !htmlContentType(page.ContentType) {
+		return strings.TrimSpace(page.Body), page.FinalURL, false, nil
+	}
+
+	if format == PageReaderRequestFormatMarkdown {
+		markdown, err := pageMarkdown(page)
+		if err == nil {
+			return markdown, page.FinalURL, false, nil
+		}
+		if !s.cfg.ReadPageMarkdownFallback {
+			return "", "", false, fmt.Errorf("converting page to Markdown: %w", err)
+		}
+		log.Printf("%s[/page_reader] Markdown conversion of %s failed, returning plain text: %v%s", colorYellow, page.FinalURL, err, colorReset)
+		markdownFallback = true
 	}
 
-	html := string(body)
+	if s.cfg.Readability {
+		if text, ok := readableText(page.Body); ok {
+			return text, page.FinalURL, markdownFallback, nil
+		}
+	}
+	return pageTextWithFallback(page), page.FinalURL, markdownFallback, nil
+}
 
+// htmlToText strips scripts, styles and tags from html and normalizes whitespace
+func htmlToText(html string) string {
 	// Strip script tags and content
 	scriptRe := regexp.MustCompile(`(?is)<script[^>]*>.*?</script>`)
 	html = scriptRe.ReplaceAllString(html, "")
@@ -634,39 +2543,41 @@ func CallReadPage(url string) (string, error) {
 	text := tagRe.ReplaceAllString(html, "")
 
 	// Decode common HTML entities
-	text = strings.ReplaceAll(text, "&nbsp;", " ")
-	text = strings.ReplaceAll(text, "&amp;", "&")
-	text = strings.ReplaceAll(text, "&lt;", "<")
-	text = strings.ReplaceAll(text, "&gt;", ">")
-	text = strings.ReplaceAll(text, "&quot;", "\"")
-	text = strings.ReplaceAll(text, "&#39;", "'")
+	text = decodeHTMLEntities(text)
 
 	// Normalize whitespace: replace multiple spaces/newlines with single space
 	spaceRe := regexp.MustCompile(`\s+`)
 	text = spaceRe.ReplaceAllString(text, " ")
 
 	// Trim leading/trailing whitespace
-	text = strings.TrimSpace(text)
-
-	return text, nil
+	return strings.TrimSpace(text)
 }
 
-// Whitelisted commands for run_command
-var allowedCommands = map[string]bool{
-	"ls": true,
-	"cd": true,
+// decodeHTMLEntities decodes the most common HTML entities
+func decodeHTMLEntities(text string) string {
+	text = strings.ReplaceAll(text, "&nbsp;", " ")
+	text = strings.ReplaceAll(text, "&lt;", "<")
+	text = strings.ReplaceAll(text, "&gt;", ">")
+	text = strings.ReplaceAll(text, "&quot;", "\"")
+	text = strings.ReplaceAll(text, "&#39;", "'")
+	text = strings.ReplaceAll(text, "&amp;", "&")
+	return text
 }
 
 // PostRunCommand implements ServerInterface.
 // (POST /run_command)
-func (Server) PostRunCommand(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostRunCommand(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableRunCommand, "ENABLE_RUN_COMMAND") {
+		return
+	}
+
 	var req RunCommandRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	output, err := CallRunCommand(req.Command)
+	output, err := s.CallRunCommand(r.Context(), req.Command)
 
 	resp := RunCommandResponse{
 		Command: &req.Command,
@@ -684,33 +2595,171 @@ func (Server) PostRunCommand(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// CallRunCommand executes a whitelisted shell command
-func CallRunCommand(command string) (string, error) {
-	// Parse command to get the base command
-	parts := strings.Fields(command)
+// PostRunCommandStream implements ServerInterface.
+// (POST /run_command/stream)
+func (s *Server) PostRunCommandStream(w http.ResponseWriter, r *http.Request) {
+	if !requireEnabled(w, s.cfg.EnableRunCommand, "ENABLE_RUN_COMMAND") {
+		return
+	}
+
+	var req RunCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sse := newSSEWriter(w)
+	if sse == nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	defer sse.Heartbeat(s.cfg.SSEHeartbeatInterval)()
+
+	log.Printf("%s[/run_command/stream] Streaming command:%s %s", colorYellow, colorReset, req.Command)
+
+	_, err := s.StreamRunCommand(r.Context(), req.Command, func(line string) {
+		_ = sse.Event("output", line)
+	})
+
+	resp := RunCommandResponse{
+		Command: &req.Command,
+	}
+	if err != nil {
+		errMsg := err.Error()
+		resp.Error = &errMsg
+	}
+	doneBytes, _ := json.Marshal(resp)
+	_ = sse.Event("done", string(doneBytes))
+}
+
+// commandWaitDelay bounds how long Wait keeps reading a command's output once the
+// command has exited or been killed
+const commandWaitDelay = 5 * time.Second
+
+// buildCommand validates command against the whitelist, argument policy and path
+// denylist, and prepares it for execution
+func (s *Server) buildCommand(ctx context.Context, command string) (*exec.Cmd, error) {
+	// Split into words, honoring quotes, to get the base command and its arguments
+	parts, err := splitShellWords(command)
+	if err != nil {
+		return nil, err
+	}
 	if len(parts) == 0 {
-		return "", fmt.Errorf("empty command")
+		return nil, fmt.Errorf("empty command")
 	}
 
 	baseCmd := parts[0]
 
-	// Check whitelist
-	if !allowedCommands[baseCmd] {
-		return "", fmt.Errorf("command not allowed: %s (allowed: ls, cd)", baseCmd)
+	// Check whitelist and the command's argument policy
+	policy, ok := commandPolicies[baseCmd]
+	if !ok {
+		return nil, fmt.Errorf("command not allowed: %s (allowed: %s)", baseCmd, strings.Join(allowedCommandNames(), ", "))
+	}
+	if err := policy.check(baseCmd, parts[1:]); err != nil {
+		return nil, err
+	}
+	if err := checkDeniedPaths(parts[1:], s.cfg.DeniedCommandPaths, s.cfg.CommandDir); err != nil {
+		return nil, err
 	}
 
-	// Execute command
-	var cmd *exec.Cmd
-	if len(parts) == 1 {
-		cmd = exec.Command(baseCmd)
-	} else {
-		cmd = exec.Command(baseCmd, parts[1:]...)
+	cmd := exec.CommandContext(ctx, baseCmd, parts[1:]...)
+	cmd.Dir = s.cfg.CommandDir
+	// Don't let a killed command's leftover children hold its output pipes, and
+	// so Wait, open forever
+	cmd.WaitDelay = commandWaitDelay
+	return cmd, nil
+}
+
+// CallRunCommand executes a whitelisted shell command, killing it if ctx ends first
+func (s *Server) CallRunCommand(ctx context.Context, command string) (string, error) {
+	cmd, err := s.buildCommand(ctx, command)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := cmd.CombinedOutput()
+	output := normalizeCommandOutput(raw, s.cfg.CommandOutputEncoding)
+	if err != nil {
+		return output, fmt.Errorf("command failed: %w - %s", err, output)
+	}
+
+	return output, nil
+}
+
+// Encodings assumed for command output bytes that are not valid UTF-8 (COMMAND_OUTPUT_ENCODING)
+const (
+	commandOutputUTF8   = "utf-8"
+	commandOutputLatin1 = "latin1"
+)
+
+// normalizeCommandOutput returns output as valid UTF-8, so it survives JSON
+// encoding intact. Valid UTF-8 is kept as is. Each byte that is not part of a valid
+// sequence becomes U+FFFD, or with encoding latin1 the ISO-8859-1 character it
+// stands for, which recovers names and text written in legacy Western encodings.
+func normalizeCommandOutput(output []byte, encoding string) string {
+	if utf8.Valid(output) {
+		return string(output)
+	}
+
+	var b strings.Builder
+	b.Grow(len(output) + len(output)/4)
+	for len(output) > 0 {
+		r, size := utf8.DecodeRune(output)
+		if r == utf8.RuneError && size == 1 && encoding == commandOutputLatin1 {
+			r = rune(output[0])
+		}
+		b.WriteRune(r)
+		output = output[size:]
+	}
+	return b.String()
+}
+
+// StreamRunCommand executes a whitelisted shell command and calls onLine for each line
+// of combined stdout/stderr as soon as it is produced. It returns the full output once
+// the command exits; cancelling ctx kills the command.
+func (s *Server) StreamRunCommand(ctx context.Context, command string, onLine func(line string)) (string, error) {
+	cmd, err := s.buildCommand(ctx, command)
+	if err != nil {
+		return "", err
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("command failed to start: %w", err)
 	}
 
-	output, err := cmd.CombinedOutput()
+	// Close the writer once the command exits so the scanner below terminates
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	var output strings.Builder
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		line := normalizeCommandOutput(scanner.Bytes(), s.cfg.CommandOutputEncoding)
+		output.WriteString(line)
+		output.WriteString("\n")
+		onLine(line)
+	}
+	// Once the scanner stops early, e.g. on a line over 64KB, nothing reads the
+	// pipe any more; closing it fails the command's pending writes so it can exit
+	// instead of blocking Wait forever
+	scanErr := scanner.Err()
+	pr.Close()
+
+	err = <-waitErr
+	if scanErr != nil {
+		return output.String(), fmt.Errorf("failed to read command output: %w", scanErr)
+	}
 	if err != nil {
-		return string(output), fmt.Errorf("command failed: %w - %s", err, string(output))
+		return output.String(), fmt.Errorf("command failed: %w - %s", err, output.String())
 	}
 
-	return string(output), nil
+	return output.String(), nil
 }
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer returns a Server with the default configuration as adjusted by
+// configure, talking to baseURL as its AI backend
+func newTestServer(t *testing.T, baseURL string, configure func(*Config)) *Server {
+	t.Helper()
+	cfg := DefaultConfig()
+	if configure != nil {
+		configure(&cfg)
+	}
+	s, err := NewServer("test-key", baseURL, http.DefaultClient, cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return s
+}
+
+// newToolTestServer is newTestServer with the server's own endpoints served at
+// InternalBaseURL, so chat tool calls that go through them work
+func newToolTestServer(t *testing.T, baseURL string, configure func(*Config)) *Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	internal := httptest.NewServer(mux)
+	t.Cleanup(internal.Close)
+	s := newTestServer(t, baseURL, func(cfg *Config) {
+		cfg.InternalBaseURL = internal.URL
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	HandlerFromMux(s, mux)
+	return s
+}
+
+// pageSite serves two readable pages, a redirect to the first and a missing one
+func pageSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html><body><p>Page A</p></body></html>"))
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html><body><script>x()</script><p>Page B</p></body></html>"))
+	})
+	mux.Handle("/old", http.RedirectHandler("/a", http.StatusFound))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPostPageReaderMixedURLs(t *testing.T) {
+	site := pageSite(t)
+	urls := []string{site.URL + "/a", site.URL + "/missing", "not a url", site.URL + "/b"}
+	body, _ := json.Marshal(PageReaderRequest{Urls: &urls})
+
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true // the site is on loopback
+	})
+	rec := httptest.NewRecorder()
+	s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(string(body))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp PageReaderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Results == nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+
+	want := []struct {
+		content string
+		failed  bool
+	}{
+		{"Page A", false},
+		{"", true},
+		{"", true},
+		{"Page B", false},
+	}
+	results := *resp.Results
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Url == nil || *r.Url != urls[i] {
+			t.Errorf("result %d is for %v, want %s", i, r.Url, urls[i])
+		}
+		if failed := r.Error != nil; failed != w.failed {
+			t.Errorf("result %d (%s): error = %v, want failed %v", i, urls[i], r.Error, w.failed)
+		}
+		if !w.failed && (r.Content == nil || !strings.Contains(*r.Content, w.content)) {
+			t.Errorf("result %d (%s): content = %v, want it to contain %q", i, urls[i], r.Content, w.content)
+		}
+	}
+}
+
+func TestPostPageReaderMaxURLs(t *testing.T) {
+	site := pageSite(t)
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true
+		cfg.PageReaderMaxURLs = 2
+	})
+
+	tests := []struct {
+		name string
+		req  PageReaderRequest
+		want int
+	}{
+		{"at the limit", PageReaderRequest{Urls: &[]string{site.URL + "/a", site.URL + "/b"}}, http.StatusOK},
+		{"over the limit", PageReaderRequest{Urls: &[]string{site.URL + "/a", site.URL + "/b", site.URL + "/a"}}, http.StatusBadRequest},
+		{"url counts toward the limit", PageReaderRequest{Url: &site.URL, Urls: &[]string{site.URL + "/a", site.URL + "/b"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(tt.req)
+		rec := httptest.NewRecorder()
+		s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(string(body))))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "too_many_urls") {
+			t.Errorf("%s: body %s, want too_many_urls", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestPostPageReaderFinalURL(t *testing.T) {
+	site := pageSite(t)
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true // the site is on loopback
+	})
+
+	tests := []struct {
+		path     string
+		finalURL string
+	}{
+		{"/old", site.URL + "/a"},
+		{"/b", site.URL + "/b"},
+	}
+	for _, tt := range tests {
+		u := site.URL + tt.path
+		body, _ := json.Marshal(PageReaderRequest{Url: &u})
+		rec := httptest.NewRecorder()
+		s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(string(body))))
+		var resp PageReaderResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s: %v", tt.path, rec.Code, rec.Body, err)
+		}
+		if resp.Url == nil || *resp.Url != site.URL+tt.path {
+			t.Errorf("%s: url = %v, want the requested URL", tt.path, resp.Url)
+		}
+		if resp.FinalUrl == nil || *resp.FinalUrl != tt.finalURL {
+			t.Errorf("%s: final_url = %v, want %s", tt.path, resp.FinalUrl, tt.finalURL)
+		}
+	}
+
+	// Each result of a batch carries its own final URL
+	urls := []string{site.URL + "/old"}
+	body, _ := json.Marshal(PageReaderRequest{Urls: &urls})
+	rec := httptest.NewRecorder()
+	s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(string(body))))
+	var resp PageReaderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Results == nil || len(*resp.Results) != 1 {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if got := (*resp.Results)[0].FinalUrl; got == nil || *got != site.URL+"/a" {
+		t.Errorf("batch final_url = %v, want %s/a", got, site.URL)
+	}
+}
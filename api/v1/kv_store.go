@@ -0,0 +1,169 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxKVKeyLength caps the length of a kv_set/kv_get key
+const maxKVKeyLength = 128
+
+// kvStore backs the kv_set and kv_get tools: a small in-memory key-value space per
+// conversation, so the model can stash intermediate results between turns. Spaces
+// expire after ttl of inactivity like the conversations they belong to, and when
+// more than maxSpaces exist the least recently used one is evicted.
+type kvStore struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	maxSpaces     int
+	maxKeys       int
+	maxValueBytes int
+	order         *list.List // front = most recently used
+	spaces        map[string]*list.Element
+}
+
+type kvSpace struct {
+	conversationID string
+	values         map[string]string
+	expiresAt      time.Time
+}
+
+func newKVStore(ttl time.Duration, maxSpaces, maxKeys, maxValueBytes int) *kvStore {
+	return &kvStore{
+		ttl:           ttl,
+		maxSpaces:     maxSpaces,
+		maxKeys:       maxKeys,
+		maxValueBytes: maxValueBytes,
+		order:         list.New(),
+		spaces:        make(map[string]*list.Element),
+	}
+}
+
+// Set stores value under key in the conversation's space and returns how many keys
+// the space now holds. It fails when the value is too large or a new key would go
+// past the per-conversation cap.
+func (k *kvStore) Set(conversationID, key, value string) (int, error) {
+	if len(value) > k.maxValueBytes {
+		return 0, fmt.Errorf("value is %d bytes, over the %d byte limit", len(value), k.maxValueBytes)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	space := k.spaceLocked(conversationID, true)
+	if _, exists := space.values[key]; !exists && len(space.values) >= k.maxKeys {
+		return len(space.values), fmt.Errorf("this conversation already stores %d keys, the limit; overwrite an existing key instead", k.maxKeys)
+	}
+	space.values[key] = value
+	return len(space.values), nil
+}
+
+// Get returns the value stored under key in the conversation's space
+func (k *kvStore) Get(conversationID, key string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	space := k.spaceLocked(conversationID, false)
+	if space == nil {
+		return "", false
+	}
+	value, ok := space.values[key]
+	return value, ok
+}
+
+// Delete drops the conversation's space, e.g. when the conversation is deleted
+func (k *kvStore) Delete(conversationID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if el, ok := k.spaces[conversationID]; ok {
+		k.removeLocked(el)
+	}
+}
+
+// spaceLocked returns the conversation's live space, refreshing its TTL, and
+// creates it when create is set; k.mu must be held
+func (k *kvStore) spaceLocked(conversationID string, create bool) *kvSpace {
+	if el, ok := k.spaces[conversationID]; ok {
+		space := el.Value.(*kvSpace)
+		if time.Now().Before(space.expiresAt) {
+			space.expiresAt = time.Now().Add(k.ttl)
+			k.order.MoveToFront(el)
+			return space
+		}
+		k.removeLocked(el)
+	}
+	if !create {
+		return nil
+	}
+
+	// Expired spaces sit at the back, so trimming from there drops them first
+	for k.order.Len() > 0 && (k.order.Len() >= k.maxSpaces || time.Now().After(k.order.Back().Value.(*kvSpace).expiresAt)) {
+		k.removeLocked(k.order.Back())
+	}
+	space := &kvSpace{conversationID: conversationID, values: map[string]string{}, expiresAt: time.Now().Add(k.ttl)}
+	k.spaces[conversationID] = k.order.PushFront(space)
+	return space
+}
+
+// removeLocked unlinks el from the store; k.mu must be held
+func (k *kvStore) removeLocked(el *list.Element) {
+	k.order.Remove(el)
+	delete(k.spaces, el.Value.(*kvSpace).conversationID)
+}
+
+type conversationIDKey struct{}
+
+// withConversationID returns ctx carrying the ID of the conversation a chat belongs to
+func withConversationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, conversationIDKey{}, id)
+}
+
+// conversationIDFrom returns the conversation ID carried by ctx, or ""
+func conversationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(conversationIDKey{}).(string)
+	return id
+}
+
+// callKVTool runs kv_set or kv_get against the store of the conversation in ctx
+func (s *Server) callKVTool(ctx context.Context, name, arguments string) (map[string]interface{}, error) {
+	conversationID := conversationIDFrom(ctx)
+	if conversationID == "" {
+		return nil, fmt.Errorf("%s is only available in /chat conversations", name)
+	}
+
+	var args struct {
+		Key   string  `json:"key"`
+		Value *string `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	key := strings.TrimSpace(args.Key)
+	if key == "" || len(key) > maxKVKeyLength {
+		return nil, fmt.Errorf("key must be 1 to %d characters", maxKVKeyLength)
+	}
+
+	if name == "kv_get" {
+		value, found := s.kv.Get(conversationID, key)
+		result := map[string]interface{}{"key": key, "found": found}
+		if found {
+			result["value"] = value
+		}
+		return result, nil
+	}
+
+	if args.Value == nil {
+		return nil, fmt.Errorf("value is required")
+	}
+	keys, err := s.kv.Set(conversationID, key, *args.Value)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"key": key, "stored": true, "keys_used": keys, "keys_limit": s.cfg.KVMaxKeys}, nil
+}
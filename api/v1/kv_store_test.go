@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStore(t *testing.T) {
+	k := newKVStore(time.Hour, 10, 2, 8)
+	if n, err := k.Set("c1", "a", "1"); err != nil || n != 1 {
+		t.Fatalf("Set a = %d, %v", n, err)
+	}
+	if _, err := k.Set("c1", "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := k.Get("c1", "a"); !ok || v != "1" {
+		t.Errorf("Get a = %q, %v, want 1", v, ok)
+	}
+	if _, ok := k.Get("c2", "a"); ok {
+		t.Error("another conversation should not see c1's keys")
+	}
+
+	if _, err := k.Set("c1", "c", "3"); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("third key: error = %v, want the key cap", err)
+	}
+	if n, err := k.Set("c1", "a", "one"); err != nil || n != 2 {
+		t.Errorf("overwriting at the cap = %d, %v, want it allowed", n, err)
+	}
+	if _, err := k.Set("c1", "b", "nine byte"); err == nil || !strings.Contains(err.Error(), "byte limit") {
+		t.Errorf("large value: error = %v, want the size cap", err)
+	}
+
+	k.Delete("c1")
+	if _, ok := k.Get("c1", "a"); ok {
+		t.Error("a deleted conversation's keys should be gone")
+	}
+}
+
+func TestKVStoreEviction(t *testing.T) {
+	k := newKVStore(time.Hour, 2, 10, 100)
+	_, _ = k.Set("a", "k", "a")
+	_, _ = k.Set("b", "k", "b")
+	if _, ok := k.Get("a", "k"); !ok { // a is now the most recently used
+		t.Fatal("a should be stored")
+	}
+	_, _ = k.Set("c", "k", "c")
+	if _, ok := k.Get("b", "k"); ok {
+		t.Error("b, the least recently used, should have been evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := k.Get(id, "k"); !ok {
+			t.Errorf("%s should still be stored", id)
+		}
+	}
+
+	k = newKVStore(20*time.Millisecond, 10, 10, 100)
+	_, _ = k.Set("a", "k", "a")
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := k.Get("a", "k"); ok {
+		t.Error("an idle conversation's keys should expire after the TTL")
+	}
+}
+
+func TestCallKVTools(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.KVMaxKeys = 1
+	})
+	ctx := withConversationID(context.Background(), "conv-1")
+
+	tests := []struct {
+		name, tool, args string
+		ctx              context.Context
+		want             string
+		wantSuccess      bool
+	}{
+		{"get before set", "kv_get", `{"key": "total"}`, ctx, `{"found":false,"key":"total"}`, true},
+		{"set", "kv_set", `{"key": " total ", "value": "42"}`, ctx, `{"key":"total","keys_limit":1,"keys_used":1,"stored":true}`, true},
+		{"get", "kv_get", `{"key": "total"}`, ctx, `{"found":true,"key":"total","value":"42"}`, true},
+		{"over the key cap", "kv_set", `{"key": "other", "value": "1"}`, ctx, "limit", false},
+		{"no value", "kv_set", `{"key": "total"}`, ctx, "value is required", false},
+		{"empty key", "kv_get", `{"key": " "}`, ctx, "key must be", false},
+		{"outside a conversation", "kv_get", `{"key": "total"}`, context.Background(), "only available in /chat", false},
+	}
+	for _, tt := range tests {
+		result, success := s.executeTool(tt.ctx, tt.tool, tt.args)
+		if success != tt.wantSuccess || !strings.Contains(result, tt.want) {
+			t.Errorf("%s: %s = %s, %v, want %s, %v", tt.name, tt.tool, result, success, tt.want, tt.wantSuccess)
+		}
+		if !json.Valid([]byte(result)) {
+			t.Errorf("%s: result %s is not JSON", tt.name, result)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Language detection tuning: a text needs minLanguageLetters letters to be judged
+// at all, a script must cover scriptShare of its letters to decide by script, and a
+// Latin-script guess needs minStopwordHits stopwords and a clear lead over the
+// runner-up. Anything less is reported as uncertain.
+const (
+	minLanguageLetters = 12
+	scriptShare        = 0.4
+	minStopwordHits    = 2
+	stopwordLead       = 1.5
+)
+
+// scriptLanguages maps scripts used by essentially one language to its ISO 639-1
+// code. Han is handled separately since Japanese mixes it with kana.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// latinStopwords holds frequent short words of the Latin-script languages the
+// detector can tell apart. A word common to several languages is listed under each
+// of them, so it counts for all and decides nothing.
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "with", "was", "on", "are", "this", "be", "by", "from", "have", "not", "or"},
+	"fr": {"le", "la", "de", "en", "les", "et", "des", "est", "une", "du", "dans", "pour", "qui", "sur", "pas", "au", "avec", "sont", "ce", "il", "aux", "ou"},
+	"de": {"der", "die", "in", "und", "das", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "von", "sich", "auf", "für", "im", "dem", "auch", "wird", "oder"},
+	"es": {"el", "la", "en", "de", "los", "las", "y", "es", "del", "por", "con", "una", "para", "su", "al", "lo", "como", "más", "pero", "sus", "fue", "este", "sobre"},
+	"it": {"il", "la", "in", "di", "che", "è", "per", "un", "della", "gli", "non", "sono", "nel", "alla", "anche", "con", "del", "dei", "questo", "una", "come", "più"},
+	"pt": {"o", "de", "os", "e", "do", "da", "em", "um", "uma", "para", "com", "não", "das", "dos", "ao", "mais", "pelo", "pela", "foi", "são", "seu"},
+	"nl": {"de", "het", "in", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "voor", "met", "ook", "aan", "worden", "bij", "naar", "wordt", "maar"},
+}
+
+// latinStopwordIndex maps each stopword to the languages listing it
+var latinStopwordIndex = func() map[string][]string {
+	index := map[string][]string{}
+	for lang, words := range latinStopwords {
+		for _, w := range words {
+			index[w] = append(index[w], lang)
+		}
+	}
+	return index
+}()
+
+// detectableLanguage reports whether detectLanguage can recognize lang, an ISO
+// 639-1 code
+func detectableLanguage(lang string) bool {
+	if _, ok := latinStopwords[lang]; ok {
+		return true
+	}
+	for _, s := range scriptLanguages {
+		if s.lang == lang {
+			return true
+		}
+	}
+	return lang == "zh" || lang == "ja"
+}
+
+// detectLanguage guesses the language of a short text such as a search snippet and
+// returns its ISO 639-1 code, or "" when the text is too short or the guess is not
+// clear. Non-Latin scripts decide by script; Latin text by counting stopwords.
+func detectLanguage(text string) string {
+	var letters, han, kana int
+	scripts := make([]int, len(scriptLanguages))
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		default:
+			for i, s := range scriptLanguages {
+				if unicode.Is(s.table, r) {
+					scripts[i]++
+					break
+				}
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// CJK text packs a word into a character or two, so it is judged on fewer letters
+	share := func(n int) bool { return float64(n) >= scriptShare*float64(letters) }
+	if kana > 0 && share(kana+han) {
+		return "ja"
+	}
+	if han >= 4 && share(han) {
+		return "zh"
+	}
+	if letters < minLanguageLetters {
+		return ""
+	}
+	for i, s := range scriptLanguages {
+		if share(scripts[i]) {
+			return s.lang
+		}
+	}
+
+	hits := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for _, lang := range latinStopwordIndex[word] {
+			hits[lang]++
+		}
+	}
+	best, bestHits, runnerUp := "", 0, 0
+	for lang, n := range hits {
+		if n > bestHits || (n == bestHits && lang < best) {
+			best, bestHits, runnerUp = lang, n, bestHits
+		} else if n > runnerUp {
+			runnerUp = n
+		}
+	}
+	if bestHits < minStopwordHits || float64(bestHits) < stopwordLead*float64(runnerUp) {
+		return ""
+	}
+	return best
+}
+
+// filterSearchResultsByLanguage keeps results whose title and snippet read as lang,
+// a language code such as "en" or "pt-BR" (only the primary subtag counts). Results
+// whose language can't be told are kept, as are all results when lang is one the
+// detector doesn't know.
+func filterSearchResultsByLanguage(resp *SearchResponse, lang string) {
+	lang = strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+	if !detectableLanguage(lang) {
+		return
+	}
+
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		kept := make([]interface{}, 0, len(results))
+		for _, result := range results {
+			item, ok := result.(map[string]interface{})
+			if !ok {
+				kept = append(kept, result)
+				continue
+			}
+			var text []string
+			for _, field := range append([]string{"title"}, searchSnippetFields...) {
+				if s, ok := item[field].(string); ok {
+					text = append(text, s)
+				}
+			}
+			if detected := detectLanguage(strings.Join(text, " ")); detected == "" || detected == lang {
+				kept = append(kept, result)
+			}
+		}
+		return kept
+	})
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"The Go programming language is an open source project to make programmers more productive.", "en"},
+		{"Le langage Go est un projet open source pour rendre les programmeurs plus productifs.", "fr"},
+		{"Die Programmiersprache Go ist ein Open-Source-Projekt, das Programmierer produktiver machen soll.", "de"},
+		{"El lenguaje de programación Go es un proyecto de código abierto para los programadores.", "es"},
+		{"Il linguaggio Go è un progetto open source che rende più produttivi gli sviluppatori.", "it"},
+		{"A linguagem Go é um projeto de código aberto para tornar os programadores mais produtivos.", "pt"},
+		{"De programmeertaal Go is een opensourceproject dat programmeurs productiever maakt.", "nl"},
+		{"Go 是一个开源的编程语言，它能让构造简单、可靠且高效的软件变得容易。", "zh"},
+		{"Go はオープンソースのプログラミング言語です。", "ja"},
+		{"Go는 간단하고 신뢰할 수 있으며 효율적인 소프트웨어를 쉽게 만들 수 있는 언어입니다.", "ko"},
+		{"Go — это язык программирования с открытым исходным кодом.", "ru"},
+		{"Golang", ""},
+		{"Go 1.24 release notes", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(tt.text); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// mixedLanguageResults is a search response with English, French and Chinese
+// results and one too short to tell
+func mixedLanguageResults() *SearchResponse {
+	resp := searchResponseWithURLs("https://en.example.com", "https://fr.example.com", "https://zh.example.com", "https://short.example.com")
+	snippets := []string{
+		"Go is an open source programming language that makes it simple to build software that is reliable.",
+		"Go est un langage de programmation open source qui permet de créer des logiciels simples et fiables.",
+		"Go 是一个开源的编程语言，它能让构造简单、可靠且高效的软件变得容易。",
+		"Golang",
+	}
+	results := (*(*resp.Queries)[0].Response)["results"].([]interface{})
+	for i, r := range results {
+		r.(map[string]interface{})["title"] = "Go"
+		r.(map[string]interface{})["snippet"] = snippets[i]
+	}
+	return resp
+}
+
+func TestFilterSearchResultsByLanguage(t *testing.T) {
+	tests := []struct {
+		lang string
+		want []string
+	}{
+		{"en", []string{"https://en.example.com", "https://short.example.com"}},
+		{"fr", []string{"https://fr.example.com", "https://short.example.com"}},
+		{"zh-CN", []string{"https://zh.example.com", "https://short.example.com"}},
+		{"EN-us", []string{"https://en.example.com", "https://short.example.com"}},
+		// The detector doesn't know Swahili, so nothing is filtered
+		{"sw", []string{"https://en.example.com", "https://fr.example.com", "https://zh.example.com", "https://short.example.com"}},
+	}
+	for _, tt := range tests {
+		resp := mixedLanguageResults()
+		filterSearchResultsByLanguage(resp, tt.lang)
+		if got := resultURLs(resp); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: results = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestPostSearchLanguage(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(mixedLanguageResults())
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.PostSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(`{"keywords": ["golang"], "language": "fr"}`)
+	var resp SearchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if got, want := resultURLs(&resp), []string{"https://fr.example.com", "https://short.example.com"}; !slices.Equal(got, want) {
+		t.Errorf("results = %q, want %q", got, want)
+	}
+
+	if rec := post(`{"keywords": ["golang"]}`); strings.Count(rec.Body.String(), `"snippet"`) != 4 {
+		t.Errorf("without a language: %s, want every result kept", rec.Body)
+	}
+	if rec := post(`{"keywords": ["golang"], "language": "french!"}`); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_language") {
+		t.Errorf("bad language: status %d, body %s, want a 400 invalid_language", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchToolLanguage(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(mixedLanguageResults())
+	}))
+	defer upstream.Close()
+	s := newToolTestServer(t, upstream.URL, nil)
+
+	result, success := s.executeTool(t.Context(), "search", `{"keywords": ["golang"], "language": "zh"}`)
+	var resp SearchResponse
+	if err := json.Unmarshal([]byte(result), &resp); err != nil || !success {
+		t.Fatalf("search = %s, %v", result, success)
+	}
+	if got, want := resultURLs(&resp), []string{"https://zh.example.com", "https://short.example.com"}; !slices.Equal(got, want) {
+		t.Errorf("results = %q, want %q", got, want)
+	}
+}
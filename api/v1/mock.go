@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// mockAnswerPrefix labels every canned answer produced in MOCK_MODE
+const mockAnswerPrefix = "[mock] "
+
+// mockCompletion stands in for the AI Builder API when MOCK_MODE is on. It is
+// deterministic: the first call of a chat asks for one search when the search tool
+// is offered, and once tool results are in (or no tools are offered) it answers
+// with a canned, clearly labelled reply that echoes the question.
+func (s *Server) mockCompletion(messages []interface{}, tools []interface{}, opts completionOptions) (choice chatCompletionChoice, usage chatUsage, cerr *completionError) {
+	question, toolsRun, calledTools := mockConversationState(messages)
+
+	if !calledTools && hasToolNamed(tools, "search") {
+		keyword := question
+		if runes := []rune(keyword); len(runes) > 80 {
+			keyword = string(runes[:80])
+		}
+		args, _ := json.Marshal(map[string][]string{"keywords": {keyword}})
+		call := chatToolCall{Id: "mock_call_1", Type: "function"}
+		call.Function.Name = "search"
+		call.Function.Arguments = string(args)
+		choice.Message.ToolCalls = []chatToolCall{call}
+		choice.FinishReason = "tool_calls"
+		log.Printf("%s[/chat] MOCK_MODE: requesting a canned search%s", colorYellow, colorReset)
+		return choice, usage, nil
+	}
+
+	content := mockAnswerPrefix + "This is a canned answer from MOCK_MODE; no model was called."
+	if len(toolsRun) > 0 {
+		content += " Tools used: " + strings.Join(toolsRun, ", ") + "."
+	}
+	content += fmt.Sprintf(" Your message was: %q", question)
+	if opts.JSONObject {
+		answer, _ := json.Marshal(map[string]interface{}{"mock": true, "answer": content})
+		content = string(answer)
+	}
+	if opts.stream != nil {
+		opts.stream.delta(content)
+	}
+
+	log.Printf("%s[/chat] MOCK_MODE: returning a canned answer%s", colorYellow, colorReset)
+	choice.Message.Content = &content
+	choice.FinishReason = "stop"
+	return choice, usage, nil
+}
+
+// mockConversationState returns the content of the last user message, the names of
+// the tools whose results follow it, and whether the model has asked for tools since
+func mockConversationState(messages []interface{}) (question string, toolsRun []string, calledTools bool) {
+	last := -1
+	for i, m := range messages {
+		if messageField(m, "role") == "user" {
+			last = i
+		}
+	}
+	if last < 0 {
+		return "", nil, false
+	}
+
+	// Tool names live on the assistant message's tool_calls, keyed by call ID
+	names := map[string]string{}
+	for _, m := range messages[last+1:] {
+		if msg, ok := m.(map[string]interface{}); ok {
+			if calls, ok := msg["tool_calls"].([]chatToolCall); ok {
+				for _, tc := range calls {
+					names[tc.Id] = tc.Function.Name
+				}
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, m := range messages[last+1:] {
+		if messageField(m, "role") != "tool" {
+			continue
+		}
+		name := names[messageField(m, "tool_call_id")]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			toolsRun = append(toolsRun, name)
+		}
+	}
+	return messageField(messages[last], "content"), toolsRun, len(names) > 0
+}
+
+// messageField reads a string field from a chat message in either of the map
+// shapes the tool loop builds
+func messageField(m interface{}, key string) string {
+	switch msg := m.(type) {
+	case map[string]string:
+		return msg[key]
+	case map[string]interface{}:
+		v, _ := msg[key].(string)
+		return v
+	}
+	return ""
+}
+
+// hasToolNamed reports whether tools contains a function called name
+func hasToolNamed(tools []interface{}, name string) bool {
+	for _, t := range tools {
+		if toolName(t) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mockToolResult returns canned, deterministic output for a tool in MOCK_MODE.
+// Search results keep the real response shape so citations still work.
+func mockToolResult(name, arguments string) string {
+	if name == "search" {
+		var args struct {
+			Keywords []string `json:"keywords"`
+		}
+		_ = json.Unmarshal([]byte(arguments), &args)
+		queries := []SearchQueryResult{}
+		for _, keyword := range args.Keywords {
+			keyword := keyword
+			response := map[string]interface{}{
+				"mock": true,
+				"results": []interface{}{
+					map[string]interface{}{
+						"title":   "Mock result for " + keyword,
+						"url":     "https://example.com/mock-search?q=" + url.QueryEscape(keyword),
+						"snippet": "Canned MOCK_MODE search result; no search provider was called.",
+					},
+				},
+			}
+			queries = append(queries, SearchQueryResult{Keyword: &keyword, Response: &response})
+		}
+		result, _ := json.Marshal(SearchResponse{Queries: &queries})
+		return string(result)
+	}
+
+	result, _ := json.Marshal(map[string]interface{}{
+		"mock":   true,
+		"tool":   name,
+		"result": fmt.Sprintf("Canned MOCK_MODE output for %s; nothing was executed.", name),
+	})
+	return string(result)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newMockServer serves the whole API in MOCK_MODE, with no API key and an
+// unreachable backend, so any real upstream call would fail
+func newMockServer(t *testing.T, configure func(*Config)) *httptest.Server {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.MockMode = true
+	if configure != nil {
+		configure(&cfg)
+	}
+	s, err := NewServer("", "http://upstream.invalid", http.DefaultClient, cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server := httptest.NewServer(Handler(s))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postMockChat posts body to /chat on server and decodes the answer
+func postMockChat(t *testing.T, server *httptest.Server, body string) ChatResponse {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/chat", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var chat ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil || resp.StatusCode != http.StatusOK || chat.Content == nil {
+		t.Fatalf("status %d: %+v, %v", resp.StatusCode, chat, err)
+	}
+	return chat
+}
+
+func TestMockModeChat(t *testing.T) {
+	server := newMockServer(t, nil)
+	body := `{"message": "What is Go?", "include_tool_outputs": true, "include_citations": true}`
+
+	chat := postMockChat(t, server, body)
+	want := `[mock] This is a canned answer from MOCK_MODE; no model was called. Tools used: search. Your message was: "What is Go?"`
+	if *chat.Content != want {
+		t.Errorf("content = %q, want %q", *chat.Content, want)
+	}
+	if chat.ToolOutputs == nil || len(*chat.ToolOutputs) != 1 || (*chat.ToolOutputs)[0].Name != "search" || !strings.Contains((*chat.ToolOutputs)[0].Output, "Mock result for What is Go?") {
+		t.Errorf("tool outputs = %+v, want one canned search", chat.ToolOutputs)
+	}
+	if chat.Citations == nil || len(*chat.Citations) != 1 || (*chat.Citations)[0].Url != "https://example.com/mock-search?q=What+is+Go%3F" {
+		t.Errorf("citations = %+v, want the canned search result", chat.Citations)
+	}
+
+	// The same question gets the same answer
+	if again := postMockChat(t, server, body); *again.Content != *chat.Content {
+		t.Errorf("second answer = %q, want it identical to the first", *again.Content)
+	}
+}
+
+func TestMockModeWithoutTools(t *testing.T) {
+	server := newMockServer(t, func(cfg *Config) {
+		cfg.EnableSearch, cfg.EnableReadPage, cfg.EnableRunCommand = false, false, false
+	})
+	chat := postMockChat(t, server, `{"message": "hi", "response_format": "json_object"}`)
+	var answer struct {
+		Mock   bool   `json:"mock"`
+		Answer string `json:"answer"`
+	}
+	if err := json.Unmarshal([]byte(*chat.Content), &answer); err != nil || !answer.Mock || !strings.HasPrefix(answer.Answer, mockAnswerPrefix) || strings.Contains(answer.Answer, "Tools used") {
+		t.Errorf("content = %q, want a labelled JSON answer with no tools run", *chat.Content)
+	}
+}
+
+func TestMockToolResult(t *testing.T) {
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(mockToolResult("run_command", `{"command": "rm -rf /"}`)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["mock"] != true || out["tool"] != "run_command" || !strings.Contains(out["result"].(string), "nothing was executed") {
+		t.Errorf("run_command result = %v, want canned output", out)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// modelNameRe is the shape every model name must have before it is forwarded
+// upstream or logged: letters, digits, dashes, dots and slashes, up to 128 long
+var modelNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9./-]{0,127}$`)
+
+// loadModelAccess reads a JSON object mapping bearer tokens to the models each may
+// use, e.g. {"team-a-token": ["gpt-5"], "team-b-token": ["gpt-5", "deepseek"]}
+func loadModelAccess(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MODEL_ACCESS_FILE: %w", err)
+	}
+	var access map[string][]string
+	if err := json.Unmarshal(data, &access); err != nil {
+		return nil, fmt.Errorf("MODEL_ACCESS_FILE: expected a JSON object of token to model list: %w", err)
+	}
+	for token, models := range access {
+		if token == "" {
+			return nil, fmt.Errorf("MODEL_ACCESS_FILE: tokens must not be empty")
+		}
+		if models == nil {
+			return nil, fmt.Errorf("MODEL_ACCESS_FILE: model list for a token must not be null")
+		}
+	}
+	return access, nil
+}
+
+// allowedModelsFor returns the models r's bearer token may use: its own entry in
+// ModelAccess when it has one, the global AllowedModels otherwise. A nil result
+// means any model is allowed.
+func (s *Server) allowedModelsFor(r *http.Request) []string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		if models, ok := s.cfg.ModelAccess[token]; ok {
+			return models
+		}
+	}
+	if len(s.cfg.AllowedModels) == 0 {
+		return nil
+	}
+	return s.cfg.AllowedModels
+}
+
+// requireModelAllowed writes a 400 and returns false when model is not a plausible
+// model name, and a 403 when r's caller may not use it
+func (s *Server) requireModelAllowed(w http.ResponseWriter, r *http.Request, model string) bool {
+	if !modelNameRe.MatchString(model) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_model",
+			"model may only contain letters, digits, dashes, dots and slashes (at most 128 characters)")
+		return false
+	}
+
+	allowed := s.allowedModelsFor(r)
+	if allowed == nil {
+		return true
+	}
+	for _, m := range allowed {
+		if m == model {
+			return true
+		}
+	}
+	writeJSONError(w, http.StatusForbidden, "model_not_allowed",
+		fmt.Sprintf("Model %q is not available to this client (allowed: %s)", model, strings.Join(allowed, ", ")))
+	return false
+}
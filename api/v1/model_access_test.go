@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadModelAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"team-a": ["gpt-5"], "team-b": ["gpt-5", "deepseek"], "locked": []}`, ""},
+		{"not an object", `["gpt-5"]`, "expected a JSON object"},
+		{"empty token", `{"": ["gpt-5"]}`, "tokens must not be empty"},
+		{"null list", `{"team-a": null}`, "must not be null"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "access.json")
+		if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		access, err := loadModelAccess(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(access["team-b"], []string{"gpt-5", "deepseek"}) || access["locked"] == nil {
+			t.Errorf("%s: access = %v, %v", tt.name, access, err)
+		}
+	}
+
+	if _, err := loadModelAccess(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "MODEL_ACCESS_FILE") {
+		t.Errorf("missing file: error = %v", err)
+	}
+}
+
+func TestPostChatModelAccess(t *testing.T) {
+	model := newModelStub(t, func(int, map[string]interface{}) string { return answer("ok") })
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.AllowedModels = []string{"gpt-5"}
+		cfg.ModelAccess = map[string][]string{"tier-a": {"gpt-4o"}, "locked": {}}
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		model      string
+		wantStatus int
+	}{
+		{"global list allows", "", "gpt-5", http.StatusOK},
+		{"global list forbids", "", "gpt-4o", http.StatusForbidden},
+		{"unmapped token uses the global list", "other", "gpt-5", http.StatusOK},
+		{"token allows", "tier-a", "gpt-4o", http.StatusOK},
+		{"token overrides the global list", "tier-a", "gpt-5", http.StatusForbidden},
+		{"empty token list forbids everything", "locked", "gpt-5", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "hi", "model": "`+tt.model+`"}`))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		s.PostChat(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if tt.wantStatus == http.StatusForbidden && !strings.Contains(rec.Body.String(), "model_not_allowed") {
+			t.Errorf("%s: body = %s, want a model_not_allowed error", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestPostChatModelName(t *testing.T) {
+	model := newModelStub(t, func(int, map[string]interface{}) string { return answer("ok") })
+	s := newTestServer(t, model.URL, nil)
+
+	valid := []string{"gpt-5", "deepseek/deepseek-chat", "claude-3.5-sonnet", "Qwen2.5-72B", strings.Repeat("a", 128)}
+	invalid := []string{"-gpt", "/etc/passwd", "gpt 5", "gpt-5\nX-Injected: 1", "gpt_5", `gpt"5`, "gpt-5;rm", "模型", strings.Repeat("a", 129)}
+	for _, name := range append(valid, invalid...) {
+		body, _ := json.Marshal(map[string]string{"message": "hi", "model": name})
+		rec := postChat(t, s, string(body))
+		want := http.StatusOK
+		if slices.Contains(invalid, name) {
+			want = http.StatusBadRequest
+		}
+		if rec.Code != want {
+			t.Errorf("model %q: status = %d, want %d: %s", name, rec.Code, want, rec.Body)
+		}
+		if want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "invalid_model") {
+			t.Errorf("model %q: body = %s, want an invalid_model error", name, rec.Body)
+		}
+	}
+	if got := len(model.received()); got != len(valid) {
+		t.Errorf("model called %d times, want only the %d valid names forwarded", got, len(valid))
+	}
+}
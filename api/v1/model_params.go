@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultModelParams maps models to the upstream names of chat parameters they
+// spell differently; parameters a model doesn't list pass through unchanged. A key
+// ending in "*" matches every model starting with the rest. OpenAI's reasoning
+// models reject max_tokens in favour of max_completion_tokens.
+var defaultModelParams = map[string]map[string]string{
+	"gpt-5*": {"max_tokens": "max_completion_tokens"},
+	"o1*":    {"max_tokens": "max_completion_tokens"},
+	"o3*":    {"max_tokens": "max_completion_tokens"},
+	"o4*":    {"max_tokens": "max_completion_tokens"},
+}
+
+// mergeModelParams returns base with MODEL_PARAM_MAP entries applied on top. Each
+// entry maps "model:param" to the name model expects for param, e.g.
+// "deepseek:max_tokens" = "max_output_tokens"; mapping a parameter to itself turns
+// a default rename off.
+func mergeModelParams(base map[string]map[string]string, entries map[string]string) (map[string]map[string]string, error) {
+	merged := make(map[string]map[string]string, len(base))
+	for model, params := range base {
+		merged[model] = make(map[string]string, len(params))
+		for param, upstream := range params {
+			merged[model][param] = upstream
+		}
+	}
+	for key, upstream := range entries {
+		model, param, ok := strings.Cut(key, ":")
+		if !ok || model == "" || param == "" || upstream == "" {
+			return nil, fmt.Errorf("MODEL_PARAM_MAP entries must look like model:param=upstream_param, got %q", key+"="+upstream)
+		}
+		if merged[model] == nil {
+			merged[model] = map[string]string{}
+		}
+		merged[model][param] = upstream
+	}
+	return merged, nil
+}
+
+// modelParams returns the parameter renames for model: its own entry if it has
+// one, otherwise that of the longest matching "*" key, or nil for passthrough
+func modelParams(table map[string]map[string]string, model string) map[string]string {
+	model = strings.ToLower(model)
+	if params, ok := table[model]; ok {
+		return params
+	}
+	var best string
+	var params map[string]string
+	for key, p := range table {
+		prefix, ok := strings.CutSuffix(key, "*")
+		if ok && strings.HasPrefix(model, prefix) && len(key) > len(best) {
+			best, params = key, p
+		}
+	}
+	return params
+}
+
+// mapModelParams renames the parameters of an upstream chat completion request to
+// the names model expects (MODEL_PARAM_MAP), so a renamed field isn't silently
+// ignored upstream
+func (s *Server) mapModelParams(model string, chatReq map[string]interface{}) {
+	for param, upstream := range modelParams(s.cfg.ModelParams, model) {
+		value, ok := chatReq[param]
+		if !ok || upstream == param {
+			continue
+		}
+		delete(chatReq, param)
+		chatReq[upstream] = value
+		s.debugf("[/chat] Sending %s as %s for model %s", param, upstream, model)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestModelParams(t *testing.T) {
+	table := map[string]map[string]string{
+		"gpt-5*":      {"max_tokens": "max_completion_tokens"},
+		"gpt-5-chat*": {"max_tokens": "max_tokens"},
+		"deepseek":    {"max_tokens": "max_output_tokens"},
+	}
+	tests := []struct {
+		model string
+		want  map[string]string
+	}{
+		{"gpt-5", map[string]string{"max_tokens": "max_completion_tokens"}},
+		{"GPT-5-mini", map[string]string{"max_tokens": "max_completion_tokens"}},
+		{"gpt-5-chat-latest", map[string]string{"max_tokens": "max_tokens"}}, // the longer prefix wins
+		{"deepseek", map[string]string{"max_tokens": "max_output_tokens"}},
+		{"deepseek-r1", nil}, // exact keys don't match by prefix
+		{"gpt-4o", nil},
+	}
+	for _, tt := range tests {
+		if got := modelParams(table, tt.model); !maps.Equal(got, tt.want) {
+			t.Errorf("modelParams(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestMergeModelParams(t *testing.T) {
+	merged, err := mergeModelParams(defaultModelParams, map[string]string{
+		"deepseek:max_tokens": "max_output_tokens",
+		"o3*:max_tokens":      "max_tokens",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := merged["deepseek"]["max_tokens"]; got != "max_output_tokens" {
+		t.Errorf("added entry = %q, want max_output_tokens", got)
+	}
+	if got := merged["o3*"]["max_tokens"]; got != "max_tokens" {
+		t.Errorf("overridden default = %q, want max_tokens", got)
+	}
+	if defaultModelParams["o3*"]["max_tokens"] != "max_completion_tokens" {
+		t.Error("merging modified the built-in defaults")
+	}
+
+	for _, key := range []string{"deepseek", ":max_tokens", "deepseek:"} {
+		if _, err := mergeModelParams(nil, map[string]string{key: "x"}); err == nil || !strings.Contains(err.Error(), "MODEL_PARAM_MAP") {
+			t.Errorf("entry %q: err = %v, want a MODEL_PARAM_MAP error", key, err)
+		}
+	}
+}
+
+func TestPostChatMaxTokensMapping(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return answer("ok")
+	})
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.ModelParams, _ = mergeModelParams(cfg.ModelParams, map[string]string{"deepseek-chat:max_tokens": "max_output_tokens"})
+	})
+
+	tests := []struct {
+		model string
+		param string
+	}{
+		{"gpt-5", "max_completion_tokens"},
+		{"o3-mini", "max_completion_tokens"},
+		{"deepseek-chat", "max_output_tokens"},
+		{"gpt-4o", "max_tokens"}, // passthrough
+	}
+	for i, tt := range tests {
+		rec := postChat(t, s, fmt.Sprintf(`{"message": "hi", "model": %q, "max_tokens": 64}`, tt.model))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", tt.model, rec.Code, rec.Body)
+		}
+		req := model.received()[i]
+		if req[tt.param] != float64(64) {
+			t.Errorf("%s: request %v, want max_tokens sent as %s", tt.model, req, tt.param)
+		}
+		for _, other := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+			if _, ok := req[other]; ok && other != tt.param {
+				t.Errorf("%s: request also has %s", tt.model, other)
+			}
+		}
+	}
+
+	if rec := postChat(t, s, `{"message": "hi", "max_tokens": 0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("max_tokens 0: status %d, want 400", rec.Code)
+	}
+}
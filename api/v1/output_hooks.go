@@ -0,0 +1,72 @@
+package api
+
+import (
+	"strings"
+	"sync"
+)
+
+// OutputTransformer rewrites the final chat answer before it is returned, for
+// example to strip chain-of-thought markers or reformat the text.
+type OutputTransformer func(content string) string
+
+var (
+	outputTransformersMu sync.RWMutex
+	// outputTransformers run in order on every final answer. TrimWhitespace is built in.
+	outputTransformers = []registeredTransformer{{fn: TrimWhitespace}}
+	nextTransformerID  = 1
+)
+
+// registeredTransformer pairs a transformer with an ID so it can be unregistered
+type registeredTransformer struct {
+	id int
+	fn OutputTransformer
+}
+
+// TrimWhitespace removes leading and trailing whitespace from the answer
+func TrimWhitespace(content string) string {
+	return strings.TrimSpace(content)
+}
+
+// RegisterOutputTransformer appends fn to the transformers applied to every final
+// chat answer, after those already registered, and returns a function that removes
+// it again. Register transformers at startup, before serving, for example
+//
+//	api.RegisterOutputTransformer(func(content string) string {
+//		return thinkRe.ReplaceAllString(content, "")
+//	})
+//
+// The SAFE_MODE filter always runs after the transformers, so they cannot undo it.
+func RegisterOutputTransformer(fn OutputTransformer) (unregister func()) {
+	outputTransformersMu.Lock()
+	defer outputTransformersMu.Unlock()
+
+	id := nextTransformerID
+	nextTransformerID++
+	outputTransformers = append(outputTransformers, registeredTransformer{id: id, fn: fn})
+
+	return func() {
+		outputTransformersMu.Lock()
+		defer outputTransformersMu.Unlock()
+
+		// Build a new slice so snapshots taken by applyOutputTransformers stay intact
+		kept := make([]registeredTransformer, 0, len(outputTransformers))
+		for _, t := range outputTransformers {
+			if t.id != id {
+				kept = append(kept, t)
+			}
+		}
+		outputTransformers = kept
+	}
+}
+
+// applyOutputTransformers runs content through every registered transformer in order
+func applyOutputTransformers(content string) string {
+	outputTransformersMu.RLock()
+	transformers := outputTransformers
+	outputTransformersMu.RUnlock()
+
+	for _, t := range transformers {
+		content = t.fn(content)
+	}
+	return content
+}
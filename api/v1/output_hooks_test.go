@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRegisterOutputTransformer(t *testing.T) {
+	if got := applyOutputTransformers("  answer \n"); got != "answer" {
+		t.Errorf("built-in transformers gave %q, want the answer trimmed", got)
+	}
+
+	thinkRe := regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
+	unregisterThink := RegisterOutputTransformer(func(content string) string {
+		return thinkRe.ReplaceAllString(content, "")
+	})
+	unregisterUpper := RegisterOutputTransformer(strings.ToUpper)
+
+	// Transformers run in registration order, after the built-in trim
+	model := newModelStub(t, func(int, map[string]interface{}) string {
+		return answer("  <think>plan the reply</think>\nhello  ")
+	})
+	s := newTestServer(t, model.URL, nil)
+	rec := postChat(t, s, `{"message": "hi"}`)
+	var resp ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if *resp.Content != "HELLO" {
+		t.Errorf("content = %q, want the thinking stripped, then upper-cased", *resp.Content)
+	}
+
+	unregisterThink()
+	if got := applyOutputTransformers(" <think>x</think> hi "); got != "<THINK>X</THINK> HI" {
+		t.Errorf("after unregistering: %q, want only trim and upper-case applied", got)
+	}
+	unregisterUpper()
+	if got := applyOutputTransformers(" hi "); got != "hi" {
+		t.Errorf("after unregistering both: %q, want only the built-in trim", got)
+	}
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxExtractedItems caps how many links, images or tables are returned per page
+const maxExtractedItems = 200
+
+var (
+	linkRe      = regexp.MustCompile(`(?is)<a\s([^>]*)>(.*?)</a>`)
+	imgRe       = regexp.MustCompile(`(?is)<img\s([^>]*)>`)
+	tableRe     = regexp.MustCompile(`(?is)<table[^>]*>(.*?)</table>`)
+	rowRe       = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	cellRe      = regexp.MustCompile(`(?is)<t[hd][^>]*>(.*?)</t[hd]>`)
+	titleRe     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaRe      = regexp.MustCompile(`(?is)<meta\s([^>]*)>`)
+	canonicalRe = regexp.MustCompile(`(?is)<link\s([^>]*\brel\s*=\s*["']?canonical["']?[^>]*)>`)
+)
+
+// extractedLink is a hyperlink found on a page
+type extractedLink struct {
+	Text string `json:"text"`
+	Href string `json:"href"`
+}
+
+// extractedImage is an image found on a page
+type extractedImage struct {
+	Src string `json:"src"`
+	Alt string `json:"alt,omitempty"`
+}
+
+// extractedTable is a table found on a page, as rows of cell text
+type extractedTable struct {
+	Rows [][]string `json:"rows"`
+}
+
+// pageExtraction is the result of the extract_from_page tool. Only the field
+// matching the requested extract type is populated.
+type pageExtraction struct {
+	Url      string            `json:"url"`
+	Extract  string            `json:"extract"`
+	Links    []extractedLink   `json:"links,omitempty"`
+	Images   []extractedImage  `json:"images,omitempty"`
+	Tables   []extractedTable  `json:"tables,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ExtractFromPage fetches rawURL and returns structured data of the given kind:
+// "links", "images", "tables" or "metadata". Relative URLs are resolved against
+// the page URL.
+func (s *Server) ExtractFromPage(ctx context.Context, rawURL, extract string) (*pageExtraction, error) {
+	extract = strings.ToLower(strings.TrimSpace(extract))
+	switch extract {
+	case "links", "images", "tables", "metadata":
+	default:
+		return nil, fmt.Errorf("unsupported extract type %q (use links, images, tables or metadata)", extract)
+	}
+
+	page, err := s.fetchPage(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	base, _ := url.Parse(page.URL)
+
+	result := &pageExtraction{Url: page.URL, Extract: extract}
+	switch extract {
+	case "links":
+		result.Links = extractLinks(page.Body, base)
+	case "images":
+		result.Images = extractImages(page.Body, base)
+	case "tables":
+		result.Tables = extractTables(page.Body)
+	case "metadata":
+		result.Metadata = extractMetadata(page.Body, base)
+	}
+	return result, nil
+}
+
+// extractLinks returns the text and absolute href of each anchor with an href
+func extractLinks(html string, base *url.URL) []extractedLink {
+	links := []extractedLink{}
+	for _, m := range linkRe.FindAllStringSubmatch(html, -1) {
+		href := htmlAttr(m[1], "href")
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			continue
+		}
+		links = append(links, extractedLink{
+			Text: htmlToText(m[2]),
+			Href: resolveURL(base, href),
+		})
+		if len(links) >= maxExtractedItems {
+			break
+		}
+	}
+	return links
+}
+
+// extractImages returns the absolute src and alt text of each image
+func extractImages(html string, base *url.URL) []extractedImage {
+	images := []extractedImage{}
+	for _, m := range imgRe.FindAllStringSubmatch(html, -1) {
+		src := htmlAttr(m[1], "src")
+		if src == "" {
+			continue
+		}
+		images = append(images, extractedImage{
+			Src: resolveURL(base, src),
+			Alt: htmlAttr(m[1], "alt"),
+		})
+		if len(images) >= maxExtractedItems {
+			break
+		}
+	}
+	return images
+}
+
+// extractTables returns each table as rows of plain-text cells
+func extractTables(html string) []extractedTable {
+	tables := []extractedTable{}
+	for _, t := range tableRe.FindAllStringSubmatch(html, -1) {
+		var rows [][]string
+		for _, r := range rowRe.FindAllStringSubmatch(t[1], -1) {
+			var cells []string
+			for _, c := range cellRe.FindAllStringSubmatch(r[1], -1) {
+				cells = append(cells, htmlToText(c[1]))
+			}
+			if len(cells) > 0 {
+				rows = append(rows, cells)
+			}
+		}
+		if len(rows) > 0 {
+			tables = append(tables, extractedTable{Rows: rows})
+		}
+		if len(tables) >= maxExtractedItems {
+			break
+		}
+	}
+	return tables
+}
+
+// extractMetadata returns the page title, canonical URL and all named or
+// property-based meta tags (description, og:*, twitter:*, ...)
+func extractMetadata(html string, base *url.URL) map[string]string {
+	meta := map[string]string{}
+	if m := titleRe.FindStringSubmatch(html); m != nil {
+		meta["title"] = htmlToText(m[1])
+	}
+	if m := canonicalRe.FindStringSubmatch(html); m != nil {
+		if href := htmlAttr(m[1], "href"); href != "" {
+			meta["canonical"] = resolveURL(base, href)
+		}
+	}
+	for _, m := range metaRe.FindAllStringSubmatch(html, -1) {
+		key := htmlAttr(m[1], "property")
+		if key == "" {
+			key = htmlAttr(m[1], "name")
+		}
+		content := htmlAttr(m[1], "content")
+		if key == "" || content == "" {
+			continue
+		}
+		meta[strings.ToLower(key)] = content
+	}
+	return meta
+}
+
+// pageMeta builds the link-preview metadata returned by /page_reader/meta. Only the
+// HTML head is parsed, so tags in the body (e.g. embedded SVG titles) are ignored.
+func pageMeta(page *fetchedPage) *PageMetaResponse {
+	head := page.Body
+	if i := strings.Index(strings.ToLower(head), "</head>"); i >= 0 {
+		head = head[:i]
+	}
+	base, _ := url.Parse(page.FinalURL)
+	meta := extractMetadata(head, base)
+
+	resp := &PageMetaResponse{Url: page.FinalURL, OpenGraph: map[string]string{}}
+	if title := meta["title"]; title != "" {
+		resp.Title = &title
+	}
+	if description := meta["description"]; description != "" {
+		resp.Description = &description
+	}
+	if canonical := meta["canonical"]; canonical != "" {
+		resp.CanonicalUrl = &canonical
+	}
+	for key, value := range meta {
+		property, ok := strings.CutPrefix(key, "og:")
+		if !ok || property == "" {
+			continue
+		}
+		if property == "image" || property == "url" {
+			value = resolveURL(base, value)
+		}
+		resp.OpenGraph[property] = value
+	}
+	return resp
+}
+
+// minPageTextLength is the extracted length (in characters) below which a page is
+// assumed to render its content with JavaScript
+const minPageTextLength = 200
+
+// pageTextWithFallback returns the page's text. When almost no text survives tag
+// stripping (typical of JavaScript-rendered pages) it returns an explicit notice
+// plus the page title and meta description instead, so the model is not handed
+// an empty string.
+func pageTextWithFallback(page *fetchedPage) string {
+	text := htmlToText(page.Body)
+	if len([]rune(text)) >= minPageTextLength {
+		return text
+	}
+
+	base, _ := url.Parse(page.URL)
+	meta := extractMetadata(page.Body, base)
+
+	var b strings.Builder
+	b.WriteString("[Page appears to require JavaScript; little text extracted]")
+	if title := meta["title"]; title != "" {
+		b.WriteString("\nTitle: " + title)
+	}
+	description := meta["description"]
+	if description == "" {
+		description = meta["og:description"]
+	}
+	if description != "" {
+		b.WriteString("\nDescription: " + description)
+	}
+	if text != "" {
+		b.WriteString("\nExtracted text: " + text)
+	}
+	return b.String()
+}
+
+// htmlAttr returns the decoded value of attribute name within a tag's attribute string
+func htmlAttr(attrs, name string) string {
+	re := regexp.MustCompile(`(?is)(?:^|\s)` + regexp.QuoteMeta(name) + `\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	m := re.FindStringSubmatch(attrs)
+	if m == nil {
+		return ""
+	}
+	for _, v := range m[1:] {
+		if v != "" {
+			return strings.TrimSpace(decodeHTMLEntities(v))
+		}
+	}
+	return ""
+}
+
+// resolveURL resolves ref against base, returning ref unchanged if either is invalid
+func resolveURL(base *url.URL, ref string) string {
+	if base == nil {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var extractBase, _ = url.Parse("https://example.com/docs/page.html")
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		html string
+		want []extractedLink
+	}{
+		{`<a href="https://go.dev">Go</a>`, []extractedLink{{"Go", "https://go.dev"}}},
+		{`<a class="x" href='/about'>About <b>us</b></a>`, []extractedLink{{"About us", "https://example.com/about"}}},
+		{`<a href=next.html>Next</a>`, []extractedLink{{"Next", "https://example.com/docs/next.html"}}},
+		{`<a href="?q=a&amp;b=c">Query</a>`, []extractedLink{{"Query", "https://example.com/docs/page.html?q=a&b=c"}}},
+		{`<a href="#top">Top</a><a href="javascript:void(0)">JS</a><a name="anchor">No href</a>`, []extractedLink{}},
+	}
+	for _, tt := range tests {
+		if got := extractLinks(tt.html, extractBase); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractLinks(%s) = %+v, want %+v", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestExtractImages(t *testing.T) {
+	tests := []struct {
+		html string
+		want []extractedImage
+	}{
+		{`<img src="/logo.png" alt="Logo">`, []extractedImage{{"https://example.com/logo.png", "Logo"}}},
+		{`<IMG SRC='img/a.jpg' />`, []extractedImage{{"https://example.com/docs/img/a.jpg", ""}}},
+		{`<img alt="no source"><img data-src="lazy.png">`, []extractedImage{}},
+	}
+	for _, tt := range tests {
+		if got := extractImages(tt.html, extractBase); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractImages(%s) = %+v, want %+v", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestExtractTables(t *testing.T) {
+	tests := []struct {
+		html string
+		want []extractedTable
+	}{
+		{
+			`<table><tr><th>Name</th><th>Age</th></tr><tr><td>Ann</td><td><i>30</i></td></tr></table>`,
+			[]extractedTable{{Rows: [][]string{{"Name", "Age"}, {"Ann", "30"}}}},
+		},
+		{
+			`<table id="a"><tr><td>1</td></tr></table><p>text</p><table><tr><td>2</td></tr></table>`,
+			[]extractedTable{{Rows: [][]string{{"1"}}}, {Rows: [][]string{{"2"}}}},
+		},
+		{`<table><tr></tr></table>`, []extractedTable{}},
+	}
+	for _, tt := range tests {
+		if got := extractTables(tt.html); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractTables(%s) = %+v, want %+v", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	html := `<html><head>
+		<title> Go &amp; You </title>
+		<link rel="canonical" href="/docs/canonical.html">
+		<meta name="description" content="All about Go">
+		<meta property="og:title" content="Go!">
+		<meta name="Twitter:Card" content="summary">
+		<meta charset="utf-8">
+		<meta name="empty" content="">
+	</head></html>`
+	want := map[string]string{
+		"title":        "Go & You",
+		"canonical":    "https://example.com/docs/canonical.html",
+		"description":  "All about Go",
+		"og:title":     "Go!",
+		"twitter:card": "summary",
+	}
+	if got := extractMetadata(html, extractBase); !maps.Equal(got, want) {
+		t.Errorf("extractMetadata = %v, want %v", got, want)
+	}
+}
+
+func TestExtractFromPage(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<title>Home</title><a href="/a">A</a><img src="b.png">`))
+	}))
+	defer site.Close()
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true
+	})
+
+	got, err := s.ExtractFromPage(t.Context(), site.URL, " Links ")
+	if err != nil {
+		t.Fatalf("ExtractFromPage: %v", err)
+	}
+	if want := []extractedLink{{"A", site.URL + "/a"}}; got.Extract != "links" || !reflect.DeepEqual(got.Links, want) || got.Images != nil {
+		t.Errorf("links = %+v", got)
+	}
+	if _, err := s.ExtractFromPage(t.Context(), site.URL, "videos"); err == nil {
+		t.Error("an unknown extract type should be refused")
+	}
+}
+
+func TestPageTextWithFallback(t *testing.T) {
+	jsOnly := &fetchedPage{URL: "https://app.example.com/", Body: `<html><head>
+<title>Example App</title>
+<meta name="description" content="A single-page dashboard">
+</head><body><div id="root"></div><noscript>Enable JavaScript</noscript>
+<script src="/bundle.js"></script><script>render(document.getElementById("root"))</script>
+</body></html>`}
+	got := pageTextWithFallback(jsOnly)
+	for _, want := range []string{"require JavaScript", "Title: Example App", "Description: A single-page dashboard", "Extracted text: Example App Enable JavaScript"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("JS-only page: %q does not contain %q", got, want)
+		}
+	}
+
+	article := strings.Repeat("Plenty of server-rendered text. ", 10)
+	full := &fetchedPage{URL: "https://example.com/post", Body: "<html><body><p>" + article + "</p></body></html>"}
+	if got := pageTextWithFallback(full); got != strings.TrimSpace(article) {
+		t.Errorf("text page = %q, want the page text unchanged", got)
+	}
+}
+
+func TestPageMeta(t *testing.T) {
+	page := &fetchedPage{URL: "https://example.com/old", FinalURL: "https://example.com/posts/go.html", Body: `<html><head>
+		<title>Go 1.24 released</title>
+		<meta name="description" content="What's new in Go">
+		<link rel="canonical" href="/posts/go">
+		<meta property="og:title" content="Go 1.24">
+		<meta property="og:type" content="article">
+		<meta property="og:image" content="img/cover.png">
+		<meta property="og:url" content="/posts/go">
+		<meta property="og:" content="no property">
+	</head><body><svg><title>Icon</title></svg><meta property="og:site_name" content="body tag"></body></html>`}
+
+	got := pageMeta(page)
+	if got.Url != page.FinalURL {
+		t.Errorf("url = %q, want the final URL", got.Url)
+	}
+	if got.Title == nil || *got.Title != "Go 1.24 released" {
+		t.Errorf("title = %v, want the head title rather than the SVG one", got.Title)
+	}
+	if got.Description == nil || *got.Description != "What's new in Go" {
+		t.Errorf("description = %v", got.Description)
+	}
+	if got.CanonicalUrl == nil || *got.CanonicalUrl != "https://example.com/posts/go" {
+		t.Errorf("canonical = %v", got.CanonicalUrl)
+	}
+	want := map[string]string{
+		"title": "Go 1.24",
+		"type":  "article",
+		"image": "https://example.com/posts/img/cover.png",
+		"url":   "https://example.com/posts/go",
+	}
+	if !maps.Equal(got.OpenGraph, want) {
+		t.Errorf("open graph = %v, want %v", got.OpenGraph, want)
+	}
+
+	bare := pageMeta(&fetchedPage{FinalURL: "https://example.com/", Body: "<p>no head</p>"})
+	if bare.Title != nil || bare.Description != nil || bare.CanonicalUrl != nil || bare.OpenGraph == nil || len(bare.OpenGraph) != 0 {
+		t.Errorf("page without metadata = %+v, want only an empty open graph map", bare)
+	}
+}
+
+func TestPostPageReaderMeta(t *testing.T) {
+	fetches := 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write([]byte(`<head><meta property="og:title" content="Cached"></head><body>` + strings.Repeat("text ", 100) + `</body>`))
+	}))
+	defer site.Close()
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true
+	})
+
+	rec := httptest.NewRecorder()
+	s.PostPageReaderMeta(rec, httptest.NewRequest(http.MethodPost, "/page_reader/meta", strings.NewReader(`{"url": "`+site.URL+`"}`)))
+	var resp PageMetaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if resp.OpenGraph["title"] != "Cached" {
+		t.Errorf("open graph = %v", resp.OpenGraph)
+	}
+	if _, _, _, err := s.CallReadPage(t.Context(), site.URL, PageReaderRequestFormatText); err != nil || fetches != 1 {
+		t.Errorf("read after preview: %v, %d fetches, want the cached page reused", err, fetches)
+	}
+
+	for body, want := range map[string]int{`{}`: http.StatusBadRequest, `{"url": "http://127.0.0.1:1/"}`: http.StatusBadGateway} {
+		rec := httptest.NewRecorder()
+		s.PostPageReaderMeta(rec, httptest.NewRequest(http.MethodPost, "/page_reader/meta", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+}
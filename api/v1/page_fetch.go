@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Page fetch tuning shared by read_page and extract_from_page
+const (
+	pageCacheTTL        = 5 * time.Minute
+	pageCacheMaxEntries = 200
+	pageFetchTimeout    = 30 * time.Second
+)
+
+// fetchedPage is a raw page body as returned by the origin
+type fetchedPage struct {
+	URL string
+	// FinalURL is where the fetch ended up after following redirects
+	FinalURL string
+	Body     string
+	// ContentType is the response's media type, lowercased and without parameters,
+	// sniffed from the body when the origin didn't send a usable one
+	ContentType string
+}
+
+// tlsVersions maps PAGE_MIN_TLS_VERSION values to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// acquirePageFetch takes one of the process-wide page fetch slots
+// (MAX_CONCURRENT_PAGE_FETCHES), waiting until one frees up or ctx is done. The
+// returned function gives the slot back.
+func (s *Server) acquirePageFetch(ctx context.Context) (release func(), err error) {
+	select {
+	case s.pageFetchSlots <- struct{}{}:
+		return func() { <-s.pageFetchSlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a page fetch slot: %w", ctx.Err())
+	}
+}
+
+// newPageClient builds the HTTP client used for page fetches. Unless
+// cfg.AllowPrivateFetch is set, its dialer refuses to connect to loopback, private,
+// link-local, carrier-grade NAT and other non-public addresses. The check runs on the resolved IP at
+// connect time, so DNS names pointing at internal hosts and redirects to them are
+// rejected too. Its TLS settings (PageMinTLSVersion, InsecureSkipVerify) apply to
+// page fetches only; AI Builder calls use their own client.
+func newPageClient(cfg Config) *http.Client {
+	allowPrivate := cfg.AllowPrivateFetch
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+	}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || !isPublicIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:         tlsVersions[cfg.PageMinTLSVersion],
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.InsecureSkipVerify {
+		log.Printf("%s%s[startup] WARNING: INSECURE_SKIP_VERIFY is on - page fetches accept ANY TLS certificate. Never use this in production.%s",
+			colorBold, colorRed, colorReset)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   pageFetchTimeout,
+	}
+}
+
+// nonPublicPrefixes are the IPv4 ranges that aren't globally routable but that
+// netip.Addr has no predicate for
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, often internal cloud ranges
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and the broadcast address
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address. IPv4
+// addresses written as IPv6 (::ffff:a.b.c.d) are judged as the IPv4 address.
+func isPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() ||
+		ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchPage downloads rawURL through the SSRF-protected page client, serving
+// repeated requests for the same URL from a short-lived cache.
+func (s *Server) fetchPage(ctx context.Context, rawURL string) (*fetchedPage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: must be an absolute http(s) URL", rawURL)
+	}
+
+	if cached, ok := s.pageCache.Get(rawURL); ok {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set User-Agent to avoid being blocked
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; PageReader/1.0)")
+
+	// The slot is held until the body has been read
+	release, err := s.acquirePageFetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := s.pageClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	// Read body, decompressing it if the origin encoded it without being asked
+	reader, err := decodedBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	page := &fetchedPage{URL: rawURL, FinalURL: resp.Request.URL.String(), Body: string(body), ContentType: responseMediaType(resp.Header.Get("Content-Type"), body)}
+	s.pageCache.Set(rawURL, page)
+	return page, nil
+}
+
+// responseMediaType returns the media type of a response from its Content-Type
+// header, falling back to sniffing body when the header is missing or malformed
+func responseMediaType(contentType string, body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return strings.ToLower(mediaType)
+}
+
+// readPageContentTypes are the media types read_page always accepts; operators can
+// add more with READ_PAGE_EXTRA_CONTENT_TYPES
+var readPageContentTypes = []string{"text/html", "text/plain"}
+
+// htmlContentType reports whether mediaType is HTML, which read_page extracts text
+// from; other accepted types are returned as they are
+func htmlContentType(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// checkReadableContentType fails with an "unsupported content type" error unless
+// the page's media type is text/html, text/plain or one of the configured extras.
+// An extra may end in "/*" to accept a whole family such as "text/*".
+func (s *Server) checkReadableContentType(page *fetchedPage) error {
+	allowed := append(slices.Clone(readPageContentTypes), s.cfg.ReadPageExtraContentTypes...)
+	for _, t := range allowed {
+		if family, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(page.ContentType, family+"/") {
+			return nil
+		}
+		if strings.EqualFold(t, page.ContentType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported content type %q: read_page only reads %s", page.ContentType, strings.Join(allowed, ", "))
+}
+
+// fetchPageHead issues a HEAD request for rawURL through the SSRF-protected page
+// client and returns the final URL, status and headers minus StrippedResponseHeaders.
+func (s *Server) fetchPageHead(ctx context.Context, rawURL string) (*PageHeadResponse, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: must be an absolute http(s) URL", rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; PageReader/1.0)")
+
+	release, err := s.acquirePageFetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := s.pageClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	resp.Body.Close()
+
+	return &PageHeadResponse{
+		Url:     resp.Request.URL.String(),
+		Status:  resp.StatusCode,
+		Headers: stripHeaders(resp.Header, s.cfg.StrippedResponseHeaders),
+	}, nil
+}
+
+// stripHeaders flattens h into a map, joining repeated values with ", " and
+// dropping every header named in strip (case-insensitively)
+func stripHeaders(h http.Header, strip []string) map[string]string {
+	denied := make(map[string]bool, len(strip))
+	for _, name := range strip {
+		denied[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+
+	headers := make(map[string]string, len(h))
+	for name, values := range h {
+		if denied[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+// decodedBody returns resp's body with any Content-Encoding removed. The transport
+// only decompresses gzip it asked for itself; origins that compress unasked, or
+// use deflate, are handled here. Brotli has no standard library decoder, so it is
+// reported as an error rather than passed on as garbage.
+func decodedBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed {
+		return resp.Body, nil
+	}
+
+	var reader io.Reader = resp.Body
+	encodings := strings.Split(resp.Header.Get("Content-Encoding"), ",")
+	// Encodings are listed in the order applied, so undo them last to first
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch enc := strings.ToLower(strings.TrimSpace(encodings[i])); enc {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode gzip response: %w", err)
+			}
+			reader = gz
+		case "deflate":
+			// "deflate" is meant to be zlib-wrapped, but some servers send raw deflate
+			buffered := bufio.NewReader(reader)
+			if header, err := buffered.Peek(2); err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+				zr, err := zlib.NewReader(buffered)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode deflate response: %w", err)
+				}
+				reader = zr
+			} else {
+				reader = flate.NewReader(buffered)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported Content-Encoding %q", enc)
+		}
+	}
+	return reader, nil
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"198.18.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPageClientRefusesNonPublicAddresses(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer internal.Close()
+	// Names that resolve to a private address are refused too, as the check runs
+	// at connect time
+	byName := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1)
+
+	for _, target := range []string{internal.URL, byName} {
+		_, err := newPageClient(DefaultConfig()).Get(target)
+		if err == nil || !strings.Contains(err.Error(), "non-public address") {
+			t.Errorf("GET %s: error = %v, want a refusal", target, err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.AllowPrivateFetch = true
+	resp, err := newPageClient(cfg).Get(internal.URL)
+	if err != nil {
+		t.Fatalf("with ALLOW_PRIVATE_FETCH: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestPostPageReaderHead(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		if r.Method != http.MethodHead {
+			t.Errorf("site got %s, want HEAD", r.Method)
+		}
+		h := w.Header()
+		h.Add("Set-Cookie", "session=secret")
+		h.Add("Set-Cookie", "tracking=1")
+		h.Set("WWW-Authenticate", `Basic realm="site"`)
+		h.Set("X-Internal-Trace", "abc")
+		h.Set("Content-Type", "text/html")
+		h.Add("Vary", "Accept")
+		h.Add("Vary", "Accept-Encoding")
+	}))
+	defer site.Close()
+
+	tests := []struct {
+		name     string
+		strip    []string
+		wantGone []string
+		wantKept map[string]string
+	}{
+		{"default list", DefaultConfig().StrippedResponseHeaders, []string{"Set-Cookie", "Www-Authenticate"},
+			map[string]string{"Content-Type": "text/html", "X-Internal-Trace": "abc", "Vary": "Accept, Accept-Encoding"}},
+		{"custom list", []string{" x-internal-trace ", "content-type"}, []string{"X-Internal-Trace", "Content-Type"},
+			map[string]string{"Set-Cookie": "session=secret, tracking=1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+				cfg.AllowPrivateFetch = true // the site is on loopback
+				cfg.StrippedResponseHeaders = tt.strip
+			})
+			rec := httptest.NewRecorder()
+			s.PostPageReaderHead(rec, httptest.NewRequest(http.MethodPost, "/page_reader/head", strings.NewReader(`{"url": "`+site.URL+`/old"}`)))
+			var resp PageHeadResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+			}
+			if resp.Url != site.URL+"/new" || resp.Status != http.StatusOK {
+				t.Errorf("url, status = %s, %d, want the redirect followed to %s/new", resp.Url, resp.Status, site.URL)
+			}
+			for _, name := range tt.wantGone {
+				if v, ok := resp.Headers[name]; ok {
+					t.Errorf("header %s = %q was not stripped", name, v)
+				}
+			}
+			for name, want := range tt.wantKept {
+				if got := resp.Headers[name]; got != want {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// compressed encodes body with each Content-Encoding in order
+func compressed(t *testing.T, body string, encodings ...string) []byte {
+	t.Helper()
+	data := []byte(body)
+	for _, enc := range encodings {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch enc {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = buf.Bytes()
+	}
+	return data
+}
+
+func TestDecodedBody(t *testing.T) {
+	const html = "<html><body><p>Compressed page</p></body></html>"
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		wantErr string
+	}{
+		{"identity", "", []byte(html), ""},
+		{"gzip", "gzip", compressed(t, html, "gzip"), ""},
+		{"x-gzip", "X-Gzip", compressed(t, html, "gzip"), ""},
+		{"zlib deflate", "deflate", compressed(t, html, "deflate"), ""},
+		{"raw deflate", "deflate", compressed(t, html, "raw-deflate"), ""},
+		{"stacked", "gzip, deflate", compressed(t, html, "gzip", "deflate"), ""},
+		{"brotli", "br", []byte("not decoded"), `unsupported Content-Encoding "br"`},
+		{"corrupt gzip", "gzip", []byte("not gzip"), "failed to decode gzip response"},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(tt.body))}
+		resp.Header.Set("Content-Encoding", tt.header)
+		reader, err := decodedBody(resp)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got, err := io.ReadAll(reader); err != nil || string(got) != html {
+			t.Errorf("%s: body = %q, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestCallReadPageCompressed(t *testing.T) {
+	const html = "<html><body><p>Compressed page</p></body></html>"
+	for _, enc := range []string{"gzip", "deflate"} {
+		site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Compressed whatever the client asked for
+			w.Header().Set("Content-Encoding", enc)
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write(compressed(t, html, enc))
+		}))
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.AllowPrivateFetch = true // the site is on loopback
+		})
+		content, _, _, err := s.CallReadPage(t.Context(), site.URL, PageReaderRequestFormatText)
+		site.Close()
+		if err != nil || !strings.Contains(content, "Compressed page") {
+			t.Errorf("%s: content = %q, %v, want the readable text", enc, content, err)
+		}
+	}
+}
+
+func TestPageClientTLS(t *testing.T) {
+	site := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer site.Close()
+	tls12Only := httptest.NewUnstartedServer(site.Config.Handler)
+	tls12Only.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	tls12Only.StartTLS()
+	defer tls12Only.Close()
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		url       string
+		wantErr   string
+	}{
+		{"default rejects self-signed certs", func(*Config) {}, site.URL, "certificate"},
+		{"skip verify", func(c *Config) { c.InsecureSkipVerify = true }, site.URL, ""},
+		{"TLS 1.2 allowed by default", func(c *Config) { c.InsecureSkipVerify = true }, tls12Only.URL, ""},
+		{"minimum TLS 1.3", func(c *Config) { c.InsecureSkipVerify, c.PageMinTLSVersion = true, "1.3" }, tls12Only.URL, "protocol version"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.AllowPrivateFetch = true // the sites are on loopback
+		tt.configure(&cfg)
+		resp, err := newPageClient(cfg).Get(tt.url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPageFetchConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		_, _ = w.Write([]byte("<p>page</p>"))
+	}))
+	defer site.Close()
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true // the site is on loopback
+		cfg.MaxConcurrentPageFetches = 2
+	})
+
+	// Distinct URLs, so the page cache does not absorb any fetch
+	urls := make([]string, 8)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/%d", site.URL, i)
+	}
+	for _, result := range s.CallReadPages(t.Context(), urls, PageReaderRequestFormatText) {
+		if result.Error != nil {
+			t.Fatalf("%s: %s", *result.Url, *result.Error)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("%d fetches were in flight at once, want the limit of 2 reached and held", peak)
+	}
+
+	// With every slot taken, a caller gives up when its context ends
+	for range 2 {
+		if _, err := s.acquirePageFetch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquirePageFetch(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire with no free slot: error = %v, want the context deadline", err)
+	}
+}
+
+func TestCallReadPageContentType(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00binary"))
+		case "/notes.txt":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte("  Plain <b>notes</b>, kept as they are.\n"))
+		case "/data.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			_, _ = w.Write([]byte("a,b\n1,2"))
+		case "/unlabelled":
+			w.Header()["Content-Type"] = nil // no header, so the body is sniffed
+			_, _ = w.Write([]byte("GIF89a\x01\x00\x01\x00"))
+		}
+	}))
+	defer site.Close()
+
+	tests := []struct {
+		path    string
+		extras  []string
+		want    string
+		wantErr string
+	}{
+		{"/logo.png", nil, "", `unsupported content type "image/png"`},
+		{"/unlabelled", nil, "", `unsupported content type "image/gif"`},
+		{"/notes.txt", nil, "Plain <b>notes</b>, kept as they are.", ""},
+		{"/data.csv", nil, "", `unsupported content type "text/csv"`},
+		{"/data.csv", []string{"text/*"}, "a,b\n1,2", ""},
+		{"/logo.png", []string{"text/csv"}, "", "unsupported content type"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.AllowPrivateFetch = true // the site is on loopback
+			cfg.ReadPageExtraContentTypes = tt.extras
+		})
+		content, _, _, err := s.CallReadPage(t.Context(), site.URL+tt.path, PageReaderRequestFormatText)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s with extras %v: err = %v, want %q", tt.path, tt.extras, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || content != tt.want {
+			t.Errorf("%s with extras %v: %q, %v, want %q", tt.path, tt.extras, content, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxMarkdownDepth bounds how deeply nested elements htmlToMarkdown accepts; trees
+// deeper than this are almost always broken markup
+const maxMarkdownDepth = 128
+
+var (
+	markdownSpaceRe     = regexp.MustCompile(`\s+`)
+	markdownHeadRe      = regexp.MustCompile(`(?is)<head\b[^>]*>.*?</head>`)
+	markdownLineSpaceRe = regexp.MustCompile(`[ \t]+\n`)
+	markdownBlankRe     = regexp.MustCompile(`\n{3,}`)
+	strayTagOpenRe      = regexp.MustCompile(`</?[a-zA-Z]`)
+)
+
+// markdownBlocks are elements rendered as paragraphs of their own
+var markdownBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "dd": true, "div": true, "dl": true,
+	"dt": true, "figcaption": true, "figure": true, "footer": true, "form": true,
+	"header": true, "main": true, "nav": true, "p": true, "section": true, "table": true,
+}
+
+// markdownEmphasis maps inline formatting elements to the Markdown marker that
+// opens and closes them
+var markdownEmphasis = map[string]string{"b": "**", "strong": "**", "em": "*", "i": "*", "code": "`"}
+
+// pageMarkdown converts a fetched page to Markdown, resolving links against the
+// URL it was finally read from
+func pageMarkdown(page *fetchedPage) (string, error) {
+	base, _ := url.Parse(page.FinalURL)
+	return htmlToMarkdown(page.Body, base)
+}
+
+// markdownHeading returns the level of a heading tag such as "h2", or 0
+func markdownHeading(tag string) int {
+	if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+// markdownWriter accumulates converted output, dropping collapsed whitespace at
+// the start of a line or after a space already written
+type markdownWriter struct {
+	b strings.Builder
+}
+
+func (w *markdownWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if out := w.b.String(); out == "" || strings.HasSuffix(out, "\n") || strings.HasSuffix(out, " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	w.b.WriteString(s)
+}
+
+// markdownElement is an element htmlToMarkdown has opened and not yet closed
+type markdownElement struct {
+	tag   string
+	href  string // resolved target of an <a>, "" when it isn't rendered as a link
+	items int    // items seen so far in an <ol>, cells in a <tr> or rows in a <table>
+}
+
+// htmlToMarkdown converts a page's HTML to Markdown: headings, paragraphs, lists,
+// links, images, emphasis, code blocks and table rows. Relative URLs are resolved
+// against base. It is deliberately strict: markup it can't follow, such as a tag
+// left unterminated, an unclosed <pre> or absurd nesting, is an error rather than
+// a guess, so the caller can fall back to plain text.
+func htmlToMarkdown(html string, base *url.URL) (string, error) {
+	html = markdownHeadRe.ReplaceAllString(unreadableRe.ReplaceAllString(html, ""), "")
+
+	var w markdownWriter
+	var open []*markdownElement
+	pre := 0
+
+	addText := func(raw string) error {
+		if loc := strayTagOpenRe.FindStringIndex(raw); loc != nil {
+			tag, _, _ := strings.Cut(raw[loc[0]:], " ")
+			return fmt.Errorf("unterminated tag %q", tag)
+		}
+		if pre > 0 {
+			w.b.WriteString(decodeHTMLEntities(raw)) // code keeps its whitespace
+		} else {
+			w.write(markdownSpaceRe.ReplaceAllString(decodeHTMLEntities(raw), " "))
+		}
+		return nil
+	}
+
+	// listIndent is the indentation of an item in the innermost open list
+	listIndent := func() string {
+		lists := 0
+		for _, el := range open {
+			if el.tag == "ul" || el.tag == "ol" {
+				lists++
+			}
+		}
+		return strings.Repeat("  ", max(lists-1, 0))
+	}
+
+	// innermost returns the innermost open element with one of the given tags, or nil
+	innermost := func(tags ...string) *markdownElement {
+		for i := len(open) - 1; i >= 0; i-- {
+			if slices.Contains(tags, open[i].tag) {
+				return open[i]
+			}
+		}
+		return nil
+	}
+
+	// closeElement writes whatever ends el; el has already been removed from open
+	closeElement := func(el *markdownElement) {
+		switch {
+		case el.tag == "a" && el.href != "":
+			w.write("](" + el.href + ")")
+		case el.tag == "pre":
+			pre--
+			w.write("\n```\n\n")
+		case markdownEmphasis[el.tag] != "" && pre == 0:
+			w.write(markdownEmphasis[el.tag])
+		case el.tag == "ul", el.tag == "ol":
+			w.write("\n")
+			if innermost("ul", "ol") == nil {
+				w.write("\n")
+			}
+		case markdownBlocks[el.tag], el.tag == "blockquote", markdownHeading(el.tag) > 0:
+			w.write("\n\n")
+		case el.tag == "tr" && el.items > 0:
+			w.write(" |")
+			// The first row is the header; Markdown tables need a separator under it
+			if table := innermost("table"); table != nil {
+				if table.items == 0 {
+					w.write("\n" + strings.Repeat("| --- ", el.items) + "|")
+				}
+				table.items++
+			}
+		}
+	}
+
+	// closeFrom closes open elements innermost first, down to and including open[i]
+	closeFrom := func(i int) {
+		for len(open) > i {
+			el := open[len(open)-1]
+			open = open[:len(open)-1]
+			closeElement(el)
+		}
+	}
+
+	pos := 0
+	for _, m := range htmlTagRe.FindAllStringSubmatchIndex(html, -1) {
+		if err := addText(html[pos:m[0]]); err != nil {
+			return "", err
+		}
+		pos = m[1]
+
+		closing := m[3] > m[2]
+		tag := strings.ToLower(html[m[4]:m[5]])
+		attrs := html[m[6]:m[7]]
+
+		if closing {
+			// Close the nearest matching element, implicitly closing any left open
+			// inside it; a closing tag with nothing to match is ignored
+			i := len(open) - 1
+			for i >= 0 && open[i].tag != tag {
+				i--
+			}
+			if i < 0 {
+				continue
+			}
+			closeFrom(i)
+			continue
+		}
+
+		switch tag {
+		case "br":
+			w.write("\n")
+		case "hr":
+			w.write("\n\n---\n\n")
+		case "img":
+			if src := htmlAttr(attrs, "src"); src != "" {
+				w.write("![" + htmlAttr(attrs, "alt") + "](" + resolveURL(base, src) + ")")
+			}
+		}
+		if voidElements[tag] || strings.HasSuffix(strings.TrimSpace(attrs), "/") {
+			continue
+		}
+		if len(open) >= maxMarkdownDepth {
+			return "", fmt.Errorf("elements nested more than %d deep", maxMarkdownDepth)
+		}
+
+		el := &markdownElement{tag: tag}
+		switch {
+		case tag == "a":
+			if href := htmlAttr(attrs, "href"); href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(strings.ToLower(href), "javascript:") {
+				el.href = resolveURL(base, href)
+				w.write("[")
+			}
+		case tag == "pre":
+			pre++
+			w.write("\n\n```\n")
+		case tag == "li":
+			marker := "- "
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].tag == "ol" {
+					open[i].items++
+					marker = strconv.Itoa(open[i].items) + ". "
+					break
+				}
+				if open[i].tag == "ul" {
+					break
+				}
+			}
+			w.write("\n" + listIndent() + marker)
+		case tag == "blockquote":
+			w.write("\n\n> ")
+		case tag == "td" || tag == "th":
+			if row := innermost("tr"); row != nil {
+				row.items++
+			}
+			w.write(" | ")
+		case markdownEmphasis[tag] != "" && pre == 0:
+			w.write(markdownEmphasis[tag])
+		case tag == "ul" || tag == "ol":
+			if innermost("ul", "ol") == nil {
+				w.write("\n\n")
+			}
+		case tag == "tr":
+			w.write("\n")
+		case markdownBlocks[tag]:
+			w.write("\n\n")
+		case markdownHeading(tag) > 0:
+			w.write("\n\n" + strings.Repeat("#", markdownHeading(tag)) + " ")
+		}
+		open = append(open, el)
+	}
+	if err := addText(html[pos:]); err != nil {
+		return "", err
+	}
+	if pre > 0 {
+		return "", fmt.Errorf("<pre> block is never closed")
+	}
+	closeFrom(0)
+
+	markdown := markdownLineSpaceRe.ReplaceAllString(w.b.String(), "\n")
+	return strings.TrimSpace(markdownBlankRe.ReplaceAllString(markdown, "\n\n")), nil
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	base, _ := url.Parse("https://example.com/docs/page")
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			"headings and paragraphs",
+			`<head><title>T</title></head><h1>Title</h1><p>Some <b>bold</b> and <em>soft</em> text.</p><h2>Next</h2><p>More &amp; more</p>`,
+			"# Title\n\nSome **bold** and *soft* text.\n\n## Next\n\nMore & more",
+		},
+		{
+			"links and images",
+			`<p><a href="/about">About</a> <a href="#top">top</a> <img src="logo.png" alt="Logo"></p>`,
+			"[About](https://example.com/about) top ![Logo](https://example.com/docs/logo.png)",
+		},
+		{
+			"lists",
+			`<ul><li>one</li><li>two<ol><li>a</li><li>b</li></ol></li></ul>`,
+			"- one\n- two\n  1. a\n  2. b",
+		},
+		{
+			"code block",
+			"<pre><code>x := 1\n  y := 2</code></pre>",
+			"```\nx := 1\n  y := 2\n```",
+		},
+		{
+			"table",
+			`<table><tr><th>Name</th><th>Age</th></tr><tr><td>Ann</td><td>30</td></tr></table>`,
+			"| Name | Age |\n| --- | --- |\n| Ann | 30 |",
+		},
+	}
+	for _, tt := range tests {
+		got, err := htmlToMarkdown(tt.html, base)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToMarkdownRejectsBrokenMarkup(t *testing.T) {
+	tests := map[string]string{
+		"unterminated tag": `<p>Fine so far</p><div class="x`,
+		"unclosed pre":     `<pre>code that never ends`,
+		"deep nesting":     strings.Repeat("<div>", maxMarkdownDepth+1) + "deep",
+	}
+	for name, html := range tests {
+		if got, err := htmlToMarkdown(html, nil); err == nil {
+			t.Errorf("%s: got %q, want an error", name, got)
+		}
+	}
+}
+
+func TestCallReadPageMarkdownFallback(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			_, _ = w.Write([]byte(`<html><body><h1>Broken page</h1><p>Still readable text.</p><div class="x`))
+			return
+		}
+		_, _ = w.Write([]byte(`<html><body><h1>Good page</h1><p>Readable text.</p></body></html>`))
+	}))
+	defer site.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		format       PageReaderRequestFormat
+		fallback     bool
+		wantContent  string
+		wantFallback bool
+		wantErr      bool
+	}{
+		{"markdown", "/good", PageReaderRequestFormatMarkdown, true, "# Good page\n\nReadable text.", false, false},
+		{"text", "/good", PageReaderRequestFormatText, true, "Readable text.", false, false},
+		{"broken markdown falls back", "/broken", PageReaderRequestFormatMarkdown, true, "Still readable text.", true, false},
+		{"broken markdown without fallback", "/broken", PageReaderRequestFormatMarkdown, false, "", false, true},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.AllowPrivateFetch = true
+			cfg.ReadPageMarkdownFallback = tt.fallback
+		})
+		content, _, fallback, err := s.CallReadPage(t.Context(), site.URL+tt.path, tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !strings.Contains(content, tt.wantContent) {
+			t.Errorf("%s: content = %q, want it to contain %q", tt.name, content, tt.wantContent)
+		}
+		if strings.Contains(content, "#") != (tt.format == PageReaderRequestFormatMarkdown && !tt.wantFallback) {
+			t.Errorf("%s: content = %q, Markdown used when it shouldn't be or not when it should", tt.name, content)
+		}
+		if fallback != tt.wantFallback {
+			t.Errorf("%s: markdown fallback = %v, want %v", tt.name, fallback, tt.wantFallback)
+		}
+	}
+}
+
+func TestPostPageReaderFormat(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<h1>Broken page</h1><p>Text</p><div class="x`))
+	}))
+	defer site.Close()
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true
+	})
+
+	rec := httptest.NewRecorder()
+	s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(`{"url": "`+site.URL+`", "format": "markdown"}`)))
+	var resp PageReaderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if resp.MarkdownFallback == nil || !*resp.MarkdownFallback {
+		t.Errorf("markdown_fallback = %v, want true", resp.MarkdownFallback)
+	}
+
+	rec = httptest.NewRecorder()
+	s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(`{"urls": ["`+site.URL+`"], "format": "markdown"}`)))
+	resp = PageReaderResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Results == nil || len(*resp.Results) != 1 {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if got := (*resp.Results)[0].MarkdownFallback; got == nil || !*got {
+		t.Errorf("batch markdown_fallback = %v, want true", got)
+	}
+
+	rec = httptest.NewRecorder()
+	s.PostPageReader(rec, httptest.NewRequest(http.MethodPost, "/page_reader", strings.NewReader(`{"url": "`+site.URL+`", "format": "pdf"}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_format") {
+		t.Errorf("unknown format: status %d, body %s, want 400 invalid_format", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// builtinPromptTemplates are always available unless overridden by a file of the same name
+var builtinPromptTemplates = map[string]string{
+	"summarize":        "Summarize the following text in a few concise sentences:\n\n{{.text}}",
+	"translate":        "Translate the following text into {{.target_language}}. Reply with the translation only.\n\n{{.text}}",
+	"extract-entities": "Extract the named entities (people, organizations, locations, dates) from the following text and list them grouped by type:\n\n{{.text}}",
+}
+
+// errMissingTemplateVariable is returned when a template references a variable that was not provided
+var errMissingTemplateVariable = errors.New("missing template variable")
+
+// promptTemplates is a registry of named prompts rendered with text/template
+type promptTemplates struct {
+	templates map[string]*template.Template
+}
+
+// loadPromptTemplates registers the built-in templates, then every *.tmpl file in
+// dir (named after the file without its extension), which may override built-ins.
+func loadPromptTemplates(dir string) (*promptTemplates, error) {
+	sources := make(map[string]string, len(builtinPromptTemplates))
+	for name, text := range builtinPromptTemplates {
+		sources[name] = text
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, fmt.Errorf("listing prompt templates in %s: %w", dir, err)
+		}
+		for _, f := range files {
+			content, err := os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("reading prompt template %s: %w", f, err)
+			}
+			sources[strings.TrimSuffix(filepath.Base(f), ".tmpl")] = string(content)
+		}
+	}
+
+	registry := &promptTemplates{templates: make(map[string]*template.Template, len(sources))}
+	for name, text := range sources {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("parsing prompt template %q: %w", name, err)
+		}
+		registry.templates[name] = tmpl
+	}
+
+	log.Printf("%s[templates] Loaded prompt templates: %s%s", colorMagenta, strings.Join(registry.Names(), ", "), colorReset)
+	return registry, nil
+}
+
+// Names returns the registered template names in sorted order
+func (p *promptTemplates) Names() []string {
+	names := make([]string, 0, len(p.templates))
+	for name := range p.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render fills the named template with variables. It reports ok=false for an
+// unknown template and wraps errMissingTemplateVariable when a variable is absent.
+func (p *promptTemplates) Render(name string, variables map[string]string) (prompt string, ok bool, err error) {
+	tmpl, ok := p.templates[name]
+	if !ok {
+		return "", false, nil
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, variables); err != nil {
+		if strings.Contains(err.Error(), "map has no entry for key") {
+			return "", true, fmt.Errorf("%w: %v", errMissingTemplateVariable, err)
+		}
+		return "", true, fmt.Errorf("rendering template %q: %w", name, err)
+	}
+	return b.String(), true, nil
+}
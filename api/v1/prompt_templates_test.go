@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPromptTemplatesRender(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"greet.tmpl":     "Say hello to {{.name}}.",
+		"summarize.tmpl": "TL;DR: {{.text}}", // overrides the built-in
+		"notes.txt":      "not a template",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templates, err := loadPromptTemplates(dir)
+	if err != nil {
+		t.Fatalf("loadPromptTemplates: %v", err)
+	}
+	if got := strings.Join(templates.Names(), ","); got != "extract-entities,greet,summarize,translate" {
+		t.Errorf("names = %s", got)
+	}
+
+	tests := []struct {
+		name      string
+		variables map[string]string
+		want      string
+		found     bool
+		missing   bool
+	}{
+		{"greet", map[string]string{"name": "Ann"}, "Say hello to Ann.", true, false},
+		{"summarize", map[string]string{"text": "long text"}, "TL;DR: long text", true, false},
+		{"translate", map[string]string{"text": "hola", "target_language": "English"}, "Translate the following text into English. Reply with the translation only.\n\nhola", true, false},
+		{"translate", map[string]string{"text": "hola"}, "", true, true},
+		{"greet", nil, "", true, true},
+		{"unknown", nil, "", false, false},
+	}
+	for _, tt := range tests {
+		got, found, err := templates.Render(tt.name, tt.variables)
+		if found != tt.found || got != tt.want || errors.Is(err, errMissingTemplateVariable) != tt.missing {
+			t.Errorf("Render(%s, %v) = %q, %v, %v", tt.name, tt.variables, got, found, err)
+		}
+	}
+}
+
+func TestLoadPromptTemplatesBadFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("{{.text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPromptTemplates(dir); err == nil || !strings.Contains(err.Error(), `"broken"`) {
+		t.Errorf("error = %v, want the broken template named", err)
+	}
+}
+
+func TestPostChatTemplate(t *testing.T) {
+	model := newModelStub(t, func(int, map[string]interface{}) string { return answer("Short.") })
+	s := newTestServer(t, model.URL, nil)
+
+	post := func(name, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.PostChatTemplate(rec, httptest.NewRequest(http.MethodPost, "/chat/template/"+name, strings.NewReader(body)), name)
+		return rec
+	}
+
+	rec := post("summarize", `{"variables": {"text": "A long story."}}`)
+	var resp ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Content == nil || *resp.Content != "Short." {
+		t.Fatalf("summarize = %d %s, want the model's answer", rec.Code, rec.Body)
+	}
+	messages := model.received()[0]["messages"].([]interface{})
+	if got := messages[0].(map[string]interface{})["content"]; got != "Summarize the following text in a few concise sentences:\n\nA long story." {
+		t.Errorf("model was sent %q, want the rendered template", got)
+	}
+
+	for _, tt := range []struct {
+		name, body, code string
+		status           int
+	}{
+		{"missing", `{"variables": {}}`, "template_not_found", http.StatusNotFound},
+		{"translate", `{"variables": {"text": "hola"}}`, "missing_variable", http.StatusBadRequest},
+	} {
+		rec := post(tt.name, tt.body)
+		var errResp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || rec.Code != tt.status || errResp.Error != tt.code {
+			t.Errorf("%s = %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+	if n := len(model.received()); n != 1 {
+		t.Errorf("model called %d times, want only for the rendered template", n)
+	}
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Readability tuning, loosely after Mozilla's Readability: paragraphs shorter than
+// minReadableParagraph don't count, and a main block must hold at least
+// minReadableText characters or the whole page is used instead.
+const (
+	minReadableParagraph = 25
+	minReadableText      = 250
+)
+
+var (
+	// Content that is never readable text, removed before scoring
+	unreadableRe = regexp.MustCompile(`(?is)<!--.*?-->|<(script|style|noscript|template|svg)\b[^>]*>.*?</(?:script|style|noscript|template|svg)>`)
+	htmlTagRe    = regexp.MustCompile(`(?s)<(/?)([a-zA-Z][a-zA-Z0-9]*)\b([^>]*)>`)
+
+	// class and id hints that a block is page furniture or main content
+	boilerplateHintRe = regexp.MustCompile(`(?i)banner|breadcrumb|comment|consent|cookie|footer|header|menu|modal|nav|newsletter|popup|promo|related|share|sidebar|social|sponsor|subscribe|widget`)
+	contentHintRe     = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|story|text`)
+)
+
+// voidElements never have a closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// readableNode is an open or closed element seen while scanning a page
+type readableNode struct {
+	tag     string
+	attrs   string
+	parent  *readableNode
+	start   int // offset of the opening tag
+	end     int // offset just past the closing tag, 0 while still open
+	textLen int
+	linkLen int
+	commas  int
+	score   float64
+	scored  bool
+}
+
+// readableText picks the block of html most likely to be the main content, by
+// scoring paragraphs on length and commas and crediting their parent (fully) and
+// grandparent (half). Blocks whose text is mostly links, or whose class or id look
+// like navigation, banners or footers, score lower. ok is false when no block is a
+// clear winner, in which case callers should fall back to the whole page.
+func readableText(html string) (text string, ok bool) {
+	html = unreadableRe.ReplaceAllString(html, "")
+
+	root := &readableNode{tag: "#root"}
+	open := root
+	links := 0
+	var candidates []*readableNode
+
+	addText := func(raw string) {
+		text := strings.Join(strings.Fields(decodeHTMLEntities(raw)), " ")
+		n, commas := len(text), strings.Count(text, ",")
+		for node := open; node != nil; node = node.parent {
+			node.textLen += n
+			node.commas += commas
+			if links > 0 {
+				node.linkLen += n
+			}
+		}
+	}
+
+	pos := 0
+	for _, m := range htmlTagRe.FindAllStringSubmatchIndex(html, -1) {
+		addText(html[pos:m[0]])
+		pos = m[1]
+
+		closing := m[3] > m[2]
+		tag := strings.ToLower(html[m[4]:m[5]])
+		attrs := html[m[6]:m[7]]
+		switch {
+		case voidElements[tag]:
+		case !closing:
+			if tag == "a" {
+				links++
+			}
+			open = &readableNode{tag: tag, attrs: attrs, parent: open, start: m[0]}
+		default:
+			// Close the nearest matching element, implicitly closing any left open inside it
+			node := open
+			for node != root && node.tag != tag {
+				node = node.parent
+			}
+			if node == root {
+				continue
+			}
+			for n := open; n != node.parent; n = n.parent {
+				n.end = m[1]
+				if n.tag == "a" && links > 0 {
+					links--
+				}
+				if scoreParagraph(n) {
+					candidates = creditParagraph(candidates, n)
+				}
+			}
+			open = node.parent
+		}
+	}
+	// Elements still open at the end of the page run to its end
+	addText(html[pos:])
+	for n := open; n != root; n = n.parent {
+		n.end = len(html)
+		if scoreParagraph(n) {
+			candidates = creditParagraph(candidates, n)
+		}
+	}
+
+	var best *readableNode
+	var bestScore float64
+	for _, c := range candidates {
+		if c == root || c.end == 0 {
+			continue
+		}
+		score := c.score + tagWeight(c.tag) + classWeight(c.attrs)
+		if c.textLen > 0 {
+			score *= 1 - float64(c.linkLen)/float64(c.textLen)
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	if best == nil || bestScore <= 0 {
+		return "", false
+	}
+	text = htmlToText(html[best.start:best.end])
+	if len(text) < minReadableText {
+		return "", false
+	}
+	return text, true
+}
+
+// scoreParagraph reports whether n is a paragraph-like block with enough text to
+// count, leaving its score in n.score
+func scoreParagraph(n *readableNode) bool {
+	switch n.tag {
+	case "p", "pre", "td", "blockquote":
+	default:
+		return false
+	}
+	if n.textLen < minReadableParagraph || n.linkLen*2 > n.textLen {
+		return false
+	}
+	n.score = 1 + float64(n.commas) + float64(min(n.textLen/100, 3))
+	return true
+}
+
+// creditParagraph adds paragraph p's score to its parent and half of it to its
+// grandparent, appending either to candidates the first time it is credited
+func creditParagraph(candidates []*readableNode, p *readableNode) []*readableNode {
+	share := p.score
+	for i, node := 0, p.parent; i < 2 && node != nil; i, node = i+1, node.parent {
+		if !node.scored {
+			node.scored = true
+			candidates = append(candidates, node)
+		}
+		node.score += share
+		share /= 2
+	}
+	return candidates
+}
+
+// tagWeight biases candidates by element type
+func tagWeight(tag string) float64 {
+	switch tag {
+	case "article", "main":
+		return 10
+	case "div":
+		return 5
+	case "pre", "td", "blockquote":
+		return 3
+	case "form", "ol", "ul", "li", "dl", "dd", "dt", "address":
+		return -3
+	case "nav", "aside", "header", "footer", "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		return -25
+	}
+	return 0
+}
+
+// classWeight biases candidates by what their class and id suggest
+func classWeight(attrs string) float64 {
+	var weight float64
+	for _, name := range []string{"class", "id"} {
+		value := htmlAttr(attrs, name)
+		if value == "" {
+			continue
+		}
+		if boilerplateHintRe.MatchString(value) {
+			weight -= 25
+		}
+		if contentHintRe.MatchString(value) {
+			weight += 25
+		}
+	}
+	return weight
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clutteredPage is a news-style page whose article is surrounded by a cookie
+// banner, navigation, a sidebar of links and a footer
+var clutteredPage = `<html><head><title>Local news</title><script>track()</script></head><body>
+<div class="cookie-banner"><p>We use cookies to improve your experience, measure traffic, and show ads. Accept all?</p><button>OK</button></div>
+<nav><ul><li><a href="/">Home</a></li><li><a href="/world">World</a></li><li><a href="/sport">Sport</a></li></ul></nav>
+<div id="main-content">
+<article>
+<h1>Council approves new bridge</h1>
+<p>The city council voted on Tuesday to approve a new pedestrian bridge over the river, ending years of debate.</p>
+<p>Construction is expected to start next spring, with completion planned for late next year, officials said.</p>
+<p>Residents, shop owners, and cyclists have campaigned for the crossing since the old ferry stopped running.</p>
+</article>
+</div>
+<aside class="sidebar"><p><a href="/a">Most read: one</a>, <a href="/b">two</a>, <a href="/c">three</a>, <a href="/d">four</a></p></aside>
+<footer><p>Copyright 2026 Local News Ltd, all rights reserved, registered in England.</p></footer>
+</body></html>`
+
+func TestReadableText(t *testing.T) {
+	got, ok := readableText(clutteredPage)
+	if !ok {
+		t.Fatal("no main block found in the cluttered page")
+	}
+	for _, want := range []string{"Council approves new bridge", "pedestrian bridge", "since the old ferry"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("main block %q is missing %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"cookies", "Sport", "Most read", "Copyright", "track()"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("main block %q still contains %q", got, unwanted)
+		}
+	}
+
+	tests := map[string]string{
+		"links only": `<ul>` + strings.Repeat(`<li><a href="/x">A link with a reasonably long title</a></li>`, 20) + `</ul>`,
+		"too short":  `<article><p>Just one short paragraph, nothing more.</p></article>`,
+		"no blocks":  `plain text without any markup at all`,
+	}
+	for name, html := range tests {
+		if text, ok := readableText(html); ok {
+			t.Errorf("%s: got main block %q, want the full-text fallback", name, text)
+		}
+	}
+
+	unclosed := `<div class="content"><p>` + strings.Repeat("An unclosed paragraph of text, still readable. ", 8)
+	if text, ok := readableText(unclosed); !ok || !strings.HasPrefix(text, "An unclosed paragraph") {
+		t.Errorf("unclosed elements: %q, %v, want the paragraphs to run to the end of the page", text, ok)
+	}
+}
+
+func TestCallReadPageReadability(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(clutteredPage))
+	}))
+	defer site.Close()
+
+	for _, readability := range []bool{false, true} {
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.AllowPrivateFetch = true
+			cfg.Readability = readability
+		})
+		content, _, _, err := s.CallReadPage(t.Context(), site.URL, PageReaderRequestFormatText)
+		if err != nil {
+			t.Fatalf("readability %v: %v", readability, err)
+		}
+		if !strings.Contains(content, "pedestrian bridge") {
+			t.Errorf("readability %v: %q is missing the article", readability, content)
+		}
+		if got := strings.Contains(content, "cookies"); got == readability {
+			t.Errorf("readability %v: cookie banner kept = %v, want %v", readability, got, !readability)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recentChatsCapacity is how many chat summaries /admin/recent keeps
+const recentChatsCapacity = 100
+
+// recentChats is a fixed-size ring buffer of chat request summaries. Only metadata
+// is kept, never message content.
+type recentChats struct {
+	mu    sync.Mutex
+	items [recentChatsCapacity]RecentChat
+	next  int
+	count int
+}
+
+// add records one finished chat, overwriting the oldest when full
+func (rc *recentChats) add(chat RecentChat) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.items[rc.next] = chat
+	rc.next = (rc.next + 1) % recentChatsCapacity
+	if rc.count < recentChatsCapacity {
+		rc.count++
+	}
+}
+
+// list returns the buffered summaries, newest first
+func (rc *recentChats) list() []RecentChat {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	chats := make([]RecentChat, 0, rc.count)
+	for i := 1; i <= rc.count; i++ {
+		chats = append(chats, rc.items[(rc.next-i+recentChatsCapacity)%recentChatsCapacity])
+	}
+	return chats
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// GetAdminRecent implements ServerInterface.
+// (GET /admin/recent)
+func (s *Server) GetAdminRecent(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(RecentChatsResponse{Chats: s.recent.list()})
+}
+
+// recordRecentChat adds a summary of a chat that started at start
+func (s *Server) recordRecentChat(requestID, model string, start time.Time, toolCount, status int) {
+	s.recent.add(RecentChat{
+		Timestamp:  start.UTC().Format(time.RFC3339),
+		RequestId:  requestID,
+		Model:      model,
+		ToolCount:  toolCount,
+		DurationMs: int(time.Since(start).Milliseconds()),
+		Status:     status,
+	})
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecentChats(t *testing.T) {
+	var rc recentChats
+	if got := rc.list(); len(got) != 0 {
+		t.Errorf("empty buffer lists %d chats", len(got))
+	}
+	for i := range recentChatsCapacity + 3 {
+		rc.add(RecentChat{RequestId: fmt.Sprintf("r%d", i)})
+	}
+	got := rc.list()
+	if len(got) != recentChatsCapacity {
+		t.Fatalf("buffer lists %d chats, want %d", len(got), recentChatsCapacity)
+	}
+	newest, oldest := fmt.Sprintf("r%d", recentChatsCapacity+2), "r3"
+	if got[0].RequestId != newest || got[len(got)-1].RequestId != oldest {
+		t.Errorf("chats run from %s to %s, want newest %s first and oldest %s last", got[0].RequestId, got[len(got)-1].RequestId, newest, oldest)
+	}
+}
+
+func TestGetAdminRecent(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "mi", "to_unit": "km"}`})
+		}
+		return answer("secret answer")
+	})
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "secret question", "model": "gpt-4o"}`))
+	req.Header.Set("X-Request-ID", "req-ok")
+	s.PostChat(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "hi", "seed": -1}`))
+	req.Header.Set("X-Request-ID", "req-bad")
+	s.PostChat(httptest.NewRecorder(), req)
+
+	getRecent := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/admin/recent", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		s.GetAdminRecent(rec, r)
+		return rec
+	}
+	if rec := getRecent("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with a wrong token: status = %d, want 401", rec.Code)
+	}
+
+	rec := getRecent("admin-secret")
+	var resp RecentChatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if len(resp.Chats) != 2 {
+		t.Fatalf("chats = %+v, want both requests", resp.Chats)
+	}
+	bad, ok := resp.Chats[0], resp.Chats[1]
+	if bad.RequestId != "req-bad" || bad.Status != http.StatusBadRequest {
+		t.Errorf("newest chat = %+v, want req-bad with status 400", bad)
+	}
+	if ok.RequestId != "req-ok" || ok.Status != http.StatusOK || ok.Model != "gpt-4o" || ok.ToolCount != 1 || ok.Timestamp == "" {
+		t.Errorf("oldest chat = %+v, want req-ok on gpt-4o with one tool call", ok)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("summaries leak message content: %s", rec.Body)
+	}
+}
+
+// Regression: template chats were missing from /admin/recent
+func TestGetAdminRecentTemplateChats(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "mi", "to_unit": "km"}`})
+		}
+		return answer("Short.")
+	})
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+	})
+
+	for id, body := range map[string]string{
+		"tmpl-ok":  `{"variables": {"text": "A long story."}, "model": "gpt-4o"}`,
+		"tmpl-bad": `{"variables": {}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/chat/template/summarize", strings.NewReader(body))
+		req.Header.Set("X-Request-ID", id)
+		s.PostChatTemplate(httptest.NewRecorder(), req, "summarize")
+	}
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin/recent", nil)
+	r.Header.Set("Authorization", "Bearer admin-secret")
+	s.GetAdminRecent(rec, r)
+	var resp RecentChatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	got := map[string]RecentChat{}
+	for _, c := range resp.Chats {
+		got[c.RequestId] = c
+	}
+	if c := got["tmpl-ok"]; c.Status != http.StatusOK || c.Model != "gpt-4o" || c.ToolCount != 1 {
+		t.Errorf("template chat = %+v, want status 200 on gpt-4o with one tool call", c)
+	}
+	if c := got["tmpl-bad"]; c.Status != http.StatusBadRequest {
+		t.Errorf("template chat missing a variable = %+v, want status 400", c)
+	}
+}
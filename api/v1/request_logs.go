@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Request log buffer limits: lines are kept for requestLogTTL after a request's
+// last line, for at most requestLogMaxRequests requests of requestLogMaxLines each.
+const (
+	requestLogTTL         = 15 * time.Minute
+	requestLogMaxRequests = 500
+	requestLogMaxLines    = 500
+)
+
+// ansiColorRe matches the terminal color codes used in console logs
+var ansiColorRe = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// requestLogBuffer keeps recent log lines per request ID in memory so a single
+// request can be inspected through /admin/logs/{request_id}
+type requestLogBuffer struct {
+	mu      sync.Mutex
+	entries map[string]*requestLogEntry
+}
+
+type requestLogEntry struct {
+	lines     []string
+	dropped   int
+	expiresAt time.Time
+}
+
+func newRequestLogBuffer() *requestLogBuffer {
+	return &requestLogBuffer{entries: make(map[string]*requestLogEntry)}
+}
+
+// append adds line for requestID, keeping only the newest requestLogMaxLines lines
+// and evicting the request that expires first when the buffer is full
+func (b *requestLogBuffer) append(requestID, line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	e, ok := b.entries[requestID]
+	if !ok || now.After(e.expiresAt) {
+		if !ok && len(b.entries) >= requestLogMaxRequests {
+			b.evictOldestLocked()
+		}
+		e = &requestLogEntry{}
+		b.entries[requestID] = e
+	}
+	e.lines = append(e.lines, now.UTC().Format(time.RFC3339Nano)+" "+ansiColorRe.ReplaceAllString(line, ""))
+	if len(e.lines) > requestLogMaxLines {
+		e.dropped += len(e.lines) - requestLogMaxLines
+		e.lines = append([]string(nil), e.lines[len(e.lines)-requestLogMaxLines:]...)
+	}
+	e.expiresAt = now.Add(requestLogTTL)
+}
+
+// get returns a copy of the lines buffered for requestID and how many older lines
+// were dropped to stay within the cap
+func (b *requestLogBuffer) get(requestID string) (lines []string, dropped int, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[requestID]
+	if !ok || time.Now().After(e.expiresAt) {
+		delete(b.entries, requestID)
+		return nil, 0, false
+	}
+	return append([]string(nil), e.lines...), e.dropped, true
+}
+
+// evictOldestLocked removes the request that expires first; b.mu must be held
+func (b *requestLogBuffer) evictOldestLocked() {
+	var oldestID string
+	var oldest time.Time
+	for id, e := range b.entries {
+		if oldestID == "" || e.expiresAt.Before(oldest) {
+			oldestID, oldest = id, e.expiresAt
+		}
+	}
+	delete(b.entries, oldestID)
+}
+
+// logf logs like log.Printf and also buffers the line under requestID, unless
+// requestID is "" (outside a chat)
+func (s *Server) logf(requestID, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	log.Print(line)
+	if requestID != "" {
+		s.requestLogs.append(requestID, line)
+	}
+}
+
+type requestIDKey struct{}
+
+// withRequestID returns ctx carrying the ID of the chat request it serves, so the
+// tool and model calls made for it can log under that ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom returns the chat request ID carried by ctx, or ""
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// GetAdminLogs implements ServerInterface.
+// (GET /admin/logs/{request_id})
+func (s *Server) GetAdminLogs(w http.ResponseWriter, r *http.Request, requestId string) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	lines, dropped, ok := s.requestLogs.get(requestId)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "logs_not_found", "No buffered logs for this request ID; they may have expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(RequestLogsResponse{RequestId: requestId, Lines: lines, Dropped: dropped})
+}
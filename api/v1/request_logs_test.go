@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestLogBuffer(t *testing.T) {
+	b := newRequestLogBuffer()
+	b.append("r1", colorGreen+"first"+colorReset)
+	b.append("r1", "second")
+	b.append("r2", "other")
+
+	lines, dropped, ok := b.get("r1")
+	if !ok || dropped != 0 || len(lines) != 2 || !strings.HasSuffix(lines[0], " first") || !strings.HasSuffix(lines[1], " second") {
+		t.Errorf("get(r1) = %q, %d, %v, want the two lines without color codes", lines, dropped, ok)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, strings.SplitN(lines[0], " ", 2)[0]); err != nil {
+		t.Errorf("line %q does not start with a timestamp: %v", lines[0], err)
+	}
+	if _, _, ok := b.get("missing"); ok {
+		t.Error("get for an unknown request should miss")
+	}
+
+	// Only the newest lines are kept
+	for i := range requestLogMaxLines + 5 {
+		b.append("long", fmt.Sprintf("line %d", i))
+	}
+	lines, dropped, _ = b.get("long")
+	if len(lines) != requestLogMaxLines || dropped != 5 || !strings.HasSuffix(lines[0], " line 5") {
+		t.Errorf("long request: %d lines from %q, %d dropped, want %d from line 5, 5 dropped", len(lines), lines[0], dropped, requestLogMaxLines)
+	}
+
+	// Expired requests are gone
+	b.entries["r2"].expiresAt = time.Now().Add(-time.Second)
+	if _, _, ok := b.get("r2"); ok {
+		t.Error("get should miss once the request's lines have expired")
+	}
+}
+
+func TestRequestLogBufferEviction(t *testing.T) {
+	b := newRequestLogBuffer()
+	for i := range requestLogMaxRequests {
+		b.append(fmt.Sprintf("r%d", i), "line")
+	}
+	b.entries["r7"].expiresAt = time.Now().Add(time.Second) // expires first
+	b.append("new", "line")
+
+	if len(b.entries) != requestLogMaxRequests {
+		t.Errorf("buffer holds %d requests, want %d", len(b.entries), requestLogMaxRequests)
+	}
+	if _, _, ok := b.get("r7"); ok {
+		t.Error("the request expiring first should have been evicted")
+	}
+	if _, _, ok := b.get("new"); !ok {
+		t.Error("the new request should be buffered")
+	}
+}
+
+func TestGetAdminLogs(t *testing.T) {
+	model := newModelStub(t, func(int, map[string]interface{}) string { return answer("logged answer") })
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "find me in the logs"}`))
+	req.Header.Set("X-Request-ID", "req-123")
+	s.PostChat(httptest.NewRecorder(), req)
+
+	getLogs := func(id, token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/admin/logs/"+id, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.GetAdminLogs(rec, r, id)
+		return rec
+	}
+
+	if rec := getLogs("req-123", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", rec.Code)
+	}
+	if rec := getLogs("req-123", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with a wrong token: status = %d, want 401", rec.Code)
+	}
+	if rec := getLogs("unknown", "admin-secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown request: status = %d, want 404", rec.Code)
+	}
+
+	rec := getLogs("req-123", "admin-secret")
+	var resp RequestLogsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	logs := strings.Join(resp.Lines, "\n")
+	for _, want := range []string{"New request (id: req-123)", `"find me in the logs"`, "logged answer", "Request complete"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not mention %s:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "\x1b[") {
+		t.Errorf("logs still contain color codes:\n%s", logs)
+	}
+}
+
+// Regression: executeTool logged with log.Printf, so a chat's buffered logs left
+// out every tool event
+func TestAdminLogsIncludeTools(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls(
+				[2]string{"convert_units", `{"value": 0, "from_unit": "C", "to_unit": "F"}`},
+				[2]string{"search", `{"keywords": ["go"]}`},
+			)
+		}
+		return answer("done")
+	})
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+		cfg.EnableSearch = false
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "convert and search"}`))
+	req.Header.Set("X-Request-ID", "req-tools")
+	s.PostChat(httptest.NewRecorder(), req)
+
+	lines, _, ok := s.requestLogs.get("req-tools")
+	if !ok {
+		t.Fatal("no logs buffered for the chat")
+	}
+	logs := strings.Join(lines, "\n")
+	for _, want := range []string{"Calling AI API", "Convert units tool executed successfully", "Refusing disabled tool: search"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not mention %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestAdminEndpointsDisabledWithoutToken(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin/logs/x", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	s.GetAdminLogs(rec, r, "x")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 while ADMIN_TOKEN is unset", rec.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxLoggedUserAgent caps how much of a User-Agent header is repeated in logs
+const maxLoggedUserAgent = 80
+
+// requestMeta is who made a request, kept in its context so helpers deep in the
+// chat loop can say in their logs whom they are working for
+type requestMeta struct {
+	ClientIP  string
+	UserAgent string
+	TokenHash string // short hash of the bearer token, "" without one; never the token itself
+}
+
+type requestMetaKey struct{}
+
+// newRequestMeta collects the metadata of r, cutting a long User-Agent with marker
+func newRequestMeta(r *http.Request, marker string) *requestMeta {
+	ua := truncateText(r.Header.Get("User-Agent"), maxLoggedUserAgent, marker)
+	return &requestMeta{ClientIP: clientIP(r), UserAgent: ua, TokenHash: tokenHash(r)}
+}
+
+// withRequestMeta returns ctx carrying m
+func withRequestMeta(ctx context.Context, m *requestMeta) context.Context {
+	return context.WithValue(ctx, requestMetaKey{}, m)
+}
+
+// requestMetaFrom returns ctx's request metadata, or nil when it carries none
+func requestMetaFrom(ctx context.Context) *requestMeta {
+	m, _ := ctx.Value(requestMetaKey{}).(*requestMeta)
+	return m
+}
+
+// logTag formats m for the end of a log line, e.g.
+// ` [ip=203.0.113.7 token=1f2e3d4c5b6a7988 ua="curl/8.5.0"]`. A nil m formats as "".
+func (m *requestMeta) logTag() string {
+	if m == nil {
+		return ""
+	}
+	parts := []string{"ip=" + m.ClientIP}
+	if m.TokenHash != "" {
+		parts = append(parts, "token="+m.TokenHash)
+	}
+	if m.UserAgent != "" {
+		parts = append(parts, fmt.Sprintf("ua=%q", m.UserAgent))
+	}
+	return " [" + strings.Join(parts, " ") + "]"
+}
+
+// metaTag is the log tag of the request ctx belongs to, or "" outside a request
+func metaTag(ctx context.Context) string {
+	return requestMetaFrom(ctx).logTag()
+}
+
+// AttachRequestMeta is middleware that stores the caller's IP, User-Agent and
+// hashed bearer token in the request context, where requestMetaFrom finds them
+func (s *Server) AttachRequestMeta(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withRequestMeta(r.Context(), newRequestMeta(r, s.cfg.TruncationMarker))))
+	})
+}
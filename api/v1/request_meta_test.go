@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestMetaLogTag(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	r.RemoteAddr = "203.0.113.7:1000"
+	r.Header.Set("User-Agent", "curl/8.5.0")
+	r.Header.Set("Authorization", "Bearer secret-token")
+	tag := newRequestMeta(r, "...").logTag()
+	if want := ` [ip=203.0.113.7 token=` + tokenHash(r) + ` ua="curl/8.5.0"]`; tag != want {
+		t.Errorf("tag = %s, want %s", tag, want)
+	}
+	if strings.Contains(tag, "secret-token") {
+		t.Errorf("tag %s repeats the bearer token", tag)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/chat", nil)
+	r.RemoteAddr = "198.51.100.1:2000"
+	r.Header.Set("User-Agent", strings.Repeat("x", 200))
+	m := newRequestMeta(r, "...")
+	if m.TokenHash != "" || len(m.UserAgent) != maxLoggedUserAgent+len("...") {
+		t.Errorf("meta = %+v, want no token and the User-Agent cut short", m)
+	}
+	if tag := metaTag(context.Background()); tag != "" {
+		t.Errorf("tag outside a request = %q, want none", tag)
+	}
+}
+
+func TestRequestMetaInToolLogs(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls([2]string{"read_page", `{"url": "http://127.0.0.1/private"}`})
+		}
+		return answer("done")
+	})
+	s := newToolTestServer(t, model.URL, nil)
+
+	r := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "read it"}`))
+	r.RemoteAddr = "203.0.113.7:1000"
+	r.Header.Set("User-Agent", "meta-test/1.0")
+	rec := httptest.NewRecorder()
+	s.AttachRequestMeta(http.HandlerFunc(s.PostChat)).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// The tool caller runs on the chat loop's own context, far from the request
+	var toolLine string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "Calling /page_reader API") {
+			toolLine = line
+		}
+	}
+	if !strings.Contains(toolLine, `[ip=203.0.113.7 ua="meta-test/1.0"]`) {
+		t.Errorf("tool log line %q is missing the request metadata", toolLine)
+	}
+}
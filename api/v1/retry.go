@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Headers carrying a chat's retry budget across the internal HTTP hop to /search:
+// the chat sends what is left, the handler reports back what it used
+const (
+	retryBudgetHeader = "X-Retry-Budget"
+	retriesUsedHeader = "X-Retries-Used"
+)
+
+// retryBudget caps the retries made on behalf of one chat, across the model calls
+// and tool calls it makes (CHAT_RETRY_BUDGET)
+type retryBudget struct {
+	limit     int
+	remaining atomic.Int64
+}
+
+type retryBudgetKey struct{}
+
+// withRetryBudget returns ctx carrying a fresh budget of n retries
+func withRetryBudget(ctx context.Context, n int) context.Context {
+	b := &retryBudget{limit: n}
+	b.remaining.Store(int64(n))
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// retryBudgetFrom returns ctx's budget, or nil when retries are not budgeted
+func retryBudgetFrom(ctx context.Context) *retryBudget {
+	b, _ := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	return b
+}
+
+// take spends one retry, reporting false when none are left. A nil budget always allows.
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	return b.remaining.Add(-1) >= 0
+}
+
+// spend removes n retries made elsewhere on this budget's behalf
+func (b *retryBudget) spend(n int) {
+	if b != nil && n > 0 {
+		b.remaining.Add(-int64(n))
+	}
+}
+
+// left returns how many retries remain
+func (b *retryBudget) left() int {
+	return max(0, int(b.remaining.Load()))
+}
+
+// used returns how many retries have been spent
+func (b *retryBudget) used() int {
+	return b.limit - b.left()
+}
+
+// retryBudgetFromHeader returns r's context, carrying the budget named by the
+// X-Retry-Budget header when the caller sent one
+func retryBudgetFromHeader(r *http.Request) context.Context {
+	n, err := strconv.Atoi(r.Header.Get(retryBudgetHeader))
+	if err != nil || n < 0 {
+		return r.Context()
+	}
+	return withRetryBudget(r.Context(), n)
+}
+
+// transientError marks an upstream failure that may succeed if retried: the
+// connection failed, or the upstream answered 429 or a gateway error
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string { return e.err.Error() }
+func (e transientError) Unwrap() error { return e.err }
+
+// isTransient reports whether err, or an error it wraps, is a transientError
+func isTransient(err error) bool {
+	var t transientError
+	return errors.As(err, &t)
+}
+
+// retryableStatus reports whether an upstream HTTP status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before retry number attempt (1-based): base doubled
+// per attempt and capped at maxDelay. With jitter the delay is drawn uniformly from
+// zero to that value ("full jitter"), so clients that failed together after an
+// outage don't all come back at the same moment.
+func backoff(attempt int, base, maxDelay time.Duration, jitter bool) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if jitter {
+		delay = time.Duration(rand.Int64N(int64(delay) + 1))
+	}
+	return delay
+}
+
+// retryUpstream runs call until it succeeds or fails for good, retrying up to
+// UpstreamRetries times with a backoff in between. call reports whether its
+// failure is worth retrying. Retrying stops early when ctx is done or its retry
+// budget runs out, so the last failure is returned at once.
+func (s *Server) retryUpstream(ctx context.Context, what string, call func() (retry bool)) {
+	for attempt := 1; ; attempt++ {
+		if !call() || attempt > s.cfg.UpstreamRetries {
+			return
+		}
+		if !retryBudgetFrom(ctx).take() {
+			log.Printf("%s[retry] %s failed and the chat's retry budget is spent, not retrying%s", colorRed, what, colorReset)
+			return
+		}
+
+		delay := backoff(attempt, s.cfg.UpstreamRetryBaseDelay, s.cfg.UpstreamRetryMaxDelay, s.cfg.UpstreamRetryJitter)
+		log.Printf("%s[retry] %s failed, retrying in %s (%d/%d)%s", colorYellow, what, delay.Round(time.Millisecond), attempt, s.cfg.UpstreamRetries, colorReset)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
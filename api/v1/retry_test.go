@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := retryBudgetFrom(withRetryBudget(context.Background(), 3))
+	if !b.take() || !b.take() {
+		t.Fatal("the first two takes should succeed")
+	}
+	b.spend(1)
+	if b.left() != 0 || b.used() != 3 {
+		t.Errorf("left, used = %d, %d, want 0, 3", b.left(), b.used())
+	}
+	if b.take() {
+		t.Error("take should fail once the budget is spent")
+	}
+	if b.left() != 0 || b.used() != 3 {
+		t.Errorf("a failed take should not overdraw: left, used = %d, %d", b.left(), b.used())
+	}
+
+	unbudgeted := retryBudgetFrom(context.Background())
+	if unbudgeted != nil || !unbudgeted.take() {
+		t.Error("without a budget every retry should be allowed")
+	}
+	unbudgeted.spend(5) // must not panic
+}
+
+func TestRetryBudgetFromHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int // -1 for no budget
+	}{
+		{"", -1},
+		{"4", 4},
+		{"0", 0},
+		{"-1", -1},
+		{"lots", -1},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/search", nil)
+		if tt.header != "" {
+			r.Header.Set(retryBudgetHeader, tt.header)
+		}
+		got := -1
+		if b := retryBudgetFrom(retryBudgetFromHeader(r)); b != nil {
+			got = b.left()
+		}
+		if got != tt.want {
+			t.Errorf("header %q: budget %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, maxDelay := 100*time.Millisecond, time.Second
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 30: time.Second} {
+		if got := backoff(attempt, base, maxDelay, false); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+	for range 100 {
+		if got := backoff(3, base, maxDelay, true); got < 0 || got > 400*time.Millisecond {
+			t.Fatalf("jittered backoff(3) = %v, want within [0, 400ms]", got)
+		}
+	}
+}
+
+func TestRetryUpstream(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.UpstreamRetries = 5
+		cfg.UpstreamRetryBaseDelay = time.Millisecond
+		cfg.UpstreamRetryMaxDelay = time.Millisecond
+	})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		failures  int
+		wantCalls int
+	}{
+		{"succeeds first time", context.Background(), 0, 1},
+		{"recovers", context.Background(), 2, 3},
+		{"gives up after UpstreamRetries", context.Background(), 100, 6},
+		{"stops when the budget runs out", withRetryBudget(context.Background(), 2), 100, 3},
+		{"no budget left", withRetryBudget(context.Background(), 0), 100, 1},
+	}
+	for _, tt := range tests {
+		calls := 0
+		s.retryUpstream(tt.ctx, tt.name, func() bool {
+			calls++
+			return calls <= tt.failures
+		})
+		if calls != tt.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tt.name, calls, tt.wantCalls)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	s.retryUpstream(ctx, "cancelled", func() bool {
+		calls++
+		cancel()
+		return true
+	})
+	if calls != 1 {
+		t.Errorf("cancelled: %d calls, want retrying to stop with the context", calls)
+	}
+}
+
+// The budget is shared with the internal /search hop: retries made behind it are
+// charged to the chat
+func TestRetryBudgetCrossesSearchHop(t *testing.T) {
+	var searches atomic.Int32
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searches.Add(1)
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer provider.Close()
+	s := newToolTestServer(t, provider.URL, func(cfg *Config) {
+		cfg.UpstreamRetries = 5
+		cfg.UpstreamRetryBaseDelay = time.Millisecond
+		cfg.UpstreamRetryMaxDelay = time.Millisecond
+	})
+
+	ctx := withRetryBudget(context.Background(), 2)
+	args, _ := json.Marshal(map[string][]string{"keywords": {"golang"}})
+	if result, success := s.executeTool(ctx, "search", string(args)); success {
+		t.Fatalf("search against a failing provider succeeded: %s", result)
+	}
+	if n := searches.Load(); n != 3 {
+		t.Errorf("provider searched %d times, want 1 try and the budget's 2 retries", n)
+	}
+	if b := retryBudgetFrom(ctx); b.left() != 0 {
+		t.Errorf("budget left = %d, want the hop's retries charged to the chat", b.left())
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	err := fmt.Errorf("search: %w", transientError{errors.New("connection reset")})
+	if !isTransient(err) {
+		t.Error("a wrapped transientError should be transient")
+	}
+	if isTransient(errors.New("bad request")) {
+		t.Error("a plain error should not be transient")
+	}
+	for status, want := range map[int]bool{429: true, 502: true, 503: true, 504: true, 400: false, 500: false} {
+		if got := retryableStatus(status); got != want {
+			t.Errorf("retryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestPostChatRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		retries    int // UPSTREAM_RETRIES
+		budget     int // CHAT_RETRY_BUDGET
+		failures   int
+		status     int
+		wantStatus int
+		wantCalls  int32
+	}{
+		{"recovers after two 503s", 2, 4, 2, http.StatusServiceUnavailable, http.StatusOK, 3},
+		{"gives up after the retries", 2, 4, 5, http.StatusTooManyRequests, http.StatusTooManyRequests, 3},
+		{"not retried", 2, 4, 5, http.StatusBadRequest, http.StatusBadRequest, 1},
+		{"stops at the chat's budget", 10, 3, 10, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 4},
+	}
+	for _, tt := range tests {
+		var calls atomic.Int32
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= int32(tt.failures) {
+				http.Error(w, `{"error": "try later"}`, tt.status)
+				return
+			}
+			fmt.Fprint(w, answer("recovered"))
+		}))
+		s := newTestServer(t, model.URL, func(cfg *Config) {
+			cfg.UpstreamRetries = tt.retries
+			cfg.ChatRetryBudget = tt.budget
+			cfg.UpstreamRetryBaseDelay = time.Millisecond
+			cfg.UpstreamRetryMaxDelay = 2 * time.Millisecond
+		})
+
+		rec := postChat(t, s, `{"message": "hi"}`)
+		model.Close()
+		if rec.Code != tt.wantStatus || calls.Load() != tt.wantCalls {
+			t.Errorf("%s: status %d after %d calls, want %d after %d: %s", tt.name, rec.Code, calls.Load(), tt.wantStatus, tt.wantCalls, rec.Body)
+		}
+	}
+}
+
+func TestCallSearchAPIRetries(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"queries": [{"keyword": "golang", "response": {"results": [{"title": "Go"}]}}]}`))
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.UpstreamRetryBaseDelay = time.Millisecond
+		cfg.UpstreamRetryMaxDelay = time.Millisecond
+	})
+
+	resp, err := s.CallSearchAPI(t.Context(), []string{"golang"}, SearchOptions{})
+	if err != nil || !hasSearchResults(resp) || calls.Load() != 2 {
+		t.Errorf("CallSearchAPI = %+v, %v after %d calls, want results on the retry", resp, err, calls.Load())
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// tempDirWith creates a directory holding empty files with the given names
+func tempDirWith(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStreamRunCommand(t *testing.T) {
+	dir := tempDirWith(t, "a.txt", "b.txt")
+	s := newTestServer(t, "http://upstream.invalid", nil)
+
+	var lines []string
+	output, err := s.StreamRunCommand(context.Background(), "ls -1 "+dir, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatalf("StreamRunCommand: %v", err)
+	}
+	if want := []string{"a.txt", "b.txt"}; !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if output != "a.txt\nb.txt\n" {
+		t.Errorf("output = %q", output)
+	}
+
+	if _, err := s.StreamRunCommand(context.Background(), "rm -rf "+dir, func(string) {}); err == nil {
+		t.Error("a command off the whitelist should be refused")
+	}
+	if _, err := s.StreamRunCommand(context.Background(), "ls /etc", func(string) {}); err == nil || !strings.Contains(err.Error(), "path not allowed") {
+		t.Errorf("ls /etc: error = %v, want the path denylist to refuse it", err)
+	}
+}
+
+// Regression: relative operands were resolved against the server's working
+// directory, and the default denylist held /root and /home, so a server run from a
+// home directory refused "ls ." while a bare "ls" listed the same directory
+func TestRunCommandDir(t *testing.T) {
+	for _, d := range DefaultConfig().DeniedCommandPaths {
+		if d == "/root" || d == "/home" {
+			t.Errorf("default DENIED_COMMAND_PATHS holds %s", d)
+		}
+	}
+
+	dir := tempDirWith(t, "a.txt")
+	if err := os.Mkdir(filepath.Join(dir, "secret"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.CommandDir = dir
+		cfg.DeniedCommandPaths = []string{filepath.Join(dir, "secret")}
+	})
+
+	for _, command := range []string{"ls", "ls .", "ls -1 a.txt"} {
+		if output, err := s.CallRunCommand(t.Context(), command); err != nil || !strings.Contains(output, "a.txt") {
+			t.Errorf("%s = %q, %v, want it run in COMMAND_DIR", command, output, err)
+		}
+	}
+	for _, command := range []string{"ls secret", "ls ./secret/../secret", "ls -R"} {
+		if _, err := s.CallRunCommand(t.Context(), command); err == nil || !strings.Contains(err.Error(), "path not allowed") {
+			t.Errorf("%s: error = %v, want the denied path resolved against COMMAND_DIR", command, err)
+		}
+	}
+}
+
+// Regression: a line longer than the scanner's 64KB limit used to stop the
+// reader while the command blocked writing the rest, deadlocking Wait
+func TestStreamRunCommandLongLine(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	missing := "/" + strings.Repeat("x", 100*1024)
+
+	done := make(chan error, 1)
+	go func() {
+		// ls reports the missing path on one line of stderr
+		_, err := s.StreamRunCommand(context.Background(), "ls "+missing, func(string) {})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "failed to read command output") {
+			t.Errorf("error = %v, want a read error for the overlong line", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("StreamRunCommand hung on a line over 64KB")
+	}
+}
+
+func TestPostRunCommandStream(t *testing.T) {
+	dir := tempDirWith(t, "a.txt", "b.txt", "c.txt")
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	server := httptest.NewServer(http.HandlerFunc(s.PostRunCommandStream))
+	defer server.Close()
+
+	body, _ := json.Marshal(RunCommandRequest{Command: "ls -1 " + dir})
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want an event stream", ct)
+	}
+	raw := new(strings.Builder)
+	_, _ = io.Copy(raw, resp.Body)
+
+	// One output event per line as it is produced, then the done event
+	var names, lines []string
+	events := readSSEEvents(t, raw.String())
+	for _, ev := range events {
+		names = append(names, ev.name)
+		if ev.name == "output" {
+			lines = append(lines, ev.data)
+		}
+	}
+	if got := strings.Join(names, ","); got != "output,output,output,done" {
+		t.Fatalf("events = %s", got)
+	}
+	if want := []string{"a.txt", "b.txt", "c.txt"}; !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	var done RunCommandResponse
+	if err := json.Unmarshal([]byte(events[3].data), &done); err != nil || done.Error != nil {
+		t.Errorf("done = %s, want no error", events[3].data)
+	}
+}
+
+func TestNormalizeCommandOutput(t *testing.T) {
+	tests := []struct {
+		output   string
+		encoding string
+		want     string
+	}{
+		{"héllo\n", commandOutputUTF8, "héllo\n"},
+		{"héllo\n", commandOutputLatin1, "héllo\n"},
+		{"caf\xe9.txt", commandOutputUTF8, "caf�.txt"},
+		{"caf\xe9.txt", commandOutputLatin1, "café.txt"},
+		{"\xff\xfe ok é", commandOutputLatin1, "ÿþ ok é"},
+	}
+	for _, tt := range tests {
+		got := normalizeCommandOutput([]byte(tt.output), tt.encoding)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("normalizeCommandOutput(%q, %s) = %q, want %q", tt.output, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestPostRunCommandInvalidUTF8(t *testing.T) {
+	dir := tempDirWith(t, "caf\xe9.txt")
+	for encoding, want := range map[string]string{commandOutputUTF8: "caf�.txt\n", commandOutputLatin1: "café.txt\n"} {
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.CommandOutputEncoding = encoding
+		})
+		body, _ := json.Marshal(RunCommandRequest{Command: "ls -1 " + dir})
+		rec := httptest.NewRecorder()
+		s.PostRunCommand(rec, httptest.NewRequest(http.MethodPost, "/run_command", strings.NewReader(string(body))))
+
+		var resp RunCommandResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %q: %v", encoding, rec.Code, rec.Body, err)
+		}
+		if !utf8.Valid(rec.Body.Bytes()) || resp.Output == nil || *resp.Output != want {
+			t.Errorf("%s: body = %q, want output %q", encoding, rec.Body, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+)
+
+// Safe mode actions (SAFE_MODE_ACTION)
+const (
+	safeModeRedact = "redact"
+	safeModeRefuse = "refuse"
+)
+
+const (
+	// safeModeRedaction replaces each match when SAFE_MODE_ACTION=redact
+	safeModeRedaction = "[redacted]"
+	// safeModeRefusal replaces the whole answer when SAFE_MODE_ACTION=refuse
+	safeModeRefusal = "Sorry, I can't share that answer."
+)
+
+// contentFilter is the SAFE_MODE guardrail run over final chat answers. It is a
+// lightweight keyword/regex check, not a moderation system.
+type contentFilter struct {
+	patterns []*regexp.Regexp
+	refuse   bool
+}
+
+// newContentFilter builds the filter from cfg, returning nil when safe mode is off
+func newContentFilter(cfg Config) (*contentFilter, error) {
+	if !cfg.SafeMode {
+		return nil, nil
+	}
+	patterns, err := compileSafeModePatterns(cfg.SafeModePatterns)
+	if err != nil {
+		return nil, err
+	}
+	return &contentFilter{patterns: patterns, refuse: cfg.SafeModeAction == safeModeRefuse}, nil
+}
+
+// compileSafeModePatterns compiles each pattern case-insensitively. Plain keywords
+// are valid patterns, so a word list works as-is.
+func compileSafeModePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("SAFE_MODE_PATTERNS: invalid pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// apply returns content with matches redacted, or the refusal message when the
+// filter refuses. flagged reports whether any pattern matched. A nil filter
+// passes content through.
+func (f *contentFilter) apply(content string) (filtered string, flagged bool) {
+	if f == nil {
+		return content, false
+	}
+	for _, re := range f.patterns {
+		if !re.MatchString(content) {
+			continue
+		}
+		flagged = true
+		if f.refuse {
+			return safeModeRefusal, true
+		}
+		content = re.ReplaceAllLiteralString(content, safeModeRedaction)
+	}
+	return content, flagged
+}
+
+// filterAnswer runs a final answer through the safe mode filter, logging when it fires
+func (s *Server) filterAnswer(content string) string {
+	filtered, flagged := s.safeFilter.apply(content)
+	if flagged {
+		action := safeModeRedact
+		if s.safeFilter.refuse {
+			action = safeModeRefuse
+		}
+		log.Printf("%s[/chat] Safe mode flagged the answer (action: %s)%s", colorYellow, action, colorReset)
+	}
+	return filtered
+}
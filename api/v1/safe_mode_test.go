@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestContentFilter(t *testing.T) {
+	patterns := []string{"darn", `\bheck\b`, "secret-[0-9]+"}
+	tests := []struct {
+		name        string
+		action      string
+		content     string
+		want        string
+		wantFlagged bool
+	}{
+		{"clean", safeModeRedact, "A perfectly nice answer.", "A perfectly nice answer.", false},
+		{"keyword redacted", safeModeRedact, "Oh DARN, that is darn annoying", "Oh [redacted], that is [redacted] annoying", true},
+		{"word boundary", safeModeRedact, "Checking heckle", "Checking heckle", false},
+		{"several patterns", safeModeRedact, "heck, the code is secret-1234", "[redacted], the code is [redacted]", true},
+		{"refused", safeModeRefuse, "what the heck", safeModeRefusal, true},
+		{"clean with refuse", safeModeRefuse, "All good here", "All good here", false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.SafeMode, cfg.SafeModePatterns, cfg.SafeModeAction = true, patterns, tt.action
+		f, err := newContentFilter(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, flagged := f.apply(tt.content)
+		if got != tt.want || flagged != tt.wantFlagged {
+			t.Errorf("%s: apply(%q) = %q, %v, want %q, %v", tt.name, tt.content, got, flagged, tt.want, tt.wantFlagged)
+		}
+	}
+
+	if f, err := newContentFilter(DefaultConfig()); f != nil || err != nil {
+		t.Errorf("safe mode off: filter = %v, %v, want none", f, err)
+	}
+	var off *contentFilter
+	if got, flagged := off.apply("darn"); got != "darn" || flagged {
+		t.Errorf("nil filter changed the content: %q, %v", got, flagged)
+	}
+}
+
+func TestPostChatSafeMode(t *testing.T) {
+	model := newModelStub(t, func(int, map[string]interface{}) string { return answer("That darn bug again.") })
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.SafeMode, cfg.SafeModePatterns = true, []string{"darn"}
+	})
+
+	rec := postChat(t, s, `{"message": "hi"}`)
+	var resp ChatResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || resp.Content == nil {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if *resp.Content != "That [redacted] bug again." {
+		t.Errorf("content = %q, want the match redacted", *resp.Content)
+	}
+}
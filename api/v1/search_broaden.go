@@ -0,0 +1,43 @@
+package api
+
+import (
+	"strings"
+)
+
+// countSearchResults returns the number of results across all of resp's queries
+func countSearchResults(resp *SearchResponse) int {
+	n := 0
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		n += len(results)
+		return results
+	})
+	return n
+}
+
+// broadenSearchKeywords relaxes each keyword for a retry after too few results:
+// exact-phrase quotes are dropped, and so is the most specific term, taken to be
+// the longest word. Single-word keywords are kept. It reports false when nothing
+// could be relaxed.
+func broadenSearchKeywords(keywords []string) ([]string, bool) {
+	broader := make([]string, 0, len(keywords))
+	changed := false
+	for _, keyword := range keywords {
+		words := strings.Fields(strings.ReplaceAll(keyword, `"`, ""))
+		if len(words) > 1 {
+			longest := 0
+			for i, word := range words {
+				if len(word) >= len(words[longest]) {
+					longest = i
+				}
+			}
+			words = append(words[:longest], words[longest+1:]...)
+		}
+		relaxed := strings.Join(words, " ")
+		if relaxed == "" {
+			relaxed = keyword
+		}
+		changed = changed || relaxed != keyword
+		broader = append(broader, relaxed)
+	}
+	return broader, changed
+}
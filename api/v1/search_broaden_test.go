@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBroadenSearchKeywords(t *testing.T) {
+	tests := []struct {
+		keywords []string
+		want     []string
+		changed  bool
+	}{
+		{[]string{"rust async runtime benchmarks"}, []string{"rust async runtime"}, true},
+		{[]string{`"exact phrase"`}, []string{"exact"}, true},
+		{[]string{"golang", "go generics guide"}, []string{"golang", "go guide"}, true},
+		{[]string{"golang"}, []string{"golang"}, false},
+		{[]string{"same size"}, []string{"same"}, true}, // ties drop the later word
+	}
+	for _, tt := range tests {
+		got, changed := broadenSearchKeywords(tt.keywords)
+		if !slices.Equal(got, tt.want) || changed != tt.changed {
+			t.Errorf("broadenSearchKeywords(%q) = %q, %v, want %q, %v", tt.keywords, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestCallSearchAPIBroaden(t *testing.T) {
+	// The full query finds one result; dropping its most specific term finds four
+	var searched []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Keywords []string `json:"keywords"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		keyword := strings.Join(req.Keywords, ",")
+		searched = append(searched, keyword)
+		hits := 1
+		if keyword == "zig allocator" {
+			hits = 4
+		}
+		var results []string
+		for i := range hits {
+			results = append(results, fmt.Sprintf(`{"title": "result %d"}`, i))
+		}
+		fmt.Fprintf(w, `{"queries": [{"keyword": %q, "response": {"results": [%s]}}]}`, keyword, strings.Join(results, ","))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name         string
+		keywords     []string
+		broaden      bool
+		wantSearched []string
+		wantHits     int
+		wantBroader  []string
+	}{
+		{"broadened", []string{"zig allocator internals"}, true, []string{"zig allocator internals", "zig allocator"}, 4, []string{"zig allocator"}},
+		{"not asked", []string{"zig allocator internals"}, false, []string{"zig allocator internals"}, 1, nil},
+		{"nothing to relax", []string{"zig"}, true, []string{"zig"}, 1, nil},
+		{"broader finds no more", []string{"odin arena internals"}, true, []string{"odin arena internals", "odin arena"}, 1, nil},
+	}
+	for _, tt := range tests {
+		searched = nil
+		s := newTestServer(t, upstream.URL, func(cfg *Config) {
+			cfg.UpstreamRetries = 0
+			cfg.SearchBroadenMinResults = 3
+		})
+		resp, err := s.CallSearchAPI(t.Context(), tt.keywords, SearchOptions{MaxResults: 10, Broaden: tt.broaden})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(searched, tt.wantSearched) {
+			t.Errorf("%s: searched %q, want %q", tt.name, searched, tt.wantSearched)
+		}
+		if got := countSearchResults(resp); got != tt.wantHits {
+			t.Errorf("%s: %d results, want %d", tt.name, got, tt.wantHits)
+		}
+		var broader []string
+		if resp.BroadenedKeywords != nil {
+			broader = *resp.BroadenedKeywords
+		}
+		if !slices.Equal(broader, tt.wantBroader) {
+			t.Errorf("%s: broadened_keywords = %q, want %q", tt.name, broader, tt.wantBroader)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// errSearchBlocked is returned when a search keyword contains a blocked term
+var errSearchBlocked = errors.New("search refused: the keywords contain a blocked term")
+
+// mapSearchResults applies fn to every per-keyword result list in resp. The upstream
+// returns each keyword's results as response["results"], a list of objects with at
+// least a "url" field; lists in any other shape are left untouched.
+func mapSearchResults(resp *SearchResponse, fn func(results []interface{}) []interface{}) {
+	if resp == nil || resp.Queries == nil {
+		return
+	}
+	for _, q := range *resp.Queries {
+		if q.Response == nil {
+			continue
+		}
+		results, ok := (*q.Response)["results"].([]interface{})
+		if !ok {
+			continue
+		}
+		(*q.Response)["results"] = fn(results)
+	}
+}
+
+// searchResultURL returns the "url" field of a single search result, if any
+func searchResultURL(result interface{}) string {
+	item, ok := result.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	u, _ := item["url"].(string)
+	return u
+}
+
+// normalizeDomain lowercases a domain and strips any scheme, path, port or leading "www."
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if strings.Contains(domain, "://") {
+		if u, err := url.Parse(domain); err == nil {
+			domain = u.Hostname()
+		}
+	}
+	domain = strings.SplitN(domain, "/", 2)[0]
+	domain = strings.SplitN(domain, ":", 2)[0]
+	domain = strings.TrimPrefix(domain, "www.")
+	return strings.Trim(domain, ".")
+}
+
+// hostMatchesDomain reports whether host is domain itself or one of its subdomains,
+// so "news.example.com" matches "example.com" but "badexample.com" does not.
+func hostMatchesDomain(host, domain string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// filterSearchResultsByDomain keeps results whose host matches include (when given)
+// and does not match exclude. Results without a parsable URL are dropped only when
+// an include list is set.
+func filterSearchResultsByDomain(resp *SearchResponse, include, exclude []string) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return
+	}
+
+	normalize := func(domains []string) []string {
+		out := make([]string, 0, len(domains))
+		for _, d := range domains {
+			if d = normalizeDomain(d); d != "" {
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+	include, exclude = normalize(include), normalize(exclude)
+
+	matchesAny := func(host string, domains []string) bool {
+		for _, d := range domains {
+			if hostMatchesDomain(host, d) {
+				return true
+			}
+		}
+		return false
+	}
+
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		kept := make([]interface{}, 0, len(results))
+		for _, result := range results {
+			host := ""
+			if u, err := url.Parse(searchResultURL(result)); err == nil {
+				host = u.Hostname()
+			}
+
+			if len(include) > 0 && (host == "" || !matchesAny(host, include)) {
+				continue
+			}
+			if host != "" && matchesAny(host, exclude) {
+				continue
+			}
+			kept = append(kept, result)
+		}
+		return kept
+	})
+}
+
+// normalizeSearchKeywords trims each keyword and collapses inner runs of whitespace,
+// lowercasing too when lowercase is set. Keywords left empty and repeats are dropped.
+func normalizeSearchKeywords(keywords []string, lowercase bool) []string {
+	normalized := make([]string, 0, len(keywords))
+	seen := map[string]bool{}
+	for _, k := range keywords {
+		k = strings.Join(strings.Fields(k), " ")
+		if lowercase {
+			k = strings.ToLower(k)
+		}
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		normalized = append(normalized, k)
+	}
+	return normalized
+}
+
+// blockedSearchTerm returns the first of blocked that appears in any keyword, or ""
+// when none do. Matching ignores case and punctuation and works on whole words, so
+// blocking "ssn" does not refuse a search for "lessons"; a multi-word term matches
+// when its words appear together in that order.
+func blockedSearchTerm(keywords, blocked []string) string {
+	if len(blocked) == 0 {
+		return ""
+	}
+	padded := make([]string, len(keywords))
+	for i, k := range keywords {
+		padded[i] = " " + strings.Join(searchWords(k), " ") + " "
+	}
+	for _, term := range blocked {
+		words := searchWords(term)
+		if len(words) == 0 {
+			continue
+		}
+		needle := " " + strings.Join(words, " ") + " "
+		for _, k := range padded {
+			if strings.Contains(k, needle) {
+				return term
+			}
+		}
+	}
+	return ""
+}
+
+// searchWords splits s into lowercase words of letters and digits
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Safe search levels (SearchRequest.safe_search, SEARCH_SAFE_SEARCH)
+const (
+	safeSearchOff      = "off"
+	safeSearchModerate = "moderate"
+	safeSearchStrict   = "strict"
+)
+
+// unsafeSearchTerms drives the client-side safe search used when the upstream does
+// not support it. Strict also applies every moderate term.
+var unsafeSearchTerms = map[string]*regexp.Regexp{
+	safeSearchModerate: regexp.MustCompile(`(?i)\b(porn\w*|xxx|nsfw|hentai)\b`),
+	safeSearchStrict:   regexp.MustCompile(`(?i)\b(nude\w*|naked|sex|sexy|erotic\w*|fetish\w*|escorts?|onlyfans|gore)\b`),
+}
+
+// validSafeSearch reports whether level is a known safe search level
+func validSafeSearch(level string) bool {
+	return level == safeSearchOff || level == safeSearchModerate || level == safeSearchStrict
+}
+
+// filterUnsafeSearchResults drops results whose title, snippet or URL matches the
+// keyword list for level. It is a basic guardrail, not a content classifier.
+func filterUnsafeSearchResults(resp *SearchResponse, level string) {
+	var patterns []*regexp.Regexp
+	switch level {
+	case safeSearchModerate:
+		patterns = []*regexp.Regexp{unsafeSearchTerms[safeSearchModerate]}
+	case safeSearchStrict:
+		patterns = []*regexp.Regexp{unsafeSearchTerms[safeSearchModerate], unsafeSearchTerms[safeSearchStrict]}
+	default:
+		return
+	}
+
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		kept := results[:0]
+		for _, result := range results {
+			if item, ok := result.(map[string]interface{}); ok && searchResultMatches(item, patterns) {
+				continue
+			}
+			kept = append(kept, result)
+		}
+		return kept
+	})
+}
+
+// searchResultMatches reports whether any text field of item matches one of patterns
+func searchResultMatches(item map[string]interface{}, patterns []*regexp.Regexp) bool {
+	for _, field := range append([]string{"title", "url"}, searchSnippetFields...) {
+		text, ok := item[field].(string)
+		if !ok {
+			continue
+		}
+		for _, re := range patterns {
+			if re.MatchString(text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// searchSnippetFields are the result fields holding snippet text; titles and URLs
+// are never truncated
+var searchSnippetFields = []string{"snippet", "content", "description"}
+
+// truncateSearchSnippets cuts every result's snippet text to maxLen characters,
+// appending marker. maxLen <= 0 leaves results untouched.
+func truncateSearchSnippets(resp *SearchResponse, maxLen int, marker string) {
+	if maxLen <= 0 {
+		return
+	}
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		for _, result := range results {
+			item, ok := result.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, field := range searchSnippetFields {
+				text, ok := item[field].(string)
+				if !ok {
+					continue
+				}
+				item[field] = truncateRunes(text, maxLen, marker)
+			}
+		}
+		return results
+	})
+}
+
+// selectSearchResultFields trims every result object down to the named fields.
+// An empty field list leaves results untouched.
+func selectSearchResultFields(resp *SearchResponse, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	keep := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		keep[strings.TrimSpace(f)] = true
+	}
+
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		for i, result := range results {
+			item, ok := result.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			selected := make(map[string]interface{}, len(keep))
+			for k, v := range item {
+				if keep[k] {
+					selected[k] = v
+				}
+			}
+			results[i] = selected
+		}
+		return results
+	})
+}
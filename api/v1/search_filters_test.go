@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// searchResponseWithURLs builds a one-keyword search response listing urls as results
+func searchResponseWithURLs(urls ...string) *SearchResponse {
+	results := make([]interface{}, len(urls))
+	for i, u := range urls {
+		results[i] = map[string]interface{}{"url": u, "title": u}
+	}
+	keyword := "golang"
+	return &SearchResponse{Queries: &[]SearchQueryResult{{
+		Keyword:  &keyword,
+		Response: &map[string]interface{}{"results": results},
+	}}}
+}
+
+// resultURLs lists the urls left in every keyword's results
+func resultURLs(resp *SearchResponse) []string {
+	var urls []string
+	mapSearchResults(resp, func(results []interface{}) []interface{} {
+		for _, r := range results {
+			urls = append(urls, searchResultURL(r))
+		}
+		return results
+	})
+	return urls
+}
+
+func TestFilterSearchResultsByDomain(t *testing.T) {
+	urls := []string{
+		"https://go.dev/doc",
+		"https://www.wikipedia.org/wiki/Go",
+		"https://en.wikipedia.org/wiki/Go",
+		"https://badwikipedia.org/spam",
+		"https://news.example.com/go",
+		"not a url",
+	}
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []string
+	}{
+		{"no filters", nil, nil, urls},
+		{"include with subdomains", []string{"wikipedia.org"}, nil, []string{urls[1], urls[2]}},
+		{"include normalizes", []string{"https://WWW.Go.dev/", " example.com:443 "}, nil, []string{urls[0], urls[4]}},
+		{"exclude", nil, []string{"wikipedia.org", "example.com"}, []string{urls[0], urls[3], urls[5]}},
+		{"include and exclude", []string{"wikipedia.org"}, []string{"en.wikipedia.org"}, []string{urls[1]}},
+	}
+	for _, tt := range tests {
+		resp := searchResponseWithURLs(urls...)
+		filterSearchResultsByDomain(resp, tt.include, tt.exclude)
+		if got := resultURLs(resp); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: kept %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelectSearchResultFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{"all fields by default", nil, `[{"title":"https://go.dev","url":"https://go.dev"}]`},
+		{"url only", []string{"url"}, `[{"url":"https://go.dev"}]`},
+		{"unknown fields dropped", []string{" title ", "snippet"}, `[{"title":"https://go.dev"}]`},
+	}
+	for _, tt := range tests {
+		resp := searchResponseWithURLs("https://go.dev")
+		selectSearchResultFields(resp, tt.fields)
+		got, err := json.Marshal((*(*resp.Queries)[0].Response)["results"])
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: results = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateSearchSnippets(t *testing.T) {
+	long := "Go is an open source programming language that makes it simple to build secure, scalable systems."
+	tests := []struct {
+		name   string
+		maxLen int
+		want   string
+	}{
+		{"disabled", 0, long},
+		{"longer than the snippet", 500, long},
+		{"cut with the marker", 20, "Go is an open source…[truncated 77 bytes]"},
+	}
+	for _, tt := range tests {
+		resp := searchResponseWithURLs("https://go.dev")
+		item := (*(*resp.Queries)[0].Response)["results"].([]interface{})[0].(map[string]interface{})
+		item["snippet"], item["description"], item["title"] = long, "short", long
+		truncateSearchSnippets(resp, tt.maxLen, "…[truncated {bytes} bytes]")
+		if item["snippet"] != tt.want {
+			t.Errorf("%s: snippet = %q, want %q", tt.name, item["snippet"], tt.want)
+		}
+		if item["title"] != long || item["url"] != "https://go.dev" || item["description"] != "short" {
+			t.Errorf("%s: other fields changed: %v", tt.name, item)
+		}
+	}
+
+	// Lengths count characters, not bytes
+	resp := searchResponseWithURLs("https://example.jp")
+	item := (*(*resp.Queries)[0].Response)["results"].([]interface{})[0].(map[string]interface{})
+	item["content"] = "日本語のテキスト"
+	truncateSearchSnippets(resp, 3, "…")
+	if item["content"] != "日本語…" {
+		t.Errorf("content = %q, want three characters kept", item["content"])
+	}
+}
+
+func TestNormalizeSearchKeywords(t *testing.T) {
+	tests := []struct {
+		name      string
+		keywords  []string
+		lowercase bool
+		want      []string
+	}{
+		{"whitespace", []string{"  go \t generics ", "rust"}, false, []string{"go generics", "rust"}},
+		{"case kept", []string{"Go", "go"}, false, []string{"Go", "go"}},
+		{"lowercased", []string{"Go", " go"}, true, []string{"go"}},
+		{"empty dropped", []string{" ", "", "go"}, false, []string{"go"}},
+		{"repeats dropped", []string{"go  tips", "go tips"}, false, []string{"go tips"}},
+	}
+	for _, tt := range tests {
+		if got := normalizeSearchKeywords(tt.keywords, tt.lowercase); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCallSearchAPIFallback(t *testing.T) {
+	const found = `{"queries": [{"keyword": "golang", "response": {"results": [{"title": "Go"}]}}]}`
+	tests := []struct {
+		name          string
+		primary       func(w http.ResponseWriter)
+		fallback      bool
+		wantErr       bool
+		wantHits      bool
+		fallbackCalls int
+	}{
+		{"primary finds results", func(w http.ResponseWriter) { _, _ = w.Write([]byte(found)) }, true, false, true, 0},
+		{"primary empty, fallback answers", func(w http.ResponseWriter) { _, _ = w.Write([]byte(`{"queries": []}`)) }, true, false, true, 1},
+		{"primary fails, fallback answers", func(w http.ResponseWriter) { http.Error(w, "down", http.StatusBadGateway) }, true, false, true, 1},
+		{"primary fails, no fallback", func(w http.ResponseWriter) { http.Error(w, "down", http.StatusBadGateway) }, false, true, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryCalls, fallbackCalls := 0, 0
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				primaryCalls++
+				tt.primary(w)
+			}))
+			defer primary.Close()
+			fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fallbackCalls++
+				if r.Header.Get("Authorization") != "Bearer fallback-key" {
+					t.Errorf("fallback got Authorization %q", r.Header.Get("Authorization"))
+				}
+				_, _ = w.Write([]byte(found))
+			}))
+			defer fallback.Close()
+
+			s := newTestServer(t, primary.URL, func(cfg *Config) {
+				cfg.SearchFallbackAPIKey = "fallback-key"
+				cfg.UpstreamRetries = 0
+				if tt.fallback {
+					cfg.SearchFallbackURL = fallback.URL
+				}
+			})
+
+			resp, err := s.CallSearchAPI(t.Context(), []string{"golang"}, SearchOptions{MaxResults: 3})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := hasSearchResults(resp); got != tt.wantHits {
+				t.Errorf("results = %v, want %v", got, tt.wantHits)
+			}
+			if primaryCalls != 1 || fallbackCalls != tt.fallbackCalls {
+				t.Errorf("calls: primary %d, fallback %d, want 1, %d", primaryCalls, fallbackCalls, tt.fallbackCalls)
+			}
+		})
+	}
+}
+
+func TestCallSearchAPISnippetLength(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"queries": [{"keyword": "golang", "response": {"results": [{"title": "Go", "snippet": "abcdefghij"}]}}]}`))
+	}))
+	defer upstream.Close()
+	s := newTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.SearchSnippetMaxLength = 8
+	})
+
+	for _, tt := range []struct {
+		requested int
+		want      string
+	}{{0, "abcdefgh…[truncated 2 bytes]"}, {4, "abcd…[truncated 6 bytes]"}} {
+		resp, err := s.CallSearchAPI(t.Context(), []string{"golang"}, SearchOptions{MaxResults: 3, SnippetLength: tt.requested})
+		if err != nil {
+			t.Fatalf("CallSearchAPI: %v", err)
+		}
+		snippet := (*(*resp.Queries)[0].Response)["results"].([]interface{})[0].(map[string]interface{})["snippet"]
+		if snippet != tt.want {
+			t.Errorf("snippet length %d: snippet = %q, want %q", tt.requested, snippet, tt.want)
+		}
+	}
+}
+
+func TestCallSearchAPISafeSearch(t *testing.T) {
+	const results = `{"queries": [{"keyword": "golang", "response": {"results": [
+		{"title": "Go", "url": "https://go.dev"},
+		{"title": "Sexy gophers", "url": "https://example.com/a"},
+		{"title": "Gopher", "url": "https://example.com/b", "snippet": "nsfw pictures"}]}}]}`
+	tests := []struct {
+		name       string
+		configured string
+		upstream   bool
+		requested  string
+		wantSent   string
+		wantTitles []string
+	}{
+		{"default off", safeSearchOff, false, "", "", []string{"Go", "Sexy gophers", "Gopher"}},
+		{"default forwarded", safeSearchModerate, true, "", safeSearchModerate, []string{"Go", "Sexy gophers", "Gopher"}},
+		{"request forwarded", safeSearchOff, true, safeSearchStrict, safeSearchStrict, []string{"Go", "Sexy gophers", "Gopher"}},
+		{"moderate filtered here", safeSearchModerate, false, "", "", []string{"Go", "Sexy gophers"}},
+		{"strict filtered here", safeSearchOff, false, safeSearchStrict, "", []string{"Go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]interface{}
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&sent)
+				_, _ = w.Write([]byte(results))
+			}))
+			defer upstream.Close()
+			s := newTestServer(t, upstream.URL, func(cfg *Config) {
+				cfg.SearchSafeSearch = tt.configured
+				cfg.SearchSafeSearchUpstream = tt.upstream
+			})
+
+			resp, err := s.CallSearchAPI(t.Context(), []string{"golang"}, SearchOptions{SafeSearch: tt.requested})
+			if err != nil {
+				t.Fatalf("CallSearchAPI: %v", err)
+			}
+			if got, _ := sent["safe_search"].(string); got != tt.wantSent {
+				t.Errorf("upstream got safe_search %q, want %q", got, tt.wantSent)
+			}
+			var titles []string
+			mapSearchResults(resp, func(results []interface{}) []interface{} {
+				for _, r := range results {
+					titles = append(titles, r.(map[string]interface{})["title"].(string))
+				}
+				return results
+			})
+			if !slices.Equal(titles, tt.wantTitles) {
+				t.Errorf("titles = %q, want %q", titles, tt.wantTitles)
+			}
+		})
+	}
+}
+
+func TestPostSearchRejectsUnknownSafeSearch(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	rec := httptest.NewRecorder()
+	s.PostSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"keywords": ["go"], "safe_search": "extreme"}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_safe_search") {
+		t.Errorf("status %d, body %s, want a 400 invalid_safe_search", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchToolMaxResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		want      float64
+	}{
+		{"default", `{"keywords": ["go"]}`, 6},
+		{"requested", `{"keywords": ["go"], "max_results": 10}`, 10},
+		{"clamped to the limit", `{"keywords": ["go"], "max_results": 50}`, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]interface{}
+			model := newModelStub(t, func(n int, req map[string]interface{}) string {
+				if n == 0 {
+					return toolCalls([2]string{"search", tt.arguments})
+				}
+				return answer("done")
+			})
+			upstream := http.NewServeMux()
+			upstream.Handle("/chat/completions", model.Config.Handler)
+			upstream.HandleFunc("/search/", func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&sent)
+				_, _ = w.Write([]byte(`{"queries": [{"keyword": "go", "response": {"results": [{"title": "Go"}]}}]}`))
+			})
+			backend := httptest.NewServer(upstream)
+			defer backend.Close()
+			s := newToolTestServer(t, backend.URL, func(cfg *Config) {
+				cfg.SearchMaxResults, cfg.SearchMaxResultsLimit = 6, 20
+			})
+
+			if rec := postChat(t, s, `{"message": "search go"}`); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if sent["max_results"] != tt.want {
+				t.Errorf("upstream got max_results %v, want %v", sent["max_results"], tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockedSearchTerm(t *testing.T) {
+	blocked := []string{"ssn", "secret plans"}
+	tests := []struct {
+		keywords []string
+		want     string
+	}{
+		{[]string{"golang tutorial"}, ""},
+		{[]string{"my SSN number"}, "ssn"},
+		{[]string{"lessons"}, ""},
+		{[]string{"weather", "the Secret  Plans!"}, "secret plans"},
+		{[]string{"plans secret"}, ""},
+	}
+	for _, tt := range tests {
+		if got := blockedSearchTerm(tt.keywords, blocked); got != tt.want {
+			t.Errorf("blockedSearchTerm(%q) = %q, want %q", tt.keywords, got, tt.want)
+		}
+	}
+}
+
+func TestSearchBlockedKeywords(t *testing.T) {
+	var searched [][]string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Keywords []string `json:"keywords"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		searched = append(searched, req.Keywords)
+		_, _ = w.Write([]byte(`{"queries": [{"keyword": "weather", "response": {"results": [{"title": "Sunny"}]}}]}`))
+	}))
+	defer upstream.Close()
+	// The search tool goes through the server's own /search, which refuses it
+	s := newToolTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.SearchBlockedKeywords = []string{"secret plans"}
+	})
+
+	result, success := s.executeTool(t.Context(), "search", `{"keywords": ["weather", "Secret  Plans"]}`)
+	if success || !strings.Contains(result, "do not search for this topic") {
+		t.Errorf("search tool = %s, %v, want a refusal the model can act on", result, success)
+	}
+
+	rec := httptest.NewRecorder()
+	s.PostSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"keywords": ["SECRET plans"]}`)))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "search_blocked") {
+		t.Errorf("POST /search = %d %s, want 403 search_blocked", rec.Code, rec.Body)
+	}
+	if len(searched) != 0 {
+		t.Errorf("upstream searched for %q, want nothing sent for blocked terms", searched)
+	}
+
+	if resp, err := s.CallSearchAPI(t.Context(), []string{"weather"}, SearchOptions{}); err != nil || !hasSearchResults(resp) {
+		t.Errorf("allowed search = %+v, %v, want results", resp, err)
+	}
+}
+
+func TestSearchMaxKeywords(t *testing.T) {
+	var sent []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Keywords []string `json:"keywords"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		sent = req.Keywords
+		_, _ = w.Write([]byte(`{"queries": [{"keyword": "k1", "response": {"results": [{"title": "Go"}]}}]}`))
+	}))
+	defer upstream.Close()
+	s := newToolTestServer(t, upstream.URL, func(cfg *Config) {
+		cfg.SearchMaxKeywords = 3
+	})
+
+	many := []string{" ", ""}
+	for i := range 50 {
+		many = append(many, fmt.Sprintf("k%d", i+1))
+	}
+	want := []string{"k1", "k2", "k3"}
+	if _, err := s.CallSearchAPI(t.Context(), many, SearchOptions{}); err != nil || !slices.Equal(sent, want) {
+		t.Errorf("CallSearchAPI: %v, upstream got %q, want the first %q after dropping blanks", err, sent, want)
+	}
+
+	sent = nil
+	args, _ := json.Marshal(map[string][]string{"keywords": many[2:]})
+	if result, success := s.executeTool(t.Context(), "search", string(args)); !success || !slices.Equal(sent, want) {
+		t.Errorf("search tool = %.100s, %v; upstream got %q, want %q", result, success, sent, want)
+	}
+
+	for _, tool := range s.chatTools() {
+		if toolName(tool) != "search" {
+			continue
+		}
+		params := tool.(map[string]interface{})["function"].(map[string]interface{})["parameters"].(map[string]interface{})
+		keywords := params["properties"].(map[string]interface{})["keywords"].(map[string]interface{})
+		if keywords["maxItems"] != 3 {
+			t.Errorf("search tool keywords schema = %v, want maxItems 3", keywords)
+		}
+	}
+}
+
+// searchProvider plays the upstream search API, recording the keywords of each
+// search it receives and finding one result per keyword
+type searchProvider struct {
+	*httptest.Server
+	mu       sync.Mutex
+	searches [][]string
+}
+
+func newSearchProvider(t *testing.T) *searchProvider {
+	t.Helper()
+	p := &searchProvider{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Keywords []string `json:"keywords"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("search provider: decoding request: %v", err)
+		}
+		p.mu.Lock()
+		p.searches = append(p.searches, req.Keywords)
+		p.mu.Unlock()
+
+		var queries []SearchQueryResult
+		for _, k := range req.Keywords {
+			keyword := k
+			queries = append(queries, SearchQueryResult{
+				Keyword:  &keyword,
+				Response: &map[string]interface{}{"results": []interface{}{map[string]interface{}{"title": k}}},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(SearchResponse{Queries: &queries})
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *searchProvider) received() [][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.searches)
+}
+
+// Regression: the search tool cut the model's raw keywords to SEARCH_MAX_KEYWORDS
+// before normalization, so blank and repeated keywords used up the allowance
+func TestSearchToolKeywordCap(t *testing.T) {
+	provider := newSearchProvider(t)
+	s := newToolTestServer(t, provider.URL, func(cfg *Config) {
+		cfg.SearchMaxKeywords = 2
+	})
+
+	args, _ := json.Marshal(map[string][]string{"keywords": {"go", " go ", "", "rust", "zig"}})
+	if result, success := s.executeTool(t.Context(), "search", string(args)); !success {
+		t.Fatalf("search tool failed: %s", result)
+	}
+
+	want := [][]string{{"go", "rust"}}
+	if got := provider.received(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("provider searched for %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,14 @@
+package api
+
+import _ "embed"
+
+// openAPISpec is openapi.yaml, embedded so the spec served at /api/v1/openapi.yaml
+// always matches the binary and can't go missing at runtime
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// OpenAPISpec returns the OpenAPI spec the server was built with
+func OpenAPISpec() []byte {
+	return openAPISpec
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sseKeepalive is the comment line sent by heartbeats; clients ignore comments
+const sseKeepalive = ": keepalive\n\n"
+
+// sseWriter writes Server-Sent Events and flushes each one immediately. Writes are
+// serialized so a heartbeat goroutine can share the stream with the handler.
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+// newSSEWriter prepares w for an event stream. It returns nil when the underlying
+// ResponseWriter cannot flush, in which case streaming is not possible.
+//
+// The server's WriteTimeout would otherwise cut long-running streams off mid-way,
+// so the write deadline is cleared for this response; the stream still ends when
+// the client disconnects or the handler returns.
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	// The controller sees through wrappers that implement Unwrap (e.g. statusRecorder)
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil
+	}
+
+	return &sseWriter{w: w, rc: rc, lastWrite: time.Now()}
+}
+
+// Event sends one named event; multi-line data is split across data fields
+func (s *sseWriter) Event(event, data string) error {
+	var b strings.Builder
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+func (s *sseWriter) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastWrite = time.Now()
+	if _, err := s.w.Write([]byte(text)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Heartbeat sends a keepalive comment whenever the stream has been idle for
+// interval, so proxies don't drop the connection while tools or the upstream are
+// slow. Heartbeats stay quiet while events are flowing. The returned function stops
+// them and must be called before the handler returns; interval 0 disables them.
+func (s *sseWriter) Heartbeat(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.mu.Lock()
+				idle := time.Since(s.lastWrite) >= interval
+				s.mu.Unlock()
+				if idle && s.write(sseKeepalive) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
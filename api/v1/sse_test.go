@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one event read back from a streamed response
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readSSEEvents splits a Server-Sent Events body into its events
+func readSSEEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var cur sseEvent
+	var data []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if cur.name != "" || data != nil {
+				cur.data = strings.Join(data, "\n")
+				events = append(events, cur)
+			}
+			cur, data = sseEvent{}, nil
+		case strings.HasPrefix(line, "event: "):
+			cur.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return events
+}
+
+func TestSSEWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sse := newSSEWriter(rec)
+	if sse == nil {
+		t.Fatal("newSSEWriter returned nil for a flushable writer")
+	}
+	_ = sse.Event("output", "one line")
+	_ = sse.Event("", "two\nlines")
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := "event: output\ndata: one line\n\ndata: two\ndata: lines\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 2 || events[1].data != "two\nlines" {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+// A stream lasting longer than the server's WriteTimeout still reaches the client
+func TestSSEWriterOutlastsWriteTimeout(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse := newSSEWriter(w)
+		_ = sse.Event("output", "early")
+		time.Sleep(300 * time.Millisecond)
+		_ = sse.Event("done", "late")
+	}))
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the stream: %v", err)
+	}
+	events := readSSEEvents(t, string(body))
+	if len(events) != 2 || events[1].data != "late" {
+		t.Errorf("events = %+v, want the event sent after the write timeout", events)
+	}
+}
+
+func TestSSEWriterHeartbeat(t *testing.T) {
+	// An idle stream gets keepalives
+	rec := httptest.NewRecorder()
+	sse := newSSEWriter(rec)
+	stop := sse.Heartbeat(20 * time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	stop()
+	if n := strings.Count(rec.Body.String(), sseKeepalive); n < 2 {
+		t.Errorf("idle stream got %d keepalives in 150ms, want several at a 20ms interval", n)
+	}
+	if events := readSSEEvents(t, rec.Body.String()); len(events) != 0 {
+		t.Errorf("keepalives were read as events: %+v", events)
+	}
+
+	// A stream with events flowing more often than the interval gets none
+	rec = httptest.NewRecorder()
+	sse = newSSEWriter(rec)
+	stop = sse.Heartbeat(60 * time.Millisecond)
+	for range 15 {
+		_ = sse.Event("delta", "x")
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	if strings.Contains(rec.Body.String(), sseKeepalive) {
+		t.Errorf("busy stream got keepalives: %q", rec.Body)
+	}
+
+	// Interval 0 disables them
+	rec = httptest.NewRecorder()
+	newSSEWriter(rec).Heartbeat(0)()
+	if rec.Body.Len() != 0 {
+		t.Errorf("disabled heartbeat wrote %q", rec.Body)
+	}
+}
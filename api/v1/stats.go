@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Latency histogram layout. Durations are bucketed in milliseconds HdrHistogram
+// style: exact below 8ms, then 8 linear sub-buckets per power of two, which keeps
+// every percentile within ~6% of the true value using a fixed 184 counters.
+const (
+	latencySubBucketBits = 3
+	latencySubBuckets    = 1 << latencySubBucketBits
+	latencyMaxExponent   = 24 // ~4.6 hours; longer durations land in the last bucket
+	latencyBuckets       = latencySubBuckets + (latencyMaxExponent-latencySubBucketBits+1)*latencySubBuckets
+
+	// latencyWindow is how long samples count towards the reported percentiles.
+	// Two windows are kept, so percentiles cover between one and two windows.
+	latencyWindow = 5 * time.Minute
+)
+
+// latencyHistogram is a fixed-size histogram of durations in milliseconds
+type latencyHistogram struct {
+	counts [latencyBuckets]uint64
+	total  uint64
+}
+
+// latencyBucket maps a duration in milliseconds to its bucket index
+func latencyBucket(ms uint64) int {
+	if ms < latencySubBuckets {
+		return int(ms)
+	}
+	exp := bits.Len64(ms) - 1
+	if exp > latencyMaxExponent {
+		return latencyBuckets - 1
+	}
+	mantissa := (ms >> (exp - latencySubBucketBits)) & (latencySubBuckets - 1)
+	return latencySubBuckets + (exp-latencySubBucketBits)*latencySubBuckets + int(mantissa)
+}
+
+// latencyBucketValue returns a representative duration (the bucket midpoint) for index i
+func latencyBucketValue(i int) uint64 {
+	if i < latencySubBuckets {
+		return uint64(i)
+	}
+	exp := (i-latencySubBuckets)/latencySubBuckets + latencySubBucketBits
+	mantissa := uint64((i - latencySubBuckets) % latencySubBuckets)
+	shift := exp - latencySubBucketBits
+	low := (latencySubBuckets + mantissa) << shift
+	return low + (uint64(1)<<shift)/2
+}
+
+func (h *latencyHistogram) add(other *latencyHistogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.total += other.total
+}
+
+// percentile returns the duration in milliseconds at quantile q (0..1)
+func (h *latencyHistogram) percentile(q float64) int {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return int(latencyBucketValue(i))
+		}
+	}
+	return int(latencyBucketValue(latencyBuckets - 1))
+}
+
+// latencyRecorder keeps a rolling latency histogram per upstream name
+type latencyRecorder struct {
+	mu          sync.Mutex
+	current     map[string]*latencyHistogram
+	previous    map[string]*latencyHistogram
+	windowStart time.Time
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{
+		current:     make(map[string]*latencyHistogram),
+		previous:    make(map[string]*latencyHistogram),
+		windowStart: time.Now(),
+	}
+}
+
+// rotateLocked starts a new window when the current one is over; l.mu must be held
+func (l *latencyRecorder) rotateLocked(now time.Time) {
+	switch elapsed := now.Sub(l.windowStart); {
+	case elapsed >= 2*latencyWindow:
+		l.previous = make(map[string]*latencyHistogram)
+		l.current = make(map[string]*latencyHistogram)
+		l.windowStart = now
+	case elapsed >= latencyWindow:
+		l.previous = l.current
+		l.current = make(map[string]*latencyHistogram)
+		l.windowStart = l.windowStart.Add(latencyWindow)
+	}
+}
+
+// record adds one call duration for upstream
+func (l *latencyRecorder) record(upstream string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rotateLocked(time.Now())
+	h, ok := l.current[upstream]
+	if !ok {
+		h = &latencyHistogram{}
+		l.current[upstream] = h
+	}
+	h.counts[latencyBucket(uint64(d.Milliseconds()))]++
+	h.total++
+}
+
+// snapshot returns p50/p90/p99 for every upstream seen in the last one to two windows
+func (l *latencyRecorder) snapshot() map[string]LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rotateLocked(time.Now())
+	names := map[string]bool{}
+	for name := range l.current {
+		names[name] = true
+	}
+	for name := range l.previous {
+		names[name] = true
+	}
+
+	stats := make(map[string]LatencyStats, len(names))
+	for name := range names {
+		var merged latencyHistogram
+		if h, ok := l.previous[name]; ok {
+			merged.add(h)
+		}
+		if h, ok := l.current[name]; ok {
+			merged.add(h)
+		}
+		stats[name] = LatencyStats{
+			Count: int(merged.total),
+			P50Ms: merged.percentile(0.50),
+			P90Ms: merged.percentile(0.90),
+			P99Ms: merged.percentile(0.99),
+		}
+	}
+	return stats
+}
+
+// GetStats implements ServerInterface.
+// (GET /stats)
+func (s *Server) GetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(StatsResponse{UpstreamLatency: s.latency.snapshot()})
+}
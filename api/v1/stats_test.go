@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// histogramOf builds a latency histogram holding count samples of each duration in ms
+func histogramOf(samples map[uint64]int) *latencyHistogram {
+	h := &latencyHistogram{}
+	for ms, count := range samples {
+		h.counts[latencyBucket(ms)] += uint64(count)
+		h.total += uint64(count)
+	}
+	return h
+}
+
+func TestLatencyBucketAccuracy(t *testing.T) {
+	for ms := uint64(0); ms < 1<<20; ms = ms*5/4 + 1 {
+		got := latencyBucketValue(latencyBucket(ms))
+		if ms < latencySubBuckets {
+			if got != ms {
+				t.Errorf("%dms is reported as %dms, want it exact", ms, got)
+			}
+			continue
+		}
+		if diff := float64(got) - float64(ms); diff > float64(ms)/16 || -diff > float64(ms)/16 {
+			t.Errorf("%dms is reported as %dms, more than 1/16 off", ms, got)
+		}
+	}
+	if got := latencyBucket(uint64(24 * time.Hour / time.Millisecond)); got != latencyBuckets-1 {
+		t.Errorf("a day lands in bucket %d, want the last (%d)", got, latencyBuckets-1)
+	}
+}
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	uniform := map[uint64]int{}
+	for ms := uint64(1); ms <= 100; ms++ {
+		uniform[ms] = 1
+	}
+	tests := []struct {
+		name          string
+		samples       map[uint64]int
+		p50, p90, p99 int
+	}{
+		{"empty", nil, 0, 0, 0},
+		{"single sample", map[uint64]int{5: 1}, 5, 5, 5},
+		// 90 and 99 fall in the 88-95 and 96-103 buckets, reported by their midpoints
+		{"1 to 100ms", uniform, 50, 92, 100},
+		// 5000 falls in the 4608-5119 bucket
+		{"long tail", map[uint64]int{10: 80, 200: 15, 5000: 5}, 10, 200, 4864},
+	}
+	for _, tt := range tests {
+		h := histogramOf(tt.samples)
+		p50, p90, p99 := h.percentile(0.50), h.percentile(0.90), h.percentile(0.99)
+		if p50 != tt.p50 || p90 != tt.p90 || p99 != tt.p99 {
+			t.Errorf("%s: p50/p90/p99 = %d/%d/%d, want %d/%d/%d", tt.name, p50, p90, p99, tt.p50, tt.p90, tt.p99)
+		}
+	}
+}
+
+func TestLatencyRecorderWindows(t *testing.T) {
+	l := newLatencyRecorder()
+	for _, ms := range []int{10, 20, 30} {
+		l.record("chat", time.Duration(ms)*time.Millisecond)
+	}
+	l.record("search", 300*time.Millisecond)
+
+	// Samples from the previous window still count
+	l.windowStart = l.windowStart.Add(-latencyWindow)
+	l.record("chat", 40*time.Millisecond)
+	stats := l.snapshot()
+	if got := stats["chat"]; got.Count != 4 || got.P50Ms != 21 || got.P99Ms != 42 {
+		t.Errorf("chat stats = %+v, want 4 samples with p50 in the 20-21ms bucket and p99 in the 40-43ms one", got)
+	}
+	if got := stats["search"]; got.Count != 1 || got.P50Ms != 304 {
+		t.Errorf("search stats = %+v, want 1 sample in the 288-319ms bucket", got)
+	}
+
+	// After two idle windows everything has expired
+	l.windowStart = l.windowStart.Add(-2 * latencyWindow)
+	if stats := l.snapshot(); len(stats) != 0 {
+		t.Errorf("stats after two idle windows = %+v, want none", stats)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	s.latency.record("chat", 120*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	s.GetStats(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var resp StatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	if got := resp.UpstreamLatency["chat"]; got.Count != 1 || got.P50Ms != 124 {
+		t.Errorf("chat latency = %+v, want 1 sample in the 120-127ms bucket", got)
+	}
+}
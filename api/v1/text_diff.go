@@ -0,0 +1,217 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// text_diff tool limits. The line-level LCS table is (lines+1)² cells, so the line
+// cap keeps a single diff to a few megabytes.
+const (
+	maxTextDiffBytes  = 64 * 1024
+	maxTextDiffLines  = 1000
+	textDiffContext   = 3
+	textDiffNoNewline = "\\ No newline at end of file"
+)
+
+// textDiffResult is returned to the model by the text_diff tool
+type textDiffResult struct {
+	Identical bool   `json:"identical"`
+	Added     int    `json:"added"`
+	Removed   int    `json:"removed"`
+	Diff      string `json:"diff"`
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed from a, '+' added from b
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// TextDiff compares a and b line by line and returns a unified diff with three
+// lines of context, computed in-process
+func TextDiff(a, b string) (*textDiffResult, error) {
+	for name, text := range map[string]string{"a": a, "b": b} {
+		if len(text) > maxTextDiffBytes {
+			return nil, fmt.Errorf("%s is too large to diff (%d bytes, limit %d)", name, len(text), maxTextDiffBytes)
+		}
+	}
+	aLines, bLines := splitDiffLines(a), splitDiffLines(b)
+	if len(aLines) > maxTextDiffLines || len(bLines) > maxTextDiffLines {
+		return nil, fmt.Errorf("inputs are too long to diff (limit %d lines each)", maxTextDiffLines)
+	}
+
+	ops := diffLines(aLines, bLines)
+	result := &textDiffResult{Identical: a == b}
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			result.Added++
+		case '-':
+			result.Removed++
+		}
+	}
+	if !result.Identical && result.Added == 0 && result.Removed == 0 {
+		// Only the final newline differs: show the last line as replaced
+		last := ops[len(ops)-1]
+		ops = append(ops[:len(ops)-1], diffOp{'-', last.line}, diffOp{'+', last.line})
+		result.Added, result.Removed = 1, 1
+	}
+	if !result.Identical {
+		result.Diff = unifiedDiff(ops, strings.HasSuffix(a, "\n") || a == "", strings.HasSuffix(b, "\n") || b == "")
+	}
+	return result, nil
+}
+
+// splitDiffLines splits text into lines, without a trailing empty line for a final newline
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns a minimal edit script turning a into b, from a longest common
+// subsequence table over the lines that differ once shared ends are trimmed
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i*(m+1)+j] is the LCS length of midA[i:] and midB[j:]
+	n, m := len(midA), len(midB)
+	lcs := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+			} else {
+				lcs[i*(m+1)+j] = max(lcs[(i+1)*(m+1)+j], lcs[i*(m+1)+j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i*(m+1)+j+1] >= lcs[(i+1)*(m+1)+j]):
+			ops = append(ops, diffOp{'+', midB[j]})
+			j++
+		default:
+			ops = append(ops, diffOp{'-', midA[i]})
+			i++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return reorderDiffOps(ops)
+}
+
+// reorderDiffOps puts removals before additions within each run of changes, as
+// diff tools conventionally print them
+func reorderDiffOps(ops []diffOp) []diffOp {
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		end := start
+		for end < len(ops) && ops[end].kind != ' ' {
+			end++
+		}
+		var removed, added []diffOp
+		for _, op := range ops[start:end] {
+			if op.kind == '-' {
+				removed = append(removed, op)
+			} else {
+				added = append(added, op)
+			}
+		}
+		copy(ops[start:], append(removed, added...))
+		start = end
+	}
+	return ops
+}
+
+// unifiedDiff renders an edit script as unified diff hunks. aNewline and bNewline
+// report whether each input ended with a newline, so a missing one can be flagged.
+func unifiedDiff(ops []diffOp, aNewline, bNewline bool) string {
+	// aPos[i] and bPos[i] count the lines of a and b before ops[i]
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	lastA, lastB := -1, -1
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.kind != '+' {
+			aPos[i+1]++
+			lastA = i
+		}
+		if op.kind != '-' {
+			bPos[i+1]++
+			lastB = i
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("--- a\n+++ b\n")
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk while the next change is within two contexts' reach
+		start := max(0, i-textDiffContext)
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*textDiffContext {
+				end = next
+				continue
+			}
+			break
+		}
+		end = min(len(ops), end+textDiffContext)
+
+		aStart, aCount := aPos[start], aPos[end]-aPos[start]
+		bStart, bCount := bPos[start], bPos[end]-bPos[start]
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for k := start; k < end; k++ {
+			out.WriteByte(ops[k].kind)
+			out.WriteString(ops[k].line)
+			out.WriteByte('\n')
+			if (k == lastA && ops[k].kind != '+' && !aNewline) || (k == lastB && ops[k].kind != '-' && !bNewline) {
+				out.WriteString(textDiffNoNewline + "\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
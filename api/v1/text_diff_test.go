@@ -0,0 +1,58 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextDiff(t *testing.T) {
+	tests := []struct {
+		name           string
+		a, b           string
+		added, removed int
+		want           string
+	}{
+		{"identical", "same\n", "same\n", 0, 0, ""},
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n", 1, 1, "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"appended line", "one\ntwo\n", "one\ntwo\nthree\n", 1, 0, "--- a\n+++ b\n@@ -1,2 +1,3 @@\n one\n two\n+three\n"},
+		{"from empty", "", "new\n", 1, 0, "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+new\n"},
+		{"missing final newline", "x\n", "x", 1, 1, "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-x\n+x\n\\ No newline at end of file\n"},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n", "1\n2\nTWO\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n14\n15\n", 1, 1,
+			"--- a\n+++ b\n@@ -1,5 +1,6 @@\n 1\n 2\n+TWO\n 3\n 4\n 5\n@@ -10,6 +11,5 @@\n 10\n 11\n 12\n-13\n 14\n 15\n"},
+		{"nearby changes share a hunk", "1\n2\n3\n4\n5\n6\n7\n8\n", "1\nX\n3\n4\n5\n6\n7\nY\n", 2, 2,
+			"--- a\n+++ b\n@@ -1,8 +1,8 @@\n 1\n-2\n+X\n 3\n 4\n 5\n 6\n 7\n-8\n+Y\n"},
+	}
+	for _, tt := range tests {
+		got, err := TextDiff(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Identical != (tt.a == tt.b) || got.Added != tt.added || got.Removed != tt.removed || got.Diff != tt.want {
+			t.Errorf("%s: got %+v\n%s\nwant +%d -%d\n%s", tt.name, got, got.Diff, tt.added, tt.removed, tt.want)
+		}
+	}
+}
+
+func TestTextDiffLimits(t *testing.T) {
+	if _, err := TextDiff(strings.Repeat("x", maxTextDiffBytes+1), ""); err == nil || !strings.Contains(err.Error(), "a is too large") {
+		t.Errorf("oversized input: error = %v", err)
+	}
+	if _, err := TextDiff("", strings.Repeat("\n", maxTextDiffLines+1)); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("too many lines: error = %v", err)
+	}
+}
+
+func TestCallTextDiffTool(t *testing.T) {
+	if got, err := callTextDiffTool(`{"a": "x\n", "b": "y\n"}`); err != nil || got.Added != 1 || got.Removed != 1 {
+		t.Errorf("callTextDiffTool = %+v, %v", got, err)
+	}
+	if got, err := callTextDiffTool(`{"a": "", "b": ""}`); err != nil || !got.Identical {
+		t.Errorf("empty inputs = %+v, %v, want identical", got, err)
+	}
+	for _, args := range []string{`{"a": "x"}`, `not json`} {
+		if _, err := callTextDiffTool(args); err == nil {
+			t.Errorf("callTextDiffTool(%s) should fail", args)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import "unicode"
+
+// Heuristic tokenizer constants. Tokenizers for current models average roughly
+// four characters of English text per token, while CJK characters usually take
+// at least one token each, and every message carries a few tokens of framing.
+const (
+	charsPerToken     = 4
+	tokensPerMessage  = 4
+	tokensPerResponse = 3
+)
+
+// estimateTokens approximates how many tokens text occupies without loading a
+// real tokenizer: ASCII-range runs count at charsPerToken characters per token and
+// other letters (CJK, etc.) count as one token each.
+func estimateTokens(text string) int {
+	ascii, wide := 0, 0
+	for _, r := range text {
+		if r <= unicode.MaxASCII || !unicode.IsLetter(r) {
+			ascii++
+		} else {
+			wide++
+		}
+	}
+	return (ascii+charsPerToken-1)/charsPerToken + wide
+}
+
+// estimateChatTokens approximates the prompt size of history plus a new user message
+func estimateChatTokens(history []ChatMessage, message string) int {
+	total := tokensPerResponse
+	for _, m := range history {
+		total += tokensPerMessage + estimateTokens(m.Role) + estimateTokens(m.Content)
+	}
+	return total + tokensPerMessage + estimateTokens("user") + estimateTokens(message)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"hello world", 3},
+		{"你好", 2},
+		{"héllo", 2},
+		{"日本 go", 3},
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.text); got != tt.want {
+			t.Errorf("estimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateChatTokens(t *testing.T) {
+	if got := estimateChatTokens(nil, "hello world"); got != 11 {
+		t.Errorf("no history: got %d, want 11", got)
+	}
+	history := []ChatMessage{{Role: "user", Content: "abcd"}, {Role: "assistant", Content: "你好"}}
+	if got := estimateChatTokens(history, "hello world"); got != 26 {
+		t.Errorf("with history: got %d, want 26", got)
+	}
+}
+
+func TestPostChatEstimate(t *testing.T) {
+	tests := []struct {
+		limit   int
+		exceeds bool
+	}{
+		{11, false},
+		{10, true},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.MaxContextTokens = tt.limit
+		})
+		rec := httptest.NewRecorder()
+		s.PostChatEstimate(rec, httptest.NewRequest(http.MethodPost, "/chat/estimate", strings.NewReader(`{"message": "hello world"}`)))
+		var resp ChatEstimateResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+		}
+		if resp.Tokens != 11 || resp.Limit != tt.limit || resp.Exceeds != tt.exceeds {
+			t.Errorf("limit %d: got %+v", tt.limit, resp)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// normalizeToolArgs reads a tool call's arguments object leniently. Models now and
+// then repeat a key, sometimes with a null or empty second copy, which a plain
+// decode resolves to the last copy and so loses the value. Here each repeated key
+// keeps its last non-empty value instead. Unknown keys pass through untouched;
+// each tool's decoder ignores them. duplicates lists the repeated keys.
+func normalizeToolArgs(arguments string) (normalized string, duplicates []string, err error) {
+	dec := json.NewDecoder(strings.NewReader(arguments))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return arguments, nil, fmt.Errorf("arguments must be a JSON object")
+	}
+
+	var keys []string
+	values := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return arguments, nil, err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return arguments, nil, err
+		}
+
+		prev, seen := values[key]
+		if !seen {
+			keys = append(keys, key)
+			values[key] = value
+			continue
+		}
+		if !slices.Contains(duplicates, key) {
+			duplicates = append(duplicates, key)
+		}
+		if !emptyJSONValue(value) || emptyJSONValue(prev) {
+			values[key] = value
+		}
+	}
+	if len(duplicates) == 0 {
+		return arguments, nil, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(values[key])
+	}
+	buf.WriteByte('}')
+	return buf.String(), duplicates, nil
+}
+
+// emptyJSONValue reports whether raw is null or an empty string, array or object
+func emptyJSONValue(raw json.RawMessage) bool {
+	switch string(bytes.TrimSpace(raw)) {
+	case "null", `""`, "[]", "{}":
+		return true
+	}
+	return false
+}
+
+// unknownToolArgs returns the top-level keys of arguments that the named tool's
+// schema doesn't declare, sorted. It returns nil for tools without a schema.
+func (s *Server) unknownToolArgs(name, arguments string) []string {
+	var properties map[string]interface{}
+	for _, tool := range s.chatTools() {
+		if toolName(tool) != name {
+			continue
+		}
+		function, _ := tool.(map[string]interface{})["function"].(map[string]interface{})
+		parameters, _ := function["parameters"].(map[string]interface{})
+		properties, _ = parameters["properties"].(map[string]interface{})
+	}
+	if properties == nil {
+		return nil
+	}
+
+	var args map[string]json.RawMessage
+	_ = json.Unmarshal([]byte(arguments), &args)
+	var unknown []string
+	for key := range args {
+		if _, ok := properties[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// tolerateToolArgs normalizes a tool call's arguments before it runs, noting
+// repeated and unexpected keys in the debug log rather than failing the call
+func (s *Server) tolerateToolArgs(name, arguments string) string {
+	normalized, duplicates, err := normalizeToolArgs(arguments)
+	if err != nil {
+		return arguments // the tool's own decoder reports malformed arguments
+	}
+	if len(duplicates) > 0 {
+		s.debugf("%s[/chat] %s arguments repeat %s; keeping the last non-empty value%s", colorYellow, name, strings.Join(duplicates, ", "), colorReset)
+	}
+	if s.cfg.DebugLogging {
+		if unknown := s.unknownToolArgs(name, normalized); len(unknown) > 0 {
+			s.debugf("%s[/chat] %s arguments have unexpected fields %s; ignoring them%s", colorYellow, name, strings.Join(unknown, ", "), colorReset)
+		}
+	}
+	return normalized
+}
+
+// debugf logs like log.Printf when DEBUG_LOGGING is on
+func (s *Server) debugf(format string, args ...interface{}) {
+	if s.cfg.DebugLogging {
+		log.Printf(format, args...)
+	}
+}
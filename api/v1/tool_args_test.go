@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeToolArgs(t *testing.T) {
+	tests := []struct {
+		arguments      string
+		want           string
+		wantDuplicates []string
+	}{
+		{`{"command": "ls", "reason": "look"}`, `{"command": "ls", "reason": "look"}`, nil},
+		{`{"command": "ls /tmp", "command": null}`, `{"command":"ls /tmp"}`, []string{"command"}},
+		{`{"command": "", "command": "ls /tmp"}`, `{"command":"ls /tmp"}`, []string{"command"}},
+		{`{"keywords": ["go"], "max": 3, "keywords": []}`, `{"keywords":["go"],"max":3}`, []string{"keywords"}},
+		{`{"value": 1, "value": 2}`, `{"value":2}`, []string{"value"}},
+		{`{"a": null, "a": ""}`, `{"a":""}`, []string{"a"}},
+	}
+	for _, tt := range tests {
+		got, duplicates, err := normalizeToolArgs(tt.arguments)
+		if err != nil || got != tt.want || !slices.Equal(duplicates, tt.wantDuplicates) {
+			t.Errorf("normalizeToolArgs(%s) = %s, %q, %v, want %s, %q", tt.arguments, got, duplicates, err, tt.want, tt.wantDuplicates)
+		}
+	}
+
+	for _, bad := range []string{`["ls"]`, `"ls"`, `{"command": `, ``} {
+		if got, _, err := normalizeToolArgs(bad); err == nil || got != bad {
+			t.Errorf("normalizeToolArgs(%q) = %q, %v, want an error and the arguments unchanged", bad, got, err)
+		}
+	}
+}
+
+func TestTolerateToolArgs(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	for _, debug := range []bool{false, true} {
+		logs.Reset()
+		s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+			cfg.DebugLogging = debug
+		})
+		got := s.tolerateToolArgs("run_command", `{"command": "ls", "reason": "look", "command": null}`)
+		if got != `{"command":"ls","reason":"look"}` {
+			t.Errorf("debug %v: arguments = %s", debug, got)
+		}
+		logged := logs.String()
+		for _, want := range []string{"repeat command", "unexpected fields reason"} {
+			if strings.Contains(logged, want) != debug {
+				t.Errorf("debug %v: log %q contains %q = %v", debug, logged, want, !debug)
+			}
+		}
+	}
+	if unknown := newTestServer(t, "http://upstream.invalid", nil).unknownToolArgs("no_such_tool", `{"x": 1}`); unknown != nil {
+		t.Errorf("tool without a schema: unknown = %q, want nil", unknown)
+	}
+}
+
+func TestRunCommandToolLenientArgs(t *testing.T) {
+	dir := tempDirWith(t, "a.txt")
+	s := newToolTestServer(t, "http://upstream.invalid", nil)
+
+	tests := []struct {
+		arguments string
+		want      string
+	}{
+		{`{"command": "ls -1 ` + dir + `", "reason": "list it", "timeout": 5}`, "a.txt"},
+		{`{"command": "ls -1 ` + dir + `", "command": ""}`, "a.txt"},
+		{`{"reason": "forgot the command"}`, "command is required"},
+		{`{"command": "  "}`, "command is required"},
+	}
+	for _, tt := range tests {
+		result, _ := s.executeTool(t.Context(), "run_command", tt.arguments)
+		if !strings.Contains(result, tt.want) || !json.Valid([]byte(result)) {
+			t.Errorf("run_command %s = %s, want %q", tt.arguments, result, tt.want)
+		}
+	}
+}
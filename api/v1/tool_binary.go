@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"unicode/utf8"
+)
+
+// maxToolBinaryBytes caps the decoded size of binary data carried in one tool result
+const maxToolBinaryBytes = 256 * 1024
+
+// toolBinary is the convention for tool results that carry binary data (screenshots,
+// downloads, ...): the result is a JSON object with a "binary" field holding the
+// MIME type and the bytes, base64-encoded, for example
+//
+//	{"url": "...", "binary": {"mime_type": "image/png", "data_base64": "iVBORw0KGgo..."}}
+//
+// Other fields of the result are passed through untouched.
+type toolBinary struct {
+	MimeType   string `json:"mime_type"`
+	DataBase64 string `json:"data_base64,omitempty"`
+	Size       int    `json:"size"`
+	Omitted    string `json:"omitted,omitempty"`
+}
+
+// binaryToolResult builds a tool result carrying data under the binary convention
+func binaryToolResult(mimeType string, data []byte) string {
+	out, _ := json.Marshal(map[string]interface{}{"binary": newToolBinary(mimeType, data)})
+	return string(out)
+}
+
+// newToolBinary encodes data, dropping it (with a note) when it exceeds the size cap
+func newToolBinary(mimeType string, data []byte) toolBinary {
+	b := toolBinary{MimeType: mimeType, Size: len(data)}
+	if len(data) > maxToolBinaryBytes {
+		b.Omitted = fmt.Sprintf("binary data of %d bytes exceeds the %d byte limit", len(data), maxToolBinaryBytes)
+		return b
+	}
+	b.DataBase64 = base64.StdEncoding.EncodeToString(data)
+	return b
+}
+
+// normalizeToolResult makes a tool result safe to send as a tool message. Results
+// that are not valid UTF-8 are treated as raw bytes and wrapped under the binary
+// convention; results with a "binary" field have it validated and size-capped.
+// Anything else is returned unchanged.
+func normalizeToolResult(name, content string) string {
+	if !utf8.ValidString(content) {
+		log.Printf("%s[/chat] Tool %s returned raw binary (%d bytes), wrapping as base64%s", colorYellow, name, len(content), colorReset)
+		return binaryToolResult("application/octet-stream", []byte(content))
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &fields); err != nil {
+		return content
+	}
+	raw, ok := fields["binary"]
+	if !ok {
+		return content
+	}
+
+	var b toolBinary
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return content
+	}
+	if b.MimeType == "" {
+		b.MimeType = "application/octet-stream"
+	}
+	data, err := base64.StdEncoding.DecodeString(b.DataBase64)
+	if err != nil {
+		log.Printf("%s[/chat] Tool %s returned invalid base64 data: %v%s", colorRed, name, err, colorReset)
+		b = toolBinary{MimeType: b.MimeType, Omitted: "invalid base64 data"}
+	} else if b.Omitted == "" {
+		b = newToolBinary(b.MimeType, data)
+	}
+
+	fields["binary"], _ = json.Marshal(b)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return content
+	}
+	return string(out)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// decodeBinary returns the binary field of a tool result
+func decodeBinary(t *testing.T, result string) toolBinary {
+	t.Helper()
+	var fields struct {
+		Binary toolBinary `json:"binary"`
+	}
+	if err := json.Unmarshal([]byte(result), &fields); err != nil {
+		t.Fatalf("tool result %q is not valid JSON: %v", result, err)
+	}
+	return fields.Binary
+}
+
+func TestNormalizeToolResult(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	encoded := base64.StdEncoding.EncodeToString(png)
+	big := base64.StdEncoding.EncodeToString(make([]byte, maxToolBinaryBytes+1))
+
+	for _, plain := range []string{`{"results": ["a"]}`, "plain text", `{"binary": "not an object"}`} {
+		if got := normalizeToolResult("tool", plain); got != plain {
+			t.Errorf("normalizeToolResult(%q) = %q, want it unchanged", plain, got)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		wantMime    string
+		wantData    []byte
+		wantSize    int
+		wantOmitted string
+	}{
+		{"base64 result", `{"url": "u", "binary": {"mime_type": "image/png", "data_base64": "` + encoded + `"}}`, "image/png", png, len(png), ""},
+		{"raw bytes", string(png), "application/octet-stream", png, len(png), ""},
+		{"missing MIME type", `{"binary": {"data_base64": "` + encoded + `"}}`, "application/octet-stream", png, len(png), ""},
+		{"too large", `{"binary": {"mime_type": "image/png", "data_base64": "` + big + `"}}`, "image/png", nil, maxToolBinaryBytes + 1, "exceeds"},
+		{"invalid base64", `{"binary": {"mime_type": "image/png", "data_base64": "%%%"}}`, "image/png", nil, 0, "invalid base64"},
+	}
+	for _, tt := range tests {
+		got := normalizeToolResult("tool", tt.content)
+		b := decodeBinary(t, got)
+		data, _ := base64.StdEncoding.DecodeString(b.DataBase64)
+		if b.MimeType != tt.wantMime || !bytes.Equal(data, tt.wantData) || b.Size != tt.wantSize || !strings.Contains(b.Omitted, tt.wantOmitted) {
+			t.Errorf("%s: binary = %+v, want %s with %d bytes, omitted %q", tt.name, b, tt.wantMime, tt.wantSize, tt.wantOmitted)
+		}
+		if tt.wantOmitted != "" && b.DataBase64 != "" {
+			t.Errorf("%s: omitted data should not be sent", tt.name)
+		}
+	}
+}
+
+func TestChatBinaryToolResult(t *testing.T) {
+	screenshot := []byte("\x89PNG\r\n\x1a\nfake image")
+	restore := SetToolExecutor("search", func(arguments string) (string, bool) {
+		return binaryToolResult("image/png", screenshot), true
+	})
+	defer restore()
+
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls([2]string{"search", `{"keywords": ["screenshot"]}`})
+		}
+		return answer("got it")
+	})
+	s := newTestServer(t, model.URL, nil)
+	if rec := postChat(t, s, `{"message": "take a screenshot"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	messages := model.received()[1]["messages"].([]interface{})
+	tool := messages[len(messages)-1].(map[string]interface{})
+	b := decodeBinary(t, tool["content"].(string))
+	data, err := base64.StdEncoding.DecodeString(b.DataBase64)
+	if tool["role"] != "tool" || b.MimeType != "image/png" || err != nil || !bytes.Equal(data, screenshot) {
+		t.Errorf("tool message = %v, want the screenshot as base64 PNG", tool)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import "sync"
+
+// ToolExecutor runs a tool with its JSON-encoded arguments and returns the content
+// sent back to the model as the tool message, plus whether the call succeeded.
+type ToolExecutor func(arguments string) (result string, success bool)
+
+var (
+	toolExecutorsMu sync.RWMutex
+	toolExecutors   = map[string]ToolExecutor{}
+)
+
+// SetToolExecutor replaces the executor for the named tool and returns a function
+// that restores the previous behaviour. It is a test hook: it lets a test drive the
+// chat loop against a stub model with deterministic tool outputs, for example
+//
+//	restore := api.SetToolExecutor("search", func(args string) (string, bool) {
+//		return `{"combined_answer":"sunny"}`, true
+//	})
+//	defer restore()
+//
+// Passing a nil executor removes any override for the tool.
+func SetToolExecutor(name string, fn ToolExecutor) (restore func()) {
+	toolExecutorsMu.Lock()
+	defer toolExecutorsMu.Unlock()
+
+	prev, hadPrev := toolExecutors[name]
+	if fn == nil {
+		delete(toolExecutors, name)
+	} else {
+		toolExecutors[name] = fn
+	}
+
+	return func() {
+		toolExecutorsMu.Lock()
+		defer toolExecutorsMu.Unlock()
+		if hadPrev {
+			toolExecutors[name] = prev
+		} else {
+			delete(toolExecutors, name)
+		}
+	}
+}
+
+// lookupToolExecutor returns the override registered for name, if any
+func lookupToolExecutor(name string) ToolExecutor {
+	toolExecutorsMu.RLock()
+	defer toolExecutorsMu.RUnlock()
+	return toolExecutors[name]
+}
@@ -0,0 +1,431 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// chatToolNames lists every tool chatTools can offer
+var chatToolNames = []string{
+	"search", "read_page", "read_pages", "run_command", "convert_units", "extract_from_page",
+	"read_feed", "translate", "text_diff", "encode_decode", "kv_set", "kv_get",
+}
+
+// chatTools returns the tool definitions offered to the model on every chat
+func (s *Server) chatTools() []interface{} {
+	searchTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "search",
+			"description": "Search the web for real-time information like weather, news, current events",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"keywords": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]string{"type": "string"},
+						"maxItems":    s.cfg.SearchMaxKeywords,
+						"description": fmt.Sprintf("Search keywords, at most %d", s.cfg.SearchMaxKeywords),
+					},
+					"include_domains": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]string{"type": "string"},
+						"description": "Only return results from these domains (subdomains included), e.g. ['wikipedia.org']",
+					},
+					"exclude_domains": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]string{"type": "string"},
+						"description": "Never return results from these domains (subdomains included)",
+					},
+					"safe_search": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"off", "moderate", "strict"},
+						"description": "Filter explicit content from results (defaults to the server setting)",
+					},
+					"language": map[string]interface{}{
+						"type":        "string",
+						"description": "Only return results in this language, as a code such as 'en' or 'fr'; use it when the user wants answers from sources in one language",
+					},
+					"max_results": map[string]interface{}{
+						"type":    "integer",
+						"minimum": 1,
+						"maximum": s.cfg.SearchMaxResultsLimit,
+						"description": fmt.Sprintf("Results per keyword, up to %d (default %d); ask for more on broad queries",
+							s.cfg.SearchMaxResultsLimit, s.cfg.SearchMaxResults),
+					},
+					"broaden": map[string]interface{}{
+						"type":        "boolean",
+						"description": fmt.Sprintf("If fewer than %d results come back, retry once with broader keywords; use it for very specific or niche queries", s.cfg.SearchBroadenMinResults),
+					},
+				},
+				"required": []string{"keywords"},
+			},
+		},
+	}
+
+	readPageTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "read_page",
+			"description": "Fetch a webpage URL and extract the main text content. Use this when you need to read the content of a specific webpage. Cite final_url, the address actually read after redirects.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "The URL of the webpage to read",
+					},
+					"format": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"text", "markdown"},
+						"description": "text (default) for plain text, or markdown to keep headings, lists, links and code blocks",
+					},
+				},
+				"required": []string{"url"},
+			},
+		},
+	}
+
+	readPagesTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "read_pages",
+			"description": "Fetch several webpage URLs concurrently and extract the main text content of each. Use this instead of read_page when you need to read more than one page.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"urls": map[string]interface{}{
+						"type":        "array",
+						"items":       map[string]string{"type": "string"},
+						"maxItems":    s.cfg.PageReaderMaxURLs,
+						"description": fmt.Sprintf("The URLs of the webpages to read, at most %d", s.cfg.PageReaderMaxURLs),
+					},
+				},
+				"required": []string{"urls"},
+			},
+		},
+	}
+
+	runCommandDescription := "Run a shell command on the system. Use this to list files or check directories. Only these commands and arguments are allowed; anything else is rejected: " +
+		describeCommandPolicies() + "."
+	if len(s.cfg.DeniedCommandPaths) > 0 {
+		runCommandDescription += " Some directories are off-limits, including recursive listings that would reach them."
+	}
+	runCommandTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "run_command",
+			"description": runCommandDescription,
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"command": map[string]interface{}{
+						"type":        "string",
+						"description": "The shell command to execute (e.g., 'ls -la', 'ls /tmp')",
+					},
+				},
+				"required": []string{"command"},
+			},
+		},
+	}
+
+	convertUnitsTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "convert_units",
+			"description": "Convert a value between units of length, mass, temperature, or time (e.g. miles to km, lb to kg, F to C). Returns the converted value and the formula used.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"value": map[string]interface{}{
+						"type":        "number",
+						"description": "The numeric value to convert",
+					},
+					"from_unit": map[string]interface{}{
+						"type":        "string",
+						"description": "The unit to convert from (e.g. 'mi', 'kg', 'F', 'hours')",
+					},
+					"to_unit": map[string]interface{}{
+						"type":        "string",
+						"description": "The unit to convert to (e.g. 'km', 'lb', 'C', 'minutes')",
+					},
+				},
+				"required": []string{"value", "from_unit", "to_unit"},
+			},
+		},
+	}
+
+	extractFromPageTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "extract_from_page",
+			"description": "Fetch a webpage and return structured data instead of prose: its links (text + href), images (src + alt), tables (rows of cells), or metadata (title, description, OpenGraph tags, canonical URL).",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "The URL of the webpage",
+					},
+					"extract": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"links", "images", "tables", "metadata"},
+						"description": "What to extract from the page",
+					},
+				},
+				"required": []string{"url", "extract"},
+			},
+		},
+	}
+
+	readFeedTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "read_feed",
+			"description": "Fetch an RSS or Atom feed and return its latest entries (title, link, published date, summary). Prefer this over read_page for news sites and blogs that publish a feed.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "The URL of the RSS or Atom feed",
+					},
+					"max_entries": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("Maximum number of entries to return (default and limit: %d)", s.cfg.FeedMaxEntries),
+					},
+				},
+				"required": []string{"url"},
+			},
+		},
+	}
+
+	translateTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "translate",
+			"description": "Translate text into another language and return only the translation. Use this when the user asks for a translation of a specific passage.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"text": map[string]interface{}{
+						"type":        "string",
+						"description": "The text to translate",
+					},
+					"target_language": map[string]interface{}{
+						"type":        "string",
+						"description": "The language to translate into (e.g. 'French', 'Japanese', 'pt-BR')",
+					},
+				},
+				"required": []string{"text", "target_language"},
+			},
+		},
+	}
+
+	textDiffTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "text_diff",
+			"description": fmt.Sprintf("Compare two texts line by line and return a unified diff plus added/removed line counts. Use this instead of describing differences from memory. Each text is limited to %d KB and %d lines.", maxTextDiffBytes/1024, maxTextDiffLines),
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"a": map[string]interface{}{
+						"type":        "string",
+						"description": "The original text",
+					},
+					"b": map[string]interface{}{
+						"type":        "string",
+						"description": "The changed text",
+					},
+				},
+				"required": []string{"a", "b"},
+			},
+		},
+	}
+
+	encodeDecodeTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "encode_decode",
+			"description": fmt.Sprintf("Encode text to, or decode it from, base64, hex or URL percent-encoding. Use this instead of converting by hand, which is error-prone. Decoded data that isn't UTF-8 text comes back hex-encoded in result_hex. Input is limited to %d KB.", maxEncodeDecodeInput/1024),
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"operation": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"encode", "decode"},
+						"description": "Whether to encode the input or decode it",
+					},
+					"format": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{"base64", "hex", "url"},
+						"description": "The encoding to convert to or from",
+					},
+					"input": map[string]interface{}{
+						"type":        "string",
+						"description": "The text to encode, or the encoded data to decode",
+					},
+				},
+				"required": []string{"operation", "format", "input"},
+			},
+		},
+	}
+
+	kvSetTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "kv_set",
+			"description": fmt.Sprintf("Store a value under a key for later in this conversation, e.g. an intermediate result you will need again. Setting an existing key overwrites it. Up to %d keys, %d bytes per value; keys expire with the conversation.", s.cfg.KVMaxKeys, s.cfg.KVMaxValueBytes),
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"key": map[string]interface{}{
+						"type":        "string",
+						"description": fmt.Sprintf("The key to store under (up to %d characters)", maxKVKeyLength),
+					},
+					"value": map[string]interface{}{
+						"type":        "string",
+						"description": "The value to store",
+					},
+				},
+				"required": []string{"key", "value"},
+			},
+		},
+	}
+
+	kvGetTool := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "kv_get",
+			"description": "Read a value stored earlier in this conversation with kv_set. Returns found: false when the key was never set or has expired.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"key": map[string]interface{}{
+						"type":        "string",
+						"description": "The key to read",
+					},
+				},
+				"required": []string{"key"},
+			},
+		},
+	}
+
+	var tools []interface{}
+	for _, tool := range []interface{}{searchTool, readPageTool, readPagesTool, runCommandTool, convertUnitsTool, extractFromPageTool, readFeedTool, translateTool, textDiffTool, encodeDecodeTool, kvSetTool, kvGetTool} {
+		if s.toolEnabled(toolName(tool)) {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// toolEnabled reports whether the operator has left the named tool switched on
+func (s *Server) toolEnabled(name string) bool {
+	switch name {
+	case "search":
+		return s.cfg.EnableSearch
+	case "read_page", "read_pages", "extract_from_page", "read_feed":
+		return s.cfg.EnableReadPage
+	case "run_command":
+		return s.cfg.EnableRunCommand
+	default:
+		return true
+	}
+}
+
+// toolTimeout returns how long one call of the named tool may run
+func (s *Server) toolTimeout(name string) time.Duration {
+	if d, ok := s.cfg.ToolTimeouts[name]; ok {
+		return d
+	}
+	return s.cfg.ToolTimeout
+}
+
+// toolTimeoutEnv names the variable that overrides the timeout of the named tool
+func toolTimeoutEnv(name string) string {
+	return "TOOL_TIMEOUT_" + strings.ToUpper(name)
+}
+
+// acquireToolSlot takes one of the process-wide tool slots (MAX_CONCURRENT_TOOLS),
+// waiting until one frees up or ctx, the chat, is done. A free slot is taken even
+// if ctx is already done. The returned function gives the slot back.
+func (s *Server) acquireToolSlot(ctx context.Context) (release func(), err error) {
+	release = func() { <-s.toolSlots }
+	select {
+	case s.toolSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	select {
+	case s.toolSlots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a tool slot: %w", context.Cause(ctx))
+	}
+}
+
+// validateToolCall checks that a tool call from the model names a function and
+// carries its arguments as a JSON object
+func validateToolCall(tc chatToolCall) error {
+	if strings.TrimSpace(tc.Function.Name) == "" {
+		return fmt.Errorf("the function name is empty")
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil || args == nil {
+		return fmt.Errorf("arguments for %s must be a JSON object, got %q", tc.Function.Name, tc.Function.Arguments)
+	}
+	return nil
+}
+
+// pageReadKey returns the URL a read_page call fetches, so duplicate reads within
+// one turn can share a single fetch; ok is false for every other call
+func pageReadKey(tc chatToolCall) (url string, ok bool) {
+	if tc.Function.Name != "read_page" {
+		return "", false
+	}
+	var args struct {
+		Url string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+		return "", false
+	}
+	url = strings.TrimSpace(args.Url)
+	return url, url != ""
+}
+
+// toolBudgetExceeded reports whether name has already run as often as its
+// per-chat budget allows, given used prior calls in this chat
+func (s *Server) toolBudgetExceeded(name string, used int) bool {
+	budget, ok := s.cfg.ToolBudgets[name]
+	return ok && used >= budget
+}
+
+// toolNames extracts the function names from a list of tool definitions
+func toolNames(tools []interface{}) []string {
+	names := make([]string, 0, len(tools))
+	for _, t := range tools {
+		if name := toolName(t); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// toolName returns the function name of a single tool definition, or "" if it has none
+func toolName(tool interface{}) string {
+	def, ok := tool.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	fn, ok := def["function"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := fn["name"].(string)
+	return name
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestToolSwitches(t *testing.T) {
+	tests := []struct {
+		name      string
+		disable   func(*Config)
+		tools     []string
+		endpoints []string
+	}{
+		{"search", func(c *Config) { c.EnableSearch = false }, []string{"search"}, []string{"/search", "/search/suggest"}},
+		{"read page", func(c *Config) { c.EnableReadPage = false }, []string{"read_page", "read_pages", "extract_from_page", "read_feed"}, []string{"/page_reader"}},
+		{"run command", func(c *Config) { c.EnableRunCommand = false }, []string{"run_command"}, []string{"/run_command", "/run_command/stream"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := newModelStub(t, func(n int, req map[string]interface{}) string {
+				if n == 0 {
+					return toolCalls([2]string{tt.tools[0], `{}`})
+				}
+				return answer("done")
+			})
+			s := newTestServer(t, model.URL, tt.disable)
+
+			offered := toolNames(s.chatTools())
+			for _, name := range tt.tools {
+				if slices.Contains(offered, name) {
+					t.Errorf("disabled tool %s is offered to the model: %q", name, offered)
+				}
+			}
+			if !slices.Contains(offered, "convert_units") {
+				t.Errorf("unrelated tools were dropped too: %q", offered)
+			}
+
+			handler := Handler(s)
+			for _, path := range tt.endpoints {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`)))
+				if rec.Code != http.StatusForbidden {
+					t.Errorf("POST %s: status = %d, want %d", path, rec.Code, http.StatusForbidden)
+				}
+			}
+
+			// A model that names the tool anyway is refused
+			if rec := postChat(t, s, `{"message": "hi"}`); rec.Code != http.StatusOK {
+				t.Fatalf("chat status = %d, body %s", rec.Code, rec.Body)
+			}
+			reqs := model.received()
+			if len(reqs) != 2 {
+				t.Fatalf("model called %d times, want 2", len(reqs))
+			}
+			msgs := reqs[1]["messages"].([]interface{})
+			result, _ := msgs[len(msgs)-1].(map[string]interface{})["content"].(string)
+			if !strings.Contains(result, "tool disabled: "+tt.tools[0]) || !json.Valid([]byte(result)) {
+				t.Errorf("tool result = %q, want a disabled-tool error", result)
+			}
+		})
+	}
+}
+
+// Regression: error results were formatted into a JSON template, so a tool name
+// holding a quote or backslash made the tool message invalid JSON
+func TestToolErrorResultsAreJSON(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", nil)
+	name := `no"such\tool`
+	result, success := s.executeTool(t.Context(), name, `{}`)
+	var got map[string]string
+	if err := json.Unmarshal([]byte(result), &got); err != nil || success {
+		t.Fatalf("result = %s, %v: %v", result, success, err)
+	}
+	if got["error"] != "unknown tool: "+name {
+		t.Errorf("error = %q, want the tool name intact", got["error"])
+	}
+}
+
+func TestChatToolBudget(t *testing.T) {
+	searches := 0
+	restore := SetToolExecutor("search", func(arguments string) (string, bool) {
+		searches++
+		return `{"results": []}`, true
+	})
+	defer restore()
+
+	const rounds = 4
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n < rounds {
+			return toolCalls([2]string{"search", `{"keywords": ["again"]}`})
+		}
+		return answer("done")
+	})
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.ToolBudgets = map[string]int{"search": 2}
+	})
+	if rec := postChat(t, s, `{"message": "keep searching"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	if searches != 2 {
+		t.Errorf("search ran %d times, want the budget of 2", searches)
+	}
+	messages := model.received()[rounds]["messages"].([]interface{})
+	var results []string
+	for _, m := range messages {
+		if msg := m.(map[string]interface{}); msg["role"] == "tool" {
+			results = append(results, msg["content"].(string))
+		}
+	}
+	if len(results) != rounds {
+		t.Fatalf("got %d tool results, want %d", len(results), rounds)
+	}
+	for i, result := range results {
+		var refusal map[string]string
+		_ = json.Unmarshal([]byte(result), &refusal)
+		if refused := strings.HasPrefix(refusal["error"], "tool budget exceeded: search may be called at most 2 times"); refused != (i >= 2) {
+			t.Errorf("tool result %d = %q, want refused %v", i+1, result, i >= 2)
+		}
+	}
+}
+
+func TestValidateToolCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		function  string
+		arguments string
+		wantErr   string
+	}{
+		{"valid", "search", `{"keywords": ["go"]}`, ""},
+		{"empty object", "search", `{}`, ""},
+		{"blank name", "  ", `{}`, "function name is empty"},
+		{"null arguments", "search", `null`, "must be a JSON object"},
+		{"empty arguments", "search", ``, "must be a JSON object"},
+		{"array arguments", "search", `["go"]`, "must be a JSON object"},
+		{"truncated arguments", "search", `{"keywords": [`, "must be a JSON object"},
+	}
+	for _, tt := range tests {
+		var tc chatToolCall
+		tc.Function.Name, tc.Function.Arguments = tt.function, tt.arguments
+		err := validateToolCall(tc)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestChatMalformedToolCall(t *testing.T) {
+	searches := 0
+	restore := SetToolExecutor("search", func(arguments string) (string, bool) {
+		searches++
+		return `{"results": []}`, true
+	})
+	defer restore()
+
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls([2]string{"", `{}`}, [2]string{"search", `null`}, [2]string{"search", `{"keywords": ["go"]}`})
+		}
+		return answer("done")
+	})
+	s := newTestServer(t, model.URL, nil)
+	if rec := postChat(t, s, `{"message": "search please"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	if searches != 1 {
+		t.Errorf("search ran %d times, want only the well-formed call", searches)
+	}
+	var results []string
+	for _, m := range model.received()[1]["messages"].([]interface{}) {
+		if msg := m.(map[string]interface{}); msg["role"] == "tool" {
+			results = append(results, msg["content"].(string))
+		}
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d tool results, want one per call: %q", len(results), results)
+	}
+	for i, result := range results[:2] {
+		var body map[string]string
+		if err := json.Unmarshal([]byte(result), &body); err != nil || !strings.HasPrefix(body["error"], "malformed tool call: ") {
+			t.Errorf("tool result %d = %q, want a malformed tool call error", i+1, result)
+		}
+	}
+}
+
+func TestChatDeduplicatesPageReads(t *testing.T) {
+	var reads []string
+	restore := SetToolExecutor("read_page", func(arguments string) (string, bool) {
+		var args struct {
+			Url string `json:"url"`
+		}
+		_ = json.Unmarshal([]byte(arguments), &args)
+		reads = append(reads, args.Url)
+		return `{"content": "page at ` + args.Url + `"}`, true
+	})
+	defer restore()
+
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return toolCalls(
+				[2]string{"read_page", `{"url": "https://a.example"}`},
+				[2]string{"read_page", `{"url": "https://b.example"}`},
+				[2]string{"read_page", `{"url": " https://a.example "}`},
+			)
+		}
+		return answer("done")
+	})
+	s := newTestServer(t, model.URL, nil)
+	if rec := postChat(t, s, `{"message": "read these"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	if !slices.Equal(reads, []string{"https://a.example", "https://b.example"}) {
+		t.Errorf("pages read = %q, want each URL once", reads)
+	}
+	want := map[string]string{
+		"call_1": `{"content": "page at https://a.example"}`,
+		"call_2": `{"content": "page at https://b.example"}`,
+		"call_3": `{"content": "page at https://a.example"}`,
+	}
+	got := map[string]string{}
+	for _, m := range model.received()[1]["messages"].([]interface{}) {
+		if msg := m.(map[string]interface{}); msg["role"] == "tool" {
+			got[msg["tool_call_id"].(string)] = msg["content"].(string)
+		}
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("tool results = %q, want %q", got, want)
+	}
+}
+
+func TestToolTimeout(t *testing.T) {
+	s := newTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.ToolTimeout = time.Minute
+		cfg.ToolTimeouts = map[string]time.Duration{"read_page": 45 * time.Second, "search": 5 * time.Second}
+	})
+	for name, want := range map[string]time.Duration{"read_page": 45 * time.Second, "search": 5 * time.Second, "run_command": time.Minute} {
+		if got := s.toolTimeout(name); got != want {
+			t.Errorf("toolTimeout(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestExecuteToolTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer site.Close()
+	defer close(release)
+	s := newToolTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true
+		cfg.ToolTimeout = time.Minute
+		cfg.ToolTimeouts = map[string]time.Duration{"read_page": 50 * time.Millisecond}
+	})
+
+	// The chat has already run out of time; the tool is bounded by its own timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	result, success := s.executeTool(ctx, "read_page", `{"url": "`+site.URL+`"}`)
+	if elapsed := time.Since(start); success || elapsed > 5*time.Second {
+		t.Fatalf("read_page = %s, %v after %v, want a failure at the read_page timeout", result, success, elapsed)
+	}
+	var body map[string]string
+	if err := json.Unmarshal([]byte(result), &body); err != nil || body["error"] != "read_page timed out after 50ms" {
+		t.Errorf("result = %s, want the timeout reported to the model", result)
+	}
+}
+
+func TestExecuteToolSlots(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		_, _ = w.Write([]byte("<p>page</p>"))
+	}))
+	defer site.Close()
+	s := newToolTestServer(t, "http://upstream.invalid", func(cfg *Config) {
+		cfg.AllowPrivateFetch = true // the site is on loopback
+		cfg.MaxConcurrentTools = 2
+	})
+
+	// Several chats' tool calls at once share the two slots; distinct URLs, so the
+	// page cache does not absorb any fetch
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, ok := s.executeTool(t.Context(), "read_page", fmt.Sprintf(`{"url": "%s/%d"}`, site.URL, i)); !ok {
+				t.Errorf("read_page %d: %s", i, result)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("%d tools ran at once, want the limit of 2 reached and held", peak)
+	}
+
+	// With the pool saturated, a tool call gives up when its chat's deadline passes
+	for range 2 {
+		if _, err := s.acquireToolSlot(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	result, ok := s.executeTool(ctx, "read_page", `{"url": "`+site.URL+`/late"}`)
+	var body map[string]string
+	if ok || json.Unmarshal([]byte(result), &body) != nil || !strings.Contains(body["error"], "server is busy") {
+		t.Errorf("read_page with no free slot = %s, %v, want a busy error", result, ok)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// unsupportedLanguageReply is what the translation prompt asks the model to answer
+// when it cannot translate into the requested language
+const unsupportedLanguageReply = "UNSUPPORTED_LANGUAGE"
+
+// languageNameRe accepts language names and codes like "French", "pt-BR" or "Traditional Chinese"
+var languageNameRe = regexp.MustCompile(`^[\p{L}][\p{L} ()\-]{0,48}$`)
+
+// translationResult is the result of the translate tool
+type translationResult struct {
+	TargetLanguage string `json:"target_language"`
+	Translation    string `json:"translation"`
+}
+
+// Translate renders text in targetLanguage with a focused, tool-less model call
+// that is kept separate from the surrounding conversation.
+func (s *Server) Translate(ctx context.Context, text, targetLanguage string) (*translationResult, error) {
+	targetLanguage = strings.TrimSpace(targetLanguage)
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("text must not be empty")
+	}
+	if !languageNameRe.MatchString(targetLanguage) {
+		return nil, fmt.Errorf("unsupported target language %q", targetLanguage)
+	}
+
+	messages := []interface{}{
+		map[string]string{
+			"role": "system",
+			"content": fmt.Sprintf("You are a translation engine. Translate the user's message into %s. "+
+				"Reply with the translation only, without quotes, notes or explanations. "+
+				"If %s is not a language you can translate into, reply with exactly %s.",
+				targetLanguage, targetLanguage, unsupportedLanguageReply),
+		},
+		map[string]string{"role": "user", "content": text},
+	}
+
+	choice, _, cerr := s.doCompletion(ctx, s.cfg.DefaultModel, messages, nil, completionOptions{})
+	if cerr != nil {
+		return nil, fmt.Errorf("translation failed: %s", cerr.message)
+	}
+	if choice.Message.Content == nil || strings.TrimSpace(*choice.Message.Content) == "" {
+		return nil, fmt.Errorf("translation failed: model returned no text")
+	}
+
+	translation := strings.TrimSpace(*choice.Message.Content)
+	if translation == unsupportedLanguageReply {
+		return nil, fmt.Errorf("unsupported target language %q", targetLanguage)
+	}
+	return &translationResult{TargetLanguage: targetLanguage, Translation: translation}, nil
+}
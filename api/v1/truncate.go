@@ -0,0 +1,38 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncationBytesPlaceholder is replaced in TRUNCATION_MARKER with the number of
+// bytes cut off
+const truncationBytesPlaceholder = "{bytes}"
+
+// truncateText cuts text to at most limit bytes, backing off to the start of a
+// UTF-8 character, and appends marker with the number of bytes dropped. Text
+// within the limit is returned as is.
+func truncateText(text string, limit int, marker string) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := max(limit, 0)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + strings.ReplaceAll(marker, truncationBytesPlaceholder, strconv.Itoa(len(text)-cut))
+}
+
+// truncateRunes is truncateText with the limit counted in characters
+func truncateRunes(text string, limit int, marker string) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	cut := 0
+	for i := 0; i < limit; i++ {
+		_, size := utf8.DecodeRuneInString(text[cut:])
+		cut += size
+	}
+	return truncateText(text, cut, marker)
+}
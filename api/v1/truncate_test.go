@@ -0,0 +1,49 @@
+package api
+
+import "testing"
+
+func TestTruncateText(t *testing.T) {
+	const marker = "…[truncated {bytes} bytes]"
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{"within the limit", "short", 5, "short"},
+		{"ASCII", "abcdefghij", 4, "abcd…[truncated 6 bytes]"},
+		{"mid-character", "añb", 2, "a…[truncated 3 bytes]"}, // ñ is two bytes, both dropped
+		{"zero limit", "abc", 0, "…[truncated 3 bytes]"},
+		{"negative limit", "abc", -1, "…[truncated 3 bytes]"},
+	}
+	for _, tt := range tests {
+		if got := truncateText(tt.text, tt.limit, marker); got != tt.want {
+			t.Errorf("%s: truncateText(%q, %d) = %q, want %q", tt.name, tt.text, tt.limit, got, tt.want)
+		}
+	}
+
+	if got := truncateText("abcdef", 2, " [{bytes} more, {bytes}]"); got != "ab [4 more, 4]" {
+		t.Errorf("custom marker = %q", got)
+	}
+	if got := truncateText("abcdef", 2, ""); got != "ab" {
+		t.Errorf("empty marker = %q, want the bare cut", got)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  string
+	}{
+		{"日本語", 3, "日本語"},
+		{"日本語のテキスト", 3, "日本語 (15)"}, // five three-byte characters dropped
+		{"añbc", 2, "añ (2)"},
+		{"abc", 0, " (3)"},
+	}
+	for _, tt := range tests {
+		if got := truncateRunes(tt.text, tt.limit, " ({bytes})"); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
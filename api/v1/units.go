@@ -0,0 +1,148 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unitDef describes a unit by its dimension and its factor to the dimension's base unit
+// (metre, kilogram, second). Temperature is affine and handled separately.
+type unitDef struct {
+	dimension string
+	factor    float64
+}
+
+// unitTable maps canonical unit symbols to their definitions
+var unitTable = map[string]unitDef{
+	// length (base: m)
+	"mm": {"length", 0.001},
+	"cm": {"length", 0.01},
+	"m":  {"length", 1},
+	"km": {"length", 1000},
+	"in": {"length", 0.0254},
+	"ft": {"length", 0.3048},
+	"yd": {"length", 0.9144},
+	"mi": {"length", 1609.344},
+
+	// mass (base: kg)
+	"mg": {"mass", 0.000001},
+	"g":  {"mass", 0.001},
+	"kg": {"mass", 1},
+	"t":  {"mass", 1000},
+	"oz": {"mass", 0.028349523125},
+	"lb": {"mass", 0.45359237},
+
+	// time (base: s)
+	"ms":   {"time", 0.001},
+	"s":    {"time", 1},
+	"min":  {"time", 60},
+	"h":    {"time", 3600},
+	"day":  {"time", 86400},
+	"week": {"time", 604800},
+
+	// temperature (converted via formulas, factor unused)
+	"C": {"temperature", 0},
+	"F": {"temperature", 0},
+	"K": {"temperature", 0},
+}
+
+// unitAliases maps accepted spellings (lowercased) to canonical unit symbols
+var unitAliases = map[string]string{
+	"mm": "mm", "millimeter": "mm", "millimeters": "mm", "millimetre": "mm", "millimetres": "mm",
+	"cm": "cm", "centimeter": "cm", "centimeters": "cm", "centimetre": "cm", "centimetres": "cm",
+	"m": "m", "meter": "m", "meters": "m", "metre": "m", "metres": "m",
+	"km": "km", "kilometer": "km", "kilometers": "km", "kilometre": "km", "kilometres": "km",
+	"in": "in", "inch": "in", "inches": "in",
+	"ft": "ft", "foot": "ft", "feet": "ft",
+	"yd": "yd", "yard": "yd", "yards": "yd",
+	"mi": "mi", "mile": "mi", "miles": "mi",
+
+	"mg": "mg", "milligram": "mg", "milligrams": "mg",
+	"g": "g", "gram": "g", "grams": "g",
+	"kg": "kg", "kilogram": "kg", "kilograms": "kg",
+	"t": "t", "tonne": "t", "tonnes": "t", "metric ton": "t",
+	"oz": "oz", "ounce": "oz", "ounces": "oz",
+	"lb": "lb", "lbs": "lb", "pound": "lb", "pounds": "lb",
+
+	"ms": "ms", "millisecond": "ms", "milliseconds": "ms",
+	"s": "s", "sec": "s", "second": "s", "seconds": "s",
+	"min": "min", "minute": "min", "minutes": "min",
+	"h": "h", "hr": "h", "hour": "h", "hours": "h",
+	"d": "day", "day": "day", "days": "day",
+	"wk": "week", "week": "week", "weeks": "week",
+
+	"c": "C", "°c": "C", "celsius": "C",
+	"f": "F", "°f": "F", "fahrenheit": "F",
+	"k": "K", "kelvin": "K",
+}
+
+// temperatureFormulas holds the conversion and canonical formula for each temperature pair
+var temperatureFormulas = map[string]struct {
+	convert func(float64) float64
+	formula string
+}{
+	"C->F": {func(v float64) float64 { return v*9/5 + 32 }, "F = C × 9/5 + 32"},
+	"F->C": {func(v float64) float64 { return (v - 32) * 5 / 9 }, "C = (F − 32) × 5/9"},
+	"C->K": {func(v float64) float64 { return v + 273.15 }, "K = C + 273.15"},
+	"K->C": {func(v float64) float64 { return v - 273.15 }, "C = K − 273.15"},
+	"F->K": {func(v float64) float64 { return (v-32)*5/9 + 273.15 }, "K = (F − 32) × 5/9 + 273.15"},
+	"K->F": {func(v float64) float64 { return (v-273.15)*9/5 + 32 }, "F = (K − 273.15) × 9/5 + 32"},
+}
+
+// unitConversionResult is returned to the model by the convert_units tool
+type unitConversionResult struct {
+	Value    float64 `json:"value"`
+	FromUnit string  `json:"from_unit"`
+	ToUnit   string  `json:"to_unit"`
+	Result   float64 `json:"result"`
+	Formula  string  `json:"formula"`
+}
+
+// lookupUnit resolves a user-supplied unit name to its canonical symbol
+func lookupUnit(name string) (string, error) {
+	canonical, ok := unitAliases[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "", fmt.Errorf("unknown unit: %q", name)
+	}
+	return canonical, nil
+}
+
+// ConvertUnits converts value between two units of the same dimension and returns
+// the converted value with the canonical formula used.
+func ConvertUnits(value float64, fromUnit, toUnit string) (*unitConversionResult, error) {
+	from, err := lookupUnit(fromUnit)
+	if err != nil {
+		return nil, err
+	}
+	to, err := lookupUnit(toUnit)
+	if err != nil {
+		return nil, err
+	}
+
+	fromDef, toDef := unitTable[from], unitTable[to]
+	if fromDef.dimension != toDef.dimension {
+		return nil, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, fromDef.dimension, to, toDef.dimension)
+	}
+
+	result := &unitConversionResult{
+		Value:    value,
+		FromUnit: from,
+		ToUnit:   to,
+	}
+
+	switch {
+	case from == to:
+		result.Result = value
+		result.Formula = fmt.Sprintf("%s = %s", to, from)
+	case fromDef.dimension == "temperature":
+		tf := temperatureFormulas[from+"->"+to]
+		result.Result = tf.convert(value)
+		result.Formula = tf.formula
+	default:
+		factor := fromDef.factor / toDef.factor
+		result.Result = value * factor
+		result.Formula = fmt.Sprintf("%s = %s × %g", to, from, factor)
+	}
+
+	return result, nil
+}
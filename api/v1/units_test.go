@@ -0,0 +1,71 @@
+package api
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestConvertUnits(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+		formula  string
+	}{
+		{1, "mi", "km", 1.609344, "km = mi × 1.609344"},
+		{2.5, "kilometres", "m", 2500, "m = km × 1000"},
+		{12, "inches", "ft", 1, ""},
+		{1, "kg", "lb", 2.2046226218, ""},
+		{16, "ounces", "pound", 1, ""},
+		{90, "min", "hours", 1.5, ""},
+		{2, "weeks", "days", 14, ""},
+		{100, "C", "F", 212, "F = C × 9/5 + 32"},
+		{-40, "°F", "celsius", -40, "C = (F − 32) × 5/9"},
+		{0, "K", "C", -273.15, ""},
+		{32, "F", "kelvin", 273.15, ""},
+		{7, "m", "metres", 7, "m = m"},
+	}
+	for _, tt := range tests {
+		got, err := ConvertUnits(tt.value, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("ConvertUnits(%v, %s, %s): %v", tt.value, tt.from, tt.to, err)
+			continue
+		}
+		if math.Abs(got.Result-tt.want) > 1e-9*math.Max(1, math.Abs(tt.want)) {
+			t.Errorf("ConvertUnits(%v, %s, %s) = %v, want %v", tt.value, tt.from, tt.to, got.Result, tt.want)
+		}
+		if tt.formula != "" && got.Formula != tt.formula {
+			t.Errorf("ConvertUnits(%v, %s, %s) formula = %q, want %q", tt.value, tt.from, tt.to, got.Formula, tt.formula)
+		}
+	}
+}
+
+func TestConvertUnitsErrors(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"furlong", "m", "unknown unit"},
+		{"m", "parsec", "unknown unit"},
+		{"kg", "m", "cannot convert kg (mass) to m (length)"},
+		{"C", "s", "cannot convert"},
+	}
+	for _, tt := range tests {
+		if _, err := ConvertUnits(1, tt.from, tt.to); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ConvertUnits(1, %s, %s) error = %v, want %q", tt.from, tt.to, err, tt.want)
+		}
+	}
+}
+
+func TestCallConvertUnitsTool(t *testing.T) {
+	got, err := callConvertUnitsTool(`{"value": 0, "from_unit": "C", "to_unit": "F"}`)
+	if err != nil || got.Result != 32 || got.FromUnit != "C" || got.ToUnit != "F" {
+		t.Errorf("convert 0 C to F = %+v, %v", got, err)
+	}
+	for _, args := range []string{`{"from_unit": "C", "to_unit": "F"}`, `not json`} {
+		if _, err := callConvertUnitsTool(args); err == nil {
+			t.Errorf("callConvertUnitsTool(%s) succeeded, want an error", args)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// completionWebhookTimeout bounds each COMPLETION_WEBHOOK_URL delivery
+const completionWebhookTimeout = 5 * time.Second
+
+// webhookClient delivers completion notifications; it is separate from the AI
+// Builder and page clients so a slow receiver can't tie up their connections
+var webhookClient = &http.Client{Timeout: completionWebhookTimeout}
+
+// completionEvent is the JSON body POSTed to COMPLETION_WEBHOOK_URL after a chat.
+// Like /admin/recent it carries only metadata, never message content.
+type completionEvent struct {
+	Timestamp  string    `json:"timestamp"`
+	RequestID  string    `json:"request_id"`
+	Model      string    `json:"model"`
+	DurationMs int       `json:"duration_ms"`
+	ToolCount  int       `json:"tool_count"`
+	Usage      chatUsage `json:"usage"`
+	Status     int       `json:"status"`
+	Success    bool      `json:"success"`
+}
+
+// notifyCompletion POSTs a summary of a finished chat to COMPLETION_WEBHOOK_URL in
+// the background. Delivery is fire-and-forget: it never delays the response, and
+// failures are only logged.
+func (s *Server) notifyCompletion(requestID, model string, start time.Time, toolCount int, usage chatUsage, status int) {
+	if s.cfg.CompletionWebhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(completionEvent{
+		Timestamp:  start.UTC().Format(time.RFC3339),
+		RequestID:  requestID,
+		Model:      model,
+		DurationMs: int(time.Since(start).Milliseconds()),
+		ToolCount:  toolCount,
+		Usage:      usage,
+		Status:     status,
+		Success:    status < http.StatusBadRequest,
+	})
+	if err != nil {
+		log.Printf("%s[webhook] Failed to marshal completion event: %v%s", colorRed, err, colorReset)
+		return
+	}
+
+	go func() {
+		resp, err := webhookClient.Post(s.cfg.CompletionWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("%s[webhook] Completion webhook for %s failed: %v%s", colorRed, requestID, err, colorReset)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			log.Printf("%s[webhook] Completion webhook for %s returned HTTP %d%s", colorRed, requestID, resp.StatusCode, colorReset)
+		}
+	}()
+}
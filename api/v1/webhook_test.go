@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebhookReceiver starts a COMPLETION_WEBHOOK_URL receiver and returns its URL
+// and the raw bodies it is sent
+func newWebhookReceiver(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver.URL, bodies
+}
+
+// nextCompletionEvent waits for the next webhook delivery
+func nextCompletionEvent(t *testing.T, bodies <-chan []byte) (completionEvent, string) {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		var ev completionEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Fatalf("webhook body %s: %v", body, err)
+		}
+		return ev, string(body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the completion webhook never fired")
+		return completionEvent{}, ""
+	}
+}
+
+// withUsage adds a usage block to a model reply
+func withUsage(reply string, prompt, completion int) string {
+	var m map[string]interface{}
+	_ = json.Unmarshal([]byte(reply), &m)
+	m["usage"] = chatUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+func TestNotifyCompletion(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		if n == 0 {
+			return withUsage(toolCalls([2]string{"convert_units", `{"value": 1, "from_unit": "mi", "to_unit": "km"}`}), 10, 5)
+		}
+		return withUsage(answer("secret answer"), 20, 7)
+	})
+	url, bodies := newWebhookReceiver(t)
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.CompletionWebhookURL = url
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "secret question", "model": "gpt-4o"}`))
+	req.Header.Set("X-Request-ID", "req-ok")
+	rec := httptest.NewRecorder()
+	s.PostChat(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("chat: %d %s", rec.Code, rec.Body)
+	}
+
+	ev, body := nextCompletionEvent(t, bodies)
+	want := completionEvent{
+		Timestamp:  ev.Timestamp,
+		DurationMs: ev.DurationMs,
+		RequestID:  "req-ok",
+		Model:      "gpt-4o",
+		ToolCount:  1,
+		Usage:      chatUsage{PromptTokens: 30, CompletionTokens: 12, TotalTokens: 42},
+		Status:     http.StatusOK,
+		Success:    true,
+	}
+	if ev != want {
+		t.Errorf("event = %+v, want %+v", ev, want)
+	}
+	if _, err := time.Parse(time.RFC3339, ev.Timestamp); err != nil || ev.DurationMs < 0 {
+		t.Errorf("timestamp %q, duration %d", ev.Timestamp, ev.DurationMs)
+	}
+	if strings.Contains(body, "secret") {
+		t.Errorf("webhook body %s carries message content", body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "hi", "seed": -1}`))
+	req.Header.Set("X-Request-ID", "req-bad")
+	s.PostChat(httptest.NewRecorder(), req)
+	if ev, _ := nextCompletionEvent(t, bodies); ev.RequestID != "req-bad" || ev.Status != http.StatusBadRequest || ev.Success {
+		t.Errorf("failed chat event = %+v, want status 400 and no success", ev)
+	}
+}
+
+// Regression: template chats never fired the completion webhook
+func TestNotifyCompletionTemplateChat(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return withUsage(answer("Short."), 12, 3)
+	})
+	url, bodies := newWebhookReceiver(t)
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.CompletionWebhookURL = url
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/template/summarize", strings.NewReader(`{"variables": {"text": "A long story."}}`))
+	req.Header.Set("X-Request-ID", "tmpl-ok")
+	rec := httptest.NewRecorder()
+	s.PostChatTemplate(rec, req, "summarize")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("template chat: %d %s", rec.Code, rec.Body)
+	}
+
+	ev, body := nextCompletionEvent(t, bodies)
+	want := completionEvent{
+		Timestamp:  ev.Timestamp,
+		DurationMs: ev.DurationMs,
+		RequestID:  "tmpl-ok",
+		Model:      "gpt-5",
+		Usage:      chatUsage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+		Status:     http.StatusOK,
+		Success:    true,
+	}
+	if ev != want {
+		t.Errorf("event = %+v, want %+v", ev, want)
+	}
+	if strings.Contains(body, "long story") {
+		t.Errorf("webhook body %s carries the rendered prompt", body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/chat/template/nope", strings.NewReader(`{"variables": {}}`))
+	req.Header.Set("X-Request-ID", "tmpl-missing")
+	s.PostChatTemplate(httptest.NewRecorder(), req, "nope")
+	if ev, _ := nextCompletionEvent(t, bodies); ev.RequestID != "tmpl-missing" || ev.Status != http.StatusNotFound || ev.Success {
+		t.Errorf("unknown template event = %+v, want status 404 and no success", ev)
+	}
+}
+
+func TestNotifyCompletionDoesNotBlock(t *testing.T) {
+	model := newModelStub(t, func(n int, req map[string]interface{}) string {
+		return answer("hello")
+	})
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+	s := newTestServer(t, model.URL, func(cfg *Config) {
+		cfg.CompletionWebhookURL = receiver.URL
+	})
+
+	start := time.Now()
+	if rec := postChat(t, s, `{"message": "hi"}`); rec.Code != http.StatusOK {
+		t.Fatalf("chat: %d %s", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("chat took %v with a stalled webhook receiver, want the delivery in the background", elapsed)
+	}
+}
@@ -1,49 +1,57 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
-	"os"
 
 	api "example.com/demo-openapi/api/v1"
 	"github.com/joho/godotenv"
 )
 
+// swaggerUIDir holds the Swagger UI static files served under /docs/
+const swaggerUIDir = "docs/swagger-ui"
+
 func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	// API key is now available via os.Getenv("API_KEY")
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
-		log.Println("Warning: API_KEY not set")
+	// Load and validate all configuration once at startup
+	server, err := api.NewServerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	server := api.NewServer()
+	cfg := server.Config()
+
+	// Verify the upstream key in the background; failures are logged and shown in /readyz
+	if cfg.StartupPing {
+		go server.PingUpstream(context.Background())
+	}
 
 	mux := http.NewServeMux()
 	api.HandlerFromMux(server, mux)
 
-	// 托管 OpenAPI spec
+	// 托管 OpenAPI spec (embedded in the binary)
 	mux.HandleFunc("/api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
-		content, err := os.ReadFile("api/v1/openapi.yaml")
-		if err != nil {
-			http.Error(w, "Failed to read openapi.yaml", http.StatusInternalServerError)
-			return
-		}
 		w.Header().Set("Content-Type", "application/x-yaml")
-		w.Write(content)
+		w.Write(api.OpenAPISpec())
 	})
 
-	// 托管 Swagger UI
-	mux.Handle("/docs/", http.StripPrefix("/docs/", http.FileServer(http.Dir("docs/swagger-ui"))))
+	// 托管 Swagger UI; missing files are logged now and shown in /readyz
+	server.CheckDocs(swaggerUIDir)
+	mux.Handle("/docs/", http.StripPrefix("/docs/", http.FileServer(http.Dir(swaggerUIDir))))
 
-	addr := "0.0.0.0:8080"
-	log.Printf("Server starting on http://%s", addr)
-	log.Printf("API: curl 'http://localhost:8080/hello?name=test'")
-	log.Printf("Swagger UI: http://localhost:8080/docs/")
+	addr := cfg.ListenAddr
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	log.Printf("Server starting on %s://%s", scheme, addr)
+	log.Printf("API: curl '%s/hello?name=test'", cfg.InternalBaseURL)
+	log.Printf("Swagger UI: %s/docs/", cfg.InternalBaseURL)
 
 	// CORS middleware
 	corsHandler := func(next http.Handler) http.Handler {
@@ -62,9 +70,17 @@ func main() {
 	}
 
 	s := &http.Server{
-		Handler: corsHandler(mux),
-		Addr:    addr,
+		Handler:           corsHandler(server.RequireJSONContentType(server.LimitConcurrentChats(server.EnforceDailyQuota(server.AttachRequestMeta(mux))))),
+		Addr:              addr,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
 
+	// TLS also enables HTTP/2 automatically
+	if cfg.TLSEnabled() {
+		log.Fatal(s.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile))
+	}
 	log.Fatal(s.ListenAndServe())
 }
@@ -2,10 +2,12 @@ module example.com/demo-openapi
 
 go 1.25.5
 
-require github.com/oapi-codegen/runtime v1.1.2
+require (
+	github.com/joho/godotenv v1.5.1
+	github.com/oapi-codegen/runtime v1.1.2
+)
 
 require (
 	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
 	github.com/google/uuid v1.5.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 )